@@ -44,6 +44,11 @@ if test "$1" == "dfsan"; then
   shift
 fi
 
+if test "$1" == "nosplit"; then
+  nosplit=1
+  shift
+fi
+
 if test "$lto" == "1" -o "$asan" == "1" -o "$tsan" == "1" -o "$msan" == "1" -o "$dfsan" == "1"; then
   echo "# WARNING: support for LTO/sanitizer variants is EXPERIMENTAL and unlikely to work correctly!"
 fi
@@ -90,7 +95,7 @@ build_libgo_stage() {
 
   mkdir -p $destdir
   echo "# Configuring $variantname libgo."
-  (cd $destdir && $gofrontenddir/libgo/configure --disable-multilib --without-libatomic CC="$libgo_wrapped_cc" GOC="$goc -no-prefix $GOCFLAGS $gocflags" > $workdir/${variantname}-config.log 2>&1 || (echo "# Configure failed, see $workdir/${variantname}-config.log" && exit 1))
+  (cd $destdir && $gofrontenddir/libgo/configure --disable-multilib --without-libatomic $libgo_configflags CC="$libgo_wrapped_cc" GOC="$goc -no-prefix $GOCFLAGS $gocflags" > $workdir/${variantname}-config.log 2>&1 || (echo "# Configure failed, see $workdir/${variantname}-config.log" && exit 1))
   echo "# Building $variantname libgo."
   make -C $destdir $makeflags 2>&1 | tee $workdir/${variantname}-make.log | $workdir/makefilter
   test "${PIPESTATUS[0]}" = "0" || (echo "# Build failed, see $workdir/${variantname}-make.log" && exit 1)
@@ -123,6 +128,7 @@ if [ "$bootstrap_type" != "" ]; then
   echo "# Building helper programs."
   (cd $llgodir/cmd/cc-wrapper && go build -o $workdir/cc-wrapper)
   (cd $llgodir/cmd/makefilter && go build -o $workdir/makefilter)
+  (cd $llgodir/cmd/llgo-demangle && go build -o $workdir/llgo-demangle)
 
   # Build a stage1 compiler with gc.
   echo "# Building stage1 compiler."
@@ -162,6 +168,10 @@ if [ "$bootstrap_type" != "" ]; then
   echo "# Building stage3 compiler."
   (cd $llgodir/cmd/gllgo && PATH=$gofrontend_builddir/stage2-path:$PATH CC="$llgo_cc" CXX="$llgo_cxx" go build -compiler gccgo -gccgoflags "$gllgoflags" -o $workdir/gllgo-stage3)
 
+  # Build the build driver with the same compiler and libgo as stage3.
+  echo "# Building llgo-build."
+  (cd $llgodir/cmd/llgo-build && PATH=$gofrontend_builddir/stage2-path:$PATH CC="$llgo_cc" CXX="$llgo_cxx" go build -compiler gccgo -gccgoflags "$gllgoflags" -o $workdir/llgo-build)
+
   # Strip the compiler binaries. The binaries are currently only
   # expected to compare equal modulo debug info.
   strip -R .note.gnu.build-id -o $workdir/gllgo-stage2.stripped $workdir/gllgo-stage2
@@ -191,3 +201,11 @@ fi
 if [ "$dfsan" == "1" ]; then
   build_libgo_variant $workdir/gllgo-stage3 "-fsanitize=dataflow" "-fsanitize=dataflow -fcompilerrt-prefix=$workdir/clang_build" dfsan "$*"
 fi
+
+if [ "$nosplit" == "1" ]; then
+  # Build libgo with fixed-size goroutine stacks, for programs compiled
+  # with -fno-split-stack.
+  libgo_configflags="libgo_cv_c_split_stack_supported=no"
+  build_libgo_variant $workdir/gllgo-stage3 "" "-fno-split-stack" nosplit "$*"
+  libgo_configflags=""
+fi
@@ -45,7 +45,11 @@ func parseTriple(triple string) (goos string, goarch string, err error) {
 	goarchREs := []REs{
 		{"amd64|x86_64", "amd64"},
 		{"i[3-9]86", "386"},
+		{"aarch64|arm64", "arm64"},
 		{"xscale|((arm|thumb)(v.*)?)", "arm"},
+		{"powerpc64le|ppc64le", "ppc64le"},
+		{"powerpc64|ppc64", "ppc64"},
+		{"wasm32", "wasm"},
 	}
 	goosREs := []REs{
 		{"linux.*", "linux"},
@@ -53,6 +57,7 @@ func parseTriple(triple string) (goos string, goarch string, err error) {
 		{"k?freebsd.*", "freebsd"},
 		{"netbsd.*", "netbsd"},
 		{"openbsd.*", "openbsd"},
+		{"wasi.*", "wasip1"},
 	}
 	match := func(list []REs, s string) string {
 		for _, t := range list {
@@ -64,23 +69,25 @@ func parseTriple(triple string) (goos string, goarch string, err error) {
 	}
 
 	s := strings.Split(triple, "-")
-	switch l := len(s); l {
+	var osParts []string
+	switch len(s) {
 	default:
 		return "", "", errors.New("triple should be made up of 2, 3, or 4 parts.")
-	case 2, 3: // ARCHITECTURE-(VENDOR-)OPERATING_SYSTEM
-		goarch = s[0]
-		goos = s[l-1]
+	case 2: // ARCHITECTURE-OPERATING_SYSTEM
+		osParts = s[1:]
+	case 3: // ARCHITECTURE-VENDOR-OPERATING_SYSTEM or ARCHITECTURE-OPERATING_SYSTEM-ENVIRONMENT
+		osParts = []string{s[2], s[1]}
 	case 4: // ARCHITECTURE-VENDOR-OPERATING_SYSTEM-ENVIRONMENT
-		goarch = s[0]
-		goos = s[2]
+		osParts = s[2:3]
 	}
-	goarch = match(goarchREs, goarch)
+	goarch = match(goarchREs, s[0])
 	if goarch == "" {
 		return "", "", errors.New("unknown architecture in triple")
 	}
-	goos = match(goosREs, goos)
-	if goos == "" {
-		return "", "", errors.New("unknown OS in triple")
+	for _, part := range osParts {
+		if goos = match(goosREs, part); goos != "" {
+			return goos, goarch, nil
+		}
 	}
-	return goos, goarch, nil
+	return "", "", errors.New("unknown OS in triple")
 }
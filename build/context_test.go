@@ -0,0 +1,44 @@
+package build_test
+
+import (
+	"testing"
+
+	"github.com/go-llvm/llgo/build"
+)
+
+func TestContextFromTriple(t *testing.T) {
+	tests := []struct {
+		triple, goos, goarch string
+	}{
+		{"x86_64-unknown-linux-gnu", "linux", "amd64"},
+		{"i686-linux-gnu", "linux", "386"},
+		{"armv7-none-linux-gnueabi", "linux", "arm"},
+		{"aarch64-linux-gnu", "linux", "arm64"},
+		{"aarch64-unknown-linux-gnu", "linux", "arm64"},
+		{"arm64-apple-darwin", "darwin", "arm64"},
+		{"armv7-linux-gnueabihf", "linux", "arm"},
+		{"powerpc64le-linux-gnu", "linux", "ppc64le"},
+		{"powerpc64-unknown-linux-gnu", "linux", "ppc64"},
+		{"wasm32-wasi", "wasip1", "wasm"},
+		{"wasm32-unknown-wasi", "wasip1", "wasm"},
+		{"pnacl", "nacl", "le32"},
+	}
+	for _, test := range tests {
+		ctx, err := build.ContextFromTriple(test.triple)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.triple, err)
+			continue
+		}
+		if ctx.GOOS != test.goos || ctx.GOARCH != test.goarch {
+			t.Errorf("%s: got %s/%s, want %s/%s", test.triple, ctx.GOOS, ctx.GOARCH, test.goos, test.goarch)
+		}
+	}
+
+	// There is no GOOS for freestanding WebAssembly, whose packages are
+	// compiled by gllgo directly.
+	for _, triple := range []string{"sparc-linux-gnu", "wasm32-unknown-unknown"} {
+		if _, err := build.ContextFromTriple(triple); err == nil {
+			t.Errorf("%s: expected an error", triple)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package build
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	arMagic     = "!<arch>\n"
+	arHeaderLen = 60
+)
+
+var bitcodeMagic = []byte("BC\xc0\xde")
+
+// IsBitcode reports whether data is an LLVM bitcode file.
+func IsBitcode(data []byte) bool {
+	return bytes.HasPrefix(data, bitcodeMagic)
+}
+
+// ForEachObject calls fn with the contents of the file at path or, if the
+// file is an ar archive, with the name and contents of each of its members.
+// The symbol index and extended name table of GNU archives are skipped.
+func ForEachObject(path string, fn func(name string, data []byte) error) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte(arMagic)) {
+		return fn(path, data)
+	}
+
+	var longnames []byte
+	data = data[len(arMagic):]
+	for len(data) != 0 {
+		if len(data) < arHeaderLen {
+			return fmt.Errorf("%s: truncated archive member header", path)
+		}
+		hdr := data[:arHeaderLen]
+		data = data[arHeaderLen:]
+		size, err := strconv.Atoi(strings.TrimSpace(string(hdr[48:58])))
+		if err != nil || size < 0 || size > len(data) {
+			return fmt.Errorf("%s: invalid archive member size", path)
+		}
+		contents := data[:size]
+		data = data[size:]
+		if size%2 != 0 && len(data) != 0 {
+			data = data[1:]
+		}
+
+		name := strings.TrimRight(string(hdr[0:16]), " ")
+		switch {
+		case name == "/", name == "/SYM64/":
+			continue
+		case name == "//":
+			longnames = contents
+			continue
+		case strings.HasPrefix(name, "/"):
+			off, err := strconv.Atoi(name[1:])
+			if err != nil || off >= len(longnames) {
+				return fmt.Errorf("%s: invalid archive member name '%s'", path, name)
+			}
+			name = string(longnames[off:])
+			if i := strings.Index(name, "/\n"); i >= 0 {
+				name = name[:i]
+			}
+		default:
+			name = strings.TrimSuffix(name, "/")
+		}
+
+		if err := fn(name, contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ELFSection returns the contents of the named section of the ELF object in
+// data, or nil if the object has no such section.
+func ELFSection(data []byte, name string) ([]byte, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sect := f.Section(name)
+	if sect == nil {
+		return nil, nil
+	}
+	if sect.Type == elf.SHT_NOBITS {
+		return nil, errors.New("section " + name + " has no contents")
+	}
+	return sect.Data()
+}
+
+// ObjectSection returns the contents of the named section of the ELF object
+// at path or, if the file is an archive, of the first member containing the
+// section. If there is no such section, nil is returned.
+func ObjectSection(path, name string) ([]byte, error) {
+	var contents []byte
+	errFound := errors.New("found")
+	err := ForEachObject(path, func(member string, data []byte) error {
+		sect, err := ELFSection(data, name)
+		if err != nil || sect == nil {
+			// Skip members that are not ELF objects.
+			return nil
+		}
+		contents = sect
+		return errFound
+	})
+	if err != nil && err != errFound {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// FindExportFile returns the path of the file containing the export data
+// for the package with the given import path, searching the given
+// directories in the same order as gccgo. If there is no such file, the
+// empty string is returned.
+func FindExportFile(searchpaths []string, pkgpath string) string {
+	for _, spath := range searchpaths {
+		pkgfullpath := filepath.Join(spath, pkgpath)
+		pkgdir, name := filepath.Split(pkgfullpath)
+
+		for _, path := range [...]string{
+			pkgfullpath,
+			pkgfullpath + ".gox",
+			pkgdir + "lib" + name + ".so",
+			pkgdir + "lib" + name + ".a",
+			pkgfullpath + ".o",
+		} {
+			fi, err := os.Stat(path)
+			if err == nil && !fi.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
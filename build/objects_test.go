@@ -0,0 +1,58 @@
+package build_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-llvm/llgo/build"
+)
+
+func arMember(name string, data string) string {
+	s := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 0, 0, 0, 0644, len(data)) + data
+	if len(data)%2 != 0 {
+		s += "\n"
+	}
+	return s
+}
+
+func TestForEachObject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "llgo-build-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	longname := "a_rather_long_member_name.o"
+	archive := "!<arch>\n" +
+		arMember("/", "\x00\x00\x00\x00") +
+		arMember("//", longname+"/\n") +
+		arMember("short.o/", "abc") +
+		arMember("/0", "BC\xc0\xde")
+	path := filepath.Join(dir, "libtest.a")
+	if err := ioutil.WriteFile(path, []byte(archive), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var names, contents []string
+	err = build.ForEachObject(path, func(name string, data []byte) error {
+		names = append(names, name)
+		contents = append(contents, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := []string{"short.o", longname}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("names: %q != %q", names, expected)
+	}
+	if expected := []string{"abc", "BC\xc0\xde"}; !reflect.DeepEqual(contents, expected) {
+		t.Errorf("contents: %q != %q", contents, expected)
+	}
+	if !build.IsBitcode([]byte(contents[1])) {
+		t.Errorf("member %q not recognized as bitcode", names[1])
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	llgobuild "github.com/go-llvm/llgo/build"
+	"github.com/go-llvm/llgo/irgen"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// compileCache is an on-disk cache of compilation results. Entries are keyed
+// by a hash of everything that can affect the output of a compilation: the
+// compiler itself, the driver options, the contents of the input files and
+// the export data of every package they import.
+type compileCache struct {
+	dir string
+}
+
+// cacheEntry is the result of a compilation: the contents of the output
+// file, the export data of the compiled package, and the diagnostics, such
+// as warnings, reported by a successful compilation. The diagnostics are
+// reported again whenever the entry is used.
+type cacheEntry struct {
+	Output      []byte
+	ExportData  []byte
+	Diagnostics irgen.DiagnosticList
+}
+
+// key returns the cache key for compiling inputs with the given options.
+// If the result of the compilation cannot be cached, cacheable is false.
+func (c *compileCache) key(opts *driverOptions, kind actionKind, inputs []string) (key string, cacheable bool, err error) {
+	// The dump options write to stderr as a side effect, and imports
+	// resolved through a gccgo installation are not located by us.
+	if opts.dumpSSA || opts.dumpTrace || opts.dumpEscape != irgen.EscapeDumpNone || opts.dumpBCE || opts.GccgoPath != "" {
+		return "", false, nil
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "llgo %s %s llvm %s\n", irgen.Version(), irgen.GoVersion(), llvm.Version)
+
+	// The version is not bumped for every change to the compiler, so
+	// also key on the contents of the compiler binary. If the binary or
+	// a plugin cannot be identified, the compilation is not cached,
+	// rather than failing.
+	exeHash, err := compilerHash()
+	if err != nil {
+		return "", false, nil
+	}
+	fmt.Fprintf(h, "compiler %x\n", exeHash)
+	for _, plugin := range opts.Plugins {
+		if err := hashFileStat(h, plugin); err != nil {
+			return "", false, nil
+		}
+	}
+
+	fmt.Fprintf(h, "plugin-ep %d\n", opts.PluginEP)
+	fmt.Fprintf(h, "kind %d\n", kind)
+	fmt.Fprintf(h, "triple %q\n", opts.Triple)
+	fmt.Fprintf(h, "target %q %q %v\n", opts.Target.CPU(), opts.Target.FeatureString(), opts.Target.NoRedZone)
+	fmt.Fprintf(h, "pkgpath %q\n", opts.pkgpath)
+	fmt.Fprintf(h, "opt %d %d\n", opts.OptLevel, opts.SizeLevel)
+	fmt.Fprintf(h, "emitIR %v lto %v pic %v\n", opts.EmitIR, opts.LTO, opts.PIC)
+	fmt.Fprintf(h, "open-coded-defers %v\n", opts.openDefers)
+	fmt.Fprintf(h, "freestanding %v %q\n", opts.Freestanding, opts.RuntimePackage)
+	fmt.Fprintf(h, "split-stack %v\n", !opts.NoSplitStack)
+	fmt.Fprintf(h, "debug %v line-tables-only %v gdb-script %q\n", opts.generateDebug, opts.lineTablesOnly, opts.gdbScript)
+	fmt.Fprintf(h, "split-dwarf %q\n", opts.splitDwarfFile)
+	for _, pm := range opts.debugPrefixMaps {
+		fmt.Fprintf(h, "debug-prefix-map %q %q\n", pm.Source, pm.Replacement)
+	}
+	fmt.Fprintf(h, "sanitizer %+v\n", opts.Sanitizer)
+	fmt.Fprintf(h, "ssa-passes %v %q\n", opts.ssaPasses == nil, opts.ssaPasses)
+	for _, arg := range opts.llvmArgs {
+		fmt.Fprintf(h, "mllvm %q\n", arg)
+	}
+
+	fset := token.NewFileSet()
+	imports := make(map[string]bool)
+	for _, input := range inputs {
+		data, err := ioutil.ReadFile(input)
+		if err != nil {
+			return "", false, err
+		}
+		// The file name is recorded in positions and debug info.
+		fmt.Fprintf(h, "input %q %d\n", input, len(data))
+		h.Write(data)
+
+		f, err := parser.ParseFile(fset, input, data, parser.ImportsOnly)
+		if err != nil {
+			// Let the compiler report the error.
+			return "", false, nil
+		}
+		for _, spec := range f.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				return "", false, nil
+			}
+			imports[path] = true
+		}
+	}
+
+	// The signatures of the runtime package's functions are read from
+	// its export data.
+	if opts.RuntimePackage != "" && opts.RuntimePackage != opts.pkgpath {
+		imports[opts.RuntimePackage] = true
+	}
+
+	var importList []string
+	for path := range imports {
+		if path != "unsafe" {
+			importList = append(importList, path)
+		}
+	}
+	sort.Strings(importList)
+
+	searchpaths := append(opts.ImportSearchPaths(), ".")
+	for _, path := range importList {
+		exportFile := llgobuild.FindExportFile(searchpaths, path)
+		if exportFile == "" {
+			// Let the compiler report the error.
+			return "", false, nil
+		}
+		data, err := ioutil.ReadFile(exportFile)
+		if err != nil {
+			return "", false, err
+		}
+		fmt.Fprintf(h, "import %q %q %d\n", path, exportFile, len(data))
+		h.Write(data)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), true, nil
+}
+
+var compilerHashOnce struct {
+	sync.Once
+	sum []byte
+	err error
+}
+
+// compilerHash returns a hash of the contents of the running compiler
+// binary. The binary is read once per process.
+func compilerHash() ([]byte, error) {
+	c := &compilerHashOnce
+	c.Do(func() {
+		exe, err := exec.LookPath(os.Args[0])
+		if err != nil {
+			c.err = err
+			return
+		}
+		f, err := os.Open(exe)
+		if err != nil {
+			c.err = err
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			c.err = err
+			return
+		}
+		c.sum = h.Sum(nil)
+	})
+	return c.sum, c.err
+}
+
+func hashFileStat(h hash.Hash, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "file %q %d %d\n", path, fi.Size(), fi.ModTime().UnixNano())
+	return nil
+}
+
+func (c *compileCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// get returns the cache entry with the given key, or nil if there is none.
+func (c *compileCache) get(key string) *cacheEntry {
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var entry cacheEntry
+	if err := gob.NewDecoder(f).Decode(&entry); err != nil {
+		return nil
+	}
+	return &entry
+}
+
+// put stores entry in the cache under the given key. The entry is written
+// to a temporary file and renamed into place, so that concurrent compilations
+// never observe a partially written entry.
+func (c *compileCache) put(key string, entry *cacheEntry) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return err
+	}
+
+	path := c.path(key)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, &buf)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
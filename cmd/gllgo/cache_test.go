@@ -0,0 +1,82 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-llvm/llgo/irgen"
+)
+
+func TestCacheEntryDiagnostics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "llgo-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pos := token.Position{Filename: "x.go", Line: 3, Column: 2}
+	var diags irgen.DiagnosticList
+	d := diags.Add(pos, irgen.SeverityWarning, "something is odd")
+	d.Soft = true
+	d.Related = []irgen.RelatedPosition{{Pos: pos, Msg: "here"}}
+	entry := &cacheEntry{
+		Output:      []byte("object"),
+		ExportData:  []byte("v1;"),
+		Diagnostics: diags,
+	}
+
+	cache := &compileCache{dir: dir}
+	const key = "0123456789abcdef"
+	if err := cache.put(key, entry); err != nil {
+		t.Fatal(err)
+	}
+	got := cache.get(key)
+	if got == nil {
+		t.Fatal("entry not found")
+	}
+	if !reflect.DeepEqual(got, entry) {
+		t.Errorf("got entry %+v, want %+v", got, entry)
+	}
+	if cache.get("fedcba9876543210") != nil {
+		t.Errorf("found an entry that was never stored")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "llgo-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "x.go")
+	if err := ioutil.WriteFile(input, []byte("package x\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := &compileCache{dir: dir}
+	opts := &driverOptions{pkgpath: "x"}
+	key1, cacheable, err := cache.key(opts, actionCompile, []string{input})
+	if err != nil || !cacheable {
+		t.Fatalf("got cacheable %v, error %v; want a key", cacheable, err)
+	}
+	opts.OptLevel = 2
+	key2, _, _ := cache.key(opts, actionCompile, []string{input})
+	if key1 == key2 {
+		t.Errorf("options do not affect the key")
+	}
+
+	// A plugin that cannot be found prevents caching, rather than
+	// failing the compilation.
+	opts.Plugins = []string{filepath.Join(dir, "missing.so")}
+	if _, cacheable, err := cache.key(opts, actionCompile, []string{input}); err != nil || cacheable {
+		t.Errorf("missing plugin: got cacheable %v, error %v; want neither", cacheable, err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// parseCompressDebug parses the argument of a -gz flag, returning the
+// objcopy name of the requested compression of debug sections, or "" if
+// they are not to be compressed.
+func parseCompressDebug(arg string) (string, error) {
+	switch arg {
+	case "-gz", "-gz=zlib":
+		return "zlib", nil
+	case "-gz=zlib-gnu":
+		return "zlib-gnu", nil
+	case "-gz=none":
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported argument '%s' to '-gz'", arg[4:])
+	}
+}
+
+// splitDwarfFile returns the name of the file holding the split debug
+// information of the object file compiled for output. As for gcc, it is
+// named after the output of the driver, so that the object files linked
+// into a program need not be kept.
+func splitDwarfFile(output string) string {
+	return output[:len(output)-len(filepath.Ext(output))] + ".dwo"
+}
+
+func runObjcopy(opts *driverOptions, args ...string) error {
+	cmd := exec.Command(opts.BPrefix+"objcopy", args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Stderr.Write(out)
+	}
+	return err
+}
+
+// processDebugSections moves the split debug information of the object
+// file at path into its .dwo file, and compresses the debug sections of
+// both, as requested by -gsplit-dwarf and -gz. The object file is written
+// by LLVM with the split debug information in sections of its own.
+func processDebugSections(opts *driverOptions, path string) error {
+	var compress []string
+	if opts.CompressDebug != "" {
+		compress = []string{"--compress-debug-sections=" + opts.CompressDebug}
+	}
+	if opts.splitDwarfFile != "" {
+		if err := runObjcopy(opts, "--extract-dwo", path, opts.splitDwarfFile); err != nil {
+			return err
+		}
+		if err := runObjcopy(opts, append(compress, "--strip-dwo", path)...); err != nil {
+			return err
+		}
+		if compress != nil {
+			return runObjcopy(opts, append(compress, opts.splitDwarfFile)...)
+		}
+		return nil
+	}
+	if compress != nil {
+		return runObjcopy(opts, append(compress, path)...)
+	}
+	return nil
+}
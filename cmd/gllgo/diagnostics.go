@@ -0,0 +1,121 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io"
+	"os"
+
+	"github.com/go-llvm/llgo/irgen"
+)
+
+// diagnosticsFormat is the format in which diagnostics are written to
+// stderr, as selected by -fdiagnostics-format.
+type diagnosticsFormat int
+
+const (
+	// diagnosticsText writes diagnostics in the style of gcc, as
+	// "file:line:col: error: message", or "soft error" for soft errors.
+	diagnosticsText diagnosticsFormat = iota
+
+	// diagnosticsJSON writes each diagnostic as a JSON object on a line
+	// of its own.
+	diagnosticsJSON
+)
+
+func parseDiagnosticsFormat(name string) (diagnosticsFormat, error) {
+	switch name {
+	case "text":
+		return diagnosticsText, nil
+	case "json":
+		return diagnosticsJSON, nil
+	}
+	return 0, fmt.Errorf("unknown diagnostics format '%s'", name)
+}
+
+// jsonDiagnostic is the JSON representation of a diagnostic. Diagnostics
+// without a position, such as errors in command line options, have no
+// file, line or column. Soft errors, such as unused variables, are marked
+// so that tools may present them differently.
+type jsonDiagnostic struct {
+	File     string        `json:"file,omitempty"`
+	Line     int           `json:"line,omitempty"`
+	Column   int           `json:"column,omitempty"`
+	Severity string        `json:"severity"`
+	Soft     bool          `json:"soft,omitempty"`
+	Message  string        `json:"message"`
+	Related  []jsonRelated `json:"related,omitempty"`
+}
+
+type jsonRelated struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func newJSONDiagnostic(pos token.Position, severity irgen.Severity, msg string) *jsonDiagnostic {
+	return &jsonDiagnostic{
+		File:     pos.Filename,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Severity: severity.String(),
+		Message:  msg,
+	}
+}
+
+// writeDiagnostics writes the diagnostics in list to w in the given format.
+func writeDiagnostics(w io.Writer, list irgen.DiagnosticList, format diagnosticsFormat) {
+	for _, d := range list {
+		if format == diagnosticsText {
+			fmt.Fprintf(w, "%s\n", d)
+			continue
+		}
+		jd := newJSONDiagnostic(d.Pos, d.Severity, d.Msg)
+		jd.Soft = d.Soft
+		for _, r := range d.Related {
+			jd.Related = append(jd.Related, jsonRelated{
+				File:    r.Pos.Filename,
+				Line:    r.Pos.Line,
+				Column:  r.Pos.Column,
+				Message: r.Msg,
+			})
+		}
+		writeJSONDiagnostic(w, jd)
+	}
+}
+
+func writeJSONDiagnostic(w io.Writer, jd *jsonDiagnostic) {
+	// A jsonDiagnostic can always be marshalled.
+	data, _ := json.Marshal(jd)
+	fmt.Fprintf(w, "%s\n", data)
+}
+
+// report writes err to stderr in the given format.
+func report(err error, format diagnosticsFormat) {
+	switch err := err.(type) {
+	case nil:
+	case irgen.DiagnosticList:
+		writeDiagnostics(os.Stderr, err, format)
+	case scanner.ErrorList:
+		for _, e := range err {
+			if format == diagnosticsJSON {
+				writeJSONDiagnostic(os.Stderr, newJSONDiagnostic(e.Pos, irgen.SeverityError, e.Msg))
+			} else {
+				fmt.Fprintf(os.Stderr, "%s\n", e)
+			}
+		}
+	default:
+		if format == diagnosticsJSON {
+			writeJSONDiagnostic(os.Stderr, newJSONDiagnostic(token.Position{}, irgen.SeverityError, err.Error()))
+		} else {
+			fmt.Fprintf(os.Stderr, "gllgo: error: %s\n", err)
+		}
+	}
+}
@@ -12,29 +12,20 @@ package main
 import (
 	"errors"
 	"fmt"
-	"go/scanner"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/go-llvm/llgo/debug"
+	"github.com/go-llvm/llgo/driver"
 	"github.com/go-llvm/llgo/irgen"
 	"llvm.org/llvm/bindings/go/llvm"
 )
 
-func report(err error) {
-	if list, ok := err.(scanner.ErrorList); ok {
-		for _, e := range list {
-			fmt.Fprintf(os.Stderr, "%s\n", e)
-		}
-	} else if err != nil {
-		fmt.Fprintf(os.Stderr, "gllgo: error: %s\n", err)
-	}
-}
-
 func displayVersion() {
 	fmt.Printf("llgo version %s (%s)\n", irgen.Version(), irgen.GoVersion())
 	fmt.Println()
@@ -42,21 +33,18 @@ func displayVersion() {
 }
 
 func initCompiler(opts *driverOptions) (*irgen.Compiler, error) {
-	importPaths := make([]string, len(opts.importPaths)+len(opts.libPaths))
-	copy(importPaths, opts.importPaths)
-	copy(importPaths[len(opts.importPaths):], opts.libPaths)
-	if opts.prefix != "" {
-		importPaths = append(importPaths, filepath.Join(opts.prefix, "lib", "go"))
-	}
-	copts := irgen.CompilerOptions{
-		TargetTriple:       opts.triple,
-		GenerateDebug:      opts.generateDebug,
-		DebugPrefixMaps:    opts.debugPrefixMaps,
-		DumpSSA:            opts.dumpSSA,
-		GccgoPath:          opts.gccgoPath,
-		ImportPaths:        importPaths,
-		SanitizerAttribute: opts.sanitizer.getAttribute(),
-	}
+	copts := opts.CompilerOptions()
+	copts.GenerateDebug = opts.generateDebug
+	copts.DebugPrefixMaps = opts.debugPrefixMaps
+	copts.LineTablesOnly = opts.lineTablesOnly
+	copts.SplitDwarfFile = opts.splitDwarfFile
+	copts.DumpSSA = opts.dumpSSA
+	copts.DumpEscape = opts.dumpEscape
+	copts.DumpBCE = opts.dumpBCE
+	copts.SSAPasses = opts.ssaPasses
+	copts.OpenCodedDefers = opts.openDefers
+	copts.MaxErrors = opts.maxErrors
+	copts.GDBScript = opts.gdbScript
 	if opts.dumpTrace {
 		copts.Logger = log.New(os.Stderr, "", 0)
 	}
@@ -77,105 +65,30 @@ type action struct {
 	inputs []string
 }
 
-type sanitizerOptions struct {
-	blacklist string
-	crtPrefix string
-
-	address, thread, memory, dataflow bool
-}
-
-func (san *sanitizerOptions) resourcePath() string {
-	version := strings.Replace(llvm.Version, "svn", "", 1)
-	return filepath.Join(san.crtPrefix, "lib", "clang", version)
-}
-
-func (san *sanitizerOptions) isPIEDefault() bool {
-	return san.thread || san.memory || san.dataflow
-}
-
-func (san *sanitizerOptions) addPasses(mpm, fpm llvm.PassManager) {
-	switch {
-	case san.address:
-		mpm.AddAddressSanitizerModulePass()
-		fpm.AddAddressSanitizerFunctionPass()
-	case san.thread:
-		mpm.AddThreadSanitizerPass()
-	case san.memory:
-		mpm.AddMemorySanitizerPass()
-	case san.dataflow:
-		blacklist := san.blacklist
-		if blacklist == "" {
-			blacklist = filepath.Join(san.resourcePath(), "dfsan_abilist.txt")
-		}
-		mpm.AddDataFlowSanitizerPass(blacklist)
-	}
-}
-
-func (san *sanitizerOptions) libPath(triple, sanitizerName string) string {
-	s := strings.Split(triple, "-")
-	return filepath.Join(san.resourcePath(), "lib", s[2], "libclang_rt."+sanitizerName+"-"+s[0]+".a")
-}
-
-func (san *sanitizerOptions) addLibsForSanitizer(flags []string, triple, sanitizerName string) []string {
-	return append(flags, san.libPath(triple, sanitizerName),
-		"-Wl,--no-as-needed", "-lpthread", "-lrt", "-lm", "-ldl")
-}
-
-func (san *sanitizerOptions) addLibs(triple string, flags []string) []string {
-	switch {
-	case san.address:
-		flags = san.addLibsForSanitizer(flags, triple, "asan")
-	case san.thread:
-		flags = san.addLibsForSanitizer(flags, triple, "tsan")
-	case san.memory:
-		flags = san.addLibsForSanitizer(flags, triple, "msan")
-	case san.dataflow:
-		flags = san.addLibsForSanitizer(flags, triple, "dfsan")
-	}
-
-	return flags
-}
-
-func (san *sanitizerOptions) getAttribute() llvm.Attribute {
-	switch {
-	case san.address:
-		return llvm.SanitizeAddressAttribute
-	case san.thread:
-		return llvm.SanitizeThreadAttribute
-	case san.memory:
-		return llvm.SanitizeMemoryAttribute
-	default:
-		return 0
-	}
-}
-
 type driverOptions struct {
+	driver.Options
+
 	actions []action
 	output  string
 
-	bprefix         string
+	cacheDir        string
 	debugPrefixMaps []debug.PrefixMap
+	diagFormat      diagnosticsFormat
+	dumpBCE         bool
+	dumpEscape      irgen.EscapeDumpFormat
 	dumpSSA         bool
 	dumpTrace       bool
-	emitIR          bool
-	gccgoPath       string
+	gdbScript       string
 	generateDebug   bool
-	importPaths     []string
-	libPaths        []string
+	lineTablesOnly  bool
 	llvmArgs        []string
-	lto             bool
-	optLevel        int
-	pic             bool
-	pieLink         bool
+	maxErrors       int
+	noGDBScript     bool
+	openDefers      bool
 	pkgpath         string
-	plugins         []string
-	prefix          string
-	sanitizer       sanitizerOptions
-	sizeLevel       int
-	staticLibgcc    bool
-	staticLibgo     bool
-	staticLink      bool
-	triple          string
+	splitDwarf      bool
+	splitDwarfFile  string
+	ssaPasses       []string
 }
 
 func getInstPrefix() (string, error) {
@@ -198,7 +111,7 @@ func parseArguments(args []string) (opts driverOptions, err error) {
 	hasOtherNonFlagInputs := false
 	noPrefix := false
 	actionKind := actionLink
-	opts.triple = llvm.DefaultTargetTriple()
+	opts.Triple = llvm.DefaultTargetTriple()
 
 	for len(args) > 0 {
 		consumedArgs := 1
@@ -217,7 +130,7 @@ func parseArguments(args []string) (opts driverOptions, err error) {
 			otherInputs = append(otherInputs, args[0])
 
 		case args[0] == "-B":
-			opts.bprefix = args[1]
+			opts.BPrefix = args[1]
 			consumedArgs = 2
 
 		case args[0] == "-D":
@@ -231,11 +144,11 @@ func parseArguments(args []string) (opts driverOptions, err error) {
 			if len(args) == 1 {
 				return opts, errors.New("missing path after '-I'")
 			}
-			opts.importPaths = append(opts.importPaths, args[1])
+			opts.ImportPaths = append(opts.ImportPaths, args[1])
 			consumedArgs = 2
 
 		case strings.HasPrefix(args[0], "-I"):
-			opts.importPaths = append(opts.importPaths, args[0][2:])
+			opts.ImportPaths = append(opts.ImportPaths, args[0][2:])
 
 		case args[0] == "-isystem":
 			otherInputs = append(otherInputs, args[0], args[1])
@@ -245,27 +158,27 @@ func parseArguments(args []string) (opts driverOptions, err error) {
 			if len(args) == 1 {
 				return opts, errors.New("missing path after '-L'")
 			}
-			opts.libPaths = append(opts.libPaths, args[1])
+			opts.LibPaths = append(opts.LibPaths, args[1])
 			consumedArgs = 2
 
 		case strings.HasPrefix(args[0], "-L"):
-			opts.libPaths = append(opts.libPaths, args[0][2:])
+			opts.LibPaths = append(opts.LibPaths, args[0][2:])
 
 		case args[0] == "-O0":
-			opts.optLevel = 0
+			opts.OptLevel = 0
 
 		case args[0] == "-O1", args[0] == "-O":
-			opts.optLevel = 1
+			opts.OptLevel = 1
 
 		case args[0] == "-O2":
-			opts.optLevel = 2
+			opts.OptLevel = 2
 
 		case args[0] == "-Os":
-			opts.optLevel = 2
-			opts.sizeLevel = 1
+			opts.OptLevel = 2
+			opts.SizeLevel = 1
 
 		case args[0] == "-O3":
-			opts.optLevel = 3
+			opts.OptLevel = 3
 
 		case args[0] == "-S":
 			actionKind = actionAssemble
@@ -273,8 +186,11 @@ func parseArguments(args []string) (opts driverOptions, err error) {
 		case args[0] == "-c":
 			actionKind = actionCompile
 
+		case strings.HasPrefix(args[0], "-fcache-dir="):
+			opts.cacheDir = args[0][12:]
+
 		case strings.HasPrefix(args[0], "-fcompilerrt-prefix="):
-			opts.sanitizer.crtPrefix = args[0][20:]
+			opts.Sanitizer.CrtPrefix = args[0][20:]
 
 		case strings.HasPrefix(args[0], "-fdebug-prefix-map="):
 			split := strings.SplitN(args[0][19:], "=", 2)
@@ -283,14 +199,35 @@ func parseArguments(args []string) (opts driverOptions, err error) {
 			}
 			opts.debugPrefixMaps = append(opts.debugPrefixMaps, debug.PrefixMap{split[0], split[1]})
 
+		case strings.HasPrefix(args[0], "-fdiagnostics-format="):
+			opts.diagFormat, err = parseDiagnosticsFormat(args[0][21:])
+			if err != nil {
+				return opts, err
+			}
+
+		case args[0] == "-fdump-bce":
+			opts.dumpBCE = true
+
+		case args[0] == "-fdump-escape", args[0] == "-fdump-escape=text":
+			opts.dumpEscape = irgen.EscapeDumpText
+
+		case args[0] == "-fdump-escape=json":
+			opts.dumpEscape = irgen.EscapeDumpJSON
+
 		case args[0] == "-fdump-ssa":
 			opts.dumpSSA = true
 
 		case args[0] == "-fdump-trace":
 			opts.dumpTrace = true
 
+		case args[0] == "-ffreestanding":
+			opts.Freestanding = true
+
 		case strings.HasPrefix(args[0], "-fgccgo-path="):
-			opts.gccgoPath = args[0][13:]
+			opts.GccgoPath = args[0][13:]
+
+		case strings.HasPrefix(args[0], "-fgdb-script="):
+			opts.gdbScript = args[0][13:]
 
 		case strings.HasPrefix(args[0], "-fgo-pkgpath="):
 			opts.pkgpath = args[0][13:]
@@ -299,46 +236,106 @@ func parseArguments(args []string) (opts driverOptions, err error) {
 			// TODO(pcc): Handle this.
 
 		case args[0] == "-fload-plugin":
-			opts.plugins = append(opts.plugins, args[1])
+			if len(args) == 1 {
+				return opts, errors.New("missing path after '-fload-plugin'")
+			}
+			opts.Plugins = append(opts.Plugins, args[1])
 			consumedArgs = 2
 
+		case strings.HasPrefix(args[0], "-fplugin-ep="):
+			opts.PluginEP, err = driver.ParsePluginExtensionPoint(args[0][12:])
+			if err != nil {
+				return opts, err
+			}
+
+		case strings.HasPrefix(args[0], "-fmax-errors="):
+			opts.maxErrors, err = strconv.Atoi(args[0][13:])
+			if err != nil || opts.maxErrors < 0 {
+				return opts, errors.New("argument to '-fmax-errors' should be a non-negative integer")
+			}
+
+		case args[0] == "-fno-gdb-script":
+			opts.noGDBScript = true
+
+		case args[0] == "-fno-open-coded-defers":
+			opts.openDefers = false
+
+		case args[0] == "-fopen-coded-defers":
+			opts.openDefers = true
+
+		case args[0] == "-fno-split-stack":
+			opts.NoSplitStack = true
+
+		case args[0] == "-fsplit-stack":
+			opts.NoSplitStack = false
+
 		case args[0] == "-fno-toplevel-reorder":
 			// This is a GCC-specific code generation option. Ignore.
 
+		case strings.HasPrefix(args[0], "-fruntime-package="):
+			opts.RuntimePackage = args[0][18:]
+
 		case args[0] == "-emit-llvm":
-			opts.emitIR = true
+			opts.EmitIR = true
 
 		case args[0] == "-flto":
-			opts.lto = true
+			opts.LTO = true
 
 		case args[0] == "-fPIC":
-			opts.pic = true
+			opts.PIC = true
 
 		case strings.HasPrefix(args[0], "-fsanitize-blacklist="):
-			opts.sanitizer.blacklist = args[0][21:]
+			opts.Sanitizer.Blacklist = args[0][21:]
 
 		// TODO(pcc): Enforce mutual exclusion between sanitizers.
 
 		case args[0] == "-fsanitize=address":
-			opts.sanitizer.address = true
+			opts.Sanitizer.Address = true
 
 		case args[0] == "-fsanitize=thread":
-			opts.sanitizer.thread = true
+			opts.Sanitizer.Thread = true
 
 		case args[0] == "-fsanitize=memory":
-			opts.sanitizer.memory = true
+			opts.Sanitizer.Memory = true
 
 		case args[0] == "-fsanitize=dataflow":
-			opts.sanitizer.dataflow = true
+			opts.Sanitizer.Dataflow = true
+
+		case strings.HasPrefix(args[0], "-fssa-passes="):
+			// An empty list disables the SSA optimization passes.
+			opts.ssaPasses = []string{}
+			if passes := args[0][13:]; passes != "" {
+				opts.ssaPasses = strings.Split(passes, ",")
+			}
 
 		case args[0] == "-g":
 			opts.generateDebug = true
+			opts.lineTablesOnly = false
+
+		case args[0] == "-gline-tables-only":
+			opts.generateDebug = true
+			opts.lineTablesOnly = true
+
+		case args[0] == "-gsplit-dwarf":
+			opts.generateDebug = true
+			opts.splitDwarf = true
+
+		case args[0] == "-gz", strings.HasPrefix(args[0], "-gz="):
+			opts.CompressDebug, err = parseCompressDebug(args[0])
+			if err != nil {
+				return opts, err
+			}
 
 		case args[0] == "-mllvm":
 			opts.llvmArgs = append(opts.llvmArgs, args[1])
 			consumedArgs = 2
 
-		case strings.HasPrefix(args[0], "-m"), args[0] == "-funsafe-math-optimizations", args[0] == "-ffp-contract=off":
+		case strings.HasPrefix(args[0], "-m"):
+			if err := opts.Target.ParseFlag(args[0]); err != nil {
+				return opts, err
+			}
+
+		case args[0] == "-funsafe-math-optimizations", args[0] == "-ffp-contract=off":
 			// TODO(pcc): Handle code generation options.
 
 		case args[0] == "-no-prefix":
@@ -352,7 +349,7 @@ func parseArguments(args []string) (opts driverOptions, err error) {
 			consumedArgs = 2
 
 		case args[0] == "-pie":
-			opts.pieLink = true
+			opts.PIELink = true
 
 		case args[0] == "-print-libgcc-file-name",
 			args[0] == "-print-multi-os-directory",
@@ -361,13 +358,20 @@ func parseArguments(args []string) (opts driverOptions, err error) {
 			opts.output = args[0]
 
 		case args[0] == "-static":
-			opts.staticLink = true
+			opts.StaticLink = true
 
 		case args[0] == "-static-libgcc":
-			opts.staticLibgcc = true
+			opts.StaticLibgcc = true
 
 		case args[0] == "-static-libgo":
-			opts.staticLibgo = true
+			opts.StaticLibgo = true
+
+		case args[0] == "-target":
+			if len(args) == 1 {
+				return opts, errors.New("missing triple after '-target'")
+			}
+			opts.Triple = args[1]
+			consumedArgs = 2
 
 		default:
 			return opts, fmt.Errorf("unrecognized command line option '%s'", args[0])
@@ -376,26 +380,46 @@ func parseArguments(args []string) (opts driverOptions, err error) {
 		args = args[consumedArgs:]
 	}
 
+	if err := opts.Target.Resolve(opts.Triple); err != nil {
+		return opts, err
+	}
+
+	if opts.RuntimePackage != "" && !opts.Freestanding {
+		return opts, errors.New("'-fruntime-package' requires '-ffreestanding'")
+	}
+
+	if opts.NoSplitStack && (opts.Sanitizer.Enabled() || opts.LTO) {
+		return opts, errors.New("'-fno-split-stack' cannot be combined with '-fsanitize' or '-flto'")
+	}
+
 	if actionKind != actionPrint && len(goInputs) == 0 && !hasOtherNonFlagInputs {
 		return opts, errors.New("no input files")
 	}
 
 	if !noPrefix {
-		opts.prefix, err = getInstPrefix()
+		opts.Prefix, err = getInstPrefix()
 		if err != nil {
 			return opts, err
 		}
 	}
 
-	if opts.sanitizer.crtPrefix == "" {
-		opts.sanitizer.crtPrefix = opts.prefix
+	// Refer to the installed pretty-printers by default.
+	if opts.gdbScript == "" && opts.Prefix != "" {
+		opts.gdbScript = filepath.Join(opts.Prefix, "share", "llgo", "llgo-gdb.py")
+	}
+	if opts.noGDBScript {
+		opts.gdbScript = ""
 	}
 
-	if opts.sanitizer.isPIEDefault() {
+	if opts.Sanitizer.CrtPrefix == "" {
+		opts.Sanitizer.CrtPrefix = opts.Prefix
+	}
+
+	if opts.Sanitizer.IsPIEDefault() {
 		// This should really only be turning on -fPIE, but this isn't
 		// easy to do from Go, and -fPIC is a superset of it anyway.
-		opts.pic = true
-		opts.pieLink = true
+		opts.PIC = true
+		opts.PIELink = true
 	}
 
 	switch actionKind {
@@ -430,104 +454,65 @@ func parseArguments(args []string) (opts driverOptions, err error) {
 		}
 	}
 
+	// The debug sections of object files are split and compressed by
+	// objcopy once they have been written.
+	if opts.output == "-" && actionKind == actionCompile && !opts.EmitIR && !opts.LTO {
+		if opts.splitDwarf {
+			return opts, errors.New("'-gsplit-dwarf' requires an output file")
+		}
+		if opts.CompressDebug != "" {
+			return opts, errors.New("'-gz' requires an output file")
+		}
+	}
+	if opts.splitDwarf && opts.output != "-" {
+		opts.splitDwarfFile = splitDwarfFile(opts.output)
+	}
+
 	return opts, nil
 }
 
-func runPasses(opts *driverOptions, tm llvm.TargetMachine, m llvm.Module) {
-	fpm := llvm.NewFunctionPassManagerForModule(m)
-	defer fpm.Dispose()
-
-	mpm := llvm.NewPassManager()
-	defer mpm.Dispose()
-
-	pmb := llvm.NewPassManagerBuilder()
-	defer pmb.Dispose()
-
-	pmb.SetOptLevel(opts.optLevel)
-	pmb.SetSizeLevel(opts.sizeLevel)
-
-	target := tm.TargetData()
-	mpm.Add(target)
-	fpm.Add(target)
-	tm.AddAnalysisPasses(mpm)
-	tm.AddAnalysisPasses(fpm)
-
-	mpm.AddVerifierPass()
-	fpm.AddVerifierPass()
-
-	pmb.Populate(mpm)
-	pmb.PopulateFunc(fpm)
-
-	if opts.optLevel == 0 {
-		// Remove references (via the descriptor) to dead functions,
-		// for compatibility with other compilers.
-		mpm.AddGlobalDCEPass()
+func writeOutput(output string, data []byte) error {
+	if output == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
 	}
+	return ioutil.WriteFile(output, data, 0666)
+}
 
-	opts.sanitizer.addPasses(mpm, fpm)
-
-	fpm.InitializeFunc()
-	for fn := m.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
-		fpm.RunFunc(fn)
+// compile compiles the given Go source files, returning the contents of the
+// output file for the given action together with the package's export data.
+func compile(opts *driverOptions, kind actionKind, inputs []string) (*cacheEntry, error) {
+	compiler, err := initCompiler(opts)
+	if err != nil {
+		return nil, err
 	}
-	fpm.FinalizeFunc()
-
-	mpm.Run(m)
-}
 
-func getMetadataSectionInlineAsm(name string) string {
-	// ELF: creates a non-allocated excluded section.
-	return ".section \"" + name + "\", \"e\"\n"
-}
+	module, err := compiler.Compile(inputs, opts.pkgpath)
+	if err != nil {
+		return nil, err
+	}
+	defer module.Dispose()
 
-func getDataInlineAsm(data []byte) string {
-	edata := make([]byte, len(data)*4+10)
-
-	j := copy(edata, ".ascii \"")
-	for i := range data {
-		switch data[i] {
-		case '\000':
-			edata[j] = '\\'
-			edata[j+1] = '0'
-			edata[j+2] = '0'
-			edata[j+3] = '0'
-			j += 4
-			continue
-		case '\n':
-			edata[j] = '\\'
-			edata[j+1] = 'n'
-			j += 2
-			continue
-		case '"', '\\':
-			edata[j] = '\\'
-			j++
-		}
-		edata[j] = data[i]
-		j++
+	output, err := driver.EmitModule(&opts.Options, module, kind == actionAssemble)
+	if err != nil {
+		writeDiagnostics(os.Stderr, module.Diagnostics, opts.diagFormat)
+		return nil, err
 	}
-	edata[j] = '"'
-	edata[j+1] = '\n'
-	return string(edata[0 : j+2])
+	return &cacheEntry{Output: output, ExportData: module.ExportData, Diagnostics: module.Diagnostics}, nil
 }
 
-// Get the lib-relative path to the standard libraries for the given driver
-// options. This is normally '.' but can vary for cross compilation, LTO,
-// sanitizers etc.
-func getVariantDir(opts *driverOptions) string {
-	switch {
-	case opts.lto:
-		return "llvm-lto.0"
-	case opts.sanitizer.address:
-		return "llvm-asan.0"
-	case opts.sanitizer.thread:
-		return "llvm-tsan.0"
-	case opts.sanitizer.memory:
-		return "llvm-msan.0"
-	case opts.sanitizer.dataflow:
-		return "llvm-dfsan.0"
-	default:
-		return "."
+// writeCompileOutput reports the diagnostics of a compilation and writes
+// its output. The cache holds object files as LLVM emits them, so their
+// debug sections are processed after every write.
+func writeCompileOutput(opts *driverOptions, kind actionKind, output string, entry *cacheEntry) error {
+	writeDiagnostics(os.Stderr, entry.Diagnostics, opts.diagFormat)
+	if err := writeOutput(output, entry.Output); err != nil {
+		return err
 	}
+	if kind == actionCompile && !opts.EmitIR && !opts.LTO {
+		return processDebugSections(opts, output)
+	}
+	return nil
 }
 
 func performAction(opts *driverOptions, kind actionKind, inputs []string, output string) error {
@@ -535,12 +520,12 @@ func performAction(opts *driverOptions, kind actionKind, inputs []string, output
 	case actionPrint:
 		switch opts.output {
 		case "-print-libgcc-file-name":
-			cmd := exec.Command(opts.bprefix+"gcc", "-print-libgcc-file-name")
+			cmd := exec.Command(opts.BPrefix+"gcc", "-print-libgcc-file-name")
 			out, err := cmd.CombinedOutput()
 			os.Stdout.Write(out)
 			return err
 		case "-print-multi-os-directory":
-			fmt.Println(getVariantDir(opts))
+			fmt.Println(driver.VariantDir(&opts.Options))
 			return nil
 		case "--version":
 			displayVersion()
@@ -550,177 +535,38 @@ func performAction(opts *driverOptions, kind actionKind, inputs []string, output
 		}
 
 	case actionCompile, actionAssemble:
-		compiler, err := initCompiler(opts)
-		if err != nil {
-			return err
-		}
-
-		module, err := compiler.Compile(inputs, opts.pkgpath)
-		if err != nil {
-			return err
-		}
-
-		defer module.Dispose()
-
-		target, err := llvm.GetTargetFromTriple(opts.triple)
-		if err != nil {
-			return err
-		}
-
-		optLevel := [...]llvm.CodeGenOptLevel{
-			llvm.CodeGenLevelNone,
-			llvm.CodeGenLevelLess,
-			llvm.CodeGenLevelDefault,
-			llvm.CodeGenLevelAggressive,
-		}[opts.optLevel]
-
-		relocMode := llvm.RelocStatic
-		if opts.pic {
-			relocMode = llvm.RelocPIC
-		}
-
-		tm := target.CreateTargetMachine(opts.triple, "", "", optLevel,
-			relocMode, llvm.CodeModelDefault)
-		defer tm.Dispose()
-
-		runPasses(opts, tm, module.Module)
-
-		var file *os.File
-		if output == "-" {
-			file = os.Stdout
-		} else {
-			file, err = os.Create(output)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-		}
-
-		switch {
-		case !opts.lto && !opts.emitIR:
-			if module.ExportData != nil {
-				asm := getMetadataSectionInlineAsm(".go_export")
-				asm += getDataInlineAsm(module.ExportData)
-				module.Module.SetInlineAsm(asm)
-			}
-
-			fileType := llvm.AssemblyFile
-			if kind == actionCompile {
-				fileType = llvm.ObjectFile
-			}
-			mb, err := tm.EmitToMemoryBuffer(module.Module, fileType)
+		var cache *compileCache
+		var key string
+		if opts.cacheDir != "" {
+			cache = &compileCache{dir: opts.cacheDir}
+			var cacheable bool
+			var err error
+			key, cacheable, err = cache.key(opts, kind, inputs)
 			if err != nil {
 				return err
 			}
-			defer mb.Dispose()
-
-			bytes := mb.Bytes()
-			_, err = file.Write(bytes)
-			return err
-
-		case opts.lto:
-			bcmb := llvm.WriteBitcodeToMemoryBuffer(module.Module)
-			defer bcmb.Dispose()
-
-			// This is a bit of a hack. We just want an object file
-			// containing some metadata sections. This might be simpler
-			// if we had bindings for the MC library, but for now we create
-			// a fresh module containing only inline asm that creates the
-			// sections.
-			outmodule := llvm.NewModule("")
-			defer outmodule.Dispose()
-			asm := getMetadataSectionInlineAsm(".llvmbc")
-			asm += getDataInlineAsm(bcmb.Bytes())
-			if module.ExportData != nil {
-				asm += getMetadataSectionInlineAsm(".go_export")
-				asm += getDataInlineAsm(module.ExportData)
-			}
-			outmodule.SetInlineAsm(asm)
-
-			fileType := llvm.AssemblyFile
-			if kind == actionCompile {
-				fileType = llvm.ObjectFile
+			if !cacheable {
+				cache = nil
+			} else if entry := cache.get(key); entry != nil {
+				return writeCompileOutput(opts, kind, output, entry)
 			}
-			mb, err := tm.EmitToMemoryBuffer(outmodule, fileType)
-			if err != nil {
-				return err
-			}
-			defer mb.Dispose()
-
-			bytes := mb.Bytes()
-			_, err = file.Write(bytes)
-			return err
-
-		case kind == actionCompile:
-			err := llvm.WriteBitcodeToFile(module.Module, file)
-			return err
-
-		case kind == actionAssemble:
-			_, err := file.WriteString(module.Module.String())
-			return err
-
-		default:
-			panic("unexpected action kind")
 		}
 
-	case actionLink:
-		// TODO(pcc): Teach this to do LTO.
-		args := []string{"-o", output}
-		if opts.pic {
-			args = append(args, "-fPIC")
-		}
-		if opts.pieLink {
-			args = append(args, "-pie")
-		}
-		if opts.staticLink {
-			args = append(args, "-static")
-		}
-		if opts.staticLibgcc {
-			args = append(args, "-static-libgcc")
-		}
-		for _, p := range opts.libPaths {
-			args = append(args, "-L", p)
-		}
-		for _, p := range opts.importPaths {
-			args = append(args, "-I", p)
+		entry, err := compile(opts, kind, inputs)
+		if err != nil {
+			return err
 		}
-		args = append(args, inputs...)
-		var linkerPath string
-		if opts.gccgoPath == "" {
-			// TODO(pcc): See if we can avoid calling gcc here.
-			// We currently rely on it to find crt*.o and compile
-			// any C source files passed as arguments.
-			linkerPath = opts.bprefix + "gcc"
-
-			if opts.prefix != "" {
-				libdir := filepath.Join(opts.prefix, "lib", getVariantDir(opts))
-				args = append(args, "-L", libdir)
-				if !opts.staticLibgo {
-					args = append(args, "-Wl,-rpath,"+libdir)
-				}
-			}
 
-			args = append(args, "-lgobegin")
-			if opts.staticLibgo {
-				args = append(args, "-Wl,-Bstatic", "-lgo", "-Wl,-Bdynamic", "-lpthread", "-lm")
-			} else {
-				args = append(args, "-lgo")
-			}
-		} else {
-			linkerPath = opts.gccgoPath
-			if opts.staticLibgo {
-				args = append(args, "-static-libgo")
-			}
+		if cache != nil {
+			// Failing to populate the cache does not affect the
+			// result of the compilation.
+			cache.put(key, entry)
 		}
 
-		args = opts.sanitizer.addLibs(opts.triple, args)
+		return writeCompileOutput(opts, kind, output, entry)
 
-		cmd := exec.Command(linkerPath, args...)
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			os.Stderr.Write(out)
-		}
-		return err
+	case actionLink:
+		return driver.Link(&opts.Options, inputs, output)
 
 	default:
 		panic("unexpected action kind")
@@ -730,14 +576,15 @@ func performAction(opts *driverOptions, kind actionKind, inputs []string, output
 func performActions(opts *driverOptions) error {
 	var extraInput string
 
-	for _, plugin := range opts.plugins {
-		err := llvm.LoadLibraryPermanently(plugin)
-		if err != nil {
-			return err
-		}
+	if err := driver.LoadPlugins(&opts.Options); err != nil {
+		return err
 	}
 
-	llvm.ParseCommandLineOptions(append([]string{"llgo"}, opts.llvmArgs...), "llgo (LLVM option parsing)\n")
+	llvmArgs := append([]string{"llgo"}, opts.llvmArgs...)
+	if opts.splitDwarfFile != "" {
+		llvmArgs = append(llvmArgs, "-split-dwarf=Enable")
+	}
+	llvm.ParseCommandLineOptions(llvmArgs, "llgo (LLVM option parsing)\n")
 
 	for i, action := range opts.actions {
 		var output string
@@ -782,13 +629,13 @@ func main() {
 
 	opts, err := parseArguments(os.Args[1:])
 	if err != nil {
-		report(err)
+		report(err, opts.diagFormat)
 		os.Exit(1)
 	}
 
 	err = performActions(&opts)
 	if err != nil {
-		report(err)
+		report(err, opts.diagFormat)
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,98 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	arMagic     = "!<arch>\n"
+	arHeaderLen = 60
+)
+
+// writeArchive writes an ar archive containing a single ELF object, along
+// with the GNU-style symbol index that the linker requires in order to
+// resolve symbols from the archive.
+func writeArchive(path, member string, obj []byte) error {
+	syms, err := definedSymbols(obj)
+	if err != nil {
+		return err
+	}
+
+	var index bytes.Buffer
+	var names bytes.Buffer
+	for _, sym := range syms {
+		names.WriteString(sym)
+		names.WriteByte(0)
+	}
+	// The index consists of the number of symbols, followed by the offset
+	// of the member header defining each symbol, followed by the symbol
+	// names. All numbers are 32-bit big endian.
+	indexLen := 4 + 4*len(syms) + names.Len()
+	memberOffset := len(arMagic) + arHeaderLen + indexLen + indexLen%2
+	binary.Write(&index, binary.BigEndian, uint32(len(syms)))
+	for _ = range syms {
+		binary.Write(&index, binary.BigEndian, uint32(memberOffset))
+	}
+	index.Write(names.Bytes())
+
+	var ar bytes.Buffer
+	ar.WriteString(arMagic)
+	if len(syms) != 0 {
+		writeArchiveMember(&ar, "/", index.Bytes())
+	} else {
+		memberOffset = len(arMagic)
+	}
+	if len(member) > 15 {
+		// Long names require an extended name table, which we don't
+		// need, as the member name does not affect linking.
+		member = member[:15]
+	}
+	writeArchiveMember(&ar, member+"/", obj)
+
+	return ioutil.WriteFile(path, ar.Bytes(), 0666)
+}
+
+func writeArchiveMember(ar *bytes.Buffer, name string, data []byte) {
+	fmt.Fprintf(ar, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 0, 0, 0, 0644, len(data))
+	ar.Write(data)
+	if len(data)%2 != 0 {
+		ar.WriteByte('\n')
+	}
+}
+
+// definedSymbols returns the names of the global symbols defined by the
+// given ELF object.
+func definedSymbols(obj []byte) ([]string, error) {
+	f, err := elf.NewFile(bytes.NewReader(obj))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	syms, err := f.Symbols()
+	if err != nil {
+		// An object with no symbol table defines no symbols.
+		return nil, nil
+	}
+
+	var names []string
+	for _, sym := range syms {
+		bind := elf.ST_BIND(sym.Info)
+		if bind != elf.STB_GLOBAL && bind != elf.STB_WEAK {
+			continue
+		}
+		if sym.Section == elf.SHN_UNDEF || sym.Name == "" {
+			continue
+		}
+		names = append(names, sym.Name)
+	}
+	return names, nil
+}
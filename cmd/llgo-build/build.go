@@ -0,0 +1,286 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	llgobuild "github.com/go-llvm/llgo/build"
+	"github.com/go-llvm/llgo/driver"
+)
+
+// pkgAction is a node in the package dependency graph. Each action compiles
+// one package; it may only start once the actions for all of its
+// dependencies have completed.
+type pkgAction struct {
+	bpkg *build.Package
+	deps []*pkgAction
+
+	// importpath is the path by which the compiler finds the package.
+	// This is its import path, or for packages outside of GOPATH, its
+	// directory relative to the current directory.
+	importpath string
+
+	// output is the archive (or, for commands, the object file) that
+	// the package is compiled to.
+	output string
+
+	done chan struct{}
+	err  error
+}
+
+type builder struct {
+	opts    *buildOptions
+	ctx     *llgobuild.Context
+	cwd     string
+	workdir string
+
+	actions map[string]*pkgAction
+	// order lists the actions such that each action follows its
+	// dependencies.
+	order []*pkgAction
+
+	// compile performs an action. It is called concurrently for
+	// actions whose dependencies have been built.
+	compile func(a *pkgAction) error
+}
+
+func newBuilder(opts *buildOptions) (*builder, error) {
+	ctx, err := llgobuild.ContextFromTriple(opts.Triple)
+	if err != nil {
+		return nil, err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	workdir, err := ioutil.TempDir("", "llgo-build")
+	if err != nil {
+		return nil, err
+	}
+	if opts.keepWork {
+		fmt.Fprintf(os.Stderr, "WORK=%s\n", workdir)
+	}
+
+	// Export data for packages built by us is written to the work
+	// directory; the standard library's is installed with libgo.
+	opts.ImportPaths = append(opts.ImportPaths, workdir)
+
+	b := &builder{
+		opts:    opts,
+		ctx:     ctx,
+		cwd:     cwd,
+		workdir: workdir,
+		actions: make(map[string]*pkgAction),
+	}
+	b.compile = b.compileAction
+	return b, nil
+}
+
+func (b *builder) cleanup() {
+	if !b.opts.keepWork {
+		os.RemoveAll(b.workdir)
+	}
+}
+
+// resolve locates the package with the given import path, and the packages
+// it depends on, adding an action for each to the graph. Packages in GOROOT
+// are provided by libgo, and so have no action.
+func (b *builder) resolve(importpath, srcDir string, stack []string) (*pkgAction, error) {
+	bpkg, err := b.ctx.Import(importpath, srcDir, 0)
+	if err != nil {
+		return nil, err
+	}
+	if bpkg.Goroot {
+		return nil, nil
+	}
+	if a, ok := b.actions[bpkg.ImportPath]; ok {
+		if a == nil {
+			return nil, fmt.Errorf("import cycle not allowed: %s -> %s", strings.Join(stack, " -> "), bpkg.ImportPath)
+		}
+		return a, nil
+	}
+	if len(bpkg.CgoFiles) != 0 || len(bpkg.CFiles) != 0 || len(bpkg.SFiles) != 0 {
+		return nil, fmt.Errorf("%s: packages containing cgo, C or assembly files are not supported", bpkg.ImportPath)
+	}
+
+	// Mark the package as in progress, for cycle detection.
+	b.actions[bpkg.ImportPath] = nil
+	stack = append(stack, bpkg.ImportPath)
+
+	a := &pkgAction{
+		bpkg:       bpkg,
+		importpath: bpkg.ImportPath,
+		done:       make(chan struct{}),
+	}
+	if build.IsLocalImport(bpkg.ImportPath) {
+		rel, err := filepath.Rel(b.cwd, bpkg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		a.importpath = "./" + filepath.ToSlash(rel)
+	}
+	for _, imp := range bpkg.Imports {
+		if imp == "C" {
+			continue
+		}
+		dep, err := b.resolve(imp, bpkg.Dir, stack)
+		if err != nil {
+			return nil, err
+		}
+		if dep != nil {
+			a.deps = append(a.deps, dep)
+		}
+	}
+
+	dir, name := path.Split(bpkg.ImportPath)
+	if bpkg.Name == "main" {
+		a.output = filepath.Join(b.workdir, filepath.FromSlash(bpkg.ImportPath), "_main.o")
+	} else {
+		// Place the archive where gccgoimporter will find it
+		// when searching the work directory.
+		a.output = filepath.Join(b.workdir, filepath.FromSlash(dir), "lib"+name+".a")
+	}
+
+	b.actions[bpkg.ImportPath] = a
+	b.order = append(b.order, a)
+	return a, nil
+}
+
+// build builds the packages with the given import paths, and links the
+// command among them, if any.
+func (b *builder) build(importpaths []string) error {
+	// In freestanding mode, every package calls the runtime package,
+	// which is built and linked like any other package.
+	var rt *pkgAction
+	if b.opts.Freestanding && b.opts.RuntimePackage != "" {
+		var err error
+		rt, err = b.resolve(b.opts.RuntimePackage, b.cwd, nil)
+		if err != nil {
+			return err
+		}
+		if rt == nil {
+			return fmt.Errorf("%s: standard library packages are provided by libgo", b.opts.RuntimePackage)
+		}
+	}
+	rtDeps := len(b.order)
+
+	var roots []*pkgAction
+	for _, importpath := range importpaths {
+		a, err := b.resolve(importpath, b.cwd, nil)
+		if err != nil {
+			return err
+		}
+		if a == nil {
+			return fmt.Errorf("%s: standard library packages are provided by libgo", importpath)
+		}
+		roots = append(roots, a)
+	}
+
+	var mainAction *pkgAction
+	for _, a := range roots {
+		if a.bpkg.Name != "main" {
+			continue
+		}
+		if mainAction != nil {
+			return errors.New("cannot build more than one command at a time")
+		}
+		mainAction = a
+	}
+	if mainAction == nil && b.opts.output != "" {
+		return errors.New("-o requires a command to link")
+	}
+	if rt != nil {
+		for _, a := range b.order[rtDeps:] {
+			a.deps = append(a.deps, rt)
+		}
+	}
+
+	if err := b.runActions(); err != nil {
+		return err
+	}
+
+	if mainAction != nil {
+		return b.link(mainAction)
+	}
+	return nil
+}
+
+// runActions compiles every package in the graph, running at most
+// b.opts.jobs actions at a time.
+func (b *builder) runActions() error {
+	sema := make(chan struct{}, b.opts.jobs)
+	var wg sync.WaitGroup
+	for _, a := range b.order {
+		wg.Add(1)
+		go func(a *pkgAction) {
+			defer wg.Done()
+			defer close(a.done)
+			for _, dep := range a.deps {
+				<-dep.done
+				if dep.err != nil {
+					a.err = fmt.Errorf("%s: dependency %s failed to build", a.bpkg.ImportPath, dep.bpkg.ImportPath)
+					return
+				}
+			}
+			sema <- struct{}{}
+			a.err = b.compile(a)
+			<-sema
+		}(a)
+	}
+	wg.Wait()
+
+	// Report the first failure in dependency order, which is the root
+	// cause of any failures depending on it.
+	for _, a := range b.order {
+		if a.err != nil {
+			return a.err
+		}
+	}
+	return nil
+}
+
+// compileAction compiles the package built by a.
+func (b *builder) compileAction(a *pkgAction) error {
+	if b.opts.verbose {
+		fmt.Fprintf(os.Stderr, "compile -o %s %s\n", a.output, a.importpath)
+	}
+	return compilePackage(b.opts, a.importpath, a.output)
+}
+
+// link links the command built by a, together with the packages it
+// depends on.
+func (b *builder) link(a *pkgAction) error {
+	output := b.opts.output
+	if output == "" {
+		output = path.Base(a.bpkg.ImportPath)
+		if output == "." || output == "/" {
+			output = "a.out"
+		}
+	}
+
+	// Archives must be listed after the archives that depend on them.
+	inputs := []string{a.output}
+	for i := len(b.order) - 1; i >= 0; i-- {
+		if dep := b.order[i]; dep != a {
+			inputs = append(inputs, dep.output)
+		}
+	}
+
+	if b.opts.verbose {
+		fmt.Fprintf(os.Stderr, "link -o %s %s\n", output, strings.Join(inputs, " "))
+	}
+	return driver.Link(&b.opts.Options, inputs, output)
+}
@@ -0,0 +1,200 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	llgobuild "github.com/go-llvm/llgo/build"
+)
+
+// newTestBuilder returns a builder for a GOPATH holding the given packages,
+// each given as a map from file names to their contents. The builder's
+// compile function must be set by the caller.
+func newTestBuilder(t *testing.T, jobs int, pkgs map[string]map[string]string) (*builder, func()) {
+	dir, err := ioutil.TempDir("", "llgo-build-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, files := range pkgs {
+		pkgdir := filepath.Join(dir, "src", filepath.FromSlash(path))
+		if err := os.MkdirAll(pkgdir, 0777); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+		for name, src := range files {
+			if err := ioutil.WriteFile(filepath.Join(pkgdir, name), []byte(src), 0666); err != nil {
+				os.RemoveAll(dir)
+				t.Fatal(err)
+			}
+		}
+	}
+	ctx, err := llgobuild.ContextFromTriple("x86_64-unknown-linux-gnu")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	ctx.GOPATH = dir
+	b := &builder{
+		opts:    &buildOptions{jobs: jobs},
+		ctx:     ctx,
+		cwd:     dir,
+		workdir: filepath.Join(dir, "work"),
+		actions: make(map[string]*pkgAction),
+	}
+	return b, func() { os.RemoveAll(dir) }
+}
+
+// pkg returns the files of a package with the given name, importing the
+// given packages.
+func pkg(name string, imports ...string) map[string]string {
+	src := "package " + name + "\n"
+	for _, imp := range imports {
+		src += "import _ \"" + imp + "\"\n"
+	}
+	return map[string]string{"x.go": src}
+}
+
+var wide = map[string]map[string]string{
+	"prog": pkg("main", "p1", "p2", "p3", "p4"),
+	"p1":   pkg("p1", "base"),
+	"p2":   pkg("p2", "base"),
+	"p3":   pkg("p3", "base"),
+	"p4":   pkg("p4", "base"),
+	"base": pkg("base"),
+}
+
+func TestBuildOrder(t *testing.T) {
+	const jobs = 2
+	b, cleanup := newTestBuilder(t, jobs, wide)
+	defer cleanup()
+
+	var mu sync.Mutex
+	built := make(map[string]bool)
+	running, maxRunning := 0, 0
+	b.compile = func(a *pkgAction) error {
+		mu.Lock()
+		for _, dep := range a.deps {
+			if !built[dep.bpkg.ImportPath] {
+				t.Errorf("%s compiled before its dependency %s", a.bpkg.ImportPath, dep.bpkg.ImportPath)
+			}
+		}
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		built[a.bpkg.ImportPath] = true
+		mu.Unlock()
+		return nil
+	}
+
+	if _, err := b.resolve("prog", b.cwd, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.order[0].bpkg.ImportPath; got != "base" {
+		t.Errorf("first action is %s, want base", got)
+	}
+	if got := b.order[len(b.order)-1].bpkg.ImportPath; got != "prog" {
+		t.Errorf("last action is %s, want prog", got)
+	}
+	if err := b.runActions(); err != nil {
+		t.Fatal(err)
+	}
+	if len(built) != len(wide) {
+		t.Errorf("built %d packages, want %d", len(built), len(wide))
+	}
+	if maxRunning > jobs {
+		t.Errorf("%d actions ran at once, want at most %d", maxRunning, jobs)
+	}
+	if maxRunning < 2 {
+		t.Errorf("independent actions did not run in parallel")
+	}
+}
+
+func TestBuildFailure(t *testing.T) {
+	b, cleanup := newTestBuilder(t, 4, wide)
+	defer cleanup()
+
+	errBad := errors.New("p2 does not compile")
+	var mu sync.Mutex
+	built := make(map[string]bool)
+	b.compile = func(a *pkgAction) error {
+		if a.bpkg.ImportPath == "p2" {
+			return errBad
+		}
+		mu.Lock()
+		built[a.bpkg.ImportPath] = true
+		mu.Unlock()
+		return nil
+	}
+
+	// The failure is reported rather than that of the command depending
+	// on it, and the packages not depending on it are still built.
+	if err := b.build([]string{"prog"}); err != errBad {
+		t.Errorf("got error %v, want %v", err, errBad)
+	}
+	if built["prog"] {
+		t.Errorf("prog built despite the failure of its dependency")
+	}
+	for _, path := range []string{"base", "p1", "p3", "p4"} {
+		if !built[path] {
+			t.Errorf("%s not built", path)
+		}
+	}
+	if err := b.actions["prog"].err; err == nil || !strings.Contains(err.Error(), "dependency p2 failed") {
+		t.Errorf("got error %v for prog, want a dependency failure", err)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	pkgs := map[string]map[string]string{
+		"cycle1": pkg("cycle1", "cycle2"),
+		"cycle2": pkg("cycle2", "cycle1"),
+		"cfile": map[string]string{
+			"x.go": "package cfile\n",
+			"x.c":  "int x;\n",
+		},
+		"prog1": pkg("main"),
+		"prog2": pkg("main"),
+		"lib":   pkg("lib"),
+	}
+	tests := []struct {
+		pkgs   []string
+		output string
+		err    string
+	}{
+		{[]string{"cycle1"}, "", "import cycle not allowed: cycle1 -> cycle2 -> cycle1"},
+		{[]string{"cfile"}, "", "cfile: packages containing cgo, C or assembly files are not supported"},
+		{[]string{"prog1", "prog2"}, "", "cannot build more than one command at a time"},
+		{[]string{"lib"}, "lib.out", "-o requires a command to link"},
+		{[]string{"fmt"}, "", "fmt: standard library packages are provided by libgo"},
+	}
+	for _, test := range tests {
+		b, cleanup := newTestBuilder(t, 1, pkgs)
+		b.opts.output = test.output
+		b.compile = func(a *pkgAction) error {
+			t.Errorf("%v: unexpected compilation of %s", test.pkgs, a.bpkg.ImportPath)
+			return nil
+		}
+		err := b.build(test.pkgs)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%v: got error %v, want %q", test.pkgs, err, test.err)
+		}
+		cleanup()
+	}
+}
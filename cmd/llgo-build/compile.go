@@ -0,0 +1,67 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	llgobuild "github.com/go-llvm/llgo/build"
+	"github.com/go-llvm/llgo/driver"
+	"github.com/go-llvm/llgo/irgen"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// compilePackage compiles the package with the given import path to output:
+// an archive, or for a command, an object file. The packages it imports are
+// read from export data, and so must have been built already. Each package
+// is compiled in an LLVM context of its own, so that packages may be
+// compiled concurrently.
+func compilePackage(opts *buildOptions, importpath, output string) error {
+	ctx, err := llgobuild.ContextFromTriple(opts.Triple)
+	if err != nil {
+		return err
+	}
+
+	llvmctx := llvm.NewContext()
+	defer llvmctx.Dispose()
+
+	copts := opts.CompilerOptions()
+	copts.LLVMContext = llvmctx
+	copts.BinaryImports = true
+	copts.GenerateDebug = opts.debug
+	if opts.Prefix != "" {
+		copts.GDBScript = filepath.Join(opts.Prefix, "share", "llgo", "llgo-gdb.py")
+	}
+	compiler, err := irgen.NewCompiler(copts)
+	if err != nil {
+		return err
+	}
+	modules, err := compiler.CompilePackages(&ctx.Context, []string{importpath})
+	if err != nil {
+		return err
+	}
+	m := modules[0]
+	defer m.Dispose()
+	for _, d := range m.Diagnostics {
+		fmt.Fprintf(os.Stderr, "%s\n", d)
+	}
+
+	obj, err := driver.EmitModule(&opts.Options, m, false)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0777); err != nil {
+		return err
+	}
+	if m.Path == "main" {
+		return ioutil.WriteFile(output, obj, 0666)
+	}
+	return writeArchive(output, path.Base(m.Path)+".o", obj)
+}
@@ -0,0 +1,205 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// llgo-build builds Go packages and commands using llgo, without going
+// through the go tool.
+//
+// Usage:
+//
+//	llgo-build [flags] [packages]
+//
+// The named packages, and any of their dependencies outside of the standard
+// library, are compiled in dependency order. Packages whose dependencies
+// have been built are compiled in parallel, each in an LLVM context of its
+// own. The standard library is provided by the installed libgo. If one of
+// the packages is a command, it is linked as by the llgo driver.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/scanner"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/go-llvm/llgo/driver"
+	"github.com/go-llvm/llgo/irgen"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// buildOptions holds the options controlling a build. The flags share their
+// names and meaning with the llgo driver options where they exist.
+type buildOptions struct {
+	driver.Options
+
+	output   string
+	debug    bool
+	jobs     int
+	keepWork bool
+	verbose  bool
+}
+
+// stringList is a flag that may be repeated, accumulating its values.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func report(err error) {
+	switch list := err.(type) {
+	case nil:
+	case irgen.DiagnosticList:
+		for _, d := range list {
+			fmt.Fprintf(os.Stderr, "%s\n", d)
+		}
+	case scanner.ErrorList:
+		for _, e := range list {
+			fmt.Fprintf(os.Stderr, "%s\n", e)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "llgo-build: %s\n", err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: llgo-build [flags] [packages]\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func getInstPrefix() (string, error) {
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return "", err
+	}
+
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := filepath.Join(path, "..", "..")
+	return prefix, nil
+}
+
+func parseArguments() (opts buildOptions, pkgs []string, err error) {
+	var sanitizer, pluginEP, arch, cpu string
+	var noPrefix bool
+	flag.StringVar(&opts.output, "o", "", "write the linked command to this file")
+	flag.StringVar(&opts.Triple, "target", llvm.DefaultTargetTriple(), "target triple")
+	flag.IntVar(&opts.OptLevel, "O", 0, "optimization level (0-3)")
+	flag.StringVar(&sanitizer, "fsanitize", "", "sanitizer variant (address, thread, memory or dataflow)")
+	flag.BoolVar(&opts.LTO, "flto", false, "emit LLVM bitcode for link-time optimization")
+	flag.BoolVar(&opts.debug, "g", false, "generate debug information")
+	flag.StringVar(&arch, "march", "", "architecture or CPU to generate code for, as named by gcc")
+	flag.StringVar(&cpu, "mcpu", "", "CPU to generate code for, if -march is not given")
+	flag.BoolVar(&opts.NoSplitStack, "fno-split-stack", false, "compile functions without split-stack prologues")
+	flag.BoolVar(&opts.Freestanding, "ffreestanding", false, "compile code that does not depend on libgo")
+	flag.StringVar(&opts.RuntimePackage, "fruntime-package", "", "package providing the runtime functions (requires -ffreestanding)")
+	flag.Var((*stringList)(&opts.Plugins), "fload-plugin", "load the LLVM passes in this plugin (may be repeated)")
+	flag.StringVar(&pluginEP, "fplugin-ep", "early", "where the passes of plugins run (early, scalar or codegen)")
+	flag.Var((*stringList)(&opts.ImportPaths), "I", "search this directory for export data (may be repeated)")
+	flag.IntVar(&opts.jobs, "j", runtime.NumCPU(), "number of packages to build in parallel")
+	flag.StringVar(&opts.Prefix, "prefix", "", "llgo installation prefix (default: derived from the location of llgo-build)")
+	flag.BoolVar(&noPrefix, "no-prefix", false, "do not use an llgo installation prefix")
+	flag.BoolVar(&opts.keepWork, "work", false, "print the name of the temporary work directory and do not delete it")
+	flag.BoolVar(&opts.verbose, "x", false, "print the commands run")
+	flag.Usage = usage
+	flag.Parse()
+
+	pkgs = flag.Args()
+
+	if opts.OptLevel < 0 || opts.OptLevel > 3 {
+		return opts, nil, fmt.Errorf("invalid optimization level %d", opts.OptLevel)
+	}
+	if sanitizer != "" && !opts.Sanitizer.Parse(sanitizer) {
+		return opts, nil, fmt.Errorf("unknown sanitizer '%s'", sanitizer)
+	}
+	if opts.Sanitizer.Enabled() && opts.LTO {
+		return opts, nil, fmt.Errorf("-flto cannot be combined with -fsanitize")
+	}
+	if opts.NoSplitStack && (opts.Sanitizer.Enabled() || opts.LTO) {
+		return opts, nil, fmt.Errorf("-fno-split-stack cannot be combined with -fsanitize or -flto")
+	}
+	if opts.RuntimePackage != "" && !opts.Freestanding {
+		return opts, nil, fmt.Errorf("-fruntime-package requires -ffreestanding")
+	}
+	if opts.PluginEP, err = driver.ParsePluginExtensionPoint(pluginEP); err != nil {
+		return opts, nil, err
+	}
+	if cpu != "" {
+		if err := opts.Target.ParseFlag("-mcpu=" + cpu); err != nil {
+			return opts, nil, err
+		}
+	}
+	if arch != "" {
+		if err := opts.Target.ParseFlag("-march=" + arch); err != nil {
+			return opts, nil, err
+		}
+	}
+	if err := opts.Target.Resolve(opts.Triple); err != nil {
+		return opts, nil, err
+	}
+	if opts.jobs < 1 {
+		opts.jobs = 1
+	}
+
+	if noPrefix {
+		opts.Prefix = ""
+	} else if opts.Prefix == "" {
+		opts.Prefix, err = getInstPrefix()
+		if err != nil {
+			return opts, nil, err
+		}
+	}
+	opts.Sanitizer.CrtPrefix = opts.Prefix
+	if opts.Sanitizer.IsPIEDefault() {
+		opts.PIC = true
+		opts.PIELink = true
+	}
+
+	if len(pkgs) == 0 {
+		pkgs = []string{"."}
+	}
+	return opts, pkgs, nil
+}
+
+func main() {
+	llvm.InitializeAllTargets()
+	llvm.InitializeAllTargetMCs()
+	llvm.InitializeAllTargetInfos()
+	llvm.InitializeAllAsmParsers()
+	llvm.InitializeAllAsmPrinters()
+
+	opts, pkgs, err := parseArguments()
+	if err != nil {
+		report(err)
+		os.Exit(2)
+	}
+
+	if err := driver.LoadPlugins(&opts.Options); err != nil {
+		report(err)
+		os.Exit(1)
+	}
+
+	b, err := newBuilder(&opts)
+	if err != nil {
+		report(err)
+		os.Exit(1)
+	}
+
+	err = b.build(pkgs)
+	b.cleanup()
+	if err != nil {
+		report(err)
+		os.Exit(1)
+	}
+}
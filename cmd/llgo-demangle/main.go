@@ -0,0 +1,51 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// llgo-demangle demangles the symbol names given as arguments, or if there
+// are no arguments, the symbol names in its standard input, in the manner
+// of c++filt. Names that were not mangled by llgo are left unchanged.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/go-llvm/llgo/mangle"
+)
+
+// symbolRegexp matches the candidate symbol names in a line of input. The
+// names of function literals may contain the go/ssa name of a method, as in
+// "(*main.T).m$1", so parenthesized receivers are matched too.
+var symbolRegexp = regexp.MustCompile(`[\w.$:/-]+(\(\*?[\w./$-]+\)[\w.$:/-]*)*`)
+
+func demangle(sym string) string {
+	if name, err := mangle.Demangle(sym); err == nil {
+		return name
+	}
+	return sym
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		for _, sym := range os.Args[1:] {
+			fmt.Println(demangle(sym))
+		}
+		return
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	for {
+		line, err := stdin.ReadString('\n')
+		os.Stdout.WriteString(symbolRegexp.ReplaceAllStringFunc(line, demangle))
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "llgo-demangle: %s\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -7,10 +7,13 @@ package debug
 import (
 	"debug/dwarf"
 	"fmt"
+	"go/ast"
 	"go/token"
 	"os"
+	"sort"
 	"strings"
 
+	"github.com/go-llvm/llgo/mangle"
 	"golang.org/x/tools/go/ssa"
 	"golang.org/x/tools/go/types"
 	"golang.org/x/tools/go/types/typeutil"
@@ -22,12 +25,29 @@ const (
 	// non-standard debug metadata tags
 	tagAutoVariable dwarf.Tag = 0x100
 	tagArgVariable  dwarf.Tag = 0x101
+
+	// flagArtificial marks compiler-generated variables and members.
+	flagArtificial = 1 << 6
 )
 
 type PrefixMap struct {
 	Source, Replacement string
 }
 
+// EmissionKind selects how much of the program is described by the debug
+// metadata. The values are those of LLVM's DIBuilder::DebugEmissionKind.
+type EmissionKind int
+
+const (
+	// FullDebug describes the functions, types and variables of the
+	// program.
+	FullDebug EmissionKind = 1
+
+	// LineTablesOnly describes only the functions of the program, which
+	// is enough for the line tables and for symbolizing backtraces.
+	LineTablesOnly EmissionKind = 2
+)
+
 // DIBuilder builds debug metadata for Go programs.
 type DIBuilder struct {
 	// builder is the current builder; there is one per CU.
@@ -40,18 +60,57 @@ type DIBuilder struct {
 	sizes      types.Sizes
 	fset       *token.FileSet
 	prefixMaps []PrefixMap
+	kind       EmissionKind
+	splitName  string
 	types      typeutil.Map
 	voidType   llvm.Value
+
+	// funcTypes holds the descriptors of func values, which are kept
+	// apart from the subroutine types of their signatures.
+	funcTypes typeutil.Map
+
+	// typeDescriptorType and chanType describe libgo's type
+	// descriptors and channels, which do not depend on the types they
+	// describe or carry.
+	typeDescriptorType llvm.Value
+	chanType           llvm.Value
+
+	// scopes holds the local scopes of the package, ordered by
+	// position, and scopePos the position of each.
+	scopes     []scopeExtent
+	scopePos   map[*types.Scope]token.Pos
+	funcScopes map[*types.Scope]bool
+
+	// blocks and vars hold the lexical blocks and variables created
+	// for the current function.
+	blocks map[*types.Scope]llvm.Value
+	vars   map[*types.Var]llvm.Value
+}
+
+// scopeExtent is the extent of the source code covered by a local scope.
+type scopeExtent struct {
+	scope    *types.Scope
+	pos, end token.Pos
 }
 
-// NewDIBuilder creates a new debug information builder.
-func NewDIBuilder(sizes types.Sizes, module llvm.Module, fset *token.FileSet, prefixMaps []PrefixMap) *DIBuilder {
+type byPos []scopeExtent
+
+func (a byPos) Len() int           { return len(a) }
+func (a byPos) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPos) Less(i, j int) bool { return a[i].pos < a[j].pos }
+
+// NewDIBuilder creates a new debug information builder. If splitName is
+// not empty, it is recorded as the name of the file holding the split
+// debug information of the compile unit.
+func NewDIBuilder(sizes types.Sizes, module llvm.Module, fset *token.FileSet, prefixMaps []PrefixMap, kind EmissionKind, splitName string) *DIBuilder {
 	var d DIBuilder
 	d.module = module
 	d.files = make(map[*token.File]llvm.Value)
 	d.sizes = sizes
 	d.fset = fset
 	d.prefixMaps = prefixMaps
+	d.kind = kind
+	d.splitName = splitName
 	d.builder = llvm.NewDIBuilder(d.module)
 	d.cu = d.createCompileUnit()
 	return &d
@@ -90,6 +149,104 @@ func (d *DIBuilder) getFile(file *token.File) llvm.Value {
 	return diFile
 }
 
+// position returns the file and line of pos, for use as the declaration
+// coordinates of a debug metadata entry. The file is nil if pos is invalid.
+func (d *DIBuilder) position(pos token.Pos) (diFile llvm.Value, line int) {
+	if file := d.fset.File(pos); file != nil {
+		return d.getFile(file), file.Line(pos)
+	}
+	return llvm.Value{}, 0
+}
+
+// SetScopes records the local scopes of the package being compiled, so
+// that variables and debug locations may be placed in lexical blocks.
+// scopes is the Scopes map of the package's types.Info.
+func (d *DIBuilder) SetScopes(files []*ast.File, scopes map[ast.Node]*types.Scope) {
+	d.scopes = nil
+	d.scopePos = make(map[*types.Scope]token.Pos)
+	d.funcScopes = make(map[*types.Scope]bool)
+	add := func(s *types.Scope, n ast.Node) {
+		d.scopes = append(d.scopes, scopeExtent{s, n.Pos(), n.End()})
+		d.scopePos[s] = n.Pos()
+	}
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.File:
+				// The file scope is not a local scope.
+			case *ast.FuncType:
+				// Function scopes are recorded below, and
+				// the scopes of function types appearing
+				// in type expressions contain no code.
+			case *ast.FuncDecl:
+				// The scope of a function is recorded for
+				// its type, but extends over its body.
+				if s := scopes[n.Type]; s != nil {
+					d.funcScopes[s] = true
+					add(s, n)
+				}
+			case *ast.FuncLit:
+				if s := scopes[n.Type]; s != nil {
+					d.funcScopes[s] = true
+					add(s, n)
+				}
+			default:
+				if s := scopes[n]; s != nil {
+					add(s, n)
+				}
+			}
+			return true
+		})
+	}
+	sort.Stable(byPos(d.scopes))
+}
+
+// innermostScope returns the innermost local scope containing pos, or nil
+// if there is none.
+func (d *DIBuilder) innermostScope(pos token.Pos) *types.Scope {
+	i := sort.Search(len(d.scopes), func(i int) bool {
+		return d.scopes[i].pos > pos
+	})
+	// Scopes are ordered by position, with nested scopes following
+	// the scopes that contain them, so the last scope that starts
+	// before pos and ends after it is the innermost.
+	for i--; i >= 0; i-- {
+		if pos < d.scopes[i].end {
+			return d.scopes[i].scope
+		}
+	}
+	return nil
+}
+
+// lexicalBlock returns the lexical block of the current function that
+// corresponds to the given scope, creating it and its enclosing blocks if
+// necessary. The function's own scope, and scopes outside it, correspond to
+// the function.
+func (d *DIBuilder) lexicalBlock(s *types.Scope) llvm.Value {
+	pos, ok := d.scopePos[s]
+	if !ok || d.funcScopes[s] {
+		return d.fn
+	}
+	if lb, ok := d.blocks[s]; ok {
+		return lb
+	}
+	parent := d.lexicalBlock(s.Parent())
+	var diFile llvm.Value
+	var line, column int
+	if file := d.fset.File(pos); file != nil {
+		position := file.Position(pos)
+		diFile = d.getFile(file)
+		line, column = position.Line, position.Column
+	}
+	lb := d.builder.CreateLexicalBlock(parent, llvm.DILexicalBlock{
+		File:   diFile,
+		Line:   line,
+		Column: column,
+	})
+	d.blocks[s] = lb
+	return lb
+}
+
 // createCompileUnit creates and returns debug metadata for the compile
 // unit as a whole, using the first file in the file set as a representative
 // (the choice of file is arbitrary).
@@ -104,10 +261,12 @@ func (d *DIBuilder) createCompileUnit() llvm.Value {
 		panic("could not get current directory: " + err.Error())
 	}
 	return d.builder.CreateCompileUnit(llvm.DICompileUnit{
-		Language: llvm.DW_LANG_Go,
-		File:     d.remapFilePath(file.Name()),
-		Dir:      dir,
-		Producer: "llgo",
+		Language:           llvm.DW_LANG_Go,
+		File:               d.remapFilePath(file.Name()),
+		Dir:                dir,
+		Producer:           "llgo",
+		SplitDebugFilename: d.splitName,
+		EmissionKind:       llvm.DebugEmissionKind(d.kind),
 	})
 }
 
@@ -121,15 +280,30 @@ func (d *DIBuilder) PushFunction(fnptr llvm.Value, sig *types.Signature, pos tok
 		diFile = d.getFile(file)
 		line = file.Line(pos)
 	}
+	name, err := mangle.Demangle(fnptr.Name())
+	if err != nil {
+		name = fnptr.Name()
+	}
+	// Line tables do not need the types of functions.
+	var fnType llvm.Value
+	if d.kind == LineTablesOnly {
+		fnType = d.builder.CreateSubroutineType(llvm.DISubroutineType{})
+	} else {
+		fnType = d.descriptorSignature(sig, "")
+	}
 	d.fn = d.builder.CreateFunction(d.scope(), llvm.DIFunction{
-		Name:         fnptr.Name(), // TODO(axw) unmangled name?
+		Name:         name,
 		LinkageName:  fnptr.Name(),
 		File:         diFile,
 		Line:         line,
-		Type:         d.DIType(sig),
+		Type:         fnType,
 		IsDefinition: true,
 		Function:     fnptr,
 	})
+	if d.kind == FullDebug {
+		d.blocks = make(map[*types.Scope]llvm.Value)
+		d.vars = make(map[*types.Var]llvm.Value)
+	}
 }
 
 // PopFunction pops the previously pushed function off the scope stack.
@@ -137,6 +311,8 @@ func (d *DIBuilder) PopFunction() {
 	d.lb = llvm.Value{nil}
 	d.fn = llvm.Value{nil}
 	d.fnFile = ""
+	d.blocks = nil
+	d.vars = nil
 }
 
 // Declare creates an llvm.dbg.declare call for the specified function
@@ -146,12 +322,7 @@ func (d *DIBuilder) Declare(b llvm.Builder, v ssa.Value, llv llvm.Value, paramIn
 	if paramIndex >= 0 {
 		tag = tagArgVariable
 	}
-	var diFile llvm.Value
-	var line int
-	if file := d.fset.File(v.Pos()); file != nil {
-		line = file.Line(v.Pos())
-		diFile = d.getFile(file)
-	}
+	diFile, line := d.position(v.Pos())
 	localVar := d.builder.CreateLocalVariable(d.scope(), llvm.DILocalVariable{
 		Tag:   tag,
 		Name:  llv.Name(),
@@ -164,9 +335,32 @@ func (d *DIBuilder) Declare(b llvm.Builder, v ssa.Value, llv llvm.Value, paramIn
 	d.builder.InsertDeclareAtEnd(llv, localVar, expr, b.GetInsertBlock())
 }
 
-// Value creates an llvm.dbg.value call for the specified register value.
-func (d *DIBuilder) Value(b llvm.Builder, v ssa.Value, llv llvm.Value, paramIndex int) {
-	// TODO(axw)
+// Value creates an llvm.dbg.value call for the specified function
+// parameter or local variable, recording that its value is now llv. The
+// variable is placed in the lexical block of the scope in which it is
+// declared. paramIndex is the index of the parameter, or -1 if v is not a
+// parameter.
+func (d *DIBuilder) Value(b llvm.Builder, v *types.Var, llv llvm.Value, paramIndex int) {
+	localVar, ok := d.vars[v]
+	if !ok {
+		tag := tagAutoVariable
+		if paramIndex >= 0 {
+			tag = tagArgVariable
+		}
+		diFile, line := d.position(v.Pos())
+		localVar = d.builder.CreateLocalVariable(d.lexicalBlock(v.Parent()), llvm.DILocalVariable{
+			Tag:   tag,
+			Name:  v.Name(),
+			File:  diFile,
+			Line:  line,
+			ArgNo: paramIndex + 1,
+			Type:  d.DIType(v.Type()),
+		})
+		d.vars[v] = localVar
+	}
+	expr := d.builder.CreateExpression(nil)
+	call := d.builder.InsertValueAtEnd(llv, localVar, expr, 0, b.GetInsertBlock())
+	b.SetInstDebugLocation(call)
 }
 
 // SetLocation sets the current debug location.
@@ -180,10 +374,13 @@ func (d *DIBuilder) SetLocation(b llvm.Builder, pos token.Pos) {
 		// This can happen rarely, e.g. in init functions.
 		diFile := d.builder.CreateFile(d.remapFilePath(position.Filename), "")
 		d.lb = d.builder.CreateLexicalBlockFile(d.scope(), diFile, 0)
+	} else if d.blocks != nil {
+		d.lb = d.lexicalBlock(d.innermostScope(pos))
 	}
-	b.SetCurrentDebugLocation(llvm.MDNode([]llvm.Value{
-		llvm.ConstInt(llvm.Int32Type(), uint64(position.Line), false),
-		llvm.ConstInt(llvm.Int32Type(), uint64(position.Column), false),
+	ctx := d.module.Context()
+	b.SetCurrentDebugLocation(ctx.MDNode([]llvm.Value{
+		llvm.ConstInt(ctx.Int32Type(), uint64(position.Line), false),
+		llvm.ConstInt(ctx.Int32Type(), uint64(position.Column), false),
 		d.scope(),
 		llvm.Value{},
 	}))
@@ -192,20 +389,21 @@ func (d *DIBuilder) SetLocation(b llvm.Builder, pos token.Pos) {
 // Finalize must be called after all compilation units are translated,
 // generating the final debug metadata for the module.
 func (d *DIBuilder) Finalize() {
+	ctx := d.module.Context()
 	d.module.AddNamedMetadataOperand(
 		"llvm.module.flags",
-		llvm.MDNode([]llvm.Value{
-			llvm.ConstInt(llvm.Int32Type(), 2, false), // Warn on mismatch
-			llvm.MDString("Dwarf Version"),
-			llvm.ConstInt(llvm.Int32Type(), 4, false),
+		ctx.MDNode([]llvm.Value{
+			llvm.ConstInt(ctx.Int32Type(), 2, false), // Warn on mismatch
+			ctx.MDString("Dwarf Version"),
+			llvm.ConstInt(ctx.Int32Type(), 4, false),
 		}),
 	)
 	d.module.AddNamedMetadataOperand(
 		"llvm.module.flags",
-		llvm.MDNode([]llvm.Value{
-			llvm.ConstInt(llvm.Int32Type(), 1, false), // Error on mismatch
-			llvm.MDString("Debug Info Version"),
-			llvm.ConstInt(llvm.Int32Type(), 1, false),
+		ctx.MDNode([]llvm.Value{
+			llvm.ConstInt(ctx.Int32Type(), 1, false), // Error on mismatch
+			ctx.MDString("Debug Info Version"),
+			llvm.ConstInt(ctx.Int32Type(), 1, false),
 		}),
 	)
 	d.builder.Finalize()
@@ -220,7 +418,7 @@ func (d *DIBuilder) typeDebugDescriptor(t types.Type, name string) llvm.Value {
 	// Signature needs to be handled specially, to preprocess
 	// methods, moving the receiver to the parameter list.
 	if t, ok := t.(*types.Signature); ok {
-		return d.descriptorSignature(t, name)
+		return d.descriptorFunc(t, name)
 	}
 	if t == nil {
 		if d.voidType.IsNil() {
@@ -243,7 +441,7 @@ func (d *DIBuilder) descriptor(t types.Type, name string) llvm.Value {
 	case *types.Pointer:
 		return d.descriptorPointer(t)
 	case *types.Struct:
-		return d.descriptorStruct(t, name)
+		return d.descriptorStruct(t, name, token.NoPos)
 	case *types.Named:
 		return d.descriptorNamed(t)
 	case *types.Array:
@@ -309,7 +507,9 @@ func (d *DIBuilder) descriptorPointer(t *types.Pointer) llvm.Value {
 	})
 }
 
-func (d *DIBuilder) descriptorStruct(t *types.Struct, name string) llvm.Value {
+// descriptorStruct describes a struct type, declared at pos if it is the
+// underlying type of a named type.
+func (d *DIBuilder) descriptorStruct(t *types.Struct, name string, pos token.Pos) llvm.Value {
 	fields := make([]*types.Var, t.NumFields())
 	for i := range fields {
 		fields[i] = t.Field(i)
@@ -317,19 +517,23 @@ func (d *DIBuilder) descriptorStruct(t *types.Struct, name string) llvm.Value {
 	offsets := d.sizes.Offsetsof(fields)
 	members := make([]llvm.Value, len(fields))
 	for i, f := range fields {
-		// TODO(axw) file/line where member is defined.
 		t := f.Type()
+		diFile, line := d.position(f.Pos())
 		members[i] = d.builder.CreateMemberType(d.cu, llvm.DIMemberType{
 			Name:         f.Name(),
+			File:         diFile,
+			Line:         line,
 			Type:         d.DIType(t),
 			SizeInBits:   uint64(d.sizes.Sizeof(t) * 8),
 			AlignInBits:  uint64(d.sizes.Alignof(t) * 8),
 			OffsetInBits: uint64(offsets[i] * 8),
 		})
 	}
-	// TODO(axw) file/line where struct is defined.
+	diFile, line := d.position(pos)
 	return d.builder.CreateStructType(d.cu, llvm.DIStructType{
 		Name:        name,
+		File:        diFile,
+		Line:        line,
 		SizeInBits:  uint64(d.sizes.Sizeof(t) * 8),
 		AlignInBits: uint64(d.sizes.Alignof(t) * 8),
 		Elements:    members,
@@ -338,16 +542,19 @@ func (d *DIBuilder) descriptorStruct(t *types.Struct, name string) llvm.Value {
 
 func (d *DIBuilder) descriptorNamed(t *types.Named) llvm.Value {
 	// Create a placeholder for the named type, to terminate cycles.
-	placeholder := llvm.MDNode(nil)
+	placeholder := d.module.Context().MDNode(nil)
 	d.types.Set(t, placeholder)
-	var diFile llvm.Value
-	var line int
-	if file := d.fset.File(t.Obj().Pos()); file != nil {
-		line = file.Line(t.Obj().Pos())
-		diFile = d.getFile(file)
+	var underlying llvm.Value
+	if st, ok := t.Underlying().(*types.Struct); ok {
+		// Describe the struct at the declaration of the named type,
+		// so that the debugger can locate it.
+		underlying = d.descriptorStruct(st, t.Obj().Name(), t.Obj().Pos())
+	} else {
+		underlying = d.DIType(t.Underlying())
 	}
+	diFile, line := d.position(t.Obj().Pos())
 	typedef := d.builder.CreateTypedef(llvm.DITypedef{
-		Type: d.DIType(t.Underlying()),
+		Type: underlying,
 		Name: t.Obj().Name(),
 		File: diFile,
 		Line: line,
@@ -374,22 +581,6 @@ func (d *DIBuilder) descriptorSlice(t *types.Slice, name string) llvm.Value {
 	return d.typeDebugDescriptor(sliceStruct, name)
 }
 
-func (d *DIBuilder) descriptorMap(t *types.Map, name string) llvm.Value {
-	return d.descriptorBasic(types.Typ[types.Uintptr], name)
-}
-
-func (d *DIBuilder) descriptorChan(t *types.Chan, name string) llvm.Value {
-	return d.descriptorBasic(types.Typ[types.Uintptr], name)
-}
-
-func (d *DIBuilder) descriptorInterface(t *types.Interface, name string) llvm.Value {
-	ifaceStruct := types.NewStruct([]*types.Var{
-		types.NewVar(0, nil, "type", types.NewPointer(types.Typ[types.Uint8])),
-		types.NewVar(0, nil, "data", types.NewPointer(types.Typ[types.Uint8])),
-	}, nil)
-	return d.typeDebugDescriptor(ifaceStruct, name)
-}
-
 func (d *DIBuilder) descriptorSignature(t *types.Signature, name string) llvm.Value {
 	// If there's a receiver change the receiver to an
 	// additional (first) parameter, and take the value of
@@ -403,7 +594,7 @@ func (d *DIBuilder) descriptorSignature(t *types.Signature, name string) llvm.Va
 		}
 		params = types.NewTuple(paramvars...)
 		t := types.NewSignature(nil, nil, params, t.Results(), t.Variadic())
-		return d.typeDebugDescriptor(t, name)
+		return d.descriptorSignature(t, name)
 	}
 	if dt, ok := d.types.At(t).(llvm.Value); ok {
 		return dt
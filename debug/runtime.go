@@ -0,0 +1,242 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"go/token"
+
+	"github.com/go-llvm/llgo/mangle"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/types"
+
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// This file describes the types whose representation is defined by libgo:
+// interfaces, maps, channels and func values. Their layouts must be kept
+// in sync with libgo and with the code generated by irgen.
+
+// member describes a member of a struct type created by createStruct.
+type member struct {
+	name        string
+	typ         llvm.Value
+	size, align int64
+	pos         token.Pos
+}
+
+// goMember returns a member of the given name and Go type.
+func (d *DIBuilder) goMember(name string, t types.Type) member {
+	return member{
+		name:  name,
+		typ:   d.DIType(t),
+		size:  d.sizes.Sizeof(t),
+		align: d.sizes.Alignof(t),
+	}
+}
+
+// pointerMember returns a member of the given name that points to a value
+// described by pointee.
+func (d *DIBuilder) pointerMember(name string, pointee llvm.Value) member {
+	return member{
+		name:  name,
+		typ:   d.pointerTo(pointee, ""),
+		size:  d.sizes.Sizeof(types.Typ[types.UnsafePointer]),
+		align: d.sizes.Alignof(types.Typ[types.UnsafePointer]),
+	}
+}
+
+// pointerTo describes a pointer to a value described by pointee.
+func (d *DIBuilder) pointerTo(pointee llvm.Value, name string) llvm.Value {
+	ptr := types.Typ[types.UnsafePointer]
+	return d.builder.CreatePointerType(llvm.DIPointerType{
+		Pointee:     pointee,
+		SizeInBits:  uint64(d.sizes.Sizeof(ptr) * 8),
+		AlignInBits: uint64(d.sizes.Alignof(ptr) * 8),
+		Name:        name,
+	})
+}
+
+// createStruct describes a struct type with the given members, laid out
+// in order with their natural alignment.
+func (d *DIBuilder) createStruct(name string, members []member) llvm.Value {
+	var offset, align int64 = 0, 1
+	elements := make([]llvm.Value, len(members))
+	for i, m := range members {
+		offset = (offset + m.align - 1) / m.align * m.align
+		diFile, line := d.position(m.pos)
+		elements[i] = d.builder.CreateMemberType(d.cu, llvm.DIMemberType{
+			Name:         m.name,
+			File:         diFile,
+			Line:         line,
+			Type:         m.typ,
+			SizeInBits:   uint64(m.size * 8),
+			AlignInBits:  uint64(m.align * 8),
+			OffsetInBits: uint64(offset * 8),
+		})
+		offset += m.size
+		if m.align > align {
+			align = m.align
+		}
+	}
+	size := (offset + align - 1) / align * align
+	return d.builder.CreateStructType(d.cu, llvm.DIStructType{
+		Name:        name,
+		SizeInBits:  uint64(size * 8),
+		AlignInBits: uint64(align * 8),
+		Elements:    elements,
+	})
+}
+
+// descriptorTypeDescriptor describes the common prefix of libgo's type
+// descriptors (struct __go_type_descriptor), which is enough to identify
+// the dynamic type of an interface value by its string.
+func (d *DIBuilder) descriptorTypeDescriptor() llvm.Value {
+	if !d.typeDescriptorType.IsNil() {
+		return d.typeDescriptorType
+	}
+	unsafePointer := types.Typ[types.UnsafePointer]
+	d.typeDescriptorType = d.createStruct("__go_type_descriptor", []member{
+		d.goMember("__code", types.Typ[types.Uint8]),
+		d.goMember("__align", types.Typ[types.Uint8]),
+		d.goMember("__field_align", types.Typ[types.Uint8]),
+		d.goMember("__size", types.Typ[types.Uintptr]),
+		d.goMember("__hash", types.Typ[types.Uint32]),
+		d.goMember("__hashfn", unsafePointer),
+		d.goMember("__equalfn", unsafePointer),
+		d.goMember("__gc", unsafePointer),
+		d.goMember("__reflection", types.NewPointer(types.Typ[types.String])),
+		d.goMember("__uncommon", unsafePointer),
+		d.goMember("__pointer_to_this", unsafePointer),
+		d.goMember("__zero", unsafePointer),
+	})
+	return d.typeDescriptorType
+}
+
+// descriptorInterface describes an interface value. Empty interfaces hold
+// a pointer to the type descriptor of their dynamic type; other interfaces
+// hold a pointer to a method table, which begins with the type descriptor
+// pointer and is followed by a pointer to the code of each method.
+func (d *DIBuilder) descriptorInterface(t *types.Interface, name string) llvm.Value {
+	data := d.goMember("__object", types.Typ[types.UnsafePointer])
+	if t.NumMethods() == 0 {
+		return d.createStruct(name, []member{
+			d.pointerMember("__type_descriptor", d.descriptorTypeDescriptor()),
+			data,
+		})
+	}
+
+	methods := []member{d.pointerMember("__type_descriptor", d.descriptorTypeDescriptor())}
+	recv := types.NewParam(token.NoPos, nil, "", types.Typ[types.UnsafePointer])
+	for _, sel := range mangle.OrderedMethodSet(types.NewMethodSet(t)) {
+		// Methods are called with the interface's object as
+		// their receiver.
+		m := sel.Obj()
+		sig := m.Type().(*types.Signature)
+		sig = types.NewSignature(nil, recv, sig.Params(), sig.Results(), sig.Variadic())
+		mm := d.pointerMember(m.Name(), d.descriptorSignature(sig, ""))
+		mm.pos = m.Pos()
+		methods = append(methods, mm)
+	}
+	return d.createStruct(name, []member{
+		d.pointerMember("__methods", d.createStruct("", methods)),
+		data,
+	})
+}
+
+// descriptorMap describes a map value, which is a pointer to libgo's
+// struct __go_map. The map's buckets are linked lists of entries, each
+// holding a key and its value.
+func (d *DIBuilder) descriptorMap(t *types.Map, name string) llvm.Value {
+	// Create a placeholder for the entry type, to terminate the
+	// cycle through its next pointer.
+	placeholder := d.module.Context().MDNode(nil)
+	entry := d.createStruct("__go_map_entry", []member{
+		d.pointerMember("__next", placeholder),
+		d.goMember("__key", t.Key()),
+		d.goMember("__val", t.Elem()),
+	})
+	placeholder.ReplaceAllUsesWith(entry)
+
+	uintptrType := types.Typ[types.Uintptr]
+	hmap := d.createStruct("__go_map", []member{
+		d.goMember("__descriptor", types.Typ[types.UnsafePointer]),
+		d.goMember("__element_count", uintptrType),
+		d.goMember("__bucket_count", uintptrType),
+		d.pointerMember("__buckets", d.pointerTo(entry, "")),
+	})
+	return d.pointerTo(hmap, name)
+}
+
+// descriptorChan describes a channel value, which is a pointer to libgo's
+// struct Hchan. The channel's buffer follows the struct in memory.
+func (d *DIBuilder) descriptorChan(t *types.Chan, name string) llvm.Value {
+	if d.chanType.IsNil() {
+		uintType := types.Typ[types.Uint]
+		unsafePointer := types.Typ[types.UnsafePointer]
+		waitq := d.createStruct("WaitQ", []member{
+			d.goMember("first", unsafePointer),
+			d.goMember("last", unsafePointer),
+		})
+		waitqMember := func(name string) member {
+			ptrSize := d.sizes.Sizeof(unsafePointer)
+			return member{name: name, typ: waitq, size: 2 * ptrSize, align: ptrSize}
+		}
+		d.chanType = d.createStruct("Hchan", []member{
+			d.goMember("qcount", uintType),
+			d.goMember("dataqsiz", uintType),
+			d.goMember("elemsize", types.Typ[types.Uint16]),
+			d.goMember("pad", types.Typ[types.Uint16]),
+			d.goMember("closed", types.Typ[types.Bool]),
+			d.pointerMember("elemtype", d.descriptorTypeDescriptor()),
+			d.goMember("sendx", uintType),
+			d.goMember("recvx", uintType),
+			waitqMember("recvq"),
+			waitqMember("sendq"),
+			d.goMember("lock", types.Typ[types.Uintptr]),
+		})
+	}
+	return d.pointerTo(d.chanType, name)
+}
+
+// descriptorFunc describes a func value, which is a pointer to a closure
+// whose first word is the function's code pointer. The closure's other
+// members depend on the function, and are described by Closure.
+func (d *DIBuilder) descriptorFunc(t *types.Signature, name string) llvm.Value {
+	if dt, ok := d.funcTypes.At(t).(llvm.Value); ok {
+		return dt
+	}
+	closure := d.createStruct("", []member{
+		d.pointerMember("__fn", d.descriptorSignature(t, "")),
+	})
+	dt := d.pointerTo(closure, name)
+	d.funcTypes.Set(t, dt)
+	return dt
+}
+
+// Closure creates an llvm.dbg.value call describing the closure of the
+// current function, fn, whose value is llv. The closure is described as an
+// artificial variable, "$closure", pointing to a struct holding the code
+// pointer followed by the function's free variables.
+func (d *DIBuilder) Closure(b llvm.Builder, fn *ssa.Function, llv llvm.Value) {
+	members := []member{d.goMember("__fn", types.Typ[types.UnsafePointer])}
+	for _, fv := range fn.FreeVars {
+		m := d.goMember(fv.Name(), fv.Type())
+		m.pos = fv.Pos()
+		members = append(members, m)
+	}
+	closure := d.createStruct("", members)
+	diFile, line := d.position(fn.Pos())
+	localVar := d.builder.CreateLocalVariable(d.fn, llvm.DILocalVariable{
+		Tag:   tagAutoVariable,
+		Name:  "$closure",
+		File:  diFile,
+		Line:  line,
+		Type:  d.pointerTo(closure, ""),
+		Flags: flagArtificial,
+	})
+	expr := d.builder.CreateExpression(nil)
+	call := d.builder.InsertValueAtEnd(llv, localVar, expr, 0, b.GetInsertBlock())
+	b.SetInstDebugLocation(call)
+}
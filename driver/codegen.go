@@ -0,0 +1,215 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"github.com/go-llvm/llgo/irgen"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// RunPasses runs the optimization pipeline selected by opts over m,
+// together with the instrumentation passes of the sanitizer and the passes
+// of any loaded plugins.
+func RunPasses(opts *Options, tm llvm.TargetMachine, m llvm.Module) {
+	fpm := llvm.NewFunctionPassManagerForModule(m)
+	defer fpm.Dispose()
+
+	mpm := llvm.NewPassManager()
+	defer mpm.Dispose()
+
+	pmb := llvm.NewPassManagerBuilder()
+	defer pmb.Dispose()
+
+	pmb.SetOptLevel(opts.OptLevel)
+	pmb.SetSizeLevel(opts.SizeLevel)
+
+	target := tm.TargetData()
+	mpm.Add(target)
+	fpm.Add(target)
+	tm.AddAnalysisPasses(mpm)
+	tm.AddAnalysisPasses(fpm)
+
+	mpm.AddVerifierPass()
+	fpm.AddVerifierPass()
+
+	pmb.Populate(mpm)
+	pmb.PopulateFunc(fpm)
+
+	if opts.OptLevel == 0 {
+		// Remove references (via the descriptor) to dead functions,
+		// for compatibility with other compilers.
+		mpm.AddGlobalDCEPass()
+	}
+
+	opts.Sanitizer.addPasses(mpm, fpm)
+
+	ppm, hasPluginPasses := addPluginPasses(target, tm)
+	if hasPluginPasses {
+		defer ppm.Dispose()
+	}
+	runPluginPasses := func(ep PluginExtensionPoint) {
+		if hasPluginPasses && opts.PluginEP == ep {
+			ppm.Run(m)
+		}
+	}
+
+	runPluginPasses(PluginEPEarly)
+
+	fpm.InitializeFunc()
+	for fn := m.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+		fpm.RunFunc(fn)
+	}
+	fpm.FinalizeFunc()
+
+	runPluginPasses(PluginEPScalar)
+
+	mpm.Run(m)
+
+	// With LTO, the module is code generated by LinkBitcode, which runs
+	// the passes at PluginEPCodegen instead.
+	if !opts.LTO {
+		runPluginPasses(PluginEPCodegen)
+	}
+}
+
+func getMetadataSectionInlineAsm(name string) string {
+	// ELF: creates a non-allocated excluded section.
+	return ".section \"" + name + "\", \"e\"\n"
+}
+
+func getDataInlineAsm(data []byte) string {
+	edata := make([]byte, len(data)*4+10)
+
+	j := copy(edata, ".ascii \"")
+	for i := range data {
+		switch data[i] {
+		case '\000':
+			edata[j] = '\\'
+			edata[j+1] = '0'
+			edata[j+2] = '0'
+			edata[j+3] = '0'
+			j += 4
+			continue
+		case '\n':
+			edata[j] = '\\'
+			edata[j+1] = 'n'
+			j += 2
+			continue
+		case '"', '\\':
+			edata[j] = '\\'
+			j++
+		}
+		edata[j] = data[i]
+		j++
+	}
+	edata[j] = '"'
+	edata[j+1] = '\n'
+	return string(edata[0 : j+2])
+}
+
+// CreateTargetMachine creates a target machine for the target, CPU,
+// features and optimization level selected by opts.
+func CreateTargetMachine(opts *Options) (llvm.TargetMachine, error) {
+	target, err := llvm.GetTargetFromTriple(opts.Triple)
+	if err != nil {
+		return llvm.TargetMachine{}, err
+	}
+
+	optLevel := [...]llvm.CodeGenOptLevel{
+		llvm.CodeGenLevelNone,
+		llvm.CodeGenLevelLess,
+		llvm.CodeGenLevelDefault,
+		llvm.CodeGenLevelAggressive,
+	}[opts.OptLevel]
+
+	relocMode := llvm.RelocStatic
+	if opts.PIC {
+		relocMode = llvm.RelocPIC
+	}
+
+	tm := target.CreateTargetMachine(opts.Triple, opts.Target.CPU(),
+		opts.Target.FeatureString(), optLevel,
+		relocMode, llvm.CodeModelDefault)
+	return tm, nil
+}
+
+// EmitModule optimizes the module compiled for a package, and returns the
+// contents of the output file for it: an object file, or assembly if
+// assembly is set. The package's export data and escape summaries are
+// placed in the .go_export and .go_escape sections. With LTO, the object
+// carries the module's bitcode in its .llvmbc section instead of machine
+// code, and with EmitIR, the output is the module's bitcode, or its IR as
+// text if assembly is set.
+func EmitModule(opts *Options, module *irgen.Module, assembly bool) ([]byte, error) {
+	tm, err := CreateTargetMachine(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer tm.Dispose()
+
+	RunPasses(opts, tm, module.Module)
+
+	fileType := llvm.ObjectFile
+	if assembly {
+		fileType = llvm.AssemblyFile
+	}
+
+	switch {
+	case !opts.LTO && !opts.EmitIR:
+		if module.ExportData != nil {
+			asm := getMetadataSectionInlineAsm(".go_export")
+			asm += getDataInlineAsm(module.ExportData)
+			asm += getMetadataSectionInlineAsm(".go_escape")
+			asm += getDataInlineAsm(module.EscapeData)
+			module.Module.SetInlineAsm(asm)
+		}
+
+		mb, err := tm.EmitToMemoryBuffer(module.Module, fileType)
+		if err != nil {
+			return nil, err
+		}
+		defer mb.Dispose()
+
+		return append([]byte(nil), mb.Bytes()...), nil
+
+	case opts.LTO:
+		bcmb := llvm.WriteBitcodeToMemoryBuffer(module.Module)
+		defer bcmb.Dispose()
+
+		// This is a bit of a hack. We just want an object file
+		// containing some metadata sections. This might be simpler
+		// if we had bindings for the MC library, but for now we create
+		// a fresh module containing only inline asm that creates the
+		// sections.
+		outmodule := module.Module.Context().NewModule("")
+		defer outmodule.Dispose()
+		asm := getMetadataSectionInlineAsm(".llvmbc")
+		asm += getDataInlineAsm(bcmb.Bytes())
+		if module.ExportData != nil {
+			asm += getMetadataSectionInlineAsm(".go_export")
+			asm += getDataInlineAsm(module.ExportData)
+			asm += getMetadataSectionInlineAsm(".go_escape")
+			asm += getDataInlineAsm(module.EscapeData)
+		}
+		outmodule.SetInlineAsm(asm)
+
+		mb, err := tm.EmitToMemoryBuffer(outmodule, fileType)
+		if err != nil {
+			return nil, err
+		}
+		defer mb.Dispose()
+
+		return append([]byte(nil), mb.Bytes()...), nil
+
+	case !assembly:
+		bcmb := llvm.WriteBitcodeToMemoryBuffer(module.Module)
+		defer bcmb.Dispose()
+
+		return append([]byte(nil), bcmb.Bytes()...), nil
+
+	default:
+		return []byte(module.Module.String()), nil
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// VariantDir returns the lib-relative path to the standard libraries for
+// the given options. This is normally '.' but can vary for cross
+// compilation, LTO, sanitizers etc. No variant is built for NoSplitStack
+// together with LTO or a sanitizer, so the drivers reject the combination.
+func VariantDir(opts *Options) string {
+	switch {
+	case opts.LTO:
+		return "llvm-lto.0"
+	case opts.Sanitizer.Address:
+		return "llvm-asan.0"
+	case opts.Sanitizer.Thread:
+		return "llvm-tsan.0"
+	case opts.Sanitizer.Memory:
+		return "llvm-msan.0"
+	case opts.Sanitizer.Dataflow:
+		return "llvm-dfsan.0"
+	case opts.NoSplitStack:
+		return "llvm-nosplit.0"
+	default:
+		return "."
+	}
+}
+
+// Link links the given objects, archives and other linker inputs into the
+// program output, together with libgo and the runtime of the sanitizer, if
+// any. With LTO, the bitcode in the inputs is first optimized and compiled
+// by LinkBitcode.
+func Link(opts *Options, inputs []string, output string) error {
+	var ltoLinkedLibgo bool
+	if opts.LTO {
+		ltoObj, linkedLibgo, err := LinkBitcode(opts, inputs)
+		if err != nil {
+			return err
+		}
+		if ltoObj != "" {
+			defer os.Remove(ltoObj)
+			inputs = append([]string{ltoObj}, inputs...)
+			ltoLinkedLibgo = linkedLibgo
+		}
+	}
+
+	args := []string{"-o", output}
+	if opts.PIC {
+		args = append(args, "-fPIC")
+	}
+	if opts.PIELink {
+		args = append(args, "-pie")
+	}
+	if opts.StaticLink {
+		args = append(args, "-static")
+	}
+	if opts.StaticLibgcc {
+		args = append(args, "-static-libgcc")
+	}
+	if opts.CompressDebug != "" {
+		args = append(args, "-gz="+opts.CompressDebug)
+	}
+	for _, p := range opts.LibPaths {
+		args = append(args, "-L", p)
+	}
+	for _, p := range opts.ImportPaths {
+		args = append(args, "-I", p)
+	}
+	args = append(args, inputs...)
+	var linkerPath string
+	if opts.GccgoPath == "" || opts.Freestanding {
+		// TODO(pcc): See if we can avoid calling gcc here.
+		// We currently rely on it to find crt*.o and compile
+		// any C source files passed as arguments.
+		linkerPath = opts.BPrefix + "gcc"
+
+		if opts.Prefix != "" {
+			libdir := filepath.Join(opts.Prefix, "lib", VariantDir(opts))
+			args = append(args, "-L", libdir)
+			if !opts.StaticLibgo {
+				args = append(args, "-Wl,-rpath,"+libdir)
+			}
+		}
+
+		switch {
+		case opts.Freestanding:
+			// The runtime package is linked like any other
+			// package, and the program does not depend on the
+			// C library. Its startup code, which calls the main
+			// function defined by the main package, must be
+			// among the inputs.
+			args = append(args, "-nostdlib")
+		case ltoLinkedLibgo:
+			// libgo is part of the LTO object, so we only
+			// need its dependencies.
+			args = append(args, "-lpthread", "-lm")
+		case opts.StaticLibgo:
+			args = append(args, "-lgobegin", "-Wl,-Bstatic", "-lgo", "-Wl,-Bdynamic", "-lpthread", "-lm")
+		default:
+			args = append(args, "-lgobegin", "-lgo")
+		}
+	} else {
+		linkerPath = opts.GccgoPath
+		if opts.StaticLibgo {
+			args = append(args, "-static-libgo")
+		}
+	}
+
+	args = opts.Sanitizer.addLibs(opts.Triple, args)
+
+	cmd := exec.Command(linkerPath, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Stderr.Write(out)
+	}
+	return err
+}
@@ -0,0 +1,211 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	llgobuild "github.com/go-llvm/llgo/build"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// bitcodeCollector gathers the bitcode contained in the inputs to a link.
+type bitcodeCollector struct {
+	bitcode [][]byte
+
+	// hasNative is set if any of the inputs contains native code, in
+	// which case symbols may be referenced from outside the bitcode.
+	hasNative bool
+}
+
+// collect adds the bitcode in the object or archive at path, and reports
+// whether every member of the archive (or the object itself) was bitcode.
+// Objects produced by llgo -flto carry their bitcode in a .llvmbc section;
+// archive members may also be bitcode files, as produced by clang -flto.
+func (c *bitcodeCollector) collect(path string) (allBitcode bool, err error) {
+	found, native := false, false
+	err = llgobuild.ForEachObject(path, func(name string, data []byte) error {
+		if llgobuild.IsBitcode(data) {
+			c.bitcode = append(c.bitcode, data)
+			found = true
+			return nil
+		}
+		bc, err := llgobuild.ELFSection(data, ".llvmbc")
+		if err != nil || bc == nil {
+			// Leave anything else to the system linker.
+			c.hasNative = true
+			native = true
+			return nil
+		}
+		c.bitcode = append(c.bitcode, bc)
+		found = true
+		return nil
+	})
+	return found && !native, err
+}
+
+// findLibrary returns the path to the named static library in the library
+// search path used for the link, or the empty string if there is none.
+func findLibrary(opts *Options, name string) string {
+	dirs := append([]string{}, opts.LibPaths...)
+	if opts.Prefix != "" {
+		dirs = append(dirs, filepath.Join(opts.Prefix, "lib", VariantDir(opts)))
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, "lib"+name+".a")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func parseBitcode(data []byte) (llvm.Module, error) {
+	// TODO(pcc): Parse from a memory buffer once the bindings
+	// support it.
+	tmpfile, err := ioutil.TempFile("", "llgo-lto")
+	if err != nil {
+		return llvm.Module{}, err
+	}
+	defer os.Remove(tmpfile.Name())
+	_, err = tmpfile.Write(data)
+	tmpfile.Close()
+	if err != nil {
+		return llvm.Module{}, err
+	}
+	return llvm.ParseBitcodeFile(tmpfile.Name())
+}
+
+// runLTOPasses runs the link-time optimization pipeline over the linked
+// module m. The passes of plugins at PluginEPCodegen run afterwards, as
+// their run for each module compiled with LTO was deferred until the
+// module is code generated here; those at the earlier extension points
+// have already run.
+func runLTOPasses(opts *Options, tm llvm.TargetMachine, m llvm.Module, internalize bool) {
+	pm := llvm.NewPassManager()
+	defer pm.Dispose()
+
+	pmb := llvm.NewPassManagerBuilder()
+	defer pmb.Dispose()
+
+	pmb.SetOptLevel(opts.OptLevel)
+	pmb.SetSizeLevel(opts.SizeLevel)
+
+	pm.Add(tm.TargetData())
+	tm.AddAnalysisPasses(pm)
+	pm.AddVerifierPass()
+
+	pmb.PopulateLTOPassManager(pm, internalize, opts.OptLevel > 0)
+
+	pm.Run(m)
+
+	if opts.PluginEP == PluginEPCodegen {
+		if ppm, ok := addPluginPasses(tm.TargetData(), tm); ok {
+			ppm.Run(m)
+			ppm.Dispose()
+		}
+	}
+}
+
+// LinkBitcode performs link-time optimization for a link with the given
+// inputs. The bitcode found in the input objects and archives, and in the
+// variant libgo if it was built for LTO, is linked into a single module,
+// which is optimized and compiled to a native object. The name of the object
+// is returned, or the empty string if no input contained bitcode. If all of
+// libgo is part of the object, linkedLibgo is true, and libgo need not be
+// linked natively. Otherwise, any native members of libgo, such as those
+// written in assembly, must be linked after the object.
+func LinkBitcode(opts *Options, inputs []string) (obj string, linkedLibgo bool, err error) {
+	var c bitcodeCollector
+	for _, input := range inputs {
+		if !strings.HasSuffix(input, ".o") && !strings.HasSuffix(input, ".a") {
+			continue
+		}
+		if _, err := c.collect(input); err != nil {
+			return "", false, err
+		}
+	}
+
+	if opts.GccgoPath == "" && !opts.Freestanding {
+		linkedLibgo = true
+		for _, lib := range []string{"gobegin", "go"} {
+			path := findLibrary(opts, lib)
+			if path == "" {
+				linkedLibgo = false
+				continue
+			}
+			allBitcode, err := c.collect(path)
+			if err != nil {
+				return "", false, err
+			}
+			linkedLibgo = linkedLibgo && allBitcode
+		}
+	}
+	if !linkedLibgo && !opts.Freestanding {
+		// libgo will be linked natively, and calls into the
+		// program's bitcode.
+		c.hasNative = true
+	}
+
+	if len(c.bitcode) == 0 {
+		return "", false, nil
+	}
+
+	composite, err := parseBitcode(c.bitcode[0])
+	if err != nil {
+		return "", false, err
+	}
+	defer composite.Dispose()
+	for _, bc := range c.bitcode[1:] {
+		m, err := parseBitcode(bc)
+		if err != nil {
+			return "", false, err
+		}
+		err = llvm.LinkModules(composite, m, llvm.LinkerDestroySource)
+		m.Dispose()
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	tm, err := CreateTargetMachine(opts)
+	if err != nil {
+		return "", false, err
+	}
+	defer tm.Dispose()
+
+	// If all code is available as bitcode, nothing outside of the
+	// module can refer to its symbols, other than the entry point.
+	runLTOPasses(opts, tm, composite, !c.hasNative)
+
+	mb, err := tm.EmitToMemoryBuffer(composite, llvm.ObjectFile)
+	if err != nil {
+		return "", false, err
+	}
+	defer mb.Dispose()
+
+	tmpfile, err := ioutil.TempFile("", "llgo-lto")
+	if err != nil {
+		return "", false, err
+	}
+	_, err = tmpfile.Write(mb.Bytes())
+	tmpfile.Close()
+	if err != nil {
+		os.Remove(tmpfile.Name())
+		return "", false, err
+	}
+
+	// The system linker determines the type of its inputs by their
+	// suffix, so give the object a name ending in ".o".
+	obj = tmpfile.Name() + ".o"
+	if err := os.Rename(tmpfile.Name(), obj); err != nil {
+		os.Remove(tmpfile.Name())
+		return "", false, err
+	}
+	return obj, linkedLibgo, nil
+}
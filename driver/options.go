@@ -0,0 +1,107 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package driver implements the code generation and linking shared by the
+// llgo drivers: llgo, the gccgo-compatible driver in cmd/gllgo, and
+// llgo-build.
+package driver
+
+import (
+	"path/filepath"
+
+	"github.com/go-llvm/llgo/irgen"
+)
+
+// Options holds the options controlling code generation and linking. The
+// drivers set them from their command line flags, which share the names of
+// the corresponding gcc flags.
+type Options struct {
+	// Triple is the LLVM triple for the target, and Target the CPU and
+	// features selected by -m flags.
+	Triple string
+	Target TargetOptions
+
+	OptLevel  int
+	SizeLevel int
+
+	// PIC generates position-independent code, and PIELink links a
+	// position-independent executable.
+	PIC     bool
+	PIELink bool
+
+	// LTO places bitcode in object files, and performs link-time
+	// optimization when linking.
+	LTO bool
+
+	// EmitIR emits LLVM IR, or bitcode when compiling to an object,
+	// instead of native code.
+	EmitIR bool
+
+	Sanitizer SanitizerOptions
+
+	// Plugins is the list of plugins to load, and PluginEP the point in
+	// the optimization pipeline at which their passes are run. With LTO,
+	// plugins must also be loaded for the link, as passes at
+	// PluginEPCodegen run as part of LinkBitcode.
+	Plugins  []string
+	PluginEP PluginExtensionPoint
+
+	NoSplitStack   bool
+	Freestanding   bool
+	RuntimePackage string
+
+	// Prefix is the llgo installation prefix, under which the export
+	// data and variants of libgo are found. It is empty if there is no
+	// installation, as with -no-prefix.
+	Prefix string
+
+	// BPrefix is prepended to the names of the gcc and binutils programs
+	// run by the drivers, as given by -B.
+	BPrefix string
+
+	// GccgoPath is the path to a gccgo binary whose libgo is used
+	// instead of the installed libgo.
+	GccgoPath string
+
+	ImportPaths []string
+	LibPaths    []string
+
+	StaticLink   bool
+	StaticLibgcc bool
+	StaticLibgo  bool
+
+	// CompressDebug is the objcopy name of the compression applied to
+	// debug sections, as requested by -gz, or empty.
+	CompressDebug string
+}
+
+// ImportSearchPaths returns the directories searched for the export data of
+// imported packages.
+func (opts *Options) ImportSearchPaths() []string {
+	importPaths := make([]string, len(opts.ImportPaths)+len(opts.LibPaths))
+	copy(importPaths, opts.ImportPaths)
+	copy(importPaths[len(opts.ImportPaths):], opts.LibPaths)
+	if opts.Prefix != "" {
+		importPaths = append(importPaths, filepath.Join(opts.Prefix, "lib", "go"))
+	}
+	return importPaths
+}
+
+// CompilerOptions returns the compiler options corresponding to opts. The
+// options that do not affect code generation, such as those controlling
+// debug information and diagnostics, are left for the driver to set.
+func (opts *Options) CompilerOptions() irgen.CompilerOptions {
+	return irgen.CompilerOptions{
+		TargetTriple:       opts.Triple,
+		GccgoPath:          opts.GccgoPath,
+		ImportPaths:        opts.ImportSearchPaths(),
+		SanitizerAttribute: opts.Sanitizer.Attribute(),
+		TargetCPU:          opts.Target.CPU(),
+		TargetFeatures:     opts.Target.FeatureString(),
+		DisableRedZone:     opts.Target.NoRedZone,
+		DisableSplitStack:  opts.NoSplitStack,
+		Freestanding:       opts.Freestanding,
+		RuntimePackage:     opts.RuntimePackage,
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+/*
+#cgo LDFLAGS: -ldl -rdynamic
+#include <dlfcn.h>
+#include <stdlib.h>
+
+typedef struct LLVMOpaquePassManager *LLVMPassManagerRef;
+
+static void llgo_call_add_passes(void *fn, LLVMPassManagerRef pm) {
+	((void (*)(LLVMPassManagerRef))fn)(pm);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// pluginEntryPoint is the name of the function through which a plugin adds
+// its passes to a pass manager. Its C signature is
+//
+//	void llgo_add_passes(LLVMPassManagerRef pm);
+//
+// Plugins that do not define it are still loaded, so that any passes or
+// options they register are available to -mllvm. Programs using this
+// package are linked with -rdynamic, so that plugins may call the LLVM C
+// API functions linked into them.
+const pluginEntryPoint = "llgo_add_passes"
+
+// PluginExtensionPoint specifies where in the optimization pipeline the
+// passes added by plugins are run.
+type PluginExtensionPoint int
+
+const (
+	// PluginEPEarly runs plugin passes before any optimization.
+	PluginEPEarly PluginExtensionPoint = iota
+
+	// PluginEPScalar runs plugin passes after the function-level scalar
+	// optimizations, and before the module-level optimizations.
+	PluginEPScalar
+
+	// PluginEPCodegen runs plugin passes after all optimizations,
+	// immediately before code generation.
+	PluginEPCodegen
+)
+
+// ParsePluginExtensionPoint returns the extension point named by the
+// argument of -fplugin-ep.
+func ParsePluginExtensionPoint(name string) (PluginExtensionPoint, error) {
+	switch name {
+	case "early":
+		return PluginEPEarly, nil
+	case "scalar":
+		return PluginEPScalar, nil
+	case "codegen":
+		return PluginEPCodegen, nil
+	}
+	return 0, fmt.Errorf("unknown plugin extension point '%s'", name)
+}
+
+// plugin is a shared library containing LLVM passes.
+type plugin struct {
+	path      string
+	addPasses unsafe.Pointer
+}
+
+// loadedPlugins holds the plugins loaded by LoadPlugins.
+var loadedPlugins []plugin
+
+// LoadPlugins loads the plugins named by opts, and looks up their entry
+// points. Plugins are loaded with RTLD_GLOBAL, so that they may use each
+// other's symbols, and are never unloaded. Their passes are run by
+// RunPasses, or with LTO, at PluginEPCodegen by LinkBitcode.
+func LoadPlugins(opts *Options) error {
+	for _, path := range opts.Plugins {
+		cpath := C.CString(path)
+		handle := C.dlopen(cpath, C.RTLD_NOW|C.RTLD_GLOBAL)
+		C.free(unsafe.Pointer(cpath))
+		if handle == nil {
+			return fmt.Errorf("could not load plugin '%s': %s", path, C.GoString(C.dlerror()))
+		}
+
+		centry := C.CString(pluginEntryPoint)
+		addPasses := C.dlsym(handle, centry)
+		C.free(unsafe.Pointer(centry))
+		loadedPlugins = append(loadedPlugins, plugin{path, addPasses})
+	}
+	return nil
+}
+
+// addPluginPasses creates a pass manager containing the passes of every
+// loaded plugin. If no plugin adds passes, ok is false.
+func addPluginPasses(target llvm.TargetData, tm llvm.TargetMachine) (pm llvm.PassManager, ok bool) {
+	for _, p := range loadedPlugins {
+		if p.addPasses == nil {
+			continue
+		}
+		if !ok {
+			pm = llvm.NewPassManager()
+			pm.Add(target)
+			tm.AddAnalysisPasses(pm)
+			ok = true
+		}
+		C.llgo_call_add_passes(p.addPasses, C.LLVMPassManagerRef(unsafe.Pointer(pm.C)))
+	}
+	if ok {
+		pm.AddVerifierPass()
+	}
+	return
+}
@@ -0,0 +1,118 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"path/filepath"
+	"strings"
+
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// SanitizerOptions selects the sanitizer, if any, that programs are
+// instrumented for, as given by -fsanitize.
+type SanitizerOptions struct {
+	// Blacklist is the dataflow sanitizer's ABI list. If empty, the
+	// list installed with compiler-rt is used.
+	Blacklist string
+
+	// CrtPrefix is the installation prefix of compiler-rt, which
+	// provides the sanitizer runtimes.
+	CrtPrefix string
+
+	Address, Thread, Memory, Dataflow bool
+}
+
+// Parse selects the sanitizer named by the argument of -fsanitize,
+// reporting whether the name is known.
+func (san *SanitizerOptions) Parse(name string) bool {
+	switch name {
+	case "address":
+		san.Address = true
+	case "thread":
+		san.Thread = true
+	case "memory":
+		san.Memory = true
+	case "dataflow":
+		san.Dataflow = true
+	default:
+		return false
+	}
+	return true
+}
+
+// Enabled reports whether any sanitizer is selected.
+func (san *SanitizerOptions) Enabled() bool {
+	return san.Address || san.Thread || san.Memory || san.Dataflow
+}
+
+func (san *SanitizerOptions) resourcePath() string {
+	version := strings.Replace(llvm.Version, "svn", "", 1)
+	return filepath.Join(san.CrtPrefix, "lib", "clang", version)
+}
+
+// IsPIEDefault reports whether the sanitizer requires programs to be
+// position-independent executables.
+func (san *SanitizerOptions) IsPIEDefault() bool {
+	return san.Thread || san.Memory || san.Dataflow
+}
+
+func (san *SanitizerOptions) addPasses(mpm, fpm llvm.PassManager) {
+	switch {
+	case san.Address:
+		mpm.AddAddressSanitizerModulePass()
+		fpm.AddAddressSanitizerFunctionPass()
+	case san.Thread:
+		mpm.AddThreadSanitizerPass()
+	case san.Memory:
+		mpm.AddMemorySanitizerPass()
+	case san.Dataflow:
+		blacklist := san.Blacklist
+		if blacklist == "" {
+			blacklist = filepath.Join(san.resourcePath(), "dfsan_abilist.txt")
+		}
+		mpm.AddDataFlowSanitizerPass(blacklist)
+	}
+}
+
+func (san *SanitizerOptions) libPath(triple, sanitizerName string) string {
+	s := strings.Split(triple, "-")
+	return filepath.Join(san.resourcePath(), "lib", s[2], "libclang_rt."+sanitizerName+"-"+s[0]+".a")
+}
+
+func (san *SanitizerOptions) addLibsForSanitizer(flags []string, triple, sanitizerName string) []string {
+	return append(flags, san.libPath(triple, sanitizerName),
+		"-Wl,--no-as-needed", "-lpthread", "-lrt", "-lm", "-ldl")
+}
+
+func (san *SanitizerOptions) addLibs(triple string, flags []string) []string {
+	switch {
+	case san.Address:
+		flags = san.addLibsForSanitizer(flags, triple, "asan")
+	case san.Thread:
+		flags = san.addLibsForSanitizer(flags, triple, "tsan")
+	case san.Memory:
+		flags = san.addLibsForSanitizer(flags, triple, "msan")
+	case san.Dataflow:
+		flags = san.addLibsForSanitizer(flags, triple, "dfsan")
+	}
+
+	return flags
+}
+
+// Attribute returns the function attribute that enables instrumentation
+// by the sanitizer, or 0 if none is needed.
+func (san *SanitizerOptions) Attribute() llvm.Attribute {
+	switch {
+	case san.Address:
+		return llvm.SanitizeAddressAttribute
+	case san.Thread:
+		return llvm.SanitizeThreadAttribute
+	case san.Memory:
+		return llvm.SanitizeMemoryAttribute
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TargetOptions holds the code generation options given by gcc-style -m
+// flags.
+type TargetOptions struct {
+	// arch and cpu are the values of -march and -mcpu, as given. As in
+	// gcc, -march takes precedence. They are mapped to an LLVM CPU and
+	// features for the target by Resolve.
+	arch, cpu string
+
+	// llvmCPU and archFeatures are the LLVM CPU and target features
+	// selected by arch or cpu.
+	llvmCPU      string
+	archFeatures []string
+
+	// features is the list of LLVM target features to enable ("+name")
+	// or disable ("-name"), in the order in which they were given. Later
+	// entries take precedence. isaFlags holds the flags they were given
+	// by, which name x86 instruction sets.
+	features []string
+	isaFlags []string
+
+	// NoRedZone prevents functions from using the area below the stack
+	// pointer.
+	NoRedZone bool
+}
+
+// gccFeatures maps the names used by gcc's -m and -mno- x86 instruction
+// set flags to the names of the corresponding LLVM target features.
+var gccFeatures = map[string]string{
+	"3dnow":    "3dnow",
+	"3dnowa":   "3dnowa",
+	"abm":      "lzcnt",
+	"adx":      "adx",
+	"aes":      "aes",
+	"avx":      "avx",
+	"avx2":     "avx2",
+	"avx512bw": "avx512bw",
+	"avx512cd": "avx512cd",
+	"avx512dq": "avx512dq",
+	"avx512er": "avx512er",
+	"avx512f":  "avx512f",
+	"avx512pf": "avx512pf",
+	"avx512vl": "avx512vl",
+	"bmi":      "bmi",
+	"bmi2":     "bmi2",
+	"cx16":     "cx16",
+	"f16c":     "f16c",
+	"fma":      "fma",
+	"fma4":     "fma4",
+	"fsgsbase": "fsgsbase",
+	"hle":      "hle",
+	"lzcnt":    "lzcnt",
+	"mmx":      "mmx",
+	"movbe":    "movbe",
+	"pclmul":   "pclmul",
+	"popcnt":   "popcnt",
+	"prfchw":   "prfchw",
+	"rdrnd":    "rdrand",
+	"rdseed":   "rdseed",
+	"rtm":      "rtm",
+	"sahf":     "sahf",
+	"sha":      "sha",
+	"sse":      "sse",
+	"sse2":     "sse2",
+	"sse3":     "sse3",
+	"sse4":     "sse4.2",
+	"sse4.1":   "sse4.1",
+	"sse4.2":   "sse4.2",
+	"sse4a":    "sse4a",
+	"ssse3":    "ssse3",
+	"tbm":      "tbm",
+	"xop":      "xop",
+}
+
+// gccNoOpFlags holds the -m flags that select the target, which is given
+// by the triple instead, and so have no effect. go build passes them to
+// gccgo.
+var gccNoOpFlags = map[string]bool{
+	"-m32":  true,
+	"-m64":  true,
+	"-marm": true,
+}
+
+// armArchCPUs maps the names used by gcc's -march on 32-bit ARM targets to
+// the LLVM CPUs that clang selects for them.
+var armArchCPUs = map[string]string{
+	"armv4":    "strongarm",
+	"armv4t":   "arm7tdmi",
+	"armv5t":   "arm10tdmi",
+	"armv5te":  "arm1022e",
+	"armv5tej": "arm926ej-s",
+	"armv6":    "arm1136jf-s",
+	"armv6j":   "arm1136j-s",
+	"armv6k":   "arm1136jf-s",
+	"armv6z":   "arm1176jzf-s",
+	"armv6zk":  "arm1176jzf-s",
+	"armv6t2":  "arm1156t2-s",
+	"armv6-m":  "cortex-m0",
+	"armv7":    "cortex-a8",
+	"armv7-a":  "cortex-a8",
+	"armv7-r":  "cortex-r4",
+	"armv7-m":  "cortex-m3",
+	"armv7e-m": "cortex-m4",
+	"armv8-a":  "cortex-a53",
+}
+
+// armExtensions and aarch64Extensions map the names of the "+ext"
+// extensions accepted by gcc's -march and -mcpu on ARM and AArch64 targets
+// to the names of the corresponding LLVM target features. An extension is
+// disabled by prefixing its name with "no".
+var armExtensions = map[string]string{
+	"crc": "crc",
+}
+
+var aarch64Extensions = map[string]string{
+	"crc":    "crc",
+	"crypto": "crypto",
+	"fp":     "fp-armv8",
+	"simd":   "neon",
+}
+
+// ParseFlag applies the -m flag to t. Flags that select the target, such as
+// -m64 (which go build passes to gccgo), are ignored, and other flags that
+// are not recognized are rejected.
+func (t *TargetOptions) ParseFlag(flag string) error {
+	switch {
+	case strings.HasPrefix(flag, "-march="), strings.HasPrefix(flag, "-mcpu="):
+		cpu := flag[strings.IndexRune(flag, '=')+1:]
+		switch cpu {
+		case "":
+			return fmt.Errorf("missing CPU name in '%s'", flag)
+		case "native":
+			return fmt.Errorf("'%s' is not supported; name the CPU explicitly", flag)
+		}
+		if strings.HasPrefix(flag, "-march=") {
+			t.arch = cpu
+		} else {
+			t.cpu = cpu
+		}
+
+	case strings.HasPrefix(flag, "-mtune="):
+		// LLVM does not distinguish the CPU being tuned for from the
+		// CPU whose instructions may be used, and tuning must not
+		// enable instructions, so this is accepted but has no effect.
+
+	case flag == "-mno-red-zone":
+		t.NoRedZone = true
+
+	case flag == "-mred-zone":
+		t.NoRedZone = false
+
+	case gccNoOpFlags[flag]:
+		// The triple selects the target.
+
+	case strings.HasPrefix(flag, "-mno-"):
+		feature, ok := gccFeatures[flag[5:]]
+		if !ok {
+			return fmt.Errorf("unrecognized command line option '%s'", flag)
+		}
+		t.features = append(t.features, "-"+feature)
+		t.isaFlags = append(t.isaFlags, flag)
+
+	default:
+		feature, ok := gccFeatures[flag[2:]]
+		if !ok {
+			return fmt.Errorf("unrecognized command line option '%s'", flag)
+		}
+		t.features = append(t.features, "+"+feature)
+		t.isaFlags = append(t.isaFlags, flag)
+	}
+	return nil
+}
+
+// Resolve maps the CPU named by -march or -mcpu to an LLVM CPU and target
+// features for the target described by triple. It must be called once the
+// flags have been parsed, and before CPU or FeatureString. CPUs that cannot
+// be mapped, and instruction set flags for other targets, are rejected.
+func (t *TargetOptions) Resolve(triple string) error {
+	t.llvmCPU, t.archFeatures = "", nil
+	arch := strings.SplitN(triple, "-", 2)[0]
+	if len(t.isaFlags) != 0 && !isX86(arch) {
+		return fmt.Errorf("'%s' is not supported for target '%s'", t.isaFlags[0], triple)
+	}
+
+	flag, value := "-march", t.arch
+	if value == "" {
+		flag, value = "-mcpu", t.cpu
+	}
+	if value == "" {
+		return nil
+	}
+	unsupported := fmt.Errorf("'%s=%s' is not supported for target '%s'", flag, value, triple)
+
+	// The CPU may be followed by "+ext" extensions.
+	exts := strings.Split(value, "+")
+	name := exts[0]
+	exts = exts[1:]
+
+	var extFeatures map[string]string
+	switch {
+	case isX86(arch):
+		// gcc and LLVM share the names of x86 CPUs, and gcc does not
+		// accept extensions for them.
+		t.llvmCPU = name
+
+	case arch == "aarch64" || arch == "arm64":
+		// The only architecture is armv8-a, whose features are those
+		// of LLVM's generic CPU.
+		if flag == "-march" {
+			if name != "armv8-a" {
+				return unsupported
+			}
+			name = "generic"
+		}
+		t.llvmCPU = name
+		extFeatures = aarch64Extensions
+
+	case strings.HasPrefix(arch, "arm") || strings.HasPrefix(arch, "thumb"):
+		if flag == "-march" {
+			cpu, ok := armArchCPUs[name]
+			if !ok {
+				return unsupported
+			}
+			name = cpu
+		}
+		t.llvmCPU = name
+		extFeatures = armExtensions
+
+	default:
+		return unsupported
+	}
+
+	for _, ext := range exts {
+		sign := "+"
+		if strings.HasPrefix(ext, "no") {
+			sign, ext = "-", ext[2:]
+		}
+		feature, ok := extFeatures[ext]
+		if !ok {
+			return unsupported
+		}
+		t.archFeatures = append(t.archFeatures, sign+feature)
+	}
+	return nil
+}
+
+// isX86 reports whether arch, the first component of a triple, names an
+// x86 architecture.
+func isX86(arch string) bool {
+	switch arch {
+	case "i386", "i486", "i586", "i686", "x86_64", "amd64":
+		return true
+	}
+	return false
+}
+
+// CPU returns the LLVM name of the CPU to generate code for, or the
+// empty string for the triple's default.
+func (t *TargetOptions) CPU() string {
+	return t.llvmCPU
+}
+
+// FeatureString returns t's features in the form expected by LLVM. The
+// features of -march or -mcpu come first, so that -m flags override them.
+func (t *TargetOptions) FeatureString() string {
+	features := append(append([]string(nil), t.archFeatures...), t.features...)
+	return strings.Join(features, ",")
+}
@@ -13,6 +13,18 @@ gofrontend_builddir=$workdir/gofrontend_build
 mkdir -p "$prefix/bin"
 cp $workdir/gllgo-stage3 "$prefix/bin/llgo"
 
+# Install the build driver.
+cp $workdir/llgo-build "$prefix/bin/llgo-build"
+
+# Install the demangler.
+cp $workdir/llgo-demangle "$prefix/bin/llgo-demangle"
+
+# Install the gdb pretty-printers, which programs built with -g refer to, and
+# the lldb formatters.
+mkdir -p "$prefix/share/llgo"
+cp $llgodir/utils/gdb/llgo-gdb.py "$prefix/share/llgo/llgo-gdb.py"
+cp $llgodir/utils/lldb/llgo_lldb.py "$prefix/share/llgo/llgo_lldb.py"
+
 # Install llgo-go.
 cp $llgodir/llgo-go.sh "$prefix/bin/llgo-go"
 chmod +x "$prefix/bin/llgo-go"
@@ -0,0 +1,358 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package irgen
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/tools/go/types"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// An abiLowering decides how the arguments and results of functions are
+// represented in LLVM IR, following the C ABI of a target. Go functions
+// use the same ABI as C functions, so that they may call and be called by
+// the C parts of the runtime.
+type abiLowering interface {
+	functionTypeInfo(tm *llvmTypeMap, args []types.Type, results []types.Type) functionTypeInfo
+}
+
+// checkABILowering returns an error if the C ABI of the given triple is
+// known to differ from every lowering, so that the signatures of Go
+// functions would not match those of the C functions they call and are
+// called by.
+func checkABILowering(triple string) error {
+	switch tripleArch(triple) {
+	case "ppc64":
+		// The ELFv1 ABI of big-endian PowerPC64 returns composites
+		// other than complex numbers in memory, and does not pass
+		// homogeneous aggregates in floating-point registers.
+		return fmt.Errorf("the C ABI of big-endian PowerPC64 is not supported: %s", triple)
+	}
+	return nil
+}
+
+// newABILowering returns the lowering for the C ABI of the given triple.
+// Targets without a specific lowering, other than those rejected by
+// checkABILowering, use the x86-64 lowering.
+func newABILowering(triple string) abiLowering {
+	env := triple[strings.LastIndex(triple, "-")+1:]
+	switch arch := tripleArch(triple); arch {
+	case "x86":
+		return i386Lowering{}
+
+	case "aarch64":
+		return &aggregateLowering{
+			maxHomogeneous:  4,
+			maxDirectArg:    16,
+			maxDirectResult: 16,
+			regSize:         8,
+		}
+
+	case "arm", "thumb":
+		l := &aggregateLowering{
+			maxDirectArg:    64,
+			maxDirectResult: 4,
+			regSize:         4,
+			byVal:           true,
+		}
+		if strings.HasSuffix(env, "hf") {
+			l.maxHomogeneous = 4
+		}
+		return l
+
+	case "wasm32":
+		// As in clang, composites are passed and returned in memory.
+		return &aggregateLowering{byVal: true}
+
+	case "ppc64le":
+		return &aggregateLowering{
+			maxHomogeneous:  8,
+			maxDirectArg:    64,
+			maxDirectResult: 16,
+			regSize:         8,
+			byVal:           true,
+			resultPairs:     true,
+		}
+	}
+	return amd64Lowering{}
+}
+
+// amd64Lowering implements the System V x86-64 ABI.
+type amd64Lowering struct{}
+
+// isComposite reports whether bt is an aggregate or complex type.
+func isComposite(bt backendType) bool {
+	switch bt.(type) {
+	case *structBType, *arrayBType:
+		return true
+	}
+	return false
+}
+
+// homogeneousAggregate determines whether the composite bt consists of
+// between one and max members of the same floating-point type. If so, it
+// returns the type of the members, and the number of members.
+func (tm *llvmTypeMap) homogeneousAggregate(bt backendType, max int) (elem *floatBType, n int, ok bool) {
+	offsets := tm.getBackendOffsets(bt)
+	if len(offsets) == 0 || len(offsets) > max {
+		return nil, 0, false
+	}
+	for _, ot := range offsets {
+		f, ok := ot.typ.(*floatBType)
+		if !ok || (elem != nil && f.isDouble != elem.isDouble) {
+			return nil, 0, false
+		}
+		elem = f
+	}
+	return elem, len(offsets), true
+}
+
+// aggregateLowering implements the ABIs of targets whose LLVM backends
+// allocate registers to arguments and results, so that it suffices to
+// decide how each of them is represented. These are AAPCS64 on AArch64,
+// the ARM EABI (AAPCS, or AAPCS-VFP on hard-float targets) and the ELFv2
+// ABI on little-endian PowerPC64. Their rules are as follows:
+//
+//   - Scalars are passed directly.
+//   - Homogeneous aggregates of up to maxHomogeneous floating-point members
+//     are passed as an array of their member type, which the backend
+//     allocates to consecutive floating-point registers, or to the stack.
+//   - Other composites of up to maxDirectArg bytes are passed as an integer
+//     if they fit in a register, and otherwise as an array of registers.
+//     The same applies to results of up to maxDirectResult bytes. Whether
+//     the backend may split an argument array between the remaining
+//     registers and the stack depends on the ABI: AAPCS64 never does (rule
+//     C.11), but AAPCS (rule C.5) and ELFv2 do, so code must not assume
+//     that an argument lies wholly in registers or wholly on the stack.
+//   - Larger composites are passed by reference, or, if byVal is set, copied
+//     to the stack. Larger results are returned through memory whose
+//     address is passed by the caller (on AArch64, in x8).
+type aggregateLowering struct {
+	maxHomogeneous  int
+	maxDirectArg    int64
+	maxDirectResult int64
+
+	// regSize is the size in bytes of a general-purpose register.
+	regSize int64
+
+	// byVal is set if composites passed in memory are copied to the
+	// stack, rather than passed by reference to a copy.
+	byVal bool
+
+	// resultPairs is set if results occupying two registers are
+	// returned as a pair of registers, rather than as an array.
+	resultPairs bool
+}
+
+// lower returns the types through which an argument, or if result is set,
+// a result of backend type bt is passed directly, or indirect if it is
+// passed in memory.
+func (l *aggregateLowering) lower(tm *llvmTypeMap, bt backendType, result bool) (argTypes []llvm.Type, indirect bool) {
+	if !isComposite(bt) {
+		return []llvm.Type{bt.ToLLVM(tm.ctx)}, false
+	}
+	if elem, n, ok := tm.homogeneousAggregate(bt, l.maxHomogeneous); ok {
+		return []llvm.Type{llvm.ArrayType(elem.ToLLVM(tm.ctx), n)}, false
+	}
+
+	maxDirect := l.maxDirectArg
+	if result {
+		maxDirect = l.maxDirectResult
+	}
+	llt := bt.ToLLVM(tm.ctx)
+	size := int64(tm.target.TypeAllocSize(llt))
+	switch {
+	case size == 0:
+		return nil, false
+	case size <= l.regSize:
+		return []llvm.Type{tm.ctx.IntType(int(size) * 8)}, false
+	case size <= maxDirect:
+		// Composites aligned to more than a register occupy an
+		// aligned pair of registers.
+		elemSize := l.regSize
+		if a := int64(tm.target.ABITypeAlignment(llt)); a > elemSize {
+			elemSize = a
+		}
+		elem := tm.ctx.IntType(int(elemSize) * 8)
+		n := int(align(size, elemSize) / elemSize)
+		if result && l.resultPairs && n == 2 {
+			return []llvm.Type{elem, elem}, false
+		}
+		return []llvm.Type{llvm.ArrayType(elem, n)}, false
+	}
+	return nil, true
+}
+
+func (l *aggregateLowering) functionTypeInfo(tm *llvmTypeMap, args []types.Type, results []types.Type) (fi functionTypeInfo) {
+	var returnType llvm.Type
+	var argTypes []llvm.Type
+	if len(results) == 0 {
+		returnType = tm.ctx.VoidType()
+		fi.retInf = &directRetInfo{}
+	} else {
+		var resultsType llvm.Type
+		var bt backendType
+		if len(results) == 1 {
+			resultsType = tm.ToLLVM(results[0])
+			bt = tm.getBackendType(results[0])
+		} else {
+			elements := make([]llvm.Type, len(results))
+			var retFields []backendType
+			for i, t := range results {
+				elements[i] = tm.ToLLVM(t)
+				retFields = append(retFields, tm.getBackendType(t))
+			}
+			resultsType = tm.ctx.StructType(elements, false)
+			bt = &structBType{retFields}
+		}
+
+		retTypes, indirect := l.lower(tm, bt, true)
+		switch {
+		case indirect:
+			returnType = tm.ctx.VoidType()
+			argTypes = []llvm.Type{llvm.PointerType(resultsType, 0)}
+			fi.argAttrs = []llvm.Attribute{llvm.StructRetAttribute}
+			fi.retInf = &indirectRetInfo{numResults: len(results), resultsType: resultsType}
+
+		case len(retTypes) == 0:
+			returnType = tm.ctx.VoidType()
+			fi.retInf = &directRetInfo{numResults: len(results), resultsType: resultsType}
+
+		case len(retTypes) == 1:
+			returnType = retTypes[0]
+			fi.retInf = &directRetInfo{numResults: len(results), retTypes: retTypes, resultsType: resultsType}
+
+		default:
+			returnType = tm.ctx.StructType(retTypes, false)
+			fi.retInf = &directRetInfo{numResults: len(results), retTypes: retTypes, resultsType: resultsType}
+		}
+	}
+
+	for _, arg := range args {
+		bt := tm.getBackendType(arg)
+		directArgTypes, indirect := l.lower(tm, bt, false)
+		if indirect {
+			fi.argInfos = append(fi.argInfos, &indirectArgInfo{len(argTypes)})
+			argTypes = append(argTypes, llvm.PointerType(tm.ToLLVM(arg), 0))
+			if l.byVal {
+				fi.argAttrs = append(fi.argAttrs, llvm.ByValAttribute)
+			} else {
+				// The callee receives a pointer to the caller's
+				// copy.
+				fi.argAttrs = append(fi.argAttrs, 0)
+			}
+			continue
+		}
+		fi.argInfos = append(fi.argInfos, &directArgInfo{
+			argOffset: len(argTypes),
+			argTypes:  directArgTypes,
+			valType:   bt.ToLLVM(tm.ctx),
+		})
+		argTypes = append(argTypes, directArgTypes...)
+		fi.argAttrs = append(fi.argAttrs, make([]llvm.Attribute, len(directArgTypes))...)
+	}
+
+	fi.functionType = llvm.FunctionType(returnType, argTypes, false)
+	return
+}
+
+// i386Lowering implements the System V i386 ABI, in which all arguments
+// are passed on the stack. As in clang, composites of up to 16 bytes made
+// up only of 4- and 8-byte scalars are expanded into their members, which
+// has the same effect on the stack layout as copying them, and other
+// composites are copied to the stack. As in gcc, complex64 results are
+// returned in EDX:EAX, and other composite results, including complex128,
+// are returned through memory whose address is passed by the caller.
+type i386Lowering struct{}
+
+// expand returns the types of the members of the composite bt, and
+// whether it may be expanded into them.
+func (i386Lowering) expand(tm *llvmTypeMap, bt backendType) (argTypes []llvm.Type, ok bool) {
+	var size uint64
+	for _, ot := range tm.getBackendOffsets(bt) {
+		t := ot.typ.ToLLVM(tm.ctx)
+		tsize := tm.target.TypeAllocSize(t)
+		if tsize != 4 && tsize != 8 {
+			return nil, false
+		}
+		argTypes = append(argTypes, t)
+		size += tsize
+	}
+	if size > 16 || size != tm.target.TypeAllocSize(bt.ToLLVM(tm.ctx)) {
+		return nil, false
+	}
+	return argTypes, true
+}
+
+func isComplex(t types.Type) bool {
+	if b, ok := t.Underlying().(*types.Basic); ok {
+		return b.Info()&types.IsComplex != 0
+	}
+	return false
+}
+
+func (l i386Lowering) functionTypeInfo(tm *llvmTypeMap, args []types.Type, results []types.Type) (fi functionTypeInfo) {
+	var returnType llvm.Type
+	var argTypes []llvm.Type
+	switch {
+	case len(results) == 0:
+		returnType = tm.ctx.VoidType()
+		fi.retInf = &directRetInfo{}
+
+	case len(results) == 1 && !isComposite(tm.getBackendType(results[0])):
+		bt := tm.getBackendType(results[0])
+		returnType = bt.ToLLVM(tm.ctx)
+		fi.retInf = &directRetInfo{numResults: 1, retTypes: []llvm.Type{returnType}, resultsType: tm.ToLLVM(results[0])}
+
+	case len(results) == 1 && isComplex(results[0]) && tm.Sizeof(results[0]) == 8:
+		returnType = tm.ctx.Int64Type()
+		fi.retInf = &directRetInfo{numResults: 1, retTypes: []llvm.Type{returnType}, resultsType: tm.ToLLVM(results[0])}
+
+	default:
+		var resultsType llvm.Type
+		if len(results) == 1 {
+			resultsType = tm.ToLLVM(results[0])
+		} else {
+			elements := make([]llvm.Type, len(results))
+			for i := range elements {
+				elements[i] = tm.ToLLVM(results[i])
+			}
+			resultsType = tm.ctx.StructType(elements, false)
+		}
+		returnType = tm.ctx.VoidType()
+		argTypes = []llvm.Type{llvm.PointerType(resultsType, 0)}
+		fi.argAttrs = []llvm.Attribute{llvm.StructRetAttribute}
+		fi.retInf = &indirectRetInfo{numResults: len(results), resultsType: resultsType}
+	}
+
+	for _, arg := range args {
+		bt := tm.getBackendType(arg)
+		var directArgTypes []llvm.Type
+		if isComposite(bt) {
+			var ok bool
+			directArgTypes, ok = l.expand(tm, bt)
+			if !ok {
+				fi.argInfos = append(fi.argInfos, &indirectArgInfo{len(argTypes)})
+				argTypes = append(argTypes, llvm.PointerType(tm.ToLLVM(arg), 0))
+				fi.argAttrs = append(fi.argAttrs, llvm.ByValAttribute)
+				continue
+			}
+		} else {
+			directArgTypes = []llvm.Type{bt.ToLLVM(tm.ctx)}
+		}
+		fi.argInfos = append(fi.argInfos, &directArgInfo{
+			argOffset: len(argTypes),
+			argTypes:  directArgTypes,
+			valType:   bt.ToLLVM(tm.ctx),
+		})
+		argTypes = append(argTypes, directArgTypes...)
+		fi.argAttrs = append(fi.argAttrs, make([]llvm.Attribute, len(directArgTypes))...)
+	}
+
+	fi.functionType = llvm.FunctionType(returnType, argTypes, false)
+	return
+}
@@ -5,6 +5,7 @@
 package irgen
 
 import (
+	"fmt"
 	"go/ast"
 	"go/token"
 	"golang.org/x/tools/go/loader"
@@ -29,7 +30,9 @@ func (c *compiler) processAnnotations(u *unit, pkginfo *loader.PackageInfo) {
 		for _, ident := range idents {
 			if v := members[pkginfo.ObjectOf(ident)]; !v.IsNil() {
 				for _, attr := range attrs {
-					attr.Apply(v)
+					if err := attr.Apply(v); err != nil {
+						c.reportAttributeError(err, ident, members)
+					}
 				}
 			}
 		}
@@ -38,7 +41,7 @@ func (c *compiler) processAnnotations(u *unit, pkginfo *loader.PackageInfo) {
 		for _, decl := range f.Decls {
 			switch decl := decl.(type) {
 			case *ast.FuncDecl:
-				attrs := parseAttributes(decl.Doc)
+				attrs := parseAttributes(decl.Doc, c.fileset, &c.diagnostics)
 				applyAttributes(attrs, decl.Name)
 			case *ast.GenDecl:
 				if decl.Tok != token.VAR {
@@ -46,10 +49,27 @@ func (c *compiler) processAnnotations(u *unit, pkginfo *loader.PackageInfo) {
 				}
 				for _, spec := range decl.Specs {
 					varspec := spec.(*ast.ValueSpec)
-					attrs := parseAttributes(decl.Doc)
+					attrs := parseAttributes(decl.Doc, c.fileset, &c.diagnostics)
 					applyAttributes(attrs, varspec.Names...)
 				}
 			}
 		}
 	}
 }
+
+// reportAttributeError reports the failure to apply an attribute to the
+// declaration of ident. If the attribute's name is taken by a function of
+// the package, its declaration is also reported.
+func (c *compiler) reportAttributeError(err error, ident *ast.Ident, members map[types.Object]llvm.Value) {
+	d := c.diagnostics.Add(c.fileset.Position(ident.Pos()), SeverityError, err.Error())
+	if conflict, ok := err.(*nameConflictError); ok {
+		for obj, v := range members {
+			if v == conflict.fn {
+				d.Related = append(d.Related, RelatedPosition{
+					Pos: c.fileset.Position(obj.Pos()),
+					Msg: fmt.Sprintf("%s is defined by %s", conflict.name, obj.Name()),
+				})
+			}
+		}
+	}
+}
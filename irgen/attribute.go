@@ -7,6 +7,7 @@ package irgen
 import (
 	"fmt"
 	"go/ast"
+	"go/token"
 	"llvm.org/llvm/bindings/go/llvm"
 	"strings"
 )
@@ -16,13 +17,14 @@ const AttributeCommentPrefix = "#llgo "
 // Attribute represents an attribute associated with a
 // global variable or function.
 type Attribute interface {
-	Apply(llvm.Value)
+	Apply(llvm.Value) error
 }
 
 // parseAttribute parses zero or more #llgo comment attributes associated with
 // a global variable or function. The comment group provided will be processed
-// one line at a time using parseAttribute.
-func parseAttributes(doc *ast.CommentGroup) []Attribute {
+// one line at a time using parseAttribute. Invalid attributes are reported to
+// diags, at the position of their comment.
+func parseAttributes(doc *ast.CommentGroup, fset *token.FileSet, diags *DiagnosticList) []Attribute {
 	var attributes []Attribute
 	if doc == nil {
 		return attributes
@@ -37,7 +39,11 @@ func parseAttributes(doc *ast.CommentGroup) []Attribute {
 		if strings.HasPrefix(comment.Text, "/*") {
 			text = text[:len(text)-2]
 		}
-		attr := parseAttribute(strings.TrimSpace(text))
+		attr, err := parseAttribute(strings.TrimSpace(text))
+		if err != nil {
+			diags.Add(fset.Position(comment.Pos()), SeverityError, err.Error())
+			continue
+		}
 		if attr != nil {
 			attributes = append(attributes, attr)
 		}
@@ -48,9 +54,9 @@ func parseAttributes(doc *ast.CommentGroup) []Attribute {
 // parseAttribute parses a single #llgo comment attribute associated with
 // a global variable or function. The string provided will be parsed
 // if it begins with AttributeCommentPrefix, otherwise nil is returned.
-func parseAttribute(line string) Attribute {
+func parseAttribute(line string) (Attribute, error) {
 	if !strings.HasPrefix(line, AttributeCommentPrefix) {
-		return nil
+		return nil, nil
 	}
 	line = strings.TrimSpace(line[len(AttributeCommentPrefix):])
 	colon := strings.IndexRune(line, ':')
@@ -62,24 +68,22 @@ func parseAttribute(line string) Attribute {
 	}
 	switch key {
 	case "linkage":
-		return parseLinkageAttribute(value)
+		return parseLinkageAttribute(value), nil
 	case "name":
-		return nameAttribute(strings.TrimSpace(value))
+		return nameAttribute(strings.TrimSpace(value)), nil
 	case "attr":
-		return parseLLVMAttribute(strings.TrimSpace(value))
+		return parseLLVMAttribute(strings.TrimSpace(value)), nil
 	case "thread_local":
-		return tlsAttribute{}
-	default:
-		// FIXME decide what to do here. return error? log warning?
-		panic("unknown attribute key: " + key)
+		return tlsAttribute{}, nil
 	}
-	return nil
+	return nil, fmt.Errorf("unknown #llgo attribute %q", key)
 }
 
 type linkageAttribute llvm.Linkage
 
-func (a linkageAttribute) Apply(v llvm.Value) {
+func (a linkageAttribute) Apply(v llvm.Value) error {
 	v.SetLinkage(llvm.Linkage(a))
+	return nil
 }
 
 func parseLinkageAttribute(value string) linkageAttribute {
@@ -116,13 +120,24 @@ func parseLinkageAttribute(value string) linkageAttribute {
 
 type nameAttribute string
 
-func (a nameAttribute) Apply(v llvm.Value) {
+// nameConflictError is returned by nameAttribute.Apply if the name is
+// already taken by a function with a body.
+type nameConflictError struct {
+	name string
+	fn   llvm.Value
+}
+
+func (e *nameConflictError) Error() string {
+	return fmt.Sprintf("cannot take the name %s from a function that has a body", e.name)
+}
+
+func (a nameAttribute) Apply(v llvm.Value) error {
 	if !v.IsAFunction().IsNil() {
 		name := string(a)
 		curr := v.GlobalParent().NamedFunction(name)
 		if !curr.IsNil() && curr != v {
 			if curr.BasicBlocksCount() != 0 {
-				panic(fmt.Errorf("Want to take the name %s from a function that has a body!", name))
+				return &nameConflictError{name, curr}
 			}
 			curr.SetName(name + "_llgo_replaced")
 			curr.ReplaceAllUsesWith(llvm.ConstBitCast(v, curr.Type()))
@@ -131,6 +146,7 @@ func (a nameAttribute) Apply(v llvm.Value) {
 	} else {
 		v.SetName(string(a))
 	}
+	return nil
 }
 
 func parseLLVMAttribute(value string) llvmAttribute {
@@ -153,16 +169,18 @@ func parseLLVMAttribute(value string) llvmAttribute {
 
 type llvmAttribute llvm.Attribute
 
-func (a llvmAttribute) Apply(v llvm.Value) {
+func (a llvmAttribute) Apply(v llvm.Value) error {
 	if !v.IsAFunction().IsNil() {
 		v.AddFunctionAttr(llvm.Attribute(a))
 	} else {
 		v.AddAttribute(llvm.Attribute(a))
 	}
+	return nil
 }
 
 type tlsAttribute struct{}
 
-func (tlsAttribute) Apply(v llvm.Value) {
+func (tlsAttribute) Apply(v llvm.Value) error {
 	v.SetThreadLocal(true)
+	return nil
 }
@@ -0,0 +1,94 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package irgen
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"sort"
+
+	"golang.org/x/tools/go/ssa"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// boundsCheck emits a check that 0 <= index < length for the index
+// operation instr, unless the bounds check elimination pass has proven
+// the operation to be in bounds.
+func (fr *frame) boundsCheck(instr ssa.Instruction, index, length llvm.Value, errcode uint64) {
+	if fr.bce != nil {
+		if _, ok := fr.bce.InBounds[instr]; ok {
+			return
+		}
+	}
+
+	zero := llvm.ConstNull(fr.types.inttype)
+	i0 := fr.builder.CreateICmp(llvm.IntSLT, index, zero, "")
+	li := fr.builder.CreateICmp(llvm.IntSLE, length, index, "")
+
+	cond := fr.builder.CreateOr(i0, li, "")
+
+	fr.condBrRuntimeError(cond, errcode)
+}
+
+// bceRecord describes a bounds check removed by the bounds check
+// elimination pass.
+type bceRecord struct {
+	pos    token.Position
+	fn     string
+	instr  string
+	reason string
+}
+
+type byBCERecordPos []bceRecord
+
+func (a byBCERecordPos) Len() int      { return len(a) }
+func (a byBCERecordPos) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byBCERecordPos) Less(i, j int) bool {
+	switch {
+	case a[i].pos.Filename != a[j].pos.Filename:
+		return a[i].pos.Filename < a[j].pos.Filename
+	case a[i].pos.Offset != a[j].pos.Offset:
+		return a[i].pos.Offset < a[j].pos.Offset
+	case a[i].fn != a[j].fn:
+		return a[i].fn < a[j].fn
+	default:
+		return a[i].instr < a[j].instr
+	}
+}
+
+// dumpEliminatedBoundsChecks writes the bounds checks removed from the
+// functions of pkg to w, ordered by source position.
+func (c *compiler) dumpEliminatedBoundsChecks(w io.Writer, pkg *ssa.Package) error {
+	if c.bce == nil {
+		return nil
+	}
+	var records []bceRecord
+	for instr, reason := range c.bce.InBounds {
+		fn := instr.Parent()
+		if fn.Pkg != pkg && fn.Pkg != nil {
+			continue
+		}
+		pos := instr.Pos()
+		if pos == token.NoPos {
+			pos = fn.Pos()
+		}
+		records = append(records, bceRecord{
+			pos:    c.fileset.Position(pos),
+			fn:     fn.String(),
+			instr:  instr.String(),
+			reason: reason,
+		})
+	}
+	sort.Sort(byBCERecordPos(records))
+
+	for _, r := range records {
+		_, err := fmt.Fprintf(w, "%s: %s: bounds check for %q eliminated: %s\n", formatPosition(r.pos), r.fn, r.instr, r.reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
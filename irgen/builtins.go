@@ -76,9 +76,9 @@ func (fr *frame) callCopy(dest, source *govalue) *govalue {
 
 func (fr *frame) callRecover(isDeferredRecover bool) *govalue {
 	startbb := fr.builder.GetInsertBlock()
-	recoverbb := llvm.AddBasicBlock(fr.function, "")
-	contbb := llvm.AddBasicBlock(fr.function, "")
-	canRecover := fr.builder.CreateTrunc(fr.canRecover, llvm.Int1Type(), "")
+	recoverbb := fr.ctx.AddBasicBlock(fr.function, "")
+	contbb := fr.ctx.AddBasicBlock(fr.function, "")
+	canRecover := fr.builder.CreateTrunc(fr.canRecover, fr.ctx.Int1Type(), "")
 	fr.builder.CreateCondBr(canRecover, recoverbb, contbb)
 
 	fr.builder.SetInsertPointAtEnd(recoverbb)
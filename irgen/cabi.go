@@ -300,16 +300,14 @@ func directEncode(ctx llvm.Context, allocaBuilder llvm.Builder, builder llvm.Bui
 		builder.CreateStore(val, alloca)
 		args[0] = builder.CreateLoad(bitcast, "")
 
-	case 2:
-		encodeType := llvm.StructType(argTypes, false)
+	default:
+		encodeType := ctx.StructType(argTypes, false)
 		alloca := allocaBuilder.CreateAlloca(valType, "")
 		bitcast := builder.CreateBitCast(alloca, llvm.PointerType(encodeType, 0), "")
 		builder.CreateStore(val, alloca)
-		args[0] = builder.CreateLoad(builder.CreateStructGEP(bitcast, 0, ""), "")
-		args[1] = builder.CreateLoad(builder.CreateStructGEP(bitcast, 1, ""), "")
-
-	default:
-		panic("unexpected argTypes size")
+		for i := range argTypes {
+			args[i] = builder.CreateLoad(builder.CreateStructGEP(bitcast, i, ""), "")
+		}
 	}
 }
 
@@ -332,7 +330,7 @@ func directDecode(ctx llvm.Context, allocaBuilder llvm.Builder, builder llvm.Bui
 		bitcast := builder.CreateBitCast(alloca, llvm.PointerType(args[0].Type(), 0), "")
 		builder.CreateStore(args[0], bitcast)
 
-	case 2:
+	default:
 		alloca = allocaBuilder.CreateAlloca(valType, "")
 		var argTypes []llvm.Type
 		for _, a := range args {
@@ -340,11 +338,9 @@ func directDecode(ctx llvm.Context, allocaBuilder llvm.Builder, builder llvm.Bui
 		}
 		encodeType := ctx.StructType(argTypes, false)
 		bitcast := builder.CreateBitCast(alloca, llvm.PointerType(encodeType, 0), "")
-		builder.CreateStore(args[0], builder.CreateStructGEP(bitcast, 0, ""))
-		builder.CreateStore(args[1], builder.CreateStructGEP(bitcast, 1, ""))
-
-	default:
-		panic("unexpected argTypes size")
+		for i, a := range args {
+			builder.CreateStore(a, builder.CreateStructGEP(bitcast, i, ""))
+		}
 	}
 
 	return builder.CreateLoad(alloca, "")
@@ -530,11 +526,15 @@ func (fi *functionTypeInfo) invoke(ctx llvm.Context, allocaBuilder llvm.Builder,
 	return fi.retInf.decode(ctx, allocaBuilder, builder, call)
 }
 
-func (tm *llvmTypeMap) getFunctionTypeInfo(args []types.Type, results []types.Type) (fi functionTypeInfo) {
+func (tm *llvmTypeMap) getFunctionTypeInfo(args []types.Type, results []types.Type) functionTypeInfo {
+	return tm.abi.functionTypeInfo(tm, args, results)
+}
+
+func (amd64Lowering) functionTypeInfo(tm *llvmTypeMap, args []types.Type, results []types.Type) (fi functionTypeInfo) {
 	var returnType llvm.Type
 	var argTypes []llvm.Type
 	if len(results) == 0 {
-		returnType = llvm.VoidType()
+		returnType = tm.ctx.VoidType()
 		fi.retInf = &directRetInfo{}
 	} else {
 		aik := tm.classify(results...)
@@ -561,19 +561,19 @@ func (tm *llvmTypeMap) getFunctionTypeInfo(args []types.Type, results []types.Ty
 			retTypes, retAttrs, _, _ := tm.expandType(nil, nil, bt)
 			switch len(retTypes) {
 			case 0: // e.g., empty struct
-				returnType = llvm.VoidType()
+				returnType = tm.ctx.VoidType()
 			case 1:
 				returnType = retTypes[0]
 				fi.retAttr = retAttrs[0]
 			case 2:
-				returnType = llvm.StructType(retTypes, false)
+				returnType = tm.ctx.StructType(retTypes, false)
 			default:
 				panic("unexpected expandType result")
 			}
 			fi.retInf = &directRetInfo{numResults: len(results), retTypes: retTypes, resultsType: resultsType}
 
 		case AIK_Indirect:
-			returnType = llvm.VoidType()
+			returnType = tm.ctx.VoidType()
 			argTypes = []llvm.Type{llvm.PointerType(resultsType, 0)}
 			fi.argAttrs = []llvm.Attribute{llvm.StructRetAttribute}
 			fi.retInf = &indirectRetInfo{numResults: len(results), resultsType: resultsType}
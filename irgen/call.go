@@ -22,8 +22,11 @@ func (fr *frame) createCall(fn *govalue, argValues []*govalue) []*govalue {
 	var results []llvm.Value
 	if fr.unwindBlock.IsNil() {
 		results = typinfo.call(fr.types.ctx, fr.allocaBuilder, fr.builder, fn.value, args)
+	} else if fr.wasm {
+		results = typinfo.call(fr.types.ctx, fr.allocaBuilder, fr.builder, fn.value, args)
+		fr.checkPanicking(fr.ctx.AddBasicBlock(fr.function, ""), fr.unwindBlock)
 	} else {
-		contbb := llvm.AddBasicBlock(fr.function, "")
+		contbb := fr.ctx.AddBasicBlock(fr.function, "")
 		results = typinfo.invoke(fr.types.ctx, fr.allocaBuilder, fr.builder, fn.value, args, contbb, fr.unwindBlock)
 	}
 
@@ -6,19 +6,27 @@ package irgen
 
 import (
 	"golang.org/x/tools/go/types"
+	"llvm.org/llvm/bindings/go/llvm"
 )
 
 // makeClosure creates a closure from a function pointer and
 // a set of bindings. The bindings are addresses of captured
-// variables.
-func (fr *frame) makeClosure(fn *govalue, bindings []*govalue) *govalue {
+// variables. If onStack is set, the closure is allocated on
+// the stack; escape analysis must have determined that the
+// closure does not escape.
+func (fr *frame) makeClosure(fn *govalue, bindings []*govalue, onStack bool) *govalue {
 	govalues := append([]*govalue{fn}, bindings...)
 	fields := make([]*types.Var, len(govalues))
 	for i, v := range govalues {
 		field := types.NewField(0, nil, "_", v.Type(), false)
 		fields[i] = field
 	}
-	block := fr.createTypeMalloc(types.NewStruct(fields, nil))
+	var block llvm.Value
+	if onStack {
+		block = fr.allocaBuilder.CreateAlloca(fr.types.ToLLVM(types.NewStruct(fields, nil)), "")
+	} else {
+		block = fr.createTypeMalloc(types.NewStruct(fields, nil))
+	}
 	for i, v := range govalues {
 		addressPtr := fr.builder.CreateStructGEP(block, i, "")
 		fr.builder.CreateStore(v.value, addressPtr)
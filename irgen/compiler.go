@@ -6,15 +6,22 @@ package irgen
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"go/build"
+	"go/parser"
+	"go/scanner"
 	"go/token"
 	"log"
+	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 
 	llgobuild "github.com/go-llvm/llgo/build"
 	"github.com/go-llvm/llgo/debug"
+	"github.com/go-llvm/llgo/ssaopt"
 	"llvm.org/llvm/bindings/go/llvm"
 
 	"golang.org/x/tools/go/gccgoimporter"
@@ -28,7 +35,17 @@ type Module struct {
 	llvm.Module
 	Path       string
 	ExportData []byte
-	disposed   bool
+
+	// EscapeData holds the escape summaries of the package's functions,
+	// for use by packages importing it. Drivers store it in the
+	// ".go_escape" section of the object file.
+	EscapeData []byte
+
+	// Diagnostics holds the warnings diagnosed while compiling the
+	// package. Errors are instead returned by the compiler.
+	Diagnostics DiagnosticList
+
+	disposed bool
 }
 
 func (m *Module) Dispose() {
@@ -53,6 +70,16 @@ type CompilerOptions struct {
 	// replacement prefixes, to be applied in debug info.
 	DebugPrefixMaps []debug.PrefixMap
 
+	// LineTablesOnly restricts the debug data to the line tables, as
+	// -gline-tables-only does for clang: functions are described, but
+	// not the types and variables of the program.
+	LineTablesOnly bool
+
+	// SplitDwarfFile, if not empty, is the name of the file to which
+	// the driver splits the debug data of the object file. It is
+	// recorded in the compile unit, so that debuggers can find it.
+	SplitDwarfFile string
+
 	// Logger is a logger used for tracing compilation.
 	Logger *log.Logger
 
@@ -60,6 +87,16 @@ type CompilerOptions struct {
 	// to stderr before generating code for it.
 	DumpSSA bool
 
+	// DumpEscape, if not EscapeDumpNone, causes the result of escape
+	// analysis for each heap allocation to be written to stderr, in the
+	// given format, once the package has been compiled.
+	DumpEscape EscapeDumpFormat
+
+	// DumpBCE causes the bounds checks removed by the bounds check
+	// elimination pass to be written to stderr once the package has
+	// been compiled.
+	DumpBCE bool
+
 	// GccgoPath is the path to the gccgo binary whose libgo we read import
 	// data from. If blank, the caller is expected to supply an import
 	// path in ImportPaths.
@@ -68,19 +105,88 @@ type CompilerOptions struct {
 	// ImportPaths is the list of additional import paths
 	ImportPaths []string
 
+	// BinaryImports causes CompilePackages to compile only the named
+	// packages from source. The packages they import are instead read
+	// from export data, as for Compile.
+	BinaryImports bool
+
+	// LLVMContext is the LLVM context in which the modules are created.
+	// If it is nil, the global context is used. A context may only be
+	// used by one compilation at a time, so compilations that run in
+	// parallel must each be given a context of their own, which the
+	// caller disposes of once it has disposed of the modules.
+	LLVMContext llvm.Context
+
 	// SanitizerAttribute is an attribute to apply to functions to enable
 	// dynamic instrumentation using a sanitizer.
 	SanitizerAttribute llvm.Attribute
+
+	// OpenCodedDefers enables the open-coding of deferred calls where
+	// possible: the calls are made directly on return, and a function
+	// registers a single call with the runtime, which makes any calls
+	// that remain live if the function panics or calls runtime.Goexit.
+	OpenCodedDefers bool
+
+	// TargetCPU and TargetFeatures, if not empty, are the LLVM names of
+	// the CPU to generate code for and the comma-separated list of target
+	// features to enable ("+name") or disable ("-name"). They are recorded
+	// in the attributes of each function, so that they are honored when
+	// the module is compiled as part of a link-time optimization.
+	TargetCPU      string
+	TargetFeatures string
+
+	// DisableRedZone prevents functions from using the area below the
+	// stack pointer.
+	DisableRedZone bool
+
+	// DisableSplitStack compiles functions without split-stack
+	// prologues, for use with a runtime whose goroutines have
+	// contiguous stacks of a fixed size.
+	DisableSplitStack bool
+
+	// Freestanding compiles code that does not depend on libgo. The
+	// runtime functions called by generated code are instead those of
+	// the package with import path RuntimePackage, as described in
+	// freestanding.go, and functions do not use split stacks. If
+	// RuntimePackage is empty, only code that calls no runtime
+	// functions may be compiled. Main packages define the C function
+	// main, which initializes the program and calls main.main. Code for
+	// WebAssembly targets must be compiled in freestanding mode with a
+	// runtime package, which implements the panic flag model described
+	// in panicflag.go.
+	Freestanding   bool
+	RuntimePackage string
+
+	// SSAPasses is the list of names of the ssaopt passes to run over
+	// each function before generating code for it. If nil, the passes
+	// in ssaopt.DefaultPasses are run. Heap allocations are only moved
+	// to the stack if the list includes "stackalloc".
+	SSAPasses []string
+
+	// MaxErrors limits the number of errors returned by Compile and
+	// CompilePackages, as -fmax-errors does for gcc. If it is zero, all
+	// errors are returned.
+	MaxErrors int
+
+	// GDBScript, if not empty, is the path of a Python script for gdb,
+	// such as the pretty-printers in utils/gdb. When generating debug
+	// information for a main package, the path is recorded in the
+	// .debug_gdb_scripts section, from which gdb loads the script.
+	GDBScript string
 }
 
 type Compiler struct {
 	opts       CompilerOptions
 	dataLayout string
 	pnacl      bool
+	wasm       bool
 }
 
 func NewCompiler(opts CompilerOptions) (*Compiler, error) {
 	compiler := &Compiler{opts: opts}
+	if compiler.opts.LLVMContext.C == nil {
+		compiler.opts.LLVMContext = llvm.GlobalContext()
+	}
 	if strings.ToLower(compiler.opts.TargetTriple) == "pnacl" {
 		compiler.opts.TargetTriple = PNaClTriple
 		compiler.pnacl = true
@@ -90,24 +196,260 @@ func NewCompiler(opts CompilerOptions) (*Compiler, error) {
 		return nil, err
 	}
 	compiler.dataLayout = dataLayout
+	compiler.wasm = tripleArch(compiler.opts.TargetTriple) == "wasm32"
+	if compiler.wasm && (!compiler.opts.Freestanding || compiler.opts.RuntimePackage == "") {
+		return nil, errors.New("WebAssembly targets require a freestanding runtime package")
+	}
+	if err := checkABILowering(compiler.abiTriple()); err != nil {
+		return nil, err
+	}
+	if compiler.opts.SSAPasses == nil {
+		compiler.opts.SSAPasses = ssaopt.DefaultPasses
+	}
+	if _, err := ssaopt.NewPassManager(compiler.opts.SSAPasses); err != nil {
+		return nil, err
+	}
 	return compiler, nil
 }
 
-func (c *Compiler) Compile(filenames []string, importpath string) (m *Module, err error) {
+// abiTriple returns the triple whose C ABI is used to lower function
+// signatures. PNaCl modules are compiled for an ARM triple, but the PNaCl
+// toolchain lowers their signatures itself, so they retain the generic
+// lowering.
+func (c *Compiler) abiTriple() string {
+	if c.pnacl {
+		return "pnacl"
+	}
+	return c.opts.TargetTriple
+}
+
+func (c *Compiler) newCompiler() *compiler {
 	target := llvm.NewTargetData(c.dataLayout)
-	compiler := &compiler{
+	// Stack allocation uses the escape summaries of the package, which
+	// are computed once its functions have been optimized, so it and the
+	// passes following it are run as each function is defined. The pass
+	// names were validated by NewCompiler.
+	names := c.opts.SSAPasses
+	split := len(names)
+	for i, name := range names {
+		if name == "stackalloc" {
+			split = i
+			break
+		}
+	}
+	passManager, _ := ssaopt.NewPassManager(names[:split])
+	passManager.Logger = c.opts.Logger
+	latePassManager, _ := ssaopt.NewPassManager(names[split:])
+	latePassManager.Logger = c.opts.Logger
+
+	llvmtypes := NewLLVMTypeMap(c.opts.LLVMContext, target, c.abiTriple())
+	var bce *ssaopt.BoundsCheckElimination
+	var devirt *ssaopt.Devirtualization
+	var stackAlloc *ssaopt.StackAllocation
+	for _, p := range append(passManager.Passes(), latePassManager.Passes()...) {
+		switch p := p.(type) {
+		case *ssaopt.BoundsCheckElimination:
+			bce = p
+		case *ssaopt.Devirtualization:
+			p.Logger = c.opts.Logger
+			devirt = p
+		case *ssaopt.StackAllocation:
+			p.Sizes = llvmtypes
+			stackAlloc = p
+		}
+	}
+	return &compiler{
 		CompilerOptions: c.opts,
+		ctx:             c.opts.LLVMContext,
 		dataLayout:      c.dataLayout,
 		target:          target,
 		pnacl:           c.pnacl,
-		llvmtypes:       NewLLVMTypeMap(llvm.GlobalContext(), target),
+		wasm:            c.wasm,
+		llvmtypes:       llvmtypes,
+		passManager:     passManager,
+		latePassManager: latePassManager,
+		bce:             bce,
+		devirt:          devirt,
+		stackAlloc:      stackAlloc,
+		optimized:       make(map[*ssa.Function]bool),
+		reportedMissing: make(map[missingRuntimeFunc]bool),
+	}
+}
+
+func (c *Compiler) Compile(filenames []string, importpath string) (m *Module, err error) {
+	m, err = c.newCompiler().compile(filenames, importpath)
+	return m, c.limitErrors(err)
+}
+
+// limitErrors applies the MaxErrors option to err, if it is a
+// DiagnosticList.
+func (c *Compiler) limitErrors(err error) error {
+	if list, ok := err.(DiagnosticList); ok {
+		return list.limitErrors(c.opts.MaxErrors)
+	}
+	return err
+}
+
+// CompilePackages type-checks the packages with the given import paths,
+// together with their transitive dependencies, from source, and compiles
+// each of them to a separate module. If BinaryImports is set, only the
+// named packages are compiled, and their imports are read from export
+// data. The modules are returned in dependency order, so that every module
+// follows the modules of the packages it imports.
+//
+// If buildctx is nil, a context configured from the target triple is used.
+// Local import paths are relative to the current directory.
+// At most one of the packages may be a command; it is compiled with the
+// import path "main", as the gccgo conventions require.
+func (c *Compiler) CompilePackages(buildctx *build.Context, importpaths []string) ([]*Module, error) {
+	modules, err := c.compilePackages(buildctx, importpaths)
+	return modules, c.limitErrors(err)
+}
+
+func (c *Compiler) compilePackages(buildctx *build.Context, importpaths []string) ([]*Module, error) {
+	if buildctx == nil {
+		llgoctx, err := llgobuild.ContextFromTriple(c.opts.TargetTriple)
+		if err != nil {
+			return nil, err
+		}
+		buildctx = &llgoctx.Context
+	}
+
+	target := llvm.NewTargetData(c.dataLayout)
+	initmap := make(map[*types.Package]gccgoimporter.InitData)
+	var typeErrors DiagnosticList
+	impcfg := &loader.Config{
+		Fset: token.NewFileSet(),
+		// We must retain comments; this is important for
+		// annotation processing.
+		ParserMode: parser.DeclarationErrors | parser.ParseComments,
+		TypeChecker: types.Config{
+			Sizes: NewLLVMTypeMap(c.opts.LLVMContext, target, c.abiTriple()),
+			Error: typeErrors.addTypeError,
+		},
+		Build:         buildctx,
+		SourceImports: !c.opts.BinaryImports,
+	}
+	var searchpaths []string
+	if c.opts.BinaryImports {
+		var err error
+		impcfg.TypeChecker.Import, searchpaths, err = newImporter(&c.opts, initmap)
+		if err != nil {
+			return nil, err
+		}
+	} else if c.opts.Freestanding && c.opts.RuntimePackage != "" {
+		impcfg.Import(c.opts.RuntimePackage)
+	}
+
+	haveMain := false
+	for _, path := range importpaths {
+		bpkg, err := buildctx.Import(path, ".", 0)
+		if err != nil {
+			return nil, err
+		}
+		if bpkg.Name != "main" {
+			impcfg.Import(path)
+			continue
+		}
+		if haveMain {
+			return nil, errors.New("cannot compile more than one command at a time")
+		}
+		haveMain = true
+		filenames := make([]string, len(bpkg.GoFiles))
+		for i, f := range bpkg.GoFiles {
+			filenames[i] = filepath.Join(bpkg.Dir, f)
+		}
+		if err := impcfg.CreateFromFilenames("main", filenames...); err != nil {
+			return nil, err
+		}
+	}
+
+	iprog, err := impcfg.Load()
+	if err != nil {
+		return nil, typeErrors.orError(err)
 	}
-	return compiler.compile(filenames, importpath)
+	program := ssa.Create(iprog, ssa.BareInits)
+
+	// Packages compiled from source have no import data, so we
+	// record the init data and escape summaries of each package as
+	// we compile it. Those of binary imports are read with their
+	// export data.
+	summaries := make(ssaopt.Summaries)
+	var binaryPkgs []*types.Package
+	var runtimePkg *types.Package
+	for pkg, info := range iprog.AllPackages {
+		if len(info.Files) == 0 {
+			binaryPkgs = append(binaryPkgs, pkg)
+		}
+		if c.opts.Freestanding && pkg.Path() == c.opts.RuntimePackage {
+			runtimePkg = pkg
+		}
+	}
+	c.newCompiler().loadEscapeSummaries(searchpaths, binaryPkgs, summaries)
+	if runtimePkg == nil && c.opts.Freestanding && c.opts.RuntimePackage != "" && c.opts.BinaryImports {
+		runtimePkg, err = impcfg.TypeChecker.Import(make(map[string]*types.Package), c.opts.RuntimePackage)
+		if err != nil {
+			return nil, fmt.Errorf("could not import runtime package %q: %v", c.opts.RuntimePackage, err)
+		}
+	}
+	var modules []*Module
+	for _, pkginfo := range packagesInDependencyOrder(iprog) {
+		compiler := c.newCompiler()
+		compiler.runtimePkg = runtimePkg
+		m, err := compiler.compilePackage(program, pkginfo, iprog.Fset, initmap, summaries)
+		if err != nil {
+			for _, m := range modules {
+				m.Dispose()
+			}
+			// Diagnostics identify the package by their
+			// positions.
+			if _, ok := err.(DiagnosticList); ok {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s: %v", pkginfo.Pkg.Path(), err)
+		}
+		modules = append(modules, m)
+	}
+	return modules, nil
+}
+
+// packagesInDependencyOrder returns the packages of iprog ordered such that
+// each package follows the packages it imports. Packages without source
+// (such as "unsafe" and those imported from export data) are omitted.
+func packagesInDependencyOrder(iprog *loader.Program) []*loader.PackageInfo {
+	var order []*loader.PackageInfo
+	seen := make(map[*types.Package]bool)
+	var visit func(pkg *types.Package)
+	visit = func(pkg *types.Package) {
+		if seen[pkg] {
+			return
+		}
+		seen[pkg] = true
+		imports := append([]*types.Package(nil), pkg.Imports()...)
+		sort.Sort(byPackagePath(imports))
+		for _, imp := range imports {
+			visit(imp)
+		}
+		if info := iprog.AllPackages[pkg]; info != nil && len(info.Files) != 0 {
+			order = append(order, info)
+		}
+	}
+	for _, info := range iprog.InitialPackages() {
+		visit(info.Pkg)
+	}
+	return order
 }
 
+type byPackagePath []*types.Package
+
+func (a byPackagePath) Len() int           { return len(a) }
+func (a byPackagePath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPackagePath) Less(i, j int) bool { return a[i].Path() < a[j].Path() }
+
 type compiler struct {
 	CompilerOptions
 
+	ctx        llvm.Context
 	module     *Module
 	dataLayout string
 	target     llvm.TargetData
@@ -117,6 +459,15 @@ type compiler struct {
 	llvmtypes *llvmTypeMap
 	types     *TypeMap
 
+	// runtimePkg is the runtime package in freestanding mode, if any.
+	runtimePkg *types.Package
+
+	// diagnostics holds the diagnostics reported while translating the
+	// package, and reportedMissing the uses of undefined runtime
+	// functions that have been reported.
+	diagnostics     DiagnosticList
+	reportedMissing map[missingRuntimeFunc]bool
+
 	// runtimetypespkg is the type-checked runtime/types.go file,
 	// which is used for evaluating the types of runtime functions.
 	runtimetypespkg *types.Package
@@ -127,7 +478,43 @@ type compiler struct {
 	// compile PNaCl modules.
 	pnacl bool
 
+	// wasm is set to true if the target is WebAssembly, which does not
+	// support split stacks or zero-cost exception handling. Panics are
+	// lowered as described in panicflag.go.
+	wasm bool
+
+	// panickingName is the symbol name of the runtime package's
+	// Panicking variable, which is the panic flag if wasm is set.
+	panickingName string
+
 	debug *debug.DIBuilder
+
+	// escapeSummaries holds the escape summaries of the functions in
+	// the package being compiled and in its imports.
+	escapeSummaries ssaopt.Summaries
+
+	// escapeDecisions collects the results of escape analysis
+	// if DumpEscape is set.
+	escapeDecisions []ssaopt.AllocDecision
+
+	// passManager runs the SSA optimization passes, and optimized
+	// records the functions it has been run over. latePassManager runs
+	// the passes that use the package's escape summaries, as each
+	// function is defined.
+	passManager     *ssaopt.PassManager
+	latePassManager *ssaopt.PassManager
+	optimized       map[*ssa.Function]bool
+
+	// bce is the bounds check elimination pass run by passManager,
+	// if any.
+	bce *ssaopt.BoundsCheckElimination
+
+	// devirt is the devirtualization pass run by passManager, if any.
+	devirt *ssaopt.Devirtualization
+
+	// stackAlloc is the stack allocation pass run by latePassManager,
+	// if any.
+	stackAlloc *ssaopt.StackAllocation
 }
 
 func (c *compiler) logf(format string, v ...interface{}) {
@@ -136,14 +523,107 @@ func (c *compiler) logf(format string, v ...interface{}) {
 	}
 }
 
+// splitStack reports whether functions use split stacks.
+func (c *compiler) splitStack() bool {
+	return !c.wasm && !c.Freestanding && !c.DisableSplitStack
+}
+
+// describeVariables reports whether the debug data describes the types
+// and variables of the program, rather than just its line tables.
+func (c *compiler) describeVariables() bool {
+	return c.GenerateDebug && !c.LineTablesOnly
+}
+
+// addStackModelFlag records whether the module's functions use split
+// stacks in a module flag, so that linking modules compiled for different
+// stack models is an error.
+func (c *compiler) addStackModelFlag() {
+	var split uint64
+	if c.splitStack() {
+		split = 1
+	}
+	c.module.AddNamedMetadataOperand(
+		"llvm.module.flags",
+		c.ctx.MDNode([]llvm.Value{
+			llvm.ConstInt(c.ctx.Int32Type(), 1, false), // Error on mismatch
+			c.ctx.MDString("Go Split Stack"),
+			llvm.ConstInt(c.ctx.Int32Type(), split, false),
+		}),
+	)
+}
+
+// addStackSizeMetadata records the stack space used by the static allocas
+// of each function defined in the module in the named metadata
+// go.stack_sizes, if the module does not use split stacks. Each operand is
+// a pair of a function and its size in bytes, which is a lower bound on the
+// size of its frame, for use by tools bounding the stack use of programs
+// whose goroutines have fixed-size stacks.
+func (c *compiler) addStackSizeMetadata() {
+	if c.splitStack() {
+		return
+	}
+	for fn := c.module.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+		if fn.IsDeclaration() {
+			continue
+		}
+		var size uint64
+		entry := fn.EntryBasicBlock()
+		for inst := entry.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+			if inst.IsAAllocaInst().IsNil() {
+				continue
+			}
+			count := inst.Operand(0)
+			if count.IsAConstantInt().IsNil() {
+				continue
+			}
+			size += c.target.TypeAllocSize(inst.Type().ElementType()) * count.ZExtValue()
+		}
+		c.module.AddNamedMetadataOperand(
+			"go.stack_sizes",
+			c.ctx.MDNode([]llvm.Value{
+				fn,
+				llvm.ConstInt(c.ctx.Int64Type(), size, false),
+			}),
+		)
+	}
+}
+
 func (c *compiler) addCommonFunctionAttrs(fn llvm.Value) {
 	fn.AddTargetDependentFunctionAttr("disable-tail-calls", "true")
-	fn.AddTargetDependentFunctionAttr("split-stack", "")
+	if c.splitStack() {
+		fn.AddTargetDependentFunctionAttr("split-stack", "")
+	}
+	if c.TargetCPU != "" {
+		fn.AddTargetDependentFunctionAttr("target-cpu", c.TargetCPU)
+	}
+	if c.TargetFeatures != "" {
+		fn.AddTargetDependentFunctionAttr("target-features", c.TargetFeatures)
+	}
+	if c.DisableRedZone {
+		fn.AddFunctionAttr(llvm.NoRedZoneAttribute)
+	}
 	if attr := c.SanitizerAttribute; attr != 0 {
 		fn.AddFunctionAttr(attr)
 	}
 }
 
+// newImporter returns an importer reading export data from the import
+// paths of opts, or from the installation of the gccgo at GccgoPath, and
+// recording the init data of the imported packages in initmap. It also
+// returns the directories searched for export data.
+func newImporter(opts *CompilerOptions, initmap map[*types.Package]gccgoimporter.InitData) (types.Importer, []string, error) {
+	if opts.GccgoPath == "" {
+		searchpaths := append(append([]string{}, opts.ImportPaths...), ".")
+		return gccgoimporter.GetImporter(searchpaths, initmap), searchpaths, nil
+	}
+	var inst gccgoimporter.GccgoInstallation
+	if err := inst.InitFromDriver(opts.GccgoPath); err != nil {
+		return nil, nil, err
+	}
+	searchpaths := append(append([]string{}, opts.ImportPaths...), inst.SearchPaths()...)
+	return inst.GetImporter(opts.ImportPaths, initmap), searchpaths, nil
+}
+
 func (compiler *compiler) compile(filenames []string, importpath string) (m *Module, err error) {
 	buildctx, err := llgobuild.ContextFromTriple(compiler.TargetTriple)
 	if err != nil {
@@ -151,31 +631,27 @@ func (compiler *compiler) compile(filenames []string, importpath string) (m *Mod
 	}
 
 	initmap := make(map[*types.Package]gccgoimporter.InitData)
-	var importer types.Importer
-	if compiler.GccgoPath == "" {
-		paths := append(append([]string{}, compiler.ImportPaths...), ".")
-		importer = gccgoimporter.GetImporter(paths, initmap)
-	} else {
-		var inst gccgoimporter.GccgoInstallation
-		err = inst.InitFromDriver(compiler.GccgoPath)
-		if err != nil {
-			return nil, err
-		}
-		importer = inst.GetImporter(compiler.ImportPaths, initmap)
+	importer, searchpaths, err := newImporter(&compiler.CompilerOptions, initmap)
+	if err != nil {
+		return nil, err
 	}
 
+	var typeErrors DiagnosticList
 	impcfg := &loader.Config{
 		Fset: token.NewFileSet(),
 		TypeChecker: types.Config{
 			Import: importer,
 			Sizes:  compiler.llvmtypes,
+			Error:  typeErrors.addTypeError,
 		},
 		Build: &buildctx.Context,
 	}
 	// Must use parseFiles, so we retain comments;
 	// this is important for annotation processing.
 	astFiles, err := parseFiles(impcfg.Fset, filenames)
-	if err != nil {
+	if list, ok := err.(scanner.ErrorList); ok {
+		return nil, diagnosticsFromScanner(list)
+	} else if err != nil {
 		return nil, err
 	}
 	// If no import path is specified, then set the import
@@ -186,29 +662,79 @@ func (compiler *compiler) compile(filenames []string, importpath string) (m *Mod
 	impcfg.CreateFromFiles(importpath, astFiles...)
 	iprog, err := impcfg.Load()
 	if err != nil {
-		return nil, err
+		return nil, typeErrors.orError(err)
 	}
 	program := ssa.Create(iprog, ssa.BareInits)
 	mainPkginfo := iprog.InitialPackages()[0]
+	if compiler.Freestanding && compiler.RuntimePackage != "" {
+		if mainPkginfo.Pkg.Path() == compiler.RuntimePackage {
+			compiler.runtimePkg = mainPkginfo.Pkg
+		} else {
+			compiler.runtimePkg, err = importer(make(map[string]*types.Package), compiler.RuntimePackage)
+			if err != nil {
+				return nil, fmt.Errorf("could not import runtime package %q: %v", compiler.RuntimePackage, err)
+			}
+		}
+	}
+	summaries := make(ssaopt.Summaries)
+	compiler.loadEscapeSummaries(searchpaths, mainPkginfo.Pkg.Imports(), summaries)
+	return compiler.compilePackage(program, mainPkginfo, impcfg.Fset, initmap, summaries)
+}
+
+// compilePackage translates a single type-checked package of program into
+// a new module. The package's init data and escape summaries are recorded in
+// initmap and summaries, so that packages compiled later in the same process
+// may refer to them.
+func (compiler *compiler) compilePackage(program *ssa.Program, mainPkginfo *loader.PackageInfo, fset *token.FileSet, initmap map[*types.Package]gccgoimporter.InitData, summaries ssaopt.Summaries) (m *Module, err error) {
 	mainPkg := program.CreatePackage(mainPkginfo)
+	importpath := mainPkg.Object.Path()
+	compiler.fileset = fset
 
 	// Create a Module, which contains the LLVM module.
 	modulename := importpath
-	compiler.module = &Module{Module: llvm.NewModule(modulename), Path: modulename}
+	compiler.module = &Module{Module: compiler.ctx.NewModule(modulename), Path: modulename}
 	compiler.module.SetTarget(compiler.TargetTriple)
 	compiler.module.SetDataLayout(compiler.dataLayout)
+	compiler.addStackModelFlag()
 
 	// Create a new translation unit.
 	unit := newUnit(compiler, mainPkg)
 
 	// Create the runtime interface.
-	compiler.runtime, err = newRuntimeInterface(compiler.module.Module, compiler.llvmtypes)
+	compiler.runtime, err = newRuntimeInterface(compiler.module.Module, compiler.llvmtypes, compiler.Freestanding, compiler.wasm, compiler.runtimePkg)
 	if err != nil {
 		return nil, err
 	}
+	if compiler.wasm {
+		if compiler.runtimePkg == nil {
+			return nil, fmt.Errorf("runtime package %q is not loaded, which is required for WebAssembly targets", compiler.RuntimePackage)
+		}
+		compiler.panickingName, err = lookupPanicking(compiler.runtimePkg)
+		if err != nil {
+			return nil, err
+		}
+	}
 
+	// In debug mode, the SSA builder records the values of source
+	// variables, which we describe in the debug information.
+	if compiler.describeVariables() {
+		mainPkg.SetDebugMode(true)
+	}
 	mainPkg.Build()
 
+	// Optimize the package's functions before summarizing them, so
+	// that the summaries benefit from the optimizations.
+	compiler.optimizePackage(mainPkg)
+
+	// Summarize the escape behaviour of the package's functions. The
+	// summaries are used when lowering allocations, and exported for
+	// the benefit of importers.
+	compiler.escapeSummaries = summaries
+	compiler.computeEscapeSummaries(mainPkg)
+	if compiler.stackAlloc != nil {
+		compiler.stackAlloc.Summaries = summaries
+	}
+
 	// Create a struct responsible for mapping static types to LLVM types,
 	// and to runtime/dynamic type values.
 	compiler.types = NewTypeMap(
@@ -218,29 +744,63 @@ func (compiler *compiler) compile(filenames []string, importpath string) (m *Mod
 		compiler.runtime,
 		MethodResolver(unit),
 	)
+	compiler.types.diagnostics = &compiler.diagnostics
 
 	if compiler.GenerateDebug {
+		kind := debug.FullDebug
+		if compiler.LineTablesOnly {
+			kind = debug.LineTablesOnly
+		}
 		compiler.debug = debug.NewDIBuilder(
 			types.Sizes(compiler.llvmtypes),
 			compiler.module.Module,
-			impcfg.Fset,
+			fset,
 			compiler.DebugPrefixMaps,
+			kind,
+			compiler.SplitDwarfFile,
 		)
 		defer compiler.debug.Destroy()
 		defer compiler.debug.Finalize()
+		if kind == debug.FullDebug {
+			compiler.debug.SetScopes(mainPkginfo.Files, mainPkginfo.Scopes)
+		}
 	}
 
 	unit.translatePackage(mainPkg)
+	compiler.passManager.LogTimings()
+	compiler.latePassManager.LogTimings()
+	if compiler.DumpEscape != EscapeDumpNone {
+		if err := compiler.dumpEscapeDecisions(os.Stderr); err != nil {
+			return nil, err
+		}
+	}
+	if compiler.DumpBCE {
+		if err := compiler.dumpEliminatedBoundsChecks(os.Stderr, mainPkg); err != nil {
+			return nil, err
+		}
+	}
 	compiler.processAnnotations(unit, mainPkginfo)
 
 	if importpath == "main" {
 		if err = compiler.createInitMainFunction(mainPkg, initmap); err != nil {
 			return nil, fmt.Errorf("failed to create __go_init_main: %v", err)
 		}
+		if compiler.GenerateDebug && compiler.GDBScript != "" {
+			compiler.addGDBScript(compiler.GDBScript)
+		}
 	} else {
-		compiler.module.ExportData = compiler.buildExportData(mainPkg, initmap)
+		initdata := compiler.buildPackageInitData(mainPkg, initmap)
+		compiler.module.ExportData = compiler.buildExportData(mainPkg, initdata)
+		compiler.module.EscapeData = compiler.buildEscapeData(mainPkg)
+		initmap[mainPkg.Object] = initdata
 	}
+	compiler.addStackSizeMetadata()
 
+	compiler.diagnostics.Sort()
+	if compiler.diagnostics.HasErrors() {
+		return nil, compiler.diagnostics
+	}
+	compiler.module.Diagnostics = compiler.diagnostics
 	return compiler.module, nil
 }
 
@@ -293,7 +853,7 @@ func (c *compiler) buildPackageInitData(mainPkg *ssa.Package, initmap map[*types
 	}
 
 	if imp := mainPkg.Func("init"); imp != nil {
-		impname := c.types.mc.mangleFunctionName(imp)
+		impname := c.types.mc.MangleFunctionName(imp)
 		uniqinits = append(uniqinits, gccgoimporter.PackageInit{mainPkg.Object.Name(), impname, ourprio})
 	}
 
@@ -303,32 +863,94 @@ func (c *compiler) buildPackageInitData(mainPkg *ssa.Package, initmap map[*types
 func (c *compiler) createInitMainFunction(mainPkg *ssa.Package, initmap map[*types.Package]gccgoimporter.InitData) error {
 	initdata := c.buildPackageInitData(mainPkg, initmap)
 
-	ftyp := llvm.FunctionType(llvm.VoidType(), nil, false)
+	ftyp := llvm.FunctionType(c.ctx.VoidType(), nil, false)
 	initMain := llvm.AddFunction(c.module.Module, "__go_init_main", ftyp)
 	c.addCommonFunctionAttrs(initMain)
-	entry := llvm.AddBasicBlock(initMain, "entry")
+	entry := c.ctx.AddBasicBlock(initMain, "entry")
 
-	builder := llvm.GlobalContext().NewBuilder()
+	builder := c.ctx.NewBuilder()
 	defer builder.Dispose()
 	builder.SetInsertPointAtEnd(entry)
 
+	// In the panic flag model, no further packages are initialized
+	// once an initializer panics.
+	var propagatebb llvm.BasicBlock
+	if c.wasm {
+		propagatebb = c.ctx.AddBasicBlock(initMain, "")
+		builder.SetInsertPointAtEnd(propagatebb)
+		builder.CreateRetVoid()
+		builder.SetInsertPointAtEnd(entry)
+	}
+
 	for _, init := range initdata.Inits {
 		initfn := c.module.Module.NamedFunction(init.InitFunc)
 		if initfn.IsNil() {
 			initfn = llvm.AddFunction(c.module.Module, init.InitFunc, ftyp)
 		}
 		builder.CreateCall(initfn, nil, "")
+		if c.wasm {
+			contbb := c.ctx.AddBasicBlock(initMain, "")
+			builder.CreateCondBr(c.panicking(builder), propagatebb, contbb)
+			builder.SetInsertPointAtEnd(contbb)
+		}
 	}
 
 	builder.CreateRetVoid()
+
+	if c.Freestanding {
+		c.createFreestandingMain(mainPkg, initMain)
+	}
 	return nil
 }
 
-func (c *compiler) buildExportData(mainPkg *ssa.Package, initmap map[*types.Package]gccgoimporter.InitData) []byte {
+// createFreestandingMain defines the entry point of a freestanding program,
+// which is not started by libgo. This is the C function
+//
+//	int main(void)
+//
+// which initializes the program's packages, calls main.main, and returns 0,
+// or in the panic flag model, 2 if either panics. It is called by the
+// startup code of the system or of the runtime.
+func (c *compiler) createFreestandingMain(mainPkg *ssa.Package, initMain llvm.Value) {
+	mainFunc := mainPkg.Func("main")
+	if mainFunc == nil {
+		// The package does not declare main, so it cannot be
+		// linked into a program.
+		return
+	}
+	mainMain := c.module.Module.NamedFunction(c.types.mc.MangleFunctionName(mainFunc))
+
+	ftyp := llvm.FunctionType(c.ctx.Int32Type(), nil, false)
+	fn := llvm.AddFunction(c.module.Module, "main", ftyp)
+	c.addCommonFunctionAttrs(fn)
+	entry := c.ctx.AddBasicBlock(fn, "entry")
+
+	builder := c.ctx.NewBuilder()
+	defer builder.Dispose()
+
+	var panicbb llvm.BasicBlock
+	if c.wasm {
+		panicbb = c.ctx.AddBasicBlock(fn, "")
+		builder.SetInsertPointAtEnd(panicbb)
+		builder.CreateRet(llvm.ConstInt(c.ctx.Int32Type(), 2, false))
+	}
+
+	builder.SetInsertPointAtEnd(entry)
+	for _, callee := range []llvm.Value{initMain, mainMain} {
+		builder.CreateCall(callee, nil, "")
+		if c.wasm {
+			contbb := c.ctx.AddBasicBlock(fn, "")
+			builder.CreateCondBr(c.panicking(builder), panicbb, contbb)
+			builder.SetInsertPointAtEnd(contbb)
+		}
+	}
+	builder.CreateRet(llvm.ConstNull(c.ctx.Int32Type()))
+}
+
+func (c *compiler) buildExportData(mainPkg *ssa.Package, initdata gccgoimporter.InitData) []byte {
 	exportData := importer.ExportData(mainPkg.Object)
 	b := bytes.NewBuffer(exportData)
 
-	initdata := c.buildPackageInitData(mainPkg, initmap)
 	b.WriteString("v1;\npriority ")
 	b.WriteString(strconv.Itoa(initdata.Priority))
 	b.WriteString(";\n")
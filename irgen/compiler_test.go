@@ -0,0 +1,289 @@
+package irgen_test
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-llvm/llgo/irgen"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+const testTriple = "x86_64-unknown-linux-gnu"
+
+// writePackages creates a GOPATH holding the given packages, each given as
+// the source of a single file, and returns a build context for it.
+func writePackages(t *testing.T, pkgs map[string]string) (*build.Context, func()) {
+	dir, err := ioutil.TempDir("", "llgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, src := range pkgs {
+		pkgdir := filepath.Join(dir, "src", filepath.FromSlash(path))
+		if err := os.MkdirAll(pkgdir, 0777); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(pkgdir, "x.go"), []byte(src), 0666); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	ctx := build.Default
+	ctx.GOPATH = dir
+	ctx.CgoEnabled = false
+	return &ctx, func() { os.RemoveAll(dir) }
+}
+
+func compilePackages(t *testing.T, opts irgen.CompilerOptions, pkgs map[string]string, paths ...string) ([]*irgen.Module, error) {
+	ctx, cleanup := writePackages(t, pkgs)
+	defer cleanup()
+	opts.TargetTriple = testTriple
+	compiler, err := irgen.NewCompiler(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compiler.CompilePackages(ctx, paths)
+}
+
+func disposeModules(modules []*irgen.Module) {
+	for _, m := range modules {
+		m.Dispose()
+	}
+}
+
+var diamond = map[string]string{
+	"a": `package a
+
+import (
+	"b"
+	"c"
+)
+
+func F() interface{} { return b.T{c.G()} }
+`,
+	"b": `package b
+
+import "c"
+
+type T struct{ X int }
+
+func (t T) Get() int { return t.X + c.G() }
+`,
+	"c": `package c
+
+func G() int { return 42 }
+`,
+	"cmd": `package main
+
+import "a"
+
+func main() { a.F() }
+`,
+}
+
+func TestCompilePackagesOrder(t *testing.T) {
+	modules, err := compilePackages(t, irgen.CompilerOptions{}, diamond, "cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disposeModules(modules)
+
+	var paths []string
+	for _, m := range modules {
+		paths = append(paths, m.Path)
+	}
+	if got, want := strings.Join(paths, " "), "c b a main"; got != want {
+		t.Errorf("got modules %q, want %q", got, want)
+	}
+	for _, m := range modules[:3] {
+		if m.ExportData == nil {
+			t.Errorf("%s: missing export data", m.Path)
+		}
+	}
+	if modules[3].ExportData != nil {
+		t.Errorf("main: unexpected export data")
+	}
+}
+
+func TestCompilePackagesShared(t *testing.T) {
+	modules, err := compilePackages(t, irgen.CompilerOptions{}, diamond, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disposeModules(modules)
+	if len(modules) != 3 {
+		t.Fatalf("got %d modules, want 3", len(modules))
+	}
+	c, b, a := modules[0], modules[1], modules[2]
+
+	// Functions and type descriptors are defined by the module of the
+	// package declaring them, and referred to by the same symbols from
+	// the modules of the packages importing them.
+	tests := []struct {
+		name       string
+		definer    *irgen.Module
+		referrers  []*irgen.Module
+		isFunction bool
+	}{
+		{"c.G", c, []*irgen.Module{b, a}, true},
+		{"__go_tdn_b.T", b, []*irgen.Module{a}, false},
+	}
+	for _, test := range tests {
+		lookup := func(m *irgen.Module) (defined, found bool) {
+			if test.isFunction {
+				fn := m.NamedFunction(test.name)
+				return !fn.IsNil() && fn.BasicBlocksCount() > 0, !fn.IsNil()
+			}
+			g := m.NamedGlobal(test.name)
+			return !g.IsNil() && !g.IsDeclaration(), !g.IsNil()
+		}
+		if defined, _ := lookup(test.definer); !defined {
+			t.Errorf("%s: not defined by %s", test.name, test.definer.Path)
+		}
+		for _, m := range test.referrers {
+			if defined, found := lookup(m); !found || defined {
+				t.Errorf("%s: not declared by %s", test.name, m.Path)
+			}
+		}
+	}
+}
+
+func TestCompilePackagesBinaryImports(t *testing.T) {
+	deps, err := compilePackages(t, irgen.CompilerOptions{}, diamond, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disposeModules(deps)
+
+	dir, err := ioutil.TempDir("", "llgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, m := range deps {
+		if err := ioutil.WriteFile(filepath.Join(dir, m.Path+".gox"), m.ExportData, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the named package is compiled; its imports are read from
+	// the export data of the packages compiled above.
+	opts := irgen.CompilerOptions{BinaryImports: true, ImportPaths: []string{dir}}
+	modules, err := compilePackages(t, opts, diamond, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disposeModules(modules)
+	if len(modules) != 1 || modules[0].Path != "a" {
+		t.Fatalf("got %d modules, want only a", len(modules))
+	}
+	if fn := modules[0].NamedFunction("c.G"); fn.IsNil() || fn.BasicBlocksCount() != 0 {
+		t.Errorf("c.G: not declared by a")
+	}
+}
+
+func TestCompilePackagesErrors(t *testing.T) {
+	pkgs := map[string]string{
+		"bad": `package bad
+
+var x int = "one"
+var y int = "two"
+`,
+		"user": `package user
+
+import "bad"
+
+func F() {}
+`,
+		"cmd1": `package main
+
+func main() {}
+`,
+		"cmd2": `package main
+
+func main() {}
+`,
+	}
+
+	// Type errors in a dependency are reported as diagnostics.
+	_, err := compilePackages(t, irgen.CompilerOptions{}, pkgs, "user")
+	list, ok := err.(irgen.DiagnosticList)
+	if !ok {
+		t.Fatalf("got error %v, want a DiagnosticList", err)
+	}
+	if len(list) != 2 {
+		t.Errorf("got %d diagnostics, want 2", len(list))
+	}
+	for _, d := range list {
+		if filepath.Base(d.Pos.Filename) != "x.go" || d.Pos.Line < 3 || d.Severity != irgen.SeverityError {
+			t.Errorf("unexpected diagnostic: %s", d)
+		}
+	}
+
+	// MaxErrors limits the errors returned, noting the truncation.
+	_, err = compilePackages(t, irgen.CompilerOptions{MaxErrors: 1}, pkgs, "user")
+	if list, ok := err.(irgen.DiagnosticList); !ok || len(list) != 2 || list[1].Severity != irgen.SeverityNote {
+		t.Errorf("with MaxErrors 1: got error %v, want an error and a note", err)
+	}
+
+	if _, err := compilePackages(t, irgen.CompilerOptions{}, pkgs, "cmd1", "cmd2"); err == nil {
+		t.Errorf("two commands: expected an error")
+	}
+	if _, err := compilePackages(t, irgen.CompilerOptions{}, pkgs, "missing"); err == nil {
+		t.Errorf("missing package: expected an error")
+	}
+}
+
+func TestCompilePackagesConcurrently(t *testing.T) {
+	ctx, cleanup := writePackages(t, diamond)
+	defer cleanup()
+
+	// Compilations given LLVM contexts of their own may run in parallel,
+	// and produce the same modules as when run alone.
+	const n = 4
+	irs := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			llvmctx := llvm.NewContext()
+			defer llvmctx.Dispose()
+			opts := irgen.CompilerOptions{TargetTriple: testTriple, LLVMContext: llvmctx}
+			compiler, err := irgen.NewCompiler(opts)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			modules, err := compiler.CompilePackages(ctx, []string{"cmd"})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer disposeModules(modules)
+			for _, m := range modules {
+				if m.Context() != llvmctx {
+					errs[i] = fmt.Errorf("%s: module created in the wrong context", m.Path)
+					return
+				}
+				irs[i] += m.String()
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if irs[i] != irs[0] {
+			t.Errorf("compilation %d differs from compilation 0", i)
+		}
+	}
+}
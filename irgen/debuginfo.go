@@ -0,0 +1,126 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package irgen
+
+import (
+	"strconv"
+
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/types"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// When generating debug information, packages are built in SSA debug mode,
+// in which the builder emits a DebugRef instruction wherever a source
+// variable is referred to. Once local variables have been lifted to
+// registers, a DebugRef records the SSA value that the variable holds at
+// that point, from which we emit an llvm.dbg.value call. Parameters are
+// described on entry to the function, and the phis created when lifting a
+// variable at the start of each block that merges its values.
+//
+// Variables that remain in memory are described by llvm.dbg.declare calls
+// for their allocas instead (see defineFunction).
+
+// debugVar returns the local variable whose value is recorded by ref, or
+// nil if ref refers to the address of a variable, to a global variable, or
+// to an expression other than a variable.
+func debugVar(ref *ssa.DebugRef) *types.Var {
+	if ref.IsAddr {
+		return nil
+	}
+	v, ok := ref.Object().(*types.Var)
+	if !ok || v.IsField() || isGlobalObject(v) {
+		return nil
+	}
+	return v
+}
+
+// debugVars returns the local variables recorded by the DebugRefs of f,
+// keyed by the value held by each variable.
+func debugVars(f *ssa.Function) map[ssa.Value]*types.Var {
+	vars := make(map[ssa.Value]*types.Var)
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if ref, ok := instr.(*ssa.DebugRef); ok {
+				if v := debugVar(ref); v != nil {
+					vars[ref.X] = v
+				}
+			}
+		}
+	}
+	return vars
+}
+
+// debugRef emits an llvm.dbg.value call for the variable whose value is
+// recorded by ref. Values whose load was avoided, and which therefore have
+// no register, are not described.
+func (fr *frame) debugRef(ref *ssa.DebugRef) {
+	v := debugVar(ref)
+	if v == nil {
+		return
+	}
+	switch ref.X.(type) {
+	case *ssa.Const, *ssa.Function, *ssa.Global:
+	default:
+		if _, ok := fr.env[ref.X]; !ok {
+			return
+		}
+	}
+	fr.debug.Value(fr.builder, v, fr.llvmvalue(ref.X), -1)
+}
+
+// debugParams emits llvm.dbg.value calls for the named parameters of f
+// that are held in registers. Parameters in declared is the set of
+// positions of parameters already described by llvm.dbg.declare calls.
+func (fr *frame) debugParams(f *ssa.Function, declared map[int]bool) {
+	for i, param := range f.Params {
+		v, ok := param.Object().(*types.Var)
+		if !ok || v.Name() == "" || v.Name() == "_" || declared[i] {
+			continue
+		}
+		fr.debug.Value(fr.builder, v, fr.llvmvalue(param), i)
+	}
+}
+
+// debugPhis emits llvm.dbg.value calls for those of the given phis that
+// were created by lifting a source variable. Such a phi is named after the
+// variable, which is identified by the DebugRefs of the phi or of one of
+// its edges.
+func (fr *frame) debugPhis(phis []ssa.Instruction) {
+	for _, instr := range phis {
+		phi := instr.(*ssa.Phi)
+		v := fr.debugVars[phi]
+		for _, edge := range phi.Edges {
+			if v != nil {
+				break
+			}
+			if ev := fr.debugVars[edge]; ev != nil && ev.Name() == phi.Comment {
+				v = ev
+			}
+		}
+		if v != nil {
+			fr.debug.Value(fr.builder, v, fr.llvmvalue(phi), -1)
+		}
+	}
+}
+
+// addGDBScript records the path of a Python script for gdb in the
+// .debug_gdb_scripts section. Each entry of the section is a byte 1,
+// identifying a Python script file, followed by the script's
+// NUL-terminated path. As gcc does, we mark the section as holding
+// mergeable strings ("MS"), so that the linker removes duplicates. LLVM
+// gives us no control over the flags of a global's section, so the entry
+// is written as module-level assembly. Only main packages record a script,
+// and they have no export data, whose sections the drivers also write as
+// module-level assembly.
+func (c *compiler) addGDBScript(path string) {
+	asm := ".section \".debug_gdb_scripts\", \"MS\",@progbits,1\n"
+	asm += ".byte 1"
+	for i := 0; i < len(path); i++ {
+		asm += "," + strconv.Itoa(int(path[i]))
+	}
+	asm += ",0\n"
+	c.module.SetInlineAsm(asm)
+}
@@ -0,0 +1,322 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package irgen
+
+import (
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/types"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// maxOpenCodedDefers is the number of defers that fit in the bitmask
+// recording which open-coded defers are live.
+const maxOpenCodedDefers = 64
+
+// openDefers holds the state of a function whose deferred calls are
+// open-coded: rather than registering each deferred call with the runtime,
+// the function saves the call's operands in a stack slot, and sets a bit in
+// a mask. Live deferred calls are made directly on return.
+//
+// So that panics and runtime.Goexit make the live deferred calls in order
+// with those of other functions, the function registers a single deferred
+// call of a thunk with the runtime on entry, passing it the function's defer
+// state. The thunk makes the calls that are still live, so it does nothing
+// once they have been made on return. Defers are only open-coded if
+// requested, and if the deferred functions may not call recover.
+type openDefers struct {
+	// index maps each defer instruction to its bit in the mask. Bits
+	// are assigned in the order in which the defers may execute.
+	index  map[*ssa.Defer]int
+	defers []*ssa.Defer
+
+	// operands holds the operands of each deferred call that must be
+	// saved.
+	operands [][]ssa.Value
+
+	// The remaining fields point into the defer state: frame is the frame
+	// under which the thunk is registered, cont the frame under which it
+	// registers itself to make the remaining calls, mask the bitmask of
+	// live defers, and slots the slots in which the operands of each
+	// deferred call are saved.
+	frame, cont, mask llvm.Value
+	slots             []llvm.Value
+}
+
+// bind returns a copy of od whose pointers address the fields of the defer
+// state at state, computed using b.
+func (od *openDefers) bind(b llvm.Builder, state llvm.Value) *openDefers {
+	bound := *od
+	bound.frame = b.CreateStructGEP(state, 0, "")
+	bound.cont = b.CreateStructGEP(state, 1, "")
+	bound.mask = b.CreateStructGEP(state, 2, "")
+	bound.slots = make([]llvm.Value, len(od.defers))
+	for i := range bound.slots {
+		bound.slots[i] = b.CreateStructGEP(state, i+3, "")
+	}
+	return &bound
+}
+
+// openCodedDefers returns the defer instructions of f in an order consistent
+// with any order in which they may execute, if f's deferred calls may be
+// open-coded, or nil otherwise. This is the case if open-coding is enabled,
+// every defer executes at most once, and every deferred call is a call of a
+// known function which does not call recover, from a function which does
+// not call recover, and panics are lowered to landing pads.
+func (u *unit) openCodedDefers(f *ssa.Function) []*ssa.Defer {
+	// The thunk does not check the panic flag after each call, so a
+	// panicking deferred call would not stop it in the panic flag model.
+	if !u.OpenCodedDefers || u.wasm || callsRecover(f) {
+		return nil
+	}
+
+	var defers []*ssa.Defer
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if d, ok := instr.(*ssa.Defer); ok {
+				defers = append(defers, d)
+			}
+		}
+	}
+	if len(defers) == 0 || len(defers) > maxOpenCodedDefers {
+		return nil
+	}
+	for _, d := range defers {
+		callee := d.Call.StaticCallee()
+		if callee == nil || u.escapeSummaries.MayRecover(callee) {
+			return nil
+		}
+		// A defer in a loop may be executed any number of times.
+		if reachable(d.Block(), d.Block()) {
+			return nil
+		}
+	}
+
+	// Order the defers topologically by reachability. As no defer can
+	// reach itself, this is possible, and consistent with the order of
+	// the defers on any path through the function.
+	precedes := func(a, b *ssa.Defer) bool {
+		if a.Block() == b.Block() {
+			return instrIndex(a) < instrIndex(b)
+		}
+		return reachable(a.Block(), b.Block())
+	}
+	ordered := make([]*ssa.Defer, 0, len(defers))
+	placed := make(map[*ssa.Defer]bool)
+	for len(ordered) < len(defers) {
+	next:
+		for _, d := range defers {
+			if placed[d] {
+				continue
+			}
+			for _, e := range defers {
+				if !placed[e] && e != d && precedes(e, d) {
+					continue next
+				}
+			}
+			placed[d] = true
+			ordered = append(ordered, d)
+			break
+		}
+	}
+	return ordered
+}
+
+// reachable reports whether there is a non-empty path from block a to
+// block b.
+func reachable(a, b *ssa.BasicBlock) bool {
+	seen := make(map[*ssa.BasicBlock]bool)
+	work := append([]*ssa.BasicBlock(nil), a.Succs...)
+	for len(work) != 0 {
+		bb := work[len(work)-1]
+		work = work[:len(work)-1]
+		if bb == b {
+			return true
+		}
+		if !seen[bb] {
+			seen[bb] = true
+			work = append(work, bb.Succs...)
+		}
+	}
+	return false
+}
+
+func instrIndex(instr ssa.Instruction) int {
+	for i, in := range instr.Block().Instrs {
+		if in == instr {
+			return i
+		}
+	}
+	return -1
+}
+
+// setupOpenDefers allocates the defer state for the given deferred calls,
+// clears the mask, and registers the thunk that makes the live calls with
+// the runtime. It must be called with the builder positioned in the
+// function's prologue.
+func (fr *frame) setupOpenDefers(defers []*ssa.Defer) {
+	od := &openDefers{
+		index:    make(map[*ssa.Defer]int),
+		defers:   defers,
+		operands: make([][]ssa.Value, len(defers)),
+	}
+	elemTypes := []llvm.Type{fr.ctx.Int8Type(), fr.ctx.Int8Type(), fr.ctx.Int64Type()}
+	for i, d := range defers {
+		od.index[d] = i
+
+		// As in createThunk, functions and constants need not be
+		// saved.
+		var fields []*types.Var
+		seen := make(map[ssa.Value]bool)
+		for _, v := range append([]ssa.Value{d.Call.Value}, d.Call.Args...) {
+			switch v.(type) {
+			case *ssa.Builtin, *ssa.Function, *ssa.Const, *ssa.Global:
+				continue
+			}
+			if !seen[v] {
+				seen[v] = true
+				od.operands[i] = append(od.operands[i], v)
+				fields = append(fields, types.NewField(0, nil, "_", v.Type(), true))
+			}
+		}
+		elemTypes = append(elemTypes, fr.llvmtypes.ToLLVM(types.NewStruct(fields, nil)))
+	}
+	state := fr.builder.CreateAlloca(fr.ctx.StructType(elemTypes, false), "deferstate")
+	fr.openDefers = od.bind(fr.builder, state)
+	fr.frameptr = fr.openDefers.frame
+	fr.builder.CreateStore(llvm.ConstNull(fr.ctx.Int64Type()), fr.openDefers.mask)
+
+	// Registering a deferred call cannot panic, so the call need not be
+	// an invoke, which would split the prologue.
+	i8ptr := llvm.PointerType(fr.ctx.Int8Type(), 0)
+	thunk := fr.createOpenDeferThunk(od, state.Type())
+	thunk = fr.builder.CreateBitCast(thunk, i8ptr, "")
+	arg := fr.builder.CreateBitCast(state, i8ptr, "")
+	fr.runtime.Defer.callOnly(fr, fr.frameptr, thunk, arg)
+}
+
+// createOpenDeferThunk creates the thunk that makes the live deferred calls
+// of a function with open-coded defers, whose defer state has type statetyp.
+//
+// The thunk makes the last live call. If other calls remain live, it first
+// registers itself again under the continuation frame, and has the runtime
+// make that call once the call returns. Each call is thus made by a deferred
+// call of its own, so a panicking call does not prevent the remaining calls
+// from being made.
+func (fr *frame) createOpenDeferThunk(od *openDefers, statetyp llvm.Type) llvm.Value {
+	i8ptr := llvm.PointerType(fr.ctx.Int8Type(), 0)
+	thunkfntype := llvm.FunctionType(fr.ctx.VoidType(), []llvm.Type{i8ptr}, false)
+	thunkfn := llvm.AddFunction(fr.module.Module, "", thunkfntype)
+	thunkfn.SetLinkage(llvm.InternalLinkage)
+	fr.addCommonFunctionAttrs(thunkfn)
+
+	thunkfr := newFrame(fr.unit, thunkfn)
+	defer thunkfr.dispose()
+
+	prologuebb := fr.ctx.AddBasicBlock(thunkfn, "prologue")
+	thunkfr.builder.SetInsertPointAtEnd(prologuebb)
+	state := thunkfr.builder.CreateBitCast(thunkfn.Param(0), statetyp, "")
+	thunkfr.openDefers = od.bind(thunkfr.builder, state)
+	tod := thunkfr.openDefers
+
+	entrybb := fr.ctx.AddBasicBlock(thunkfn, "entry")
+	br := thunkfr.builder.CreateBr(entrybb)
+	thunkfr.allocaBuilder.SetInsertPointBefore(br)
+
+	thunkfr.builder.SetInsertPointAtEnd(entrybb)
+	zero := llvm.ConstNull(fr.ctx.Int64Type())
+	mask := thunkfr.builder.CreateLoad(tod.mask, "")
+	for i := len(od.defers) - 1; i >= 0; i-- {
+		bit := llvm.ConstInt(fr.ctx.Int64Type(), 1<<uint(i), false)
+		live := thunkfr.builder.CreateICmp(llvm.IntNE, thunkfr.builder.CreateAnd(mask, bit, ""), zero, "")
+
+		callbb := fr.ctx.AddBasicBlock(thunkfn, "")
+		nextbb := fr.ctx.AddBasicBlock(thunkfn, "")
+		thunkfr.builder.CreateCondBr(live, callbb, nextbb)
+
+		thunkfr.builder.SetInsertPointAtEnd(callbb)
+		rest := thunkfr.builder.CreateAnd(mask, llvm.ConstNot(bit), "")
+		thunkfr.builder.CreateStore(rest, tod.mask)
+		contbb := fr.ctx.AddBasicBlock(thunkfn, "")
+		calldeferbb := fr.ctx.AddBasicBlock(thunkfn, "")
+		more := thunkfr.builder.CreateICmp(llvm.IntNE, rest, zero, "")
+		thunkfr.builder.CreateCondBr(more, contbb, calldeferbb)
+
+		thunkfr.builder.SetInsertPointAtEnd(contbb)
+		self := thunkfr.builder.CreateBitCast(thunkfn, i8ptr, "")
+		thunkfr.runtime.Defer.callOnly(thunkfr, tod.cont, self, thunkfn.Param(0))
+		thunkfr.builder.CreateBr(calldeferbb)
+
+		thunkfr.builder.SetInsertPointAtEnd(calldeferbb)
+		thunkfr.callOpenDefer(i)
+		thunkfr.runtime.undefer.callOnly(thunkfr, tod.cont)
+		thunkfr.builder.CreateRetVoid()
+
+		thunkfr.builder.SetInsertPointAtEnd(nextbb)
+	}
+	thunkfr.builder.CreateRetVoid()
+	return thunkfn
+}
+
+// openCodeDefer saves the operands of the deferred call d, and marks it
+// as live.
+func (fr *frame) openCodeDefer(d *ssa.Defer) {
+	od := fr.openDefers
+	i := od.index[d]
+	for j, v := range od.operands[i] {
+		ptr := fr.builder.CreateStructGEP(od.slots[i], j, "")
+		fr.builder.CreateStore(fr.llvmvalue(v), ptr)
+	}
+	mask := fr.builder.CreateLoad(od.mask, "")
+	mask = fr.builder.CreateOr(mask, llvm.ConstInt(fr.ctx.Int64Type(), 1<<uint(i), false), "")
+	fr.builder.CreateStore(mask, od.mask)
+}
+
+// runOpenDefers makes the live deferred calls, in the reverse of the
+// order in which they were deferred. Each call is marked as dead before
+// it is made, so that it is not made again by the thunk if it panics.
+func (fr *frame) runOpenDefers() {
+	od := fr.openDefers
+	for i := len(od.defers) - 1; i >= 0; i-- {
+		bit := llvm.ConstInt(fr.ctx.Int64Type(), 1<<uint(i), false)
+		mask := fr.builder.CreateLoad(od.mask, "")
+		live := fr.builder.CreateICmp(llvm.IntNE, fr.builder.CreateAnd(mask, bit, ""), llvm.ConstNull(fr.ctx.Int64Type()), "")
+
+		callbb := fr.ctx.AddBasicBlock(fr.function, "")
+		contbb := fr.ctx.AddBasicBlock(fr.function, "")
+		fr.builder.CreateCondBr(live, callbb, contbb)
+
+		fr.builder.SetInsertPointAtEnd(callbb)
+		fr.builder.CreateStore(fr.builder.CreateAnd(mask, llvm.ConstNot(bit), ""), od.mask)
+		fr.callOpenDefer(i)
+		fr.builder.CreateBr(contbb)
+
+		fr.builder.SetInsertPointAtEnd(contbb)
+	}
+}
+
+// callOpenDefer makes the i'th deferred call with its saved operands.
+func (fr *frame) callOpenDefer(i int) {
+	od := fr.openDefers
+
+	// Temporarily map the operands to their saved values. The operands
+	// may be used by instructions translated later, or may not have been
+	// translated yet.
+	saved := make(map[ssa.Value]*govalue)
+	for j, v := range od.operands[i] {
+		if value, ok := fr.env[v]; ok {
+			saved[v] = value
+		}
+		ptr := fr.builder.CreateStructGEP(od.slots[i], j, "")
+		fr.env[v] = newValue(fr.builder.CreateLoad(ptr, ""), v.Type())
+	}
+	fr.callInstruction(od.defers[i])
+	for _, v := range od.operands[i] {
+		if value, ok := saved[v]; ok {
+			fr.env[v] = value
+		} else {
+			delete(fr.env, v)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package irgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"sort"
+
+	"golang.org/x/tools/go/types"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// A Diagnostic is a message about the package being compiled, such as an
+// error in its source code.
+type Diagnostic struct {
+	// Pos is the position to which the diagnostic refers. It is invalid
+	// if the diagnostic does not refer to a particular position.
+	Pos      token.Position
+	Severity Severity
+	Msg      string
+
+	// Soft reports whether the diagnostic is a soft error reported by
+	// the type checker, such as an unused variable or import, which
+	// does not affect the types of the package.
+	Soft bool
+
+	// Related holds other positions relevant to the diagnostic, such as
+	// that of a conflicting declaration, each with a message of its own.
+	Related []RelatedPosition
+}
+
+// A RelatedPosition is a position referred to by a diagnostic.
+type RelatedPosition struct {
+	Pos token.Position
+	Msg string
+}
+
+// formatDiagnostic formats a message in the style of gcc.
+func formatDiagnostic(pos token.Position, severity, msg string) string {
+	if pos.IsValid() {
+		return fmt.Sprintf("%s: %s: %s", pos, severity, msg)
+	}
+	return fmt.Sprintf("%s: %s", severity, msg)
+}
+
+// Error formats the diagnostic in the style of gcc, as
+// "file:line:col: error: message", followed by a note for each related
+// position on a line of its own. Soft errors are marked as such, as in
+// "file:line:col: soft error: message".
+func (d *Diagnostic) Error() string {
+	var b bytes.Buffer
+	severity := d.Severity.String()
+	if d.Soft {
+		severity = "soft " + severity
+	}
+	b.WriteString(formatDiagnostic(d.Pos, severity, d.Msg))
+	for _, r := range d.Related {
+		b.WriteRune('\n')
+		b.WriteString(formatDiagnostic(r.Pos, SeverityNote.String(), r.Msg))
+	}
+	return b.String()
+}
+
+// A DiagnosticList is a list of diagnostics. Compiler.Compile and
+// Compiler.CompilePackages return a DiagnosticList as their error if any
+// errors were diagnosed in the package being compiled.
+type DiagnosticList []*Diagnostic
+
+// Add appends a diagnostic with the given position, severity and message
+// to the list.
+func (l *DiagnosticList) Add(pos token.Position, severity Severity, msg string) *Diagnostic {
+	d := &Diagnostic{Pos: pos, Severity: severity, Msg: msg}
+	*l = append(*l, d)
+	return d
+}
+
+// Errorf appends an error with the given position and formatted message to
+// the list.
+func (l *DiagnosticList) Errorf(pos token.Position, format string, args ...interface{}) *Diagnostic {
+	return l.Add(pos, SeverityError, fmt.Sprintf(format, args...))
+}
+
+// HasErrors reports whether the list contains any errors.
+func (l DiagnosticList) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (l DiagnosticList) Len() int      { return len(l) }
+func (l DiagnosticList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l DiagnosticList) Less(i, j int) bool {
+	a, b := l[i].Pos, l[j].Pos
+	if a.Filename != b.Filename {
+		return a.Filename < b.Filename
+	}
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Column < b.Column
+}
+
+// Sort sorts the list by position. Diagnostics at the same position retain
+// their order.
+func (l DiagnosticList) Sort() {
+	sort.Stable(l)
+}
+
+func (l DiagnosticList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more diagnostics)", l[0].Error(), len(l)-1)
+}
+
+// diagnosticsFromScanner converts the errors reported by the parser to
+// diagnostics.
+func diagnosticsFromScanner(errs scanner.ErrorList) DiagnosticList {
+	l := make(DiagnosticList, len(errs))
+	for i, e := range errs {
+		l[i] = &Diagnostic{Pos: e.Pos, Severity: SeverityError, Msg: e.Msg}
+	}
+	return l
+}
+
+// addTypeError appends an error reported by the type checker to the list.
+// It is used as the types.Config.Error function, so that the type checker
+// reports every error rather than stopping at the first.
+func (l *DiagnosticList) addTypeError(err error) {
+	terr, ok := err.(types.Error)
+	if !ok {
+		l.Add(token.Position{}, SeverityError, err.Error())
+		return
+	}
+	d := l.Add(terr.Fset.Position(terr.Pos), SeverityError, terr.Msg)
+	d.Soft = terr.Soft
+}
+
+// orError returns the list, sorted, if it contains any errors, and err
+// otherwise. It is used to replace the summary error returned by the
+// loader with the type errors that caused it.
+func (l DiagnosticList) orError(err error) error {
+	if !l.HasErrors() {
+		return err
+	}
+	l.Sort()
+	return l
+}
+
+// limitErrors returns the list truncated before its (max+1)th error, with
+// a note recording the truncation, in the manner of gcc's -fmax-errors. If
+// max is zero, the list is returned unchanged.
+func (l DiagnosticList) limitErrors(max int) DiagnosticList {
+	if max <= 0 {
+		return l
+	}
+	n := 0
+	for i, d := range l {
+		if d.Severity != SeverityError {
+			continue
+		}
+		if n++; n > max {
+			l = l[:i:i]
+			msg := fmt.Sprintf("compilation terminated due to -fmax-errors=%d", max)
+			l.Add(token.Position{}, SeverityNote, msg)
+			break
+		}
+	}
+	return l
+}
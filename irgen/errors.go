@@ -25,12 +25,12 @@ const (
 )
 
 func (fr *frame) setBranchWeightMetadata(br llvm.Value, trueweight, falseweight uint64) {
-	mdprof := llvm.MDKindID("prof")
+	mdprof := fr.ctx.MDKindID("prof")
 
-	mdnode := llvm.MDNode([]llvm.Value{
-		llvm.MDString("branch_weights"),
-		llvm.ConstInt(llvm.Int32Type(), trueweight, false),
-		llvm.ConstInt(llvm.Int32Type(), falseweight, false),
+	mdnode := fr.ctx.MDNode([]llvm.Value{
+		fr.ctx.MDString("branch_weights"),
+		llvm.ConstInt(fr.ctx.Int32Type(), trueweight, false),
+		llvm.ConstInt(fr.ctx.Int32Type(), falseweight, false),
 	})
 
 	br.SetMetadata(mdprof, mdnode)
@@ -44,18 +44,18 @@ func (fr *frame) condBrRuntimeError(cond llvm.Value, errcode uint64) {
 	errorbb := fr.runtimeErrorBlocks[errcode]
 	newbb := errorbb.C == nil
 	if newbb {
-		errorbb = llvm.AddBasicBlock(fr.function, "")
+		errorbb = fr.ctx.AddBasicBlock(fr.function, "")
 		fr.runtimeErrorBlocks[errcode] = errorbb
 	}
 
-	contbb := llvm.AddBasicBlock(fr.function, "")
+	contbb := fr.ctx.AddBasicBlock(fr.function, "")
 
 	br := fr.builder.CreateCondBr(cond, errorbb, contbb)
 	fr.setBranchWeightMetadata(br, 1, 1000)
 
 	if newbb {
 		fr.builder.SetInsertPointAtEnd(errorbb)
-		fr.runtime.runtimeError.call(fr, llvm.ConstInt(llvm.Int32Type(), errcode, false))
+		fr.runtime.runtimeError.call(fr, llvm.ConstInt(fr.ctx.Int32Type(), errcode, false))
 		fr.builder.CreateUnreachable()
 	}
 
@@ -0,0 +1,176 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package irgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"sort"
+
+	llgobuild "github.com/go-llvm/llgo/build"
+	"github.com/go-llvm/llgo/ssaopt"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+	"golang.org/x/tools/go/types"
+)
+
+// loadEscapeSummaries adds the escape summaries exported by the given
+// packages to s. Summaries are only an optimization, so packages whose
+// summaries cannot be found (for example, because they were compiled by
+// gccgo) are skipped, and calls to their functions are assumed to let their
+// arguments escape.
+func (c *compiler) loadEscapeSummaries(searchpaths []string, pkgs []*types.Package, s ssaopt.Summaries) {
+	for _, pkg := range pkgs {
+		path := llgobuild.FindExportFile(searchpaths, pkg.Path())
+		if path == "" {
+			continue
+		}
+		data, err := llgobuild.ObjectSection(path, ".go_escape")
+		if err != nil || data == nil {
+			continue
+		}
+		if err := s.Read(bytes.NewReader(data)); err != nil {
+			c.logf("Ignoring escape summaries in %s: %v", path, err)
+		}
+	}
+}
+
+// computeEscapeSummaries computes the escape summaries of the functions
+// defined by pkg, and adds them to c.escapeSummaries.
+func (c *compiler) computeEscapeSummaries(pkg *ssa.Package) {
+	ssaopt.ComputeSummaries(packageFunctions(pkg), c.escapeSummaries)
+}
+
+// buildEscapeData returns the exported form of the escape summaries of the
+// package-level functions and methods of pkg.
+func (c *compiler) buildEscapeData(pkg *ssa.Package) []byte {
+	var names []string
+	for f := range ssautil.AllFunctions(pkg.Prog) {
+		if f.Pkg == pkg && f.Parent() == nil && len(f.Blocks) != 0 {
+			names = append(names, f.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	c.escapeSummaries.Write(&buf, names)
+	return buf.Bytes()
+}
+
+// EscapeDumpFormat specifies the format in which the results of escape
+// analysis are dumped.
+type EscapeDumpFormat int
+
+const (
+	EscapeDumpNone EscapeDumpFormat = iota
+	EscapeDumpText
+	EscapeDumpJSON
+)
+
+// escapeRecord is the dumped form of an ssaopt.AllocDecision.
+type escapeRecord struct {
+	pos, causePos token.Position
+
+	Pos     string `json:"pos"`
+	Func    string `json:"func"`
+	Var     string `json:"var"`
+	Stack   bool   `json:"stack"`
+	Escapes bool   `json:"escapes"`
+
+	// Cause and CausePos describe the instruction through which the
+	// allocation escapes, if it does.
+	Cause    string `json:"cause,omitempty"`
+	CausePos string `json:"causePos,omitempty"`
+}
+
+type byEscapeRecordPos []escapeRecord
+
+func (a byEscapeRecordPos) Len() int      { return len(a) }
+func (a byEscapeRecordPos) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byEscapeRecordPos) Less(i, j int) bool {
+	switch {
+	case a[i].pos.Filename != a[j].pos.Filename:
+		return a[i].pos.Filename < a[j].pos.Filename
+	case a[i].pos.Offset != a[j].pos.Offset:
+		return a[i].pos.Offset < a[j].pos.Offset
+	case a[i].Func != a[j].Func:
+		return a[i].Func < a[j].Func
+	default:
+		return a[i].Var < a[j].Var
+	}
+}
+
+func formatPosition(pos token.Position) string {
+	if !pos.IsValid() {
+		return "-"
+	}
+	return pos.String()
+}
+
+// dumpEscapeDecisions writes the collected escape analysis results to w,
+// ordered by source position.
+func (c *compiler) dumpEscapeDecisions(w io.Writer) error {
+	records := make([]escapeRecord, len(c.escapeDecisions))
+	for i, d := range c.escapeDecisions {
+		fn := d.Alloc.(ssa.Instruction).Parent()
+		pos := d.Alloc.Pos()
+		if pos == token.NoPos {
+			pos = fn.Pos()
+		}
+		// Name variables by their source names, and other
+		// allocations by the instruction performing them.
+		name := d.Alloc.String()
+		if alloc, ok := d.Alloc.(*ssa.Alloc); ok {
+			name = alloc.Comment
+			if name == "" {
+				name = alloc.Name()
+			}
+		}
+		r := escapeRecord{
+			pos:     c.fileset.Position(pos),
+			Func:    fn.String(),
+			Var:     name,
+			Stack:   d.Lowered(),
+			Escapes: d.Cause != nil,
+		}
+		r.Pos = formatPosition(r.pos)
+		if d.Cause != nil {
+			r.causePos = c.fileset.Position(d.Cause.Pos())
+			r.Cause = d.Cause.String()
+			r.CausePos = formatPosition(r.causePos)
+		}
+		records[i] = r
+	}
+	sort.Sort(byEscapeRecordPos(records))
+
+	switch c.DumpEscape {
+	case EscapeDumpJSON:
+		data, err := json.MarshalIndent(records, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+
+	default:
+		for _, r := range records {
+			var err error
+			switch {
+			case r.Stack:
+				_, err = fmt.Fprintf(w, "%s: %s: %s moved to stack\n", r.Pos, r.Func, r.Var)
+			case !r.Escapes:
+				_, err = fmt.Fprintf(w, "%s: %s: %s does not escape, but is allocated by the runtime\n", r.Pos, r.Func, r.Var)
+			default:
+				_, err = fmt.Fprintf(w, "%s: %s: %s escapes to heap via %q at %s\n", r.Pos, r.Func, r.Var, r.Cause, r.CausePos)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package irgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"strings"
+
+	"github.com/go-llvm/llgo/mangle"
+	"golang.org/x/tools/go/types"
+)
+
+// In freestanding mode, generated code does not call libgo. Each runtime
+// function is instead implemented by the exported function of the runtime
+// package whose name is derived from the libgo name by runtimeFuncName,
+// and which has the same signature; for example, __go_new is implemented
+// by
+//
+//	func New(size uintptr) unsafe.Pointer
+//
+// Runtime functions that the runtime package does not define are
+// unsupported, and their use is reported as an error at the position of
+// the code that requires them. Type descriptors still refer to libgo's
+// hash and equality functions (such as __go_type_hash_identity), which
+// the runtime must define under their libgo names if it supports
+// interfaces.
+
+// runtimeFuncName returns the name of the function of a freestanding
+// runtime package that implements the libgo function with the given name.
+// The "__go_" or "runtime." prefix is removed, and the remaining words are
+// capitalized and joined, so that "__go_new_map" becomes "NewMap" and
+// "runtime.chanrecv2" becomes "Chanrecv2".
+func runtimeFuncName(name string) string {
+	if strings.HasPrefix(name, "__go_") {
+		name = name[5:]
+	} else {
+		name = strings.TrimPrefix(name, "runtime.")
+	}
+	var b bytes.Buffer
+	for _, word := range strings.Split(name, "_") {
+		if word != "" {
+			b.WriteString(strings.ToUpper(word[:1]))
+			b.WriteString(word[1:])
+		}
+	}
+	return b.String()
+}
+
+// runtimeFeatures describes the language features that require the given
+// runtime functions, for use in error messages. Functions not listed here
+// are described by their name.
+var runtimeFeatures = map[string]string{
+	"Go":              "go statements",
+	"NewMap":          "maps",
+	"MapIndex":        "maps",
+	"MapLen":          "maps",
+	"Mapdelete":       "maps",
+	"Mapiterinit":     "maps",
+	"Mapiternext":     "maps",
+	"Mapiter2":        "maps",
+	"NewChannel":      "channels",
+	"ChanCap":         "channels",
+	"ChanLen":         "channels",
+	"Chanrecv2":       "channels",
+	"BuiltinClose":    "channels",
+	"Receive":         "channels",
+	"SendBig":         "channels",
+	"Newselect":       "select statements",
+	"Selectdefault":   "select statements",
+	"Selectgo":        "select statements",
+	"Selectrecv2":     "select statements",
+	"Selectsend":      "select statements",
+	"Defer":           "defer statements",
+	"CheckDefer":      "defer statements",
+	"Undefer":         "defer statements",
+	"SetDeferRetaddr": "defer statements",
+	"CanRecover":      "recover",
+	"DeferredRecover": "recover",
+	"Recover":         "recover",
+	"GetClosure":      "closures",
+	"SetClosure":      "closures",
+	"New":             "heap allocation",
+	"NewNopointers":   "heap allocation",
+	"Panic":           "panics",
+	"RuntimeError":    "run-time checks",
+}
+
+// lookupRuntimeFunc returns the symbol name of the function of the runtime
+// package pkg that implements the libgo function with the given name and
+// signature. If pkg does not define the function, ok is false.
+func lookupRuntimeFunc(pkg *types.Package, name string, args, results []types.Type) (symbol string, ok bool, err error) {
+	goname := runtimeFuncName(name)
+	fn, _ := pkg.Scope().Lookup(goname).(*types.Func)
+	if fn == nil {
+		return "", false, nil
+	}
+
+	want := types.NewSignature(nil, nil, runtimeTuple(args), runtimeTuple(results), false)
+	sig := fn.Type().(*types.Signature)
+	if sig.Recv() != nil || !types.Identical(sig, want) {
+		return "", false, fmt.Errorf("runtime function %s.%s has type %s, want %s", pkg.Path(), goname, sig, want)
+	}
+
+	var b bytes.Buffer
+	mangle.ManglePackagePath(pkg.Path(), &b)
+	b.WriteRune('.')
+	b.WriteString(goname)
+	return b.String(), true, nil
+}
+
+func runtimeTuple(ts []types.Type) *types.Tuple {
+	vars := make([]*types.Var, len(ts))
+	for i, t := range ts {
+		vars[i] = types.NewParam(token.NoPos, nil, "", t)
+	}
+	return types.NewTuple(vars...)
+}
+
+// reportMissingRuntimeFunc reports the use of the runtime function rfi,
+// which the runtime package does not define, at the position of the
+// instruction being translated, and declares it. Each feature is
+// reported at most once per position.
+func (fr *frame) reportMissingRuntimeFunc(rfi *runtimeFnInfo) {
+	feature, ok := runtimeFeatures[rfi.name]
+	if !ok {
+		feature = "runtime function " + rfi.name
+	}
+	key := missingRuntimeFunc{fr.pos, feature}
+	if fr.reportedMissing[key] {
+		return
+	}
+	fr.reportedMissing[key] = true
+
+	var msg string
+	if fr.RuntimePackage == "" {
+		msg = fmt.Sprintf("%s not supported in freestanding mode without a runtime package", feature)
+	} else {
+		msg = fmt.Sprintf("%s not supported in freestanding mode: runtime package %q does not define %s", feature, fr.RuntimePackage, rfi.name)
+	}
+	fr.diagnostics.Add(fr.fileset.Position(fr.pos), SeverityError, msg)
+
+	if rfi.fn.IsNil() {
+		rfi.fn = rfi.fi.declare(fr.module.Module, rfi.name)
+	}
+}
+
+type missingRuntimeFunc struct {
+	pos     token.Pos
+	feature string
+}
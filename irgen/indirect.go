@@ -38,7 +38,7 @@ func (fr *frame) createThunk(call ssa.CallInstruction) (thunk llvm.Value, arg ll
 	}
 
 	var isRecoverCall bool
-	i8ptr := llvm.PointerType(llvm.Int8Type(), 0)
+	i8ptr := llvm.PointerType(fr.ctx.Int8Type(), 0)
 	var structllptr llvm.Type
 	if len(args) == 0 {
 		if builtin, ok := call.Common().Value.(*ssa.Builtin); ok {
@@ -62,7 +62,7 @@ func (fr *frame) createThunk(call ssa.CallInstruction) (thunk llvm.Value, arg ll
 		arg = fr.builder.CreateBitCast(arg, i8ptr, "")
 	}
 
-	thunkfntype := llvm.FunctionType(llvm.VoidType(), []llvm.Type{i8ptr}, false)
+	thunkfntype := llvm.FunctionType(fr.ctx.VoidType(), []llvm.Type{i8ptr}, false)
 	thunkfn := llvm.AddFunction(fr.module.Module, "", thunkfntype)
 	thunkfn.SetLinkage(llvm.InternalLinkage)
 	fr.addCommonFunctionAttrs(thunkfn)
@@ -70,13 +70,13 @@ func (fr *frame) createThunk(call ssa.CallInstruction) (thunk llvm.Value, arg ll
 	thunkfr := newFrame(fr.unit, thunkfn)
 	defer thunkfr.dispose()
 
-	prologuebb := llvm.AddBasicBlock(thunkfn, "prologue")
+	prologuebb := fr.ctx.AddBasicBlock(thunkfn, "prologue")
 	thunkfr.builder.SetInsertPointAtEnd(prologuebb)
 
 	if isRecoverCall {
 		thunkarg := thunkfn.Param(0)
 		thunkarg = thunkfr.builder.CreatePtrToInt(thunkarg, fr.target.IntPtrType(), "")
-		thunkfr.canRecover = thunkfr.builder.CreateTrunc(thunkarg, llvm.Int1Type(), "")
+		thunkfr.canRecover = thunkfr.builder.CreateTrunc(thunkarg, fr.ctx.Int1Type(), "")
 	} else if len(args) > 0 {
 		thunkarg := thunkfn.Param(0)
 		thunkarg = thunkfr.builder.CreateBitCast(thunkarg, structllptr, "")
@@ -89,14 +89,22 @@ func (fr *frame) createThunk(call ssa.CallInstruction) (thunk llvm.Value, arg ll
 
 	_, isDefer := call.(*ssa.Defer)
 
-	entrybb := llvm.AddBasicBlock(thunkfn, "entry")
+	entrybb := fr.ctx.AddBasicBlock(thunkfn, "entry")
 	br := thunkfr.builder.CreateBr(entrybb)
 	thunkfr.allocaBuilder.SetInsertPointBefore(br)
 
+	// In the panic flag model, the thunk returns as soon as a call
+	// panics, leaving the runtime to check the flag.
+	if thunkfr.wasm {
+		thunkfr.unwindBlock = fr.ctx.AddBasicBlock(thunkfn, "")
+		thunkfr.builder.SetInsertPointAtEnd(thunkfr.unwindBlock)
+		thunkfr.builder.CreateRetVoid()
+	}
+
 	thunkfr.builder.SetInsertPointAtEnd(entrybb)
 	var exitbb llvm.BasicBlock
 	if isDefer {
-		exitbb = llvm.AddBasicBlock(thunkfn, "exit")
+		exitbb = fr.ctx.AddBasicBlock(thunkfn, "exit")
 		thunkfr.runtime.setDeferRetaddr.call(thunkfr, llvm.BlockAddress(thunkfn, exitbb))
 	}
 	if isDefer && isRecoverCall {
@@ -5,6 +5,8 @@
 package irgen
 
 import (
+	"github.com/go-llvm/llgo/mangle"
+	"golang.org/x/tools/go/ssa"
 	"golang.org/x/tools/go/types"
 	"llvm.org/llvm/bindings/go/llvm"
 )
@@ -17,7 +19,7 @@ func (fr *frame) interfaceMethod(lliface llvm.Value, ifacety types.Type, method
 	methodset := fr.types.MethodSet(ifacety)
 	// TODO(axw) cache ordered method index
 	index := -1
-	for i, m := range orderedMethodSet(methodset) {
+	for i, m := range mangle.OrderedMethodSet(methodset) {
 		if m.Obj() == method {
 			index = i
 			break
@@ -26,10 +28,10 @@ func (fr *frame) interfaceMethod(lliface llvm.Value, ifacety types.Type, method
 	if index == -1 {
 		panic("could not find method index")
 	}
-	llitab = fr.builder.CreateBitCast(llitab, llvm.PointerType(llvm.PointerType(llvm.Int8Type(), 0), 0), "")
+	llitab = fr.builder.CreateBitCast(llitab, llvm.PointerType(llvm.PointerType(fr.ctx.Int8Type(), 0), 0), "")
 	// Skip runtime type pointer.
 	llifnptr := fr.builder.CreateGEP(llitab, []llvm.Value{
-		llvm.ConstInt(llvm.Int32Type(), uint64(index+1), false),
+		llvm.ConstInt(fr.ctx.Int32Type(), uint64(index+1), false),
 	}, "")
 
 	llifn := fr.builder.CreateLoad(llifnptr, "")
@@ -41,13 +43,40 @@ func (fr *frame) interfaceMethod(lliface llvm.Value, ifacety types.Type, method
 	return
 }
 
+// devirtualizedTarget returns the method called by the invoke-mode call,
+// if the devirtualization pass has determined it, or nil otherwise.
+func (fr *frame) devirtualizedTarget(call *ssa.CallCommon) *ssa.Function {
+	if fr.devirt == nil || !call.IsInvoke() {
+		return nil
+	}
+	return fr.devirt.Targets[call]
+}
+
+// devirtualizedMethod returns a function and receiver pointer for a call
+// of the method fn through the specified interface, whose dynamic type is
+// known to be fn's receiver type. The interface must not be nil, as the
+// method would otherwise be loaded from a nil itab.
+func (fr *frame) devirtualizedMethod(lliface llvm.Value, fn *ssa.Function) (*govalue, *govalue) {
+	llitab := fr.builder.CreateExtractValue(lliface, 0, "")
+	fr.condBrRuntimeError(fr.builder.CreateIsNull(llitab, ""), gccgoRuntimeErrorNIL_DEREFERENCE)
+	recv := newValue(fr.builder.CreateExtractValue(lliface, 1, ""), types.Typ[types.UnsafePointer])
+
+	llfn := fr.resolveFunctionGlobal(fn)
+	llfn = llvm.ConstBitCast(llfn, llvm.PointerType(fr.ctx.Int8Type(), 0))
+	// Replace receiver type with unsafe.Pointer, as for interfaceMethod.
+	recvparam := types.NewParam(0, nil, "", types.Typ[types.UnsafePointer])
+	sig := fn.Signature
+	sig = types.NewSignature(nil, recvparam, sig.Params(), sig.Results(), sig.Variadic())
+	return newValue(llfn, sig), recv
+}
+
 // compareInterfaces emits code to compare two interfaces for
 // equality.
 func (fr *frame) compareInterfaces(a, b *govalue) *govalue {
 	aNull := a.value.IsNull()
 	bNull := b.value.IsNull()
 	if aNull && bNull {
-		return newValue(boolLLVMValue(true), types.Typ[types.Bool])
+		return newValue(fr.boolLLVMValue(true), types.Typ[types.Bool])
 	}
 
 	compare := fr.runtime.emptyInterfaceCompare
@@ -64,7 +93,7 @@ func (fr *frame) compareInterfaces(a, b *govalue) *govalue {
 
 	result := compare.call(fr, a.value, b.value)[0]
 	result = fr.builder.CreateIsNull(result, "")
-	result = fr.builder.CreateZExt(result, llvm.Int8Type(), "")
+	result = fr.builder.CreateZExt(result, fr.ctx.Int8Type(), "")
 	return newValue(result, types.Typ[types.Bool])
 }
 
@@ -78,7 +107,7 @@ func (fr *frame) makeInterface(llv llvm.Value, vty types.Type, iface types.Type)
 }
 
 func (fr *frame) makeInterfaceFromPointer(vptr llvm.Value, vty types.Type, iface types.Type) *govalue {
-	i8ptr := llvm.PointerType(llvm.Int8Type(), 0)
+	i8ptr := llvm.PointerType(fr.ctx.Int8Type(), 0)
 	llv := fr.builder.CreateBitCast(vptr, i8ptr, "")
 	value := llvm.Undef(fr.types.ToLLVM(iface))
 	itab := fr.types.getItabPointer(vty, iface.Underlying().(*types.Interface))
@@ -137,7 +166,7 @@ func (fr *frame) interfaceTypeCheck(val *govalue, ty types.Type) (v *govalue, ok
 		valtd := fr.getInterfaceTypeDescriptor(val)
 		tyequal := fr.runtime.typeDescriptorsEqual.call(fr, valtd, tytd)[0]
 		okval = newValue(tyequal, types.Typ[types.Bool])
-		tyequal = fr.builder.CreateTrunc(tyequal, llvm.Int1Type(), "")
+		tyequal = fr.builder.CreateTrunc(tyequal, fr.ctx.Int1Type(), "")
 
 		v = fr.getInterfaceValueOrNull(tyequal, val, ty)
 	}
@@ -13,7 +13,7 @@ import (
 func (fr *frame) makeMap(typ types.Type, cap_ *govalue) *govalue {
 	// TODO(pcc): call __go_new_map_big here if needed
 	dyntyp := fr.types.getMapDescriptorPointer(typ)
-	dyntyp = fr.builder.CreateBitCast(dyntyp, llvm.PointerType(llvm.Int8Type(), 0), "")
+	dyntyp = fr.builder.CreateBitCast(dyntyp, llvm.PointerType(fr.ctx.Int8Type(), 0), "")
 	var cap llvm.Value
 	if cap_ != nil {
 		cap = fr.convert(cap_, types.Typ[types.Uintptr]).value
@@ -29,13 +29,13 @@ func (fr *frame) mapLookup(m, k *govalue) (v *govalue, ok *govalue) {
 	llk := k.value
 	pk := fr.allocaBuilder.CreateAlloca(llk.Type(), "")
 	fr.builder.CreateStore(llk, pk)
-	valptr := fr.runtime.mapIndex.call(fr, m.value, pk, boolLLVMValue(false))[0]
+	valptr := fr.runtime.mapIndex.call(fr, m.value, pk, fr.boolLLVMValue(false))[0]
 	valptr.AddInstrAttribute(2, llvm.NoCaptureAttribute)
 	valptr.AddInstrAttribute(2, llvm.ReadOnlyAttribute)
 	okbit := fr.builder.CreateIsNotNull(valptr, "")
 
 	elemtyp := m.Type().Underlying().(*types.Map).Elem()
-	ok = newValue(fr.builder.CreateZExt(okbit, llvm.Int8Type(), ""), types.Typ[types.Bool])
+	ok = newValue(fr.builder.CreateZExt(okbit, fr.ctx.Int8Type(), ""), types.Typ[types.Bool])
 	v = fr.loadOrNull(okbit, valptr, elemtyp)
 	return
 }
@@ -45,7 +45,7 @@ func (fr *frame) mapUpdate(m, k, v *govalue) {
 	llk := k.value
 	pk := fr.allocaBuilder.CreateAlloca(llk.Type(), "")
 	fr.builder.CreateStore(llk, pk)
-	valptr := fr.runtime.mapIndex.call(fr, m.value, pk, boolLLVMValue(true))[0]
+	valptr := fr.runtime.mapIndex.call(fr, m.value, pk, fr.boolLLVMValue(true))[0]
 	valptr.AddInstrAttribute(2, llvm.NoCaptureAttribute)
 	valptr.AddInstrAttribute(2, llvm.ReadOnlyAttribute)
 
@@ -69,8 +69,8 @@ func (fr *frame) mapIterInit(m *govalue) []*govalue {
 	// controls whether the code we generate for "next" (below) calls the
 	// runtime function for the first or the next element. We let the
 	// optimizer reorganize this into something more sensible.
-	isinit := fr.allocaBuilder.CreateAlloca(llvm.Int1Type(), "")
-	fr.builder.CreateStore(llvm.ConstNull(llvm.Int1Type()), isinit)
+	isinit := fr.allocaBuilder.CreateAlloca(fr.ctx.Int1Type(), "")
+	fr.builder.CreateStore(llvm.ConstNull(fr.ctx.Int1Type()), isinit)
 
 	return []*govalue{m, newValue(isinit, types.NewPointer(types.Typ[types.Bool]))}
 }
@@ -85,7 +85,7 @@ func (fr *frame) mapIterNext(iter []*govalue) []*govalue {
 
 	m, isinitptr := iter[0], iter[1]
 
-	i8ptr := llvm.PointerType(llvm.Int8Type(), 0)
+	i8ptr := llvm.PointerType(fr.ctx.Int8Type(), 0)
 	mapiterbufty := llvm.ArrayType(i8ptr, 4)
 	mapiterbuf := fr.allocaBuilder.CreateAlloca(mapiterbufty, "")
 	mapiterbufelem0ptr := fr.builder.CreateStructGEP(mapiterbuf, 0, "")
@@ -97,14 +97,14 @@ func (fr *frame) mapIterNext(iter []*govalue) []*govalue {
 
 	isinit := fr.builder.CreateLoad(isinitptr.value, "")
 
-	initbb := llvm.AddBasicBlock(fr.function, "")
-	nextbb := llvm.AddBasicBlock(fr.function, "")
-	contbb := llvm.AddBasicBlock(fr.function, "")
+	initbb := fr.ctx.AddBasicBlock(fr.function, "")
+	nextbb := fr.ctx.AddBasicBlock(fr.function, "")
+	contbb := fr.ctx.AddBasicBlock(fr.function, "")
 
 	fr.builder.CreateCondBr(isinit, nextbb, initbb)
 
 	fr.builder.SetInsertPointAtEnd(initbb)
-	fr.builder.CreateStore(llvm.ConstAllOnes(llvm.Int1Type()), isinitptr.value)
+	fr.builder.CreateStore(llvm.ConstAllOnes(fr.ctx.Int1Type()), isinitptr.value)
 	fr.runtime.mapiterinit.call(fr, m.value, mapiterbufelem0ptr)
 	fr.builder.CreateBr(contbb)
 
@@ -115,10 +115,10 @@ func (fr *frame) mapIterNext(iter []*govalue) []*govalue {
 	fr.builder.SetInsertPointAtEnd(contbb)
 	mapiterbufelem0 := fr.builder.CreateLoad(mapiterbufelem0ptr, "")
 	okbit := fr.builder.CreateIsNotNull(mapiterbufelem0, "")
-	ok := fr.builder.CreateZExt(okbit, llvm.Int8Type(), "")
+	ok := fr.builder.CreateZExt(okbit, fr.ctx.Int8Type(), "")
 
-	loadbb := llvm.AddBasicBlock(fr.function, "")
-	cont2bb := llvm.AddBasicBlock(fr.function, "")
+	loadbb := fr.ctx.AddBasicBlock(fr.function, "")
+	cont2bb := fr.ctx.AddBasicBlock(fr.function, "")
 	fr.builder.CreateCondBr(okbit, loadbb, cont2bb)
 
 	fr.builder.SetInsertPointAtEnd(loadbb)
@@ -0,0 +1,47 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package irgen
+
+import (
+	"sort"
+
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// packageFunctions returns the functions that may be defined by the module
+// for pkg, sorted by name.
+func packageFunctions(pkg *ssa.Package) []*ssa.Function {
+	var fns []*ssa.Function
+	for f := range ssautil.AllFunctions(pkg.Prog) {
+		// Synthetic wrappers do not have a package, and are
+		// defined by each package using them.
+		if f.Pkg == pkg || f.Pkg == nil {
+			fns = append(fns, f)
+		}
+	}
+	sort.Sort(byFunctionString(fns))
+	return fns
+}
+
+// optimizePackage runs the SSA optimization passes over the functions
+// of pkg.
+func (c *compiler) optimizePackage(pkg *ssa.Package) {
+	for _, f := range packageFunctions(pkg) {
+		c.optimizeFunction(f)
+	}
+}
+
+// optimizeFunction runs the SSA optimization passes over f, unless they
+// have already been run over it. Functions created after optimizePackage
+// was called, such as wrappers required by type descriptors, are optimized
+// when they are defined.
+func (c *compiler) optimizeFunction(f *ssa.Function) {
+	if c.optimized[f] {
+		return
+	}
+	c.optimized[f] = true
+	c.passManager.Run(f)
+}
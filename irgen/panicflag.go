@@ -0,0 +1,126 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package irgen
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/go-llvm/llgo/mangle"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/types"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// WebAssembly has no means of unwinding the stack, so panics cannot be
+// lowered to landing pads. Instead, the runtime sets a flag where it would
+// otherwise begin unwinding the stack (that is, when a function panics,
+// when a panic is recovered, or when a deferred call panics), and returns.
+// Every call is followed by a check of the flag, and if it is set, control
+// passes to the function's unwind block:
+//
+//   - Functions without defers propagate the panic to their caller by
+//     returning zero values, leaving the flag set.
+//   - Functions with defers call CheckDefer, which clears the flag if the
+//     panic was recovered by one of the function's deferred calls. If the
+//     flag is still set, the panic is propagated; otherwise the remaining
+//     deferred calls are made, and the function returns as if it had
+//     recovered from a landing pad.
+//
+// Since the zero values returned by propagating functions are never
+// observed, this preserves the semantics of the landing pad lowering. The
+// thunks of deferred calls and go statements, the bridges to functions
+// calling recover, and __go_init_main propagate panics in the same way.
+//
+// As libgo cannot be built for WebAssembly, the runtime is a freestanding
+// runtime package, and the flag is its variable
+//
+//	var Panicking bool
+//
+// Its Panic and RuntimeError functions set the flag and return, rather than
+// unwinding the stack, and the runtime checks the flag after each call it
+// makes to generated code, such as the deferred calls made by Undefer and
+// CheckDefer, and the call of __go_init_main.
+
+// lookupPanicking returns the symbol name of the Panicking variable of the
+// runtime package pkg, or an error if pkg does not define it.
+func lookupPanicking(pkg *types.Package) (string, error) {
+	v, _ := pkg.Scope().Lookup("Panicking").(*types.Var)
+	if v == nil {
+		return "", fmt.Errorf("runtime package %q does not define Panicking, which is required for WebAssembly targets", pkg.Path())
+	}
+	if !types.Identical(v.Type(), types.Typ[types.Bool]) {
+		return "", fmt.Errorf("runtime variable %s.Panicking has type %s, want bool", pkg.Path(), v.Type())
+	}
+	var b bytes.Buffer
+	mangle.ManglePackagePath(pkg.Path(), &b)
+	b.WriteString(".Panicking")
+	return b.String(), nil
+}
+
+// panickingGlobal returns the flag set by the runtime while a panic is
+// propagating.
+func (c *compiler) panickingGlobal() llvm.Value {
+	flag := c.module.Module.NamedGlobal(c.panickingName)
+	if flag.IsNil() {
+		flag = llvm.AddGlobal(c.module.Module, c.ctx.Int8Type(), c.panickingName)
+	}
+	return flag
+}
+
+// panicking returns a boolean which is true if a panic is propagating.
+func (c *compiler) panicking(b llvm.Builder) llvm.Value {
+	flag := b.CreateLoad(c.panickingGlobal(), "")
+	return b.CreateIsNotNull(flag, "")
+}
+
+// checkPanicking branches to lpad if a panic is propagating, and otherwise
+// to cont, at which the builder is positioned.
+func (fr *frame) checkPanicking(cont, lpad llvm.BasicBlock) {
+	br := fr.builder.CreateCondBr(fr.panicking(fr.builder), lpad, cont)
+	fr.setBranchWeightMetadata(br, 1, 1000)
+	fr.builder.SetInsertPointAtEnd(cont)
+}
+
+// propagateBlock returns a block which propagates a panic to the
+// function's caller.
+func (fr *frame) propagateBlock() llvm.BasicBlock {
+	if fr.propagatebb.IsNil() {
+		fr.propagatebb = fr.ctx.AddBasicBlock(fr.function, "")
+		saved := fr.builder.GetInsertBlock()
+		fr.builder.SetInsertPointAtEnd(fr.propagatebb)
+		fr.returnZeroValues(fr.results)
+		fr.builder.SetInsertPointAtEnd(saved)
+	}
+	return fr.propagatebb
+}
+
+// returnZeroValues returns the zero values of the given result types.
+func (fr *frame) returnZeroValues(results *types.Tuple) {
+	values := make([]llvm.Value, results.Len())
+	for i := range values {
+		values[i] = llvm.ConstNull(fr.llvmtypes.ToLLVM(results.At(i).Type()))
+	}
+	fr.retInf.encode(fr.ctx, fr.allocaBuilder, fr.builder, values)
+}
+
+// setupPanicFlagUnwindBlock fills in the function's unwind block, which is
+// entered when a call returns with the panic flag set.
+func (fr *frame) setupPanicFlagUnwindBlock(rec *ssa.BasicBlock, results *types.Tuple) {
+	if fr.frameptr.IsNil() {
+		fr.builder.SetInsertPointAtEnd(fr.unwindBlock)
+		fr.builder.CreateBr(fr.propagateBlock())
+		return
+	}
+
+	recoverbb := fr.createRecoverBlock(rec, results)
+
+	fr.builder.SetInsertPointAtEnd(fr.unwindBlock)
+	fr.runtime.checkDefer.callOnly(fr, fr.frameptr)
+	contbb := fr.ctx.AddBasicBlock(fr.function, "")
+	fr.checkPanicking(contbb, fr.propagateBlock())
+	fr.runDefers()
+	fr.builder.CreateBr(recoverbb)
+}
@@ -15,12 +15,22 @@ import (
 type runtimeFnInfo struct {
 	fi *functionTypeInfo
 	fn llvm.Value
+
+	// In freestanding mode, name is the name of the function of the
+	// runtime package implementing this function, and missing is set if
+	// the runtime package does not define it. Missing functions are only
+	// declared once their use has been reported, so that code generation
+	// may continue.
+	name    string
+	missing bool
 }
 
 func (rfi *runtimeFnInfo) init(tm *llvmTypeMap, m llvm.Module, name string, args []types.Type, results []types.Type) {
 	rfi.fi = new(functionTypeInfo)
 	*rfi.fi = tm.getFunctionTypeInfo(args, results)
-	rfi.fn = rfi.fi.declare(m, name)
+	if !rfi.missing {
+		rfi.fn = rfi.fi.declare(m, name)
+	}
 }
 
 func (rfi *runtimeFnInfo) call(f *frame, args ...llvm.Value) []llvm.Value {
@@ -32,11 +42,22 @@ func (rfi *runtimeFnInfo) call(f *frame, args ...llvm.Value) []llvm.Value {
 }
 
 func (rfi *runtimeFnInfo) callOnly(f *frame, args ...llvm.Value) []llvm.Value {
+	if rfi.missing {
+		f.reportMissingRuntimeFunc(rfi)
+	}
 	return rfi.fi.call(f.llvmtypes.ctx, f.allocaBuilder, f.builder, rfi.fn, args)
 }
 
 func (rfi *runtimeFnInfo) invoke(f *frame, lpad llvm.BasicBlock, args ...llvm.Value) []llvm.Value {
-	contbb := llvm.AddBasicBlock(f.function, "")
+	if rfi.missing {
+		f.reportMissingRuntimeFunc(rfi)
+	}
+	contbb := f.ctx.AddBasicBlock(f.function, "")
+	if f.wasm {
+		results := rfi.callOnly(f, args...)
+		f.checkPanicking(contbb, lpad)
+		return results
+	}
 	return rfi.fi.invoke(f.llvmtypes.ctx, f.allocaBuilder, f.builder, rfi.fn, args, contbb, lpad)
 }
 
@@ -119,7 +140,12 @@ type runtimeInterface struct {
 	undefer runtimeFnInfo
 }
 
-func newRuntimeInterface(module llvm.Module, tm *llvmTypeMap) (*runtimeInterface, error) {
+// newRuntimeInterface declares the runtime functions in module. In
+// freestanding mode, they are the functions of the runtime package rtpkg,
+// which may be nil if there is none. If panicFlag is set, panics are
+// lowered as described in panicflag.go, and so functions that panic return
+// to their caller.
+func newRuntimeInterface(module llvm.Module, tm *llvmTypeMap, freestanding, panicFlag bool, rtpkg *types.Package) (*runtimeInterface, error) {
 	var ri runtimeInterface
 
 	Bool := types.Typ[types.Bool]
@@ -492,21 +518,39 @@ func newRuntimeInterface(module llvm.Module, tm *llvmTypeMap) (*runtimeInterface
 			args: []types.Type{UnsafePointer},
 		},
 	} {
-		rt.rfi.init(tm, module, rt.name, rt.args, rt.res)
+		name := rt.name
+		if freestanding {
+			rt.rfi.name = runtimeFuncName(rt.name)
+			rt.rfi.missing = true
+			if rtpkg != nil {
+				symbol, ok, err := lookupRuntimeFunc(rtpkg, rt.name, rt.args, rt.res)
+				if err != nil {
+					return nil, err
+				}
+				name, rt.rfi.missing = symbol, !ok
+			}
+		}
+		rt.rfi.init(tm, module, name, rt.args, rt.res)
+		if rt.rfi.missing {
+			continue
+		}
 		for _, attr := range rt.attrs {
+			if panicFlag && attr == llvm.NoReturnAttribute {
+				continue
+			}
 			rt.rfi.fn.AddFunctionAttr(attr)
 		}
 	}
 
 	memsetName := "llvm.memset.p0i8.i" + strconv.Itoa(tm.target.IntPtrType().IntTypeWidth())
 	memsetType := llvm.FunctionType(
-		llvm.VoidType(),
+		tm.ctx.VoidType(),
 		[]llvm.Type{
-			llvm.PointerType(llvm.Int8Type(), 0),
-			llvm.Int8Type(),
+			llvm.PointerType(tm.ctx.Int8Type(), 0),
+			tm.ctx.Int8Type(),
 			tm.target.IntPtrType(),
-			llvm.Int32Type(),
-			llvm.Int1Type(),
+			tm.ctx.Int32Type(),
+			tm.ctx.Int1Type(),
 		},
 		false,
 	)
@@ -514,41 +558,41 @@ func newRuntimeInterface(module llvm.Module, tm *llvmTypeMap) (*runtimeInterface
 
 	memcpyName := "llvm.memcpy.p0i8.p0i8.i" + strconv.Itoa(tm.target.IntPtrType().IntTypeWidth())
 	memcpyType := llvm.FunctionType(
-		llvm.VoidType(),
+		tm.ctx.VoidType(),
 		[]llvm.Type{
-			llvm.PointerType(llvm.Int8Type(), 0),
-			llvm.PointerType(llvm.Int8Type(), 0),
+			llvm.PointerType(tm.ctx.Int8Type(), 0),
+			llvm.PointerType(tm.ctx.Int8Type(), 0),
 			tm.target.IntPtrType(),
-			llvm.Int32Type(),
-			llvm.Int1Type(),
+			tm.ctx.Int32Type(),
+			tm.ctx.Int1Type(),
 		},
 		false,
 	)
 	ri.memcpy = llvm.AddFunction(module, memcpyName, memcpyType)
 
 	returnaddressType := llvm.FunctionType(
-		llvm.PointerType(llvm.Int8Type(), 0),
-		[]llvm.Type{llvm.Int32Type()},
+		llvm.PointerType(tm.ctx.Int8Type(), 0),
+		[]llvm.Type{tm.ctx.Int32Type()},
 		false,
 	)
 	ri.returnaddress = llvm.AddFunction(module, "llvm.returnaddress", returnaddressType)
 
 	gccgoPersonalityType := llvm.FunctionType(
-		llvm.Int32Type(),
+		tm.ctx.Int32Type(),
 		[]llvm.Type{
-			llvm.Int32Type(),
-			llvm.Int64Type(),
-			llvm.PointerType(llvm.Int8Type(), 0),
-			llvm.PointerType(llvm.Int8Type(), 0),
+			tm.ctx.Int32Type(),
+			tm.ctx.Int64Type(),
+			llvm.PointerType(tm.ctx.Int8Type(), 0),
+			llvm.PointerType(tm.ctx.Int8Type(), 0),
 		},
 		false,
 	)
 	ri.gccgoPersonality = llvm.AddFunction(module, "__gccgo_personality_v0", gccgoPersonalityType)
 
-	ri.gccgoExceptionType = llvm.StructType(
+	ri.gccgoExceptionType = tm.ctx.StructType(
 		[]llvm.Type{
-			llvm.PointerType(llvm.Int8Type(), 0),
-			llvm.Int32Type(),
+			llvm.PointerType(tm.ctx.Int8Type(), 0),
+			tm.ctx.Int32Type(),
 		},
 		false,
 	)
@@ -585,26 +629,26 @@ func (fr *frame) createTypeMalloc(t types.Type) llvm.Value {
 
 func (fr *frame) memsetZero(ptr llvm.Value, size llvm.Value) {
 	memset := fr.runtime.memset
-	ptr = fr.builder.CreateBitCast(ptr, llvm.PointerType(llvm.Int8Type(), 0), "")
-	fill := llvm.ConstNull(llvm.Int8Type())
+	ptr = fr.builder.CreateBitCast(ptr, llvm.PointerType(fr.ctx.Int8Type(), 0), "")
+	fill := llvm.ConstNull(fr.ctx.Int8Type())
 	size = fr.createZExtOrTrunc(size, fr.target.IntPtrType(), "")
-	align := llvm.ConstInt(llvm.Int32Type(), 1, false)
-	isvolatile := llvm.ConstNull(llvm.Int1Type())
+	align := llvm.ConstInt(fr.ctx.Int32Type(), 1, false)
+	isvolatile := llvm.ConstNull(fr.ctx.Int1Type())
 	fr.builder.CreateCall(memset, []llvm.Value{ptr, fill, size, align, isvolatile}, "")
 }
 
 func (fr *frame) memcpy(dest llvm.Value, src llvm.Value, size llvm.Value) {
 	memcpy := fr.runtime.memcpy
-	dest = fr.builder.CreateBitCast(dest, llvm.PointerType(llvm.Int8Type(), 0), "")
-	src = fr.builder.CreateBitCast(src, llvm.PointerType(llvm.Int8Type(), 0), "")
+	dest = fr.builder.CreateBitCast(dest, llvm.PointerType(fr.ctx.Int8Type(), 0), "")
+	src = fr.builder.CreateBitCast(src, llvm.PointerType(fr.ctx.Int8Type(), 0), "")
 	size = fr.createZExtOrTrunc(size, fr.target.IntPtrType(), "")
-	align := llvm.ConstInt(llvm.Int32Type(), 1, false)
-	isvolatile := llvm.ConstNull(llvm.Int1Type())
+	align := llvm.ConstInt(fr.ctx.Int32Type(), 1, false)
+	isvolatile := llvm.ConstNull(fr.ctx.Int1Type())
 	fr.builder.CreateCall(memcpy, []llvm.Value{dest, src, size, align, isvolatile}, "")
 }
 
 func (fr *frame) returnAddress(level uint64) llvm.Value {
 	returnaddress := fr.runtime.returnaddress
-	levelValue := llvm.ConstInt(llvm.Int32Type(), level, false)
+	levelValue := llvm.ConstInt(fr.ctx.Int32Type(), level, false)
 	return fr.builder.CreateCall(returnaddress, []llvm.Value{levelValue}, "")
 }
@@ -19,6 +19,23 @@ func (fr *frame) makeSlice(sliceType types.Type, length, capacity *govalue) *gov
 	return newValue(llslice[0], sliceType)
 }
 
+// makeStackSlice creates a slice with the given constant length and
+// capacity, whose backing array is allocated on the stack.
+func (fr *frame) makeStackSlice(sliceType types.Type, length, capacity int64) *govalue {
+	elemtyp := sliceType.Underlying().(*types.Slice).Elem()
+	arraytyp := llvm.ArrayType(fr.types.ToLLVM(elemtyp), int(capacity))
+	array := fr.allocaBuilder.CreateAlloca(arraytyp, "")
+	fr.memsetZero(array, llvm.SizeOf(arraytyp))
+	arrayptr := fr.builder.CreateBitCast(array, llvm.PointerType(fr.ctx.Int8Type(), 0), "")
+
+	llslicetyp := fr.llvmtypes.sliceBackendType().ToLLVM(fr.llvmtypes.ctx)
+	sliceValue := llvm.Undef(llslicetyp)
+	sliceValue = fr.builder.CreateInsertValue(sliceValue, arrayptr, 0, "")
+	sliceValue = fr.builder.CreateInsertValue(sliceValue, llvm.ConstInt(fr.types.inttype, uint64(length), false), 1, "")
+	sliceValue = fr.builder.CreateInsertValue(sliceValue, llvm.ConstInt(fr.types.inttype, uint64(capacity), false), 2, "")
+	return newValue(sliceValue, sliceType)
+}
+
 func (fr *frame) slice(x llvm.Value, xtyp types.Type, low, high, max llvm.Value) llvm.Value {
 	if !low.IsNil() {
 		low = fr.createZExtOrTrunc(low, fr.types.inttype, "")
@@ -41,7 +58,7 @@ func (fr *frame) slice(x llvm.Value, xtyp types.Type, low, high, max llvm.Value)
 		arraytyp := typ.Elem().Underlying().(*types.Array)
 		elemtyp = arraytyp.Elem()
 		arrayptr = x
-		arrayptr = fr.builder.CreateBitCast(arrayptr, llvm.PointerType(llvm.Int8Type(), 0), "")
+		arrayptr = fr.builder.CreateBitCast(arrayptr, llvm.PointerType(fr.ctx.Int8Type(), 0), "")
 		arraylen = llvm.ConstInt(fr.llvmtypes.inttype, uint64(arraytyp.Len()), false)
 		arraycap = arraylen
 	case *types.Slice:
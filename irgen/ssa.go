@@ -12,6 +12,7 @@ import (
 	"sort"
 
 	"github.com/go-llvm/llgo/ssaopt"
+	"golang.org/x/tools/go/exact"
 	"golang.org/x/tools/go/ssa"
 	"golang.org/x/tools/go/ssa/ssautil"
 	"golang.org/x/tools/go/types"
@@ -76,7 +77,7 @@ func (gi *globalInit) build(typ llvm.Type) llvm.Value {
 		for i, eltyp := range eltypes {
 			elems[i] = gi.elems[i].build(eltyp)
 		}
-		return llvm.ConstStruct(elems, false)
+		return typ.Context().ConstStruct(elems, false)
 	case llvm.ArrayTypeKind:
 		eltyp := typ.ElementType()
 		elems := make([]llvm.Value, len(gi.elems))
@@ -171,7 +172,7 @@ func (u *unit) translatePackage(pkg *ssa.Package) {
 		case *ssa.Global:
 			elemtyp := deref(v.Type())
 			llelemtyp := u.llvmtypes.ToLLVM(elemtyp)
-			vname := u.types.mc.mangleGlobalName(v)
+			vname := u.types.mc.MangleGlobalName(v)
 			global := llvm.AddGlobal(u.module.Module, llelemtyp, vname)
 			if !v.Object().Exported() {
 				global.SetLinkage(llvm.InternalLinkage)
@@ -206,9 +207,9 @@ func (u *unit) addGlobal(global llvm.Value, ty types.Type) {
 	u.globalInits[global] = new(globalInit)
 
 	if hasPointers(ty) {
-		global = llvm.ConstBitCast(global, llvm.PointerType(llvm.Int8Type(), 0))
+		global = llvm.ConstBitCast(global, llvm.PointerType(u.ctx.Int8Type(), 0))
 		size := llvm.ConstInt(u.types.inttype, uint64(u.types.Sizeof(ty)), false)
-		root := llvm.ConstStruct([]llvm.Value{global, size}, false)
+		root := u.ctx.ConstStruct([]llvm.Value{global, size}, false)
 		u.gcRoots = append(u.gcRoots, root)
 	}
 }
@@ -217,7 +218,7 @@ func (u *unit) addGlobal(global llvm.Value, ty types.Type) {
 func (u *unit) ResolveMethod(s *types.Selection) *govalue {
 	m := u.pkg.Prog.Method(s)
 	llfn := u.resolveFunctionGlobal(m)
-	llfn = llvm.ConstBitCast(llfn, llvm.PointerType(llvm.Int8Type(), 0))
+	llfn = llvm.ConstBitCast(llfn, llvm.PointerType(u.ctx.Int8Type(), 0))
 	return newValue(llfn, m.Signature)
 }
 
@@ -226,8 +227,8 @@ func (u *unit) ResolveMethod(s *types.Selection) *govalue {
 func (u *unit) resolveFunctionDescriptorGlobal(f *ssa.Function) llvm.Value {
 	llfd, ok := u.funcDescriptors[f]
 	if !ok {
-		name := u.types.mc.mangleFunctionName(f) + "$descriptor"
-		llfd = llvm.AddGlobal(u.module.Module, llvm.PointerType(llvm.Int8Type(), 0), name)
+		name := u.types.mc.MangleFunctionName(f) + "$descriptor"
+		llfd = llvm.AddGlobal(u.module.Module, llvm.PointerType(u.ctx.Int8Type(), 0), name)
 		llfd.SetGlobalConstant(true)
 		u.funcDescriptors[f] = llfd
 	}
@@ -238,7 +239,7 @@ func (u *unit) resolveFunctionDescriptorGlobal(f *ssa.Function) llvm.Value {
 // first-class value representation.
 func (u *unit) resolveFunctionDescriptor(f *ssa.Function) *govalue {
 	llfd := u.resolveFunctionDescriptorGlobal(f)
-	llfd = llvm.ConstBitCast(llfd, llvm.PointerType(llvm.Int8Type(), 0))
+	llfd = llvm.ConstBitCast(llfd, llvm.PointerType(u.ctx.Int8Type(), 0))
 	return newValue(llfd, f.Signature)
 }
 
@@ -247,7 +248,7 @@ func (u *unit) resolveFunctionGlobal(f *ssa.Function) llvm.Value {
 	if v, ok := u.globals[f]; ok {
 		return v
 	}
-	name := u.types.mc.mangleFunctionName(f)
+	name := u.types.mc.MangleFunctionName(f)
 	// It's possible that the function already exists in the module;
 	// for example, if it's a runtime intrinsic that the compiler
 	// has already referenced.
@@ -298,7 +299,7 @@ func (u *unit) defineFunction(f *ssa.Function) {
 	// Methods cannot be referred to via a descriptor.
 	if !isMethod {
 		llfd := u.resolveFunctionDescriptorGlobal(f)
-		llfd.SetInitializer(llvm.ConstBitCast(llfn, llvm.PointerType(llvm.Int8Type(), 0)))
+		llfd.SetInitializer(llvm.ConstBitCast(llfn, llvm.PointerType(u.ctx.Int8Type(), 0)))
 		llfd.SetLinkage(linkage)
 	}
 
@@ -307,7 +308,15 @@ func (u *unit) defineFunction(f *ssa.Function) {
 		return
 	}
 
-	ssaopt.LowerAllocsToStack(f)
+	u.optimizeFunction(f)
+	u.latePassManager.Run(f)
+	var decisions []ssaopt.AllocDecision
+	if u.stackAlloc != nil {
+		decisions = u.stackAlloc.Decisions[f]
+	}
+	if u.DumpEscape != EscapeDumpNone {
+		u.escapeDecisions = append(u.escapeDecisions, decisions...)
+	}
 
 	if u.DumpSSA {
 		f.WriteTo(os.Stderr)
@@ -315,6 +324,12 @@ func (u *unit) defineFunction(f *ssa.Function) {
 
 	fr := newFrame(u, llfn)
 	defer fr.dispose()
+	fr.pos = f.Pos()
+	for _, d := range decisions {
+		if _, isAlloc := d.Alloc.(*ssa.Alloc); !isAlloc && d.Lowered() {
+			fr.stackValues[d.Alloc] = true
+		}
+	}
 	fr.addCommonFunctionAttrs(fr.function)
 	fr.function.SetLinkage(linkage)
 
@@ -337,14 +352,18 @@ func (u *unit) defineFunction(f *ssa.Function) {
 		fr = fr.bridgeRecoverFunc(fr.function, fti)
 	}
 
+	fr.results = f.Signature.Results()
+	if fr.describeVariables() {
+		fr.debugVars = debugVars(f)
+	}
 	fr.blocks = make([]llvm.BasicBlock, len(f.Blocks))
 	fr.lastBlocks = make([]llvm.BasicBlock, len(f.Blocks))
 	for i, block := range f.Blocks {
-		fr.blocks[i] = llvm.AddBasicBlock(fr.function, fmt.Sprintf(".%d.%s", i, block.Comment))
+		fr.blocks[i] = u.ctx.AddBasicBlock(fr.function, fmt.Sprintf(".%d.%s", i, block.Comment))
 	}
 	fr.builder.SetInsertPointAtEnd(fr.blocks[0])
 
-	prologueBlock := llvm.InsertBasicBlock(fr.blocks[0], "prologue")
+	prologueBlock := u.ctx.InsertBasicBlock(fr.blocks[0], "prologue")
 	fr.builder.SetInsertPointAtEnd(prologueBlock)
 
 	// Map parameter positions to indices. We use this
@@ -353,7 +372,7 @@ func (u *unit) defineFunction(f *ssa.Function) {
 	paramPos := make(map[token.Pos]int)
 	for i, param := range f.Params {
 		paramPos[param.Pos()] = i
-		llparam := fti.argInfos[i].decode(llvm.GlobalContext(), fr.builder, fr.builder)
+		llparam := fti.argInfos[i].decode(fr.ctx, fr.builder, fr.builder)
 		if isMethod && i == 0 {
 			if _, ok := param.Type().Underlying().(*types.Pointer); !ok {
 				llparam = fr.builder.CreateBitCast(llparam, llvm.PointerType(fr.types.ToLLVM(param.Type()), 0), "")
@@ -369,13 +388,16 @@ func (u *unit) defineFunction(f *ssa.Function) {
 			fr.env[fv] = newValue(llvm.ConstNull(u.llvmtypes.ToLLVM(fv.Type())), fv.Type())
 		}
 		elemTypes := make([]llvm.Type, len(f.FreeVars)+1)
-		elemTypes[0] = llvm.PointerType(llvm.Int8Type(), 0) // function pointer
+		elemTypes[0] = llvm.PointerType(u.ctx.Int8Type(), 0) // function pointer
 		for i, fv := range f.FreeVars {
 			elemTypes[i+1] = u.llvmtypes.ToLLVM(fv.Type())
 		}
-		structType := llvm.StructType(elemTypes, false)
+		structType := u.ctx.StructType(elemTypes, false)
 		closure := fr.runtime.getClosure.call(fr)[0]
 		closure = fr.builder.CreateBitCast(closure, llvm.PointerType(structType, 0), "")
+		if fr.describeVariables() {
+			fr.debug.Closure(fr.builder, f, closure)
+		}
 		for i, fv := range f.FreeVars {
 			ptr := fr.builder.CreateStructGEP(closure, i+1, "")
 			ptr = fr.builder.CreateLoad(ptr, "")
@@ -384,21 +406,27 @@ func (u *unit) defineFunction(f *ssa.Function) {
 	}
 
 	// Allocate stack space for locals in the prologue block.
+	declaredParams := make(map[int]bool)
 	for _, local := range f.Locals {
 		typ := fr.llvmtypes.ToLLVM(deref(local.Type()))
 		alloca := fr.builder.CreateAlloca(typ, local.Comment)
 		fr.memsetZero(alloca, llvm.SizeOf(typ))
-		bcalloca := fr.builder.CreateBitCast(alloca, llvm.PointerType(llvm.Int8Type(), 0), "")
+		bcalloca := fr.builder.CreateBitCast(alloca, llvm.PointerType(u.ctx.Int8Type(), 0), "")
 		value := newValue(bcalloca, local.Type())
 		fr.env[local] = value
-		if fr.GenerateDebug {
+		if fr.describeVariables() {
 			paramIndex, ok := paramPos[local.Pos()]
-			if !ok {
+			if ok {
+				declaredParams[paramIndex] = true
+			} else {
 				paramIndex = -1
 			}
 			fr.debug.Declare(fr.builder, local, alloca, paramIndex)
 		}
 	}
+	if fr.describeVariables() {
+		fr.debugParams(f, declaredParams)
+	}
 
 	// If this is the "init" function, enable init-specific optimizations.
 	if !isMethod && f.Name() == "init" {
@@ -407,10 +435,16 @@ func (u *unit) defineFunction(f *ssa.Function) {
 
 	// If the function contains any defers, we must first create
 	// an unwind block. We can short-circuit the check for defers with
-	// f.Recover != nil.
-	if f.Recover != nil || hasDefer(f) {
-		fr.unwindBlock = llvm.AddBasicBlock(fr.function, "")
-		fr.frameptr = fr.builder.CreateAlloca(llvm.Int8Type(), "")
+	// f.Recover != nil. In the panic flag model, every function needs an
+	// unwind block to propagate panics.
+	if defers := u.openCodedDefers(f); defers != nil {
+		fr.unwindBlock = u.ctx.AddBasicBlock(fr.function, "")
+		fr.setupOpenDefers(defers)
+	} else if f.Recover != nil || hasDefer(f) {
+		fr.unwindBlock = u.ctx.AddBasicBlock(fr.function, "")
+		fr.frameptr = fr.builder.CreateAlloca(u.ctx.Int8Type(), "")
+	} else if u.wasm {
+		fr.unwindBlock = u.ctx.AddBasicBlock(fr.function, "")
 	}
 
 	term := fr.builder.CreateBr(fr.blocks[0])
@@ -422,7 +456,11 @@ func (u *unit) defineFunction(f *ssa.Function) {
 
 	fr.fixupPhis()
 
-	if !fr.unwindBlock.IsNil() {
+	switch {
+	case fr.unwindBlock.IsNil():
+	case u.wasm:
+		fr.setupPanicFlagUnwindBlock(f.Recover, f.Signature.Results())
+	default:
 		fr.setupUnwindBlock(f.Recover, f.Signature.Results())
 	}
 
@@ -445,26 +483,33 @@ type frame struct {
 	function               llvm.Value
 	builder, allocaBuilder llvm.Builder
 	retInf                 retInfo
+	results                *types.Tuple
 	blocks                 []llvm.BasicBlock
 	lastBlocks             []llvm.BasicBlock
 	runtimeErrorBlocks     [gccgoRuntimeErrorCount]llvm.BasicBlock
 	unwindBlock            llvm.BasicBlock
 	frameptr               llvm.Value
+	propagatebb            llvm.BasicBlock
 	env                    map[ssa.Value]*govalue
+	stackValues            map[ssa.Value]bool
 	ptr                    map[ssa.Value]llvm.Value
 	tuples                 map[ssa.Value][]*govalue
 	phis                   []pendingPhi
 	canRecover             llvm.Value
+	debugVars              map[ssa.Value]*types.Var
+	pos                    token.Pos
 	isInit                 bool
+	openDefers             *openDefers
 }
 
 func newFrame(u *unit, fn llvm.Value) *frame {
 	return &frame{
 		unit:          u,
 		function:      fn,
-		builder:       llvm.GlobalContext().NewBuilder(),
-		allocaBuilder: llvm.GlobalContext().NewBuilder(),
+		builder:       u.ctx.NewBuilder(),
+		allocaBuilder: u.ctx.NewBuilder(),
 		env:           make(map[ssa.Value]*govalue),
+		stackValues:   make(map[ssa.Value]bool),
 		ptr:           make(map[ssa.Value]llvm.Value),
 		tuples:        make(map[ssa.Value][]*govalue),
 	}
@@ -490,7 +535,7 @@ func (fr *frame) bridgeRecoverFunc(llfn llvm.Value, fti functionTypeInfo) *frame
 	llfn.AddFunctionAttr(llvm.NoInlineAttribute)
 
 	// Call __go_can_recover, passing in the function's return address.
-	entry := llvm.AddBasicBlock(llfn, "entry")
+	entry := fr.ctx.AddBasicBlock(llfn, "entry")
 	fr.builder.SetInsertPointAtEnd(entry)
 	canRecover := fr.runtime.canRecover.call(fr, fr.returnAddress(0))[0]
 	returnType := fti.functionType.ReturnType()
@@ -509,6 +554,20 @@ func (fr *frame) bridgeRecoverFunc(llfn llvm.Value, fti functionTypeInfo) *frame
 	}
 	args = append(args, canRecover)
 	result := fr.builder.CreateCall(llfnRecover, args, "")
+	if fr.wasm {
+		// Propagate a panic with zero values, as propagateBlock
+		// does.
+		propagatebb := fr.ctx.AddBasicBlock(llfn, "")
+		fr.checkPanicking(fr.ctx.AddBasicBlock(llfn, ""), propagatebb)
+		contbb := fr.builder.GetInsertBlock()
+		fr.builder.SetInsertPointAtEnd(propagatebb)
+		if returnType.TypeKind() == llvm.VoidTypeKind {
+			fr.builder.CreateRetVoid()
+		} else {
+			fr.builder.CreateRet(llvm.ConstNull(returnType))
+		}
+		fr.builder.SetInsertPointAtEnd(contbb)
+	}
 	if returnType.TypeKind() == llvm.VoidTypeKind {
 		fr.builder.CreateRetVoid()
 	} else {
@@ -517,23 +576,27 @@ func (fr *frame) bridgeRecoverFunc(llfn llvm.Value, fti functionTypeInfo) *frame
 
 	// The $recover function must condition calls to __go_recover on
 	// the result of __go_can_recover passed in as an argument.
+	pos := fr.pos
 	fr = newFrame(fr.unit, llfnRecover)
+	fr.pos = pos
 	fr.retInf = ftiRecover.retInf
 	fr.canRecover = fr.function.Param(len(argTypes) - 1)
 	return fr
 }
 
 func (fr *frame) registerGcRoots() {
-	if len(fr.gcRoots) != 0 {
+	// A freestanding runtime without a garbage collector need not
+	// know about the roots.
+	if len(fr.gcRoots) != 0 && !fr.runtime.registerGcRoots.missing {
 		rootty := fr.gcRoots[0].Type()
 		roots := append(fr.gcRoots, llvm.ConstNull(rootty))
 		rootsarr := llvm.ConstArray(rootty, roots)
-		rootsstruct := llvm.ConstStruct([]llvm.Value{llvm.ConstNull(llvm.PointerType(llvm.Int8Type(), 0)), rootsarr}, false)
+		rootsstruct := fr.ctx.ConstStruct([]llvm.Value{llvm.ConstNull(llvm.PointerType(fr.ctx.Int8Type(), 0)), rootsarr}, false)
 
 		rootsglobal := llvm.AddGlobal(fr.module.Module, rootsstruct.Type(), "")
 		rootsglobal.SetInitializer(rootsstruct)
 		rootsglobal.SetLinkage(llvm.InternalLinkage)
-		fr.runtime.registerGcRoots.callOnly(fr, llvm.ConstBitCast(rootsglobal, llvm.PointerType(llvm.Int8Type(), 0)))
+		fr.runtime.registerGcRoots.callOnly(fr, llvm.ConstBitCast(rootsglobal, llvm.PointerType(fr.ctx.Int8Type(), 0)))
 	}
 }
 
@@ -555,44 +618,53 @@ func (fr *frame) createLandingPad(cleanup bool) llvm.Value {
 	if cleanup {
 		lp.SetCleanup(true)
 	} else {
-		lp.AddClause(llvm.ConstNull(llvm.PointerType(llvm.Int8Type(), 0)))
+		lp.AddClause(llvm.ConstNull(llvm.PointerType(fr.ctx.Int8Type(), 0)))
 	}
 	return lp
 }
 
 // Runs defers. If a defer panics, check for recovers in later defers.
 func (fr *frame) runDefers() {
-	loopbb := llvm.AddBasicBlock(fr.function, "")
+	loopbb := fr.ctx.AddBasicBlock(fr.function, "")
 	fr.builder.CreateBr(loopbb)
 
-	retrylpad := llvm.AddBasicBlock(fr.function, "")
+	retrylpad := fr.ctx.AddBasicBlock(fr.function, "")
 	fr.builder.SetInsertPointAtEnd(retrylpad)
-	fr.createLandingPad(false)
+	if !fr.wasm {
+		fr.createLandingPad(false)
+	}
 	fr.runtime.checkDefer.callOnly(fr, fr.frameptr)
-	fr.builder.CreateBr(loopbb)
+	if fr.wasm {
+		fr.builder.CreateCondBr(fr.panicking(fr.builder), fr.propagateBlock(), loopbb)
+	} else {
+		fr.builder.CreateBr(loopbb)
+	}
 
 	fr.builder.SetInsertPointAtEnd(loopbb)
 	fr.runtime.undefer.invoke(fr, retrylpad, fr.frameptr)
 }
 
-func (fr *frame) setupUnwindBlock(rec *ssa.BasicBlock, results *types.Tuple) {
-	recoverbb := llvm.AddBasicBlock(fr.function, "")
+// createRecoverBlock creates the block to which control passes after the
+// function's deferred calls have recovered from a panic.
+func (fr *frame) createRecoverBlock(rec *ssa.BasicBlock, results *types.Tuple) llvm.BasicBlock {
+	recoverbb := fr.ctx.AddBasicBlock(fr.function, "")
 	if rec != nil {
 		fr.translateBlock(rec, recoverbb)
 	} else if results.Len() == 0 || results.At(0).Anonymous() {
 		// TODO(pcc): Remove this code after https://codereview.appspot.com/87210044/ lands
 		fr.builder.SetInsertPointAtEnd(recoverbb)
-		values := make([]llvm.Value, results.Len())
-		for i := range values {
-			values[i] = llvm.ConstNull(fr.llvmtypes.ToLLVM(results.At(i).Type()))
-		}
-		fr.retInf.encode(llvm.GlobalContext(), fr.allocaBuilder, fr.builder, values)
+		fr.returnZeroValues(results)
 	} else {
 		fr.builder.SetInsertPointAtEnd(recoverbb)
 		fr.builder.CreateUnreachable()
 	}
+	return recoverbb
+}
+
+func (fr *frame) setupUnwindBlock(rec *ssa.BasicBlock, results *types.Tuple) {
+	recoverbb := fr.createRecoverBlock(rec, results)
 
-	checkunwindbb := llvm.AddBasicBlock(fr.function, "")
+	checkunwindbb := fr.ctx.AddBasicBlock(fr.function, "")
 	fr.builder.SetInsertPointAtEnd(checkunwindbb)
 	exc := fr.createLandingPad(true)
 	fr.runDefers()
@@ -600,7 +672,7 @@ func (fr *frame) setupUnwindBlock(rec *ssa.BasicBlock, results *types.Tuple) {
 	frame := fr.builder.CreateLoad(fr.frameptr, "")
 	shouldresume := fr.builder.CreateIsNull(frame, "")
 
-	resumebb := llvm.AddBasicBlock(fr.function, "")
+	resumebb := fr.ctx.AddBasicBlock(fr.function, "")
 	fr.builder.CreateCondBr(shouldresume, resumebb, recoverbb)
 
 	fr.builder.SetInsertPointAtEnd(resumebb)
@@ -615,7 +687,22 @@ func (fr *frame) setupUnwindBlock(rec *ssa.BasicBlock, results *types.Tuple) {
 
 func (fr *frame) translateBlock(b *ssa.BasicBlock, llb llvm.BasicBlock) {
 	fr.builder.SetInsertPointAtEnd(llb)
+	// Phis precede the other instructions of the block, and must
+	// precede any calls describing them to the debugger.
+	nphis := 0
 	for _, instr := range b.Instrs {
+		if _, ok := instr.(*ssa.Phi); !ok {
+			break
+		}
+		nphis++
+	}
+	for _, instr := range b.Instrs[:nphis] {
+		fr.instruction(instr)
+	}
+	if fr.describeVariables() {
+		fr.debugPhis(b.Instrs[:nphis])
+	}
+	for _, instr := range b.Instrs[nphis:] {
 		fr.instruction(instr)
 	}
 	fr.lastBlocks[b.Index] = fr.builder.GetInsertBlock()
@@ -643,9 +730,13 @@ func (fr *frame) value(v ssa.Value) (result *govalue) {
 		}
 		// Create an external global. Globals for this package are defined
 		// on entry to translatePackage, and have initialisers.
+		// The panic flag may already have been declared.
 		llelemtyp := fr.llvmtypes.ToLLVM(deref(v.Type()))
-		vname := fr.types.mc.mangleGlobalName(v)
-		llglobal := llvm.AddGlobal(fr.module.Module, llelemtyp, vname)
+		vname := fr.types.mc.MangleGlobalName(v)
+		llglobal := fr.module.Module.NamedGlobal(vname)
+		if llglobal.IsNil() {
+			llglobal = llvm.AddGlobal(fr.module.Module, llelemtyp, vname)
+		}
 		llglobal = llvm.ConstBitCast(llglobal, fr.llvmtypes.ToLLVM(v.Type()))
 		fr.globals[v] = llglobal
 		return newValue(llglobal, v.Type())
@@ -690,7 +781,7 @@ func (fr *frame) nilCheck(v ssa.Value, llptr llvm.Value) {
 func (fr *frame) canAvoidElementLoad(refs []ssa.Instruction) bool {
 	for _, ref := range refs {
 		switch ref.(type) {
-		case *ssa.Field, *ssa.Index:
+		case *ssa.Field, *ssa.Index, *ssa.DebugRef:
 			// ok
 		default:
 			return false
@@ -718,7 +809,7 @@ func (fr *frame) canAvoidLoad(instr *ssa.UnOp, op llvm.Value) bool {
 		switch ref.(type) {
 		case *ssa.MakeInterface:
 			esc = true
-		case *ssa.Field, *ssa.Index:
+		case *ssa.Field, *ssa.Index, *ssa.DebugRef:
 			// ok
 		default:
 			return false
@@ -757,8 +848,15 @@ func (fr *frame) shouldStaticallyAllocate(alloc *ssa.Alloc) bool {
 	// We treat the number of referrers to the alloc instruction as a rough
 	// proxy for the number of elements initialized. If the data structure
 	// is densely initialized (> 1/4 elements initialized), enable the
-	// optimization.
-	return int64(len(*alloc.Referrers()))*4 > numElems
+	// optimization. DebugRefs are not counted, so that generating debug
+	// information does not affect the decision.
+	var numRefs int64
+	for _, ref := range *alloc.Referrers() {
+		if _, ok := ref.(*ssa.DebugRef); !ok {
+			numRefs++
+		}
+	}
+	return numRefs*4 > numElems
 }
 
 // If val is a constant and addr refers to a global variable which is defined in
@@ -804,6 +902,9 @@ func (fr *frame) maybeStoreInInitializer(val, addr llvm.Value) bool {
 
 func (fr *frame) instruction(instr ssa.Instruction) {
 	fr.logf("[%T] %v @ %s\n", instr, instr, fr.pkg.Prog.Fset.Position(instr.Pos()))
+	if pos := instr.Pos(); pos.IsValid() {
+		fr.pos = pos
+	}
 	if fr.GenerateDebug {
 		fr.debug.SetLocation(fr.builder, instr.Pos())
 	}
@@ -824,12 +925,12 @@ func (fr *frame) instruction(instr ssa.Instruction) {
 			global := llvm.AddGlobal(fr.module.Module, llvmtyp, "")
 			global.SetLinkage(llvm.InternalLinkage)
 			fr.addGlobal(global, typ)
-			ptr := llvm.ConstBitCast(global, llvm.PointerType(llvm.Int8Type(), 0))
+			ptr := llvm.ConstBitCast(global, llvm.PointerType(fr.ctx.Int8Type(), 0))
 			fr.env[instr] = newValue(ptr, instr.Type())
 		} else {
 			value = fr.createTypeMalloc(typ)
 			value.SetName(instr.Comment)
-			value = fr.builder.CreateBitCast(value, llvm.PointerType(llvm.Int8Type(), 0), "")
+			value = fr.builder.CreateBitCast(value, llvm.PointerType(fr.ctx.Int8Type(), 0), "")
 			fr.env[instr] = newValue(value, instr.Type())
 		}
 
@@ -867,7 +968,16 @@ func (fr *frame) instruction(instr ssa.Instruction) {
 		v := fr.value(instr.X)
 		fr.env[instr] = fr.convert(v, instr.Type())
 
+	case *ssa.DebugRef:
+		if fr.describeVariables() {
+			fr.debugRef(instr)
+		}
+
 	case *ssa.Defer:
+		if fr.openDefers != nil {
+			fr.openCodeDefer(instr)
+			break
+		}
 		fn, arg := fr.createThunk(instr)
 		fr.runtime.Defer.call(fr, fr.frameptr, fn, arg)
 
@@ -904,7 +1014,7 @@ func (fr *frame) instruction(instr ssa.Instruction) {
 		ptrtyp := llvm.PointerType(fr.llvmtypes.ToLLVM(xtyp), 0)
 		ptr = fr.builder.CreateBitCast(ptr, ptrtyp, "")
 		fieldptr := fr.builder.CreateStructGEP(ptr, instr.Field, instr.Name())
-		fieldptr = fr.builder.CreateBitCast(fieldptr, llvm.PointerType(llvm.Int8Type(), 0), "")
+		fieldptr = fr.builder.CreateBitCast(fieldptr, llvm.PointerType(fr.ctx.Int8Type(), 0), "")
 		fieldptrtyp := instr.Type()
 		fr.env[instr] = newValue(fieldptr, fieldptrtyp)
 
@@ -917,7 +1027,7 @@ func (fr *frame) instruction(instr ssa.Instruction) {
 		block := instr.Block()
 		trueBlock := fr.block(block.Succs[0])
 		falseBlock := fr.block(block.Succs[1])
-		cond = fr.builder.CreateTrunc(cond, llvm.Int1Type(), "")
+		cond = fr.builder.CreateTrunc(cond, fr.ctx.Int1Type(), "")
 		fr.builder.CreateCondBr(cond, trueBlock, falseBlock)
 
 	case *ssa.Index:
@@ -941,14 +1051,9 @@ func (fr *frame) instruction(instr ssa.Instruction) {
 		index = fr.createZExtOrTrunc(index, fr.types.inttype, "")
 
 		// Bounds checking: 0 <= index < len
-		zero := llvm.ConstNull(fr.types.inttype)
-		i0 := fr.builder.CreateICmp(llvm.IntSLT, index, zero, "")
-		li := fr.builder.CreateICmp(llvm.IntSLE, arraylen, index, "")
-
-		cond := fr.builder.CreateOr(i0, li, "")
-
-		fr.condBrRuntimeError(cond, gccgoRuntimeErrorARRAY_INDEX_OUT_OF_BOUNDS)
+		fr.boundsCheck(instr, index, arraylen, gccgoRuntimeErrorARRAY_INDEX_OUT_OF_BOUNDS)
 
+		zero := llvm.ConstNull(fr.types.inttype)
 		addr := fr.builder.CreateGEP(arrayptr, []llvm.Value{zero, index}, "")
 		if fr.canAvoidElementLoad(*instr.Referrers()) {
 			fr.ptr[instr] = addr
@@ -982,18 +1087,12 @@ func (fr *frame) instruction(instr ssa.Instruction) {
 		index = fr.createZExtOrTrunc(index, fr.types.inttype, "")
 
 		// Bounds checking: 0 <= index < len
-		zero := llvm.ConstNull(fr.types.inttype)
-		i0 := fr.builder.CreateICmp(llvm.IntSLT, index, zero, "")
-		li := fr.builder.CreateICmp(llvm.IntSLE, arraylen, index, "")
-
-		cond := fr.builder.CreateOr(i0, li, "")
-
-		fr.condBrRuntimeError(cond, errcode)
+		fr.boundsCheck(instr, index, arraylen, errcode)
 
 		ptrtyp := llvm.PointerType(fr.llvmtypes.ToLLVM(elemtyp), 0)
 		arrayptr = fr.builder.CreateBitCast(arrayptr, ptrtyp, "")
 		addr := fr.builder.CreateGEP(arrayptr, []llvm.Value{index}, "")
-		addr = fr.builder.CreateBitCast(addr, llvm.PointerType(llvm.Int8Type(), 0), "")
+		addr = fr.builder.CreateBitCast(addr, llvm.PointerType(fr.ctx.Int8Type(), 0), "")
 		fr.env[instr] = newValue(addr, types.NewPointer(elemtyp))
 
 	case *ssa.Jump:
@@ -1004,7 +1103,7 @@ func (fr *frame) instruction(instr ssa.Instruction) {
 		x := fr.value(instr.X)
 		index := fr.value(instr.Index)
 		if isString(x.Type().Underlying()) {
-			fr.env[instr] = fr.stringIndex(x, index)
+			fr.env[instr] = fr.stringIndex(instr, x, index)
 		} else {
 			v, ok := fr.mapLookup(x, index)
 			if instr.CommaOk {
@@ -1019,13 +1118,13 @@ func (fr *frame) instruction(instr ssa.Instruction) {
 
 	case *ssa.MakeClosure:
 		llfn := fr.resolveFunctionGlobal(instr.Fn.(*ssa.Function))
-		llfn = llvm.ConstBitCast(llfn, llvm.PointerType(llvm.Int8Type(), 0))
+		llfn = llvm.ConstBitCast(llfn, llvm.PointerType(fr.ctx.Int8Type(), 0))
 		fn := newValue(llfn, instr.Fn.(*ssa.Function).Signature)
 		bindings := make([]*govalue, len(instr.Bindings))
 		for i, binding := range instr.Bindings {
 			bindings[i] = fr.value(binding)
 		}
-		fr.env[instr] = fr.makeClosure(fn, bindings)
+		fr.env[instr] = fr.makeClosure(fn, bindings, fr.stackValues[instr])
 
 	case *ssa.MakeInterface:
 		// fr.ptr[instr.X] will be set if a pointer load was elided by canAvoidLoad
@@ -1040,6 +1139,13 @@ func (fr *frame) instruction(instr ssa.Instruction) {
 		fr.env[instr] = fr.makeMap(instr.Type(), fr.value(instr.Reserve))
 
 	case *ssa.MakeSlice:
+		if fr.stackValues[instr] {
+			// Escape analysis only lowers slices of constant size.
+			length, _ := exact.Int64Val(instr.Len.(*ssa.Const).Value)
+			capacity, _ := exact.Int64Val(instr.Cap.(*ssa.Const).Value)
+			fr.env[instr] = fr.makeStackSlice(instr.Type(), length, capacity)
+			break
+		}
 		length := fr.value(instr.Len)
 		capacity := fr.value(instr.Cap)
 		fr.env[instr] = fr.makeSlice(instr.Type(), length, capacity)
@@ -1084,9 +1190,14 @@ func (fr *frame) instruction(instr ssa.Instruction) {
 		for i, res := range instr.Results {
 			vals[i] = fr.llvmvalue(res)
 		}
-		fr.retInf.encode(llvm.GlobalContext(), fr.allocaBuilder, fr.builder, vals)
+		fr.retInf.encode(fr.ctx, fr.allocaBuilder, fr.builder, vals)
 
 	case *ssa.RunDefers:
+		// With open-coded defers, the live calls are made directly, and
+		// runDefers then removes the thunk registered on entry.
+		if fr.openDefers != nil {
+			fr.runOpenDefers()
+		}
 		fr.runDefers()
 
 	case *ssa.Select:
@@ -1238,14 +1349,18 @@ func (fr *frame) callInstruction(instr ssa.CallInstruction) []*govalue {
 	}
 
 	var fn *govalue
-	if call.IsInvoke() {
+	if target := fr.devirtualizedTarget(call); target != nil {
+		var recv *govalue
+		fn, recv = fr.devirtualizedMethod(fr.llvmvalue(call.Value), target)
+		args = append([]*govalue{recv}, args...)
+	} else if call.IsInvoke() {
 		var recv *govalue
 		fn, recv = fr.interfaceMethod(fr.llvmvalue(call.Value), call.Value.Type(), call.Method)
 		args = append([]*govalue{recv}, args...)
 	} else {
 		if ssafn, ok := call.Value.(*ssa.Function); ok {
 			llfn := fr.resolveFunctionGlobal(ssafn)
-			llfn = llvm.ConstBitCast(llfn, llvm.PointerType(llvm.Int8Type(), 0))
+			llfn = llvm.ConstBitCast(llfn, llvm.PointerType(fr.ctx.Int8Type(), 0))
 			fn = newValue(llfn, ssafn.Type())
 		} else {
 			// First-class function values are stored as *{*fnptr}, so
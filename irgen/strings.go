@@ -6,6 +6,7 @@ package irgen
 
 import (
 	"go/token"
+	"golang.org/x/tools/go/ssa"
 	"golang.org/x/tools/go/types"
 	"llvm.org/llvm/bindings/go/llvm"
 )
@@ -36,14 +37,18 @@ func (fr *frame) compareStrings(lhs, rhs *govalue, op token.Token) *govalue {
 		panic("unreachable")
 	}
 	result = fr.builder.CreateICmp(pred, result, zero, "")
-	result = fr.builder.CreateZExt(result, llvm.Int8Type(), "")
+	result = fr.builder.CreateZExt(result, fr.ctx.Int8Type(), "")
 	return newValue(result, types.Typ[types.Bool])
 }
 
-// stringIndex implements v = m[i]
-func (fr *frame) stringIndex(s, i *govalue) *govalue {
+// stringIndex implements v = m[i] for the index operation instr.
+func (fr *frame) stringIndex(instr ssa.Instruction, s, i *govalue) *govalue {
+	index := fr.createZExtOrTrunc(i.value, fr.types.inttype, "")
+	length := fr.builder.CreateExtractValue(s.value, 1, "")
+	fr.boundsCheck(instr, index, length, gccgoRuntimeErrorSTRING_INDEX_OUT_OF_BOUNDS)
+
 	ptr := fr.builder.CreateExtractValue(s.value, 0, "")
-	ptr = fr.builder.CreateGEP(ptr, []llvm.Value{i.value}, "")
+	ptr = fr.builder.CreateGEP(ptr, []llvm.Value{index}, "")
 	return newValue(fr.builder.CreateLoad(ptr, ""), types.Typ[types.Byte])
 }
 
@@ -61,7 +66,7 @@ func (fr *frame) stringIterNext(iter []*govalue) []*govalue {
 	result := fr.runtime.stringiter2.call(fr, str.value, k)
 	fr.builder.CreateStore(result[0], indexptr.value)
 	ok := fr.builder.CreateIsNotNull(result[0], "")
-	ok = fr.builder.CreateZExt(ok, llvm.Int8Type(), "")
+	ok = fr.builder.CreateZExt(ok, fr.ctx.Int8Type(), "")
 	v := result[1]
 
 	return []*govalue{newValue(ok, types.Typ[types.Bool]), newValue(k, types.Typ[types.Int]), newValue(v, types.Typ[types.Rune])}
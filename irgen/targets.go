@@ -19,20 +19,19 @@ const PNaClTriple = "armv7-none-linux-gnueabi"
 // For unknown targets, we enumerate all targets known to LLVM and use
 // the first one with a matching architecture.
 const (
-	x86TargetData = "e-p:64:64:64-i1:8:8-i8:8:8-i16:16:16-i32:32:32-i64:64:64-f32:32:32-f64:64:64-v64:64:64-v128:128:128-a0:0:64-s0:64:64-f80:128:128-n8:16:32:64-S128"
+	x86TargetData    = "e-p:64:64:64-i1:8:8-i8:8:8-i16:16:16-i32:32:32-i64:64:64-f32:32:32-f64:64:64-v64:64:64-v128:128:128-a0:0:64-s0:64:64-f80:128:128-n8:16:32:64-S128"
+	wasm32TargetData = "e-m:e-p:32:32-i64:64-n32:64-S128"
 )
 
 // llvmDataLayout returns the data layout string
 // representation for the specified LLVM triple.
 func llvmDataLayout(triple string) (string, error) {
-	// Triples are several fields separated by '-' characters.
-	// The first field is the architecture. The architecture's
-	// canonical form may include a '-' character, which would
-	// have been translated to '_' for inclusion in a triple.
-	arch := parseArch(triple[:strings.IndexRune(triple, '-')])
+	arch := tripleArch(triple)
 	switch arch {
 	case "x86-64":
 		return x86TargetData, nil
+	case "wasm32":
+		return wasm32TargetData, nil
 	}
 	for target := llvm.FirstTarget(); target.C != nil; target = target.NextTarget() {
 		if arch == target.Name() {
@@ -50,6 +49,18 @@ func llvmDataLayout(triple string) (string, error) {
 	return "", fmt.Errorf("Invalid target triple: %s", triple)
 }
 
+// tripleArch returns the LLVM name of the architecture of the given triple.
+func tripleArch(triple string) string {
+	// Triples are several fields separated by '-' characters.
+	// The first field is the architecture. The architecture's
+	// canonical form may include a '-' character, which would
+	// have been translated to '_' for inclusion in a triple.
+	if i := strings.IndexRune(triple, '-'); i >= 0 {
+		triple = triple[:i]
+	}
+	return parseArch(triple)
+}
+
 // Based on parseArch from LLVM's lib/Support/Triple.cpp.
 // This is used to match the target machine type.
 func parseArch(arch string) string {
@@ -62,10 +73,16 @@ func parseArch(arch string) string {
 		return "ppc"
 	case "powerpc64", "ppu":
 		return "ppc64"
+	case "powerpc64le", "ppc64le":
+		return "ppc64le"
 	case "mblaze":
 		return "mblaze"
 	case "arm", "xscale":
 		return "arm"
+	case "aarch64", "arm64":
+		return "aarch64"
+	case "wasm32":
+		return "wasm32"
 	case "thumb":
 		return "thumb"
 	case "spu", "cellspu":
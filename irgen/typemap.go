@@ -12,12 +12,12 @@ package irgen
 import (
 	"bytes"
 	"fmt"
+	"go/token"
 	"sort"
 	"strconv"
-	"strings"
 
+	"github.com/go-llvm/llgo/mangle"
 	"golang.org/x/tools/go/ssa"
-	"golang.org/x/tools/go/ssa/ssautil"
 	"golang.org/x/tools/go/types"
 	"golang.org/x/tools/go/types/typeutil"
 	"llvm.org/llvm/bindings/go/llvm"
@@ -36,6 +36,10 @@ type llvmTypeMap struct {
 	inttype    llvm.Type
 	stringType llvm.Type
 
+	// abi determines how the arguments and results of functions are
+	// passed.
+	abi abiLowering
+
 	types typeutil.Map
 }
 
@@ -50,7 +54,7 @@ type typeDescInfo struct {
 
 type TypeMap struct {
 	*llvmTypeMap
-	mc manglerContext
+	mc *mangle.Mangler
 
 	module         llvm.Module
 	pkgpath        string
@@ -73,16 +77,21 @@ type TypeMap struct {
 
 	zeroType  llvm.Type
 	zeroValue llvm.Value
+
+	// diagnostics, if not nil, receives the errors diagnosed while
+	// generating type descriptors, positioned using fset.
+	diagnostics *DiagnosticList
+	fset        *token.FileSet
 }
 
-func NewLLVMTypeMap(ctx llvm.Context, target llvm.TargetData) *llvmTypeMap {
+func NewLLVMTypeMap(ctx llvm.Context, target llvm.TargetData, triple string) *llvmTypeMap {
 	// spec says int is either 32-bit or 64-bit.
 	// ABI currently requires sizeof(int) == sizeof(uint) == sizeof(uintptr).
 	inttype := ctx.IntType(8 * target.PointerSize())
 
-	i8ptr := llvm.PointerType(llvm.Int8Type(), 0)
+	i8ptr := llvm.PointerType(ctx.Int8Type(), 0)
 	elements := []llvm.Type{i8ptr, inttype}
-	stringType := llvm.StructType(elements, false)
+	stringType := ctx.StructType(elements, false)
 
 	return &llvmTypeMap{
 		ctx: ctx,
@@ -93,6 +102,7 @@ func NewLLVMTypeMap(ctx llvm.Context, target llvm.TargetData) *llvmTypeMap {
 		target:     target,
 		inttype:    inttype,
 		stringType: stringType,
+		abi:        newABILowering(triple),
 	}
 }
 
@@ -101,15 +111,16 @@ func NewTypeMap(pkg *ssa.Package, llvmtm *llvmTypeMap, module llvm.Module, r *ru
 		llvmTypeMap:    llvmtm,
 		module:         module,
 		pkgpath:        pkg.Object.Path(),
+		fset:           pkg.Prog.Fset,
 		runtime:        r,
 		methodResolver: mr,
 	}
 
-	tm.mc.init(pkg.Prog, &tm.MethodSetCache)
+	tm.mc = mangle.NewMangler(pkg.Prog, &tm.MethodSetCache)
 
 	uintptrType := tm.inttype
 	voidPtrType := llvm.PointerType(tm.ctx.Int8Type(), 0)
-	boolType := llvm.Int8Type()
+	boolType := llvmtm.ctx.Int8Type()
 	stringPtrType := llvm.PointerType(tm.stringType, 0)
 
 	// Create runtime algorithm function types.
@@ -355,341 +366,7 @@ func (tm *llvmTypeMap) Alignof(t types.Type) int64 {
 ///////////////////////////////////////////////////////////////////////////////
 
 func (tm *TypeMap) ToRuntime(t types.Type) llvm.Value {
-	return llvm.ConstBitCast(tm.getTypeDescriptorPointer(t), llvm.PointerType(llvm.Int8Type(), 0))
-}
-
-type localNamedTypeInfo struct {
-	functionName string
-	scopeNum     int
-}
-
-type namedTypeInfo struct {
-	pkgname, pkgpath string
-	name             string
-	localNamedTypeInfo
-}
-
-type manglerContext struct {
-	ti  map[*types.Named]localNamedTypeInfo
-	msc *types.MethodSetCache
-}
-
-// Assembles the method set into the order that gccgo uses (unexported methods first).
-// TODO(pcc): cache this.
-func orderedMethodSet(ms *types.MethodSet) []*types.Selection {
-	oms := make([]*types.Selection, ms.Len())
-	omsi := 0
-	for i := 0; i != ms.Len(); i++ {
-		if sel := ms.At(i); !sel.Obj().Exported() {
-			oms[omsi] = sel
-			omsi++
-		}
-	}
-	for i := 0; i != ms.Len(); i++ {
-		if sel := ms.At(i); sel.Obj().Exported() {
-			oms[omsi] = sel
-			omsi++
-		}
-	}
-	return oms
-}
-
-func (ctx *manglerContext) init(prog *ssa.Program, msc *types.MethodSetCache) {
-	ctx.msc = msc
-	ctx.ti = make(map[*types.Named]localNamedTypeInfo)
-	for f, _ := range ssautil.AllFunctions(prog) {
-		scopeNum := 0
-		var addNamedTypesToMap func(*types.Scope)
-		addNamedTypesToMap = func(scope *types.Scope) {
-			hasNamedTypes := false
-			for _, n := range scope.Names() {
-				if tn, ok := scope.Lookup(n).(*types.TypeName); ok {
-					hasNamedTypes = true
-					ctx.ti[tn.Type().(*types.Named)] = localNamedTypeInfo{f.Name(), scopeNum}
-				}
-			}
-			if hasNamedTypes {
-				scopeNum++
-			}
-			for i := 0; i != scope.NumChildren(); i++ {
-				addNamedTypesToMap(scope.Child(i))
-			}
-		}
-		if fobj, ok := f.Object().(*types.Func); ok && fobj.Scope() != nil {
-			addNamedTypesToMap(fobj.Scope())
-		}
-	}
-}
-
-func (ctx *manglerContext) getNamedTypeInfo(t types.Type) (nti namedTypeInfo) {
-	switch t := t.(type) {
-	case *types.Basic:
-		switch t.Kind() {
-		case types.Byte:
-			nti.name = "uint8"
-		case types.Rune:
-			nti.name = "int32"
-		case types.UnsafePointer:
-			nti.pkgname = "unsafe"
-			nti.pkgpath = "unsafe"
-			nti.name = "Pointer"
-		default:
-			nti.name = t.Name()
-		}
-
-	case *types.Named:
-		obj := t.Obj()
-		if pkg := obj.Pkg(); pkg != nil {
-			nti.pkgname = obj.Pkg().Name()
-			nti.pkgpath = obj.Pkg().Path()
-		}
-		nti.name = obj.Name()
-		nti.localNamedTypeInfo = ctx.ti[t]
-
-	default:
-		panic("not a named type")
-	}
-
-	return
-}
-
-func (ctx *manglerContext) mangleSignature(s *types.Signature, recv *types.Var, b *bytes.Buffer) {
-	b.WriteRune('F')
-	if recv != nil {
-		b.WriteRune('m')
-		ctx.mangleType(recv.Type(), b)
-	}
-
-	if p := s.Params(); p.Len() != 0 {
-		b.WriteRune('p')
-		for i := 0; i != p.Len(); i++ {
-			ctx.mangleType(p.At(i).Type(), b)
-		}
-		if s.Variadic() {
-			b.WriteRune('V')
-		}
-		b.WriteRune('e')
-	}
-
-	if r := s.Results(); r.Len() != 0 {
-		b.WriteRune('r')
-		for i := 0; i != r.Len(); i++ {
-			ctx.mangleType(r.At(i).Type(), b)
-		}
-		b.WriteRune('e')
-	}
-
-	b.WriteRune('e')
-}
-
-func (ctx *manglerContext) manglePackagePath(pkgpath string, b *bytes.Buffer) {
-	pkgpath = strings.Replace(pkgpath, "/", "_", -1)
-	pkgpath = strings.Replace(pkgpath, ".", "_", -1)
-	b.WriteString(pkgpath)
-}
-
-func (ctx *manglerContext) mangleType(t types.Type, b *bytes.Buffer) {
-	switch t := t.(type) {
-	case *types.Basic, *types.Named:
-		var nb bytes.Buffer
-		ti := ctx.getNamedTypeInfo(t)
-		if ti.pkgpath != "" {
-			ctx.manglePackagePath(ti.pkgpath, &nb)
-			nb.WriteRune('.')
-		}
-		if ti.functionName != "" {
-			nb.WriteString(ti.functionName)
-			nb.WriteRune('$')
-			if ti.scopeNum != 0 {
-				nb.WriteString(strconv.Itoa(ti.scopeNum))
-				nb.WriteRune('$')
-			}
-		}
-		nb.WriteString(ti.name)
-
-		b.WriteRune('N')
-		b.WriteString(strconv.Itoa(nb.Len()))
-		b.WriteRune('_')
-		b.WriteString(nb.String())
-
-	case *types.Pointer:
-		b.WriteRune('p')
-		ctx.mangleType(t.Elem(), b)
-
-	case *types.Map:
-		b.WriteRune('M')
-		ctx.mangleType(t.Key(), b)
-		b.WriteString("__")
-		ctx.mangleType(t.Elem(), b)
-
-	case *types.Chan:
-		b.WriteRune('C')
-		ctx.mangleType(t.Elem(), b)
-		switch t.Dir() {
-		case types.SendOnly:
-			b.WriteRune('s')
-		case types.RecvOnly:
-			b.WriteRune('r')
-		case types.SendRecv:
-			b.WriteString("sr")
-		}
-		b.WriteRune('e')
-
-	case *types.Signature:
-		ctx.mangleSignature(t, t.Recv(), b)
-
-	case *types.Array:
-		b.WriteRune('A')
-		ctx.mangleType(t.Elem(), b)
-		b.WriteString(strconv.FormatInt(t.Len(), 10))
-		b.WriteRune('e')
-
-	case *types.Slice:
-		b.WriteRune('A')
-		ctx.mangleType(t.Elem(), b)
-		b.WriteRune('e')
-
-	case *types.Struct:
-		b.WriteRune('S')
-		for i := 0; i != t.NumFields(); i++ {
-			f := t.Field(i)
-			if f.Anonymous() {
-				b.WriteString("0_")
-			} else {
-				b.WriteString(strconv.Itoa(len(f.Name())))
-				b.WriteRune('_')
-				b.WriteString(f.Name())
-			}
-			ctx.mangleType(f.Type(), b)
-			// TODO: tags are mangled here
-		}
-		b.WriteRune('e')
-
-	case *types.Interface:
-		b.WriteRune('I')
-		methodset := ctx.msc.MethodSet(t)
-		for _, m := range orderedMethodSet(methodset) {
-			method := m.Obj()
-			var nb bytes.Buffer
-			if !method.Exported() {
-				nb.WriteRune('.')
-				nb.WriteString(method.Pkg().Path())
-				nb.WriteRune('.')
-			}
-			nb.WriteString(method.Name())
-
-			b.WriteString(strconv.Itoa(nb.Len()))
-			b.WriteRune('_')
-			b.WriteString(nb.String())
-
-			ctx.mangleSignature(method.Type().(*types.Signature), nil, b)
-		}
-		b.WriteRune('e')
-
-	default:
-		panic(fmt.Sprintf("unhandled type: %#v", t))
-	}
-}
-
-func (ctx *manglerContext) mangleTypeDescriptorName(t types.Type, b *bytes.Buffer) {
-	switch t := t.(type) {
-	case *types.Basic, *types.Named:
-		b.WriteString("__go_tdn_")
-		ti := ctx.getNamedTypeInfo(t)
-		if ti.pkgpath != "" {
-			ctx.manglePackagePath(ti.pkgpath, b)
-			b.WriteRune('.')
-		}
-		if ti.functionName != "" {
-			b.WriteString(ti.functionName)
-			b.WriteRune('.')
-			if ti.scopeNum != 0 {
-				b.WriteString(strconv.Itoa(ti.scopeNum))
-				b.WriteRune('.')
-			}
-		}
-		b.WriteString(ti.name)
-
-	default:
-		b.WriteString("__go_td_")
-		ctx.mangleType(t, b)
-	}
-}
-
-func (ctx *manglerContext) mangleMapDescriptorName(t types.Type, b *bytes.Buffer) {
-	b.WriteString("__go_map_")
-	ctx.mangleType(t, b)
-}
-
-func (ctx *manglerContext) mangleImtName(srctype types.Type, targettype *types.Interface, b *bytes.Buffer) {
-	b.WriteString("__go_imt_")
-	ctx.mangleType(targettype, b)
-	b.WriteString("__")
-	ctx.mangleType(srctype, b)
-}
-
-func (ctx *manglerContext) mangleHashFunctionName(t types.Type) string {
-	var b bytes.Buffer
-	b.WriteString("__go_type_hash_")
-	ctx.mangleType(t, &b)
-	return b.String()
-}
-
-func (ctx *manglerContext) mangleEqualFunctionName(t types.Type) string {
-	var b bytes.Buffer
-	b.WriteString("__go_type_equal_")
-	ctx.mangleType(t, &b)
-	return b.String()
-}
-
-func (ctx *manglerContext) mangleFunctionName(f *ssa.Function) string {
-	var b bytes.Buffer
-
-	if f.Parent() != nil {
-		// Anonymous functions are not guaranteed to
-		// have unique identifiers at the global scope.
-		b.WriteString(ctx.mangleFunctionName(f.Parent()))
-		b.WriteRune(':')
-		b.WriteString(f.String())
-		return b.String()
-	}
-
-	pkg := f.Pkg
-	var pkgobj *types.Package
-	if pkg != nil {
-		pkgobj = pkg.Object
-	} else if f.Signature.Recv() != nil {
-		pkgobj = f.Signature.Recv().Pkg()
-	} else {
-		b.WriteString(f.String())
-		return b.String()
-	}
-
-	if pkg != nil {
-		ctx.manglePackagePath(pkgobj.Path(), &b)
-		b.WriteRune('.')
-	}
-	if f.Signature.Recv() == nil && f.Name() == "init" {
-		b.WriteString(".import")
-	} else {
-		b.WriteString(f.Name())
-	}
-	if f.Signature.Recv() != nil {
-		b.WriteRune('.')
-		ctx.mangleType(f.Signature.Recv().Type(), &b)
-	}
-
-	return b.String()
-}
-
-func (ctx *manglerContext) mangleGlobalName(g *ssa.Global) string {
-	var b bytes.Buffer
-
-	ctx.manglePackagePath(g.Pkg.Object.Path(), &b)
-	b.WriteRune('.')
-	b.WriteString(g.Name())
-
-	return b.String()
+	return llvm.ConstBitCast(tm.getTypeDescriptorPointer(t), llvm.PointerType(tm.ctx.Int8Type(), 0))
 }
 
 const (
@@ -726,9 +403,9 @@ func getStringHash(s string, h uint32) uint32 {
 func (tm *TypeMap) getTypeHash(t types.Type) uint32 {
 	switch t := t.(type) {
 	case *types.Basic, *types.Named:
-		nti := tm.mc.getNamedTypeInfo(t)
-		h := getStringHash(nti.functionName+nti.name+nti.pkgpath, 0)
-		h ^= uint32(nti.scopeNum)
+		nti := tm.mc.NamedTypeInfo(t)
+		h := getStringHash(nti.FunctionName+nti.Name+nti.PkgPath, 0)
+		h ^= uint32(nti.ScopeNum)
 		return gccgoTypeClassNAMED + h
 
 	case *types.Signature:
@@ -788,7 +465,7 @@ func (tm *TypeMap) getTypeHash(t types.Type) uint32 {
 
 	case *types.Interface:
 		var h uint32
-		for _, m := range orderedMethodSet(tm.MethodSet(t)) {
+		for _, m := range mangle.OrderedMethodSet(tm.MethodSet(t)) {
 			h = getStringHash(m.Obj().Name(), h)
 			h <<= 1
 		}
@@ -802,25 +479,25 @@ func (tm *TypeMap) getTypeHash(t types.Type) uint32 {
 func (tm *TypeMap) writeType(typ types.Type, b *bytes.Buffer) {
 	switch t := typ.(type) {
 	case *types.Basic, *types.Named:
-		ti := tm.mc.getNamedTypeInfo(t)
-		if ti.pkgpath != "" {
+		ti := tm.mc.NamedTypeInfo(t)
+		if ti.PkgPath != "" {
 			b.WriteByte('\t')
-			tm.mc.manglePackagePath(ti.pkgpath, b)
+			mangle.ManglePackagePath(ti.PkgPath, b)
 			b.WriteByte('\t')
-			b.WriteString(ti.pkgname)
+			b.WriteString(ti.PkgName)
 			b.WriteByte('.')
 		}
-		if ti.functionName != "" {
+		if ti.FunctionName != "" {
 			b.WriteByte('\t')
-			b.WriteString(ti.functionName)
+			b.WriteString(ti.FunctionName)
 			b.WriteByte('$')
-			if ti.scopeNum != 0 {
-				b.WriteString(strconv.Itoa(ti.scopeNum))
+			if ti.ScopeNum != 0 {
+				b.WriteString(strconv.Itoa(ti.ScopeNum))
 				b.WriteByte('$')
 			}
 			b.WriteByte('\t')
 		}
-		b.WriteString(ti.name)
+		b.WriteString(ti.Name)
 
 	case *types.Array:
 		fmt.Fprintf(b, "[%d]", t.Len())
@@ -1168,7 +845,15 @@ func (tm *TypeMap) appendGcInsts(insts []llvm.Value, t types.Type, offset, stack
 			insts = append(insts, tm.makeGcInst(gcOpcodeIFACE), tm.makeGcInst(offset))
 		}
 	default:
-		panic(fmt.Sprintf("unhandled type: %#v", t))
+		// Only named types have a position; errors for other types
+		// are reported against the package as a whole.
+		if tm.diagnostics != nil {
+			var pos token.Position
+			if named, ok := t.(*types.Named); ok {
+				pos = tm.fset.Position(named.Obj().Pos())
+			}
+			tm.diagnostics.Errorf(pos, "cannot generate a garbage collection program for type %s", t)
+		}
 	}
 
 	return insts
@@ -1183,7 +868,7 @@ func (tm *TypeMap) emitTypeDescInitializer(t types.Type, tdi *typeDescInfo) {
 	insts = tm.appendGcInsts(insts, t, 0, 0)
 	insts = append(insts, tm.makeGcInst(gcOpcodeEND))
 
-	i8ptr := llvm.PointerType(llvm.Int8Type(), 0)
+	i8ptr := llvm.PointerType(tm.ctx.Int8Type(), 0)
 	instArray := llvm.ConstArray(i8ptr, insts)
 
 	newGc := llvm.AddGlobal(tm.module, instArray.Type(), "")
@@ -1251,9 +936,9 @@ func (tm *TypeMap) getStructAlgorithmFunctions(st *types.Struct) (hash, equal ll
 	builder := tm.ctx.NewBuilder()
 	defer builder.Dispose()
 
-	hash = llvm.AddFunction(tm.module, tm.mc.mangleHashFunctionName(st), tm.hashFnType)
+	hash = llvm.AddFunction(tm.module, tm.mc.MangleHashFunctionName(st), tm.hashFnType)
 	hash.SetLinkage(llvm.LinkOnceODRLinkage)
-	builder.SetInsertPointAtEnd(llvm.AddBasicBlock(hash, "entry"))
+	builder.SetInsertPointAtEnd(tm.ctx.AddBasicBlock(hash, "entry"))
 	sptr := builder.CreateBitCast(hash.Param(0), llsptrty, "")
 
 	hashval := llvm.ConstNull(tm.inttype)
@@ -1272,10 +957,10 @@ func (tm *TypeMap) getStructAlgorithmFunctions(st *types.Struct) (hash, equal ll
 
 	builder.CreateRet(hashval)
 
-	equal = llvm.AddFunction(tm.module, tm.mc.mangleEqualFunctionName(st), tm.equalFnType)
+	equal = llvm.AddFunction(tm.module, tm.mc.MangleEqualFunctionName(st), tm.equalFnType)
 	equal.SetLinkage(llvm.LinkOnceODRLinkage)
-	eqentrybb := llvm.AddBasicBlock(equal, "entry")
-	eqretzerobb := llvm.AddBasicBlock(equal, "retzero")
+	eqentrybb := tm.ctx.AddBasicBlock(equal, "entry")
+	eqretzerobb := tm.ctx.AddBasicBlock(equal, "retzero")
 
 	builder.SetInsertPointAtEnd(eqentrybb)
 	s1ptr := builder.CreateBitCast(equal.Param(0), llsptrty, "")
@@ -1295,7 +980,7 @@ func (tm *TypeMap) getStructAlgorithmFunctions(st *types.Struct) (hash, equal ll
 		equalcall := builder.CreateCall(fequal, []llvm.Value{f1ptr, f2ptr, fsize}, "")
 		equaleqzero := builder.CreateICmp(llvm.IntEQ, equalcall, zerobool, "")
 
-		contbb := llvm.AddBasicBlock(equal, "cont")
+		contbb := tm.ctx.AddBasicBlock(equal, "cont")
 		builder.CreateCondBr(equaleqzero, eqretzerobb, contbb)
 
 		builder.SetInsertPointAtEnd(contbb)
@@ -1330,9 +1015,9 @@ func (tm *TypeMap) getArrayAlgorithmFunctions(at *types.Array) (hash, equal llvm
 	builder := tm.ctx.NewBuilder()
 	defer builder.Dispose()
 
-	hash = llvm.AddFunction(tm.module, tm.mc.mangleHashFunctionName(at), tm.hashFnType)
+	hash = llvm.AddFunction(tm.module, tm.mc.MangleHashFunctionName(at), tm.hashFnType)
 	hash.SetLinkage(llvm.LinkOnceODRLinkage)
-	hashentrybb := llvm.AddBasicBlock(hash, "entry")
+	hashentrybb := tm.ctx.AddBasicBlock(hash, "entry")
 	builder.SetInsertPointAtEnd(hashentrybb)
 	if at.Len() == 0 {
 		builder.CreateRet(llvm.ConstNull(tm.inttype))
@@ -1340,10 +1025,10 @@ func (tm *TypeMap) getArrayAlgorithmFunctions(at *types.Array) (hash, equal llvm
 		i33 := llvm.ConstInt(tm.inttype, 33, false)
 
 		aptr := builder.CreateBitCast(hash.Param(0), llelemty, "")
-		loopbb := llvm.AddBasicBlock(hash, "loop")
+		loopbb := tm.ctx.AddBasicBlock(hash, "loop")
 		builder.CreateBr(loopbb)
 
-		exitbb := llvm.AddBasicBlock(hash, "exit")
+		exitbb := tm.ctx.AddBasicBlock(hash, "exit")
 
 		builder.SetInsertPointAtEnd(loopbb)
 		indexphi := builder.CreatePHI(tm.inttype, "")
@@ -1379,20 +1064,20 @@ func (tm *TypeMap) getArrayAlgorithmFunctions(at *types.Array) (hash, equal llvm
 	zerobool := llvm.ConstNull(tm.ctx.Int8Type())
 	onebool := llvm.ConstInt(tm.ctx.Int8Type(), 1, false)
 
-	equal = llvm.AddFunction(tm.module, tm.mc.mangleEqualFunctionName(at), tm.equalFnType)
+	equal = llvm.AddFunction(tm.module, tm.mc.MangleEqualFunctionName(at), tm.equalFnType)
 	equal.SetLinkage(llvm.LinkOnceODRLinkage)
-	eqentrybb := llvm.AddBasicBlock(equal, "entry")
+	eqentrybb := tm.ctx.AddBasicBlock(equal, "entry")
 	builder.SetInsertPointAtEnd(eqentrybb)
 	if at.Len() == 0 {
 		builder.CreateRet(onebool)
 	} else {
 		a1ptr := builder.CreateBitCast(equal.Param(0), llelemty, "")
 		a2ptr := builder.CreateBitCast(equal.Param(1), llelemty, "")
-		loopbb := llvm.AddBasicBlock(equal, "loop")
+		loopbb := tm.ctx.AddBasicBlock(equal, "loop")
 		builder.CreateBr(loopbb)
 
-		exitbb := llvm.AddBasicBlock(equal, "exit")
-		retzerobb := llvm.AddBasicBlock(equal, "retzero")
+		exitbb := tm.ctx.AddBasicBlock(equal, "exit")
+		retzerobb := tm.ctx.AddBasicBlock(equal, "retzero")
 
 		builder.SetInsertPointAtEnd(loopbb)
 		indexphi := builder.CreatePHI(tm.inttype, "")
@@ -1406,7 +1091,7 @@ func (tm *TypeMap) getArrayAlgorithmFunctions(at *types.Array) (hash, equal llvm
 		equalcall := builder.CreateCall(eequal, []llvm.Value{e1ptr, e2ptr, esize}, "")
 		equaleqzero := builder.CreateICmp(llvm.IntEQ, equalcall, zerobool, "")
 
-		contbb := llvm.AddBasicBlock(equal, "cont")
+		contbb := tm.ctx.AddBasicBlock(equal, "cont")
 		builder.CreateCondBr(equaleqzero, retzerobb, contbb)
 
 		builder.SetInsertPointAtEnd(contbb)
@@ -1478,20 +1163,20 @@ func (tm *TypeMap) getTypeDescInfo(t types.Type) *typeDescInfo {
 	}
 
 	var b bytes.Buffer
-	tm.mc.mangleTypeDescriptorName(t, &b)
+	tm.mc.MangleTypeDescriptorName(t, &b)
 
 	global := llvm.AddGlobal(tm.module, tm.getTypeDescType(t), b.String())
 	global.SetGlobalConstant(true)
 	ptr := llvm.ConstBitCast(global, llvm.PointerType(tm.commonTypeType, 0))
 
-	gc := llvm.AddGlobal(tm.module, llvm.PointerType(llvm.Int8Type(), 0), b.String()+"$gc")
+	gc := llvm.AddGlobal(tm.module, llvm.PointerType(tm.ctx.Int8Type(), 0), b.String()+"$gc")
 	gc.SetGlobalConstant(true)
 	gcPtr := llvm.ConstBitCast(gc, llvm.PointerType(tm.ctx.Int8Type(), 0))
 
 	var mapDescPtr llvm.Value
 	if m, ok := t.Underlying().(*types.Map); ok {
 		var mapb bytes.Buffer
-		tm.mc.mangleMapDescriptorName(t, &mapb)
+		tm.mc.MangleMapDescriptorName(t, &mapb)
 
 		mapDescPtr = llvm.AddGlobal(tm.module, tm.mapDescType, mapb.String())
 		mapDescPtr.SetGlobalConstant(true)
@@ -1540,11 +1225,11 @@ func (tm *TypeMap) getImtPointer(srctype types.Type, targettype *types.Interface
 	srcms := tm.MethodSet(srctype)
 	targetms := tm.MethodSet(targettype)
 
-	i8ptr := llvm.PointerType(llvm.Int8Type(), 0)
+	i8ptr := llvm.PointerType(tm.ctx.Int8Type(), 0)
 
 	elems := make([]llvm.Value, targetms.Len()+1)
 	elems[0] = tm.ToRuntime(srctype)
-	for i, targetm := range orderedMethodSet(targetms) {
+	for i, targetm := range mangle.OrderedMethodSet(targetms) {
 		srcm := srcms.Lookup(targetm.Obj().Pkg(), targetm.Obj().Name())
 
 		elems[i+1] = tm.methodResolver.ResolveMethod(srcm).value
@@ -1552,7 +1237,7 @@ func (tm *TypeMap) getImtPointer(srctype types.Type, targettype *types.Interface
 	imtinit := llvm.ConstArray(i8ptr, elems)
 
 	var b bytes.Buffer
-	tm.mc.mangleImtName(srctype, targettype, &b)
+	tm.mc.MangleImtName(srctype, targettype, &b)
 	imt := llvm.AddGlobal(tm.module, imtinit.Type(), b.String())
 	imt.SetGlobalConstant(true)
 	imt.SetInitializer(imtinit)
@@ -1801,7 +1486,7 @@ func (tm *TypeMap) makeFuncType(t types.Type, f *types.Signature) llvm.Value {
 	if f.Variadic() {
 		variadic = 1
 	}
-	vals[1] = llvm.ConstInt(llvm.Int8Type(), uint64(variadic), false)
+	vals[1] = llvm.ConstInt(tm.ctx.Int8Type(), uint64(variadic), false)
 	// in
 	vals[2] = tm.rtypeSlice(f.Params())
 	// out
@@ -1816,7 +1501,7 @@ func (tm *TypeMap) makeInterfaceType(t types.Type, i *types.Interface) llvm.Valu
 
 	methodset := tm.MethodSet(i)
 	imethods := make([]llvm.Value, methodset.Len())
-	for index, ms := range orderedMethodSet(methodset) {
+	for index, ms := range mangle.OrderedMethodSet(methodset) {
 		method := ms.Obj()
 		var imvals [3]llvm.Value
 		imvals[0] = tm.globalStringPtr(method.Name())
@@ -1907,14 +1592,14 @@ func (tm *TypeMap) makeUncommonTypePtr(t types.Type) llvm.Value {
 	vals[1] = nullStringPtr
 
 	if isbasic || isnamed {
-		nti := tm.mc.getNamedTypeInfo(t)
-		vals[0] = tm.globalStringPtr(nti.name)
-		if nti.pkgpath != "" {
-			path := nti.pkgpath
-			if nti.functionName != "" {
-				path += "." + nti.functionName
-				if nti.scopeNum != 0 {
-					path += "$" + strconv.Itoa(nti.scopeNum)
+		nti := tm.mc.NamedTypeInfo(t)
+		vals[0] = tm.globalStringPtr(nti.Name)
+		if nti.PkgPath != "" {
+			path := nti.PkgPath
+			if nti.FunctionName != "" {
+				path += "." + nti.FunctionName
+				if nti.ScopeNum != 0 {
+					path += "$" + strconv.Itoa(nti.ScopeNum)
 				}
 			}
 			vals[1] = tm.globalStringPtr(path)
@@ -1924,7 +1609,7 @@ func (tm *TypeMap) makeUncommonTypePtr(t types.Type) llvm.Value {
 	// Store methods. All methods must be stored, not only exported ones;
 	// this is to allow satisfying of interfaces with non-exported methods.
 	methods := make([]llvm.Value, mset.Len())
-	omset := orderedMethodSet(&mset)
+	omset := mangle.OrderedMethodSet(&mset)
 	for i := range methods {
 		var mvals [5]llvm.Value
 
@@ -1976,14 +1661,14 @@ func (tm *TypeMap) makeUncommonTypePtr(t types.Type) llvm.Value {
 
 // globalStringPtr returns a *string with the specified value.
 func (tm *TypeMap) globalStringPtr(value string) llvm.Value {
-	strval := llvm.ConstString(value, false)
+	strval := tm.ctx.ConstString(value, false)
 	strglobal := llvm.AddGlobal(tm.module, strval.Type(), "")
 	strglobal.SetGlobalConstant(true)
 	strglobal.SetLinkage(llvm.InternalLinkage)
 	strglobal.SetInitializer(strval)
-	strglobal = llvm.ConstBitCast(strglobal, llvm.PointerType(llvm.Int8Type(), 0))
+	strglobal = llvm.ConstBitCast(strglobal, llvm.PointerType(tm.ctx.Int8Type(), 0))
 	strlen := llvm.ConstInt(tm.inttype, uint64(len(value)), false)
-	str := llvm.ConstStruct([]llvm.Value{strglobal, strlen}, false)
+	str := tm.ctx.ConstStruct([]llvm.Value{strglobal, strlen}, false)
 	g := llvm.AddGlobal(tm.module, str.Type(), "")
 	g.SetGlobalConstant(true)
 	g.SetLinkage(llvm.InternalLinkage)
@@ -47,10 +47,10 @@ func (fr *frame) newValueFromConst(v exact.Value, typ types.Type) *govalue {
 		llvmtyp := fr.types.ToLLVM(typ)
 		strval := exact.StringVal(v)
 		strlen := len(strval)
-		i8ptr := llvm.PointerType(llvm.Int8Type(), 0)
+		i8ptr := llvm.PointerType(fr.ctx.Int8Type(), 0)
 		var ptr llvm.Value
 		if strlen > 0 {
-			init := llvm.ConstString(strval, false)
+			init := fr.ctx.ConstString(strval, false)
 			ptr = llvm.AddGlobal(fr.module.Module, init.Type(), "")
 			ptr.SetInitializer(init)
 			ptr.SetLinkage(llvm.InternalLinkage)
@@ -83,7 +83,7 @@ func (fr *frame) newValueFromConst(v exact.Value, typ types.Type) *govalue {
 		if isUntyped(typ) {
 			typ = types.Typ[types.Bool]
 		}
-		return newValue(boolLLVMValue(exact.BoolVal(v)), typ)
+		return newValue(fr.boolLLVMValue(exact.BoolVal(v)), typ)
 
 	case isFloat(typ):
 		if isUntyped(typ) {
@@ -144,7 +144,7 @@ func (fr *frame) binaryOp(lhs *govalue, op token.Token, rhs *govalue) *govalue {
 		// TODO(axw) use runtime equality algorithm (will be suitably inlined).
 		// For now, we use compare all fields unconditionally and bitwise AND
 		// to avoid branching (i.e. so we don't create additional blocks).
-		value := newValue(boolLLVMValue(true), types.Typ[types.Bool])
+		value := newValue(fr.boolLLVMValue(true), types.Typ[types.Bool])
 		for i := 0; i < typ.NumFields(); i++ {
 			t := typ.Field(i).Type()
 			lhs := newValue(b.CreateExtractValue(lhs.value, i, ""), t)
@@ -155,7 +155,7 @@ func (fr *frame) binaryOp(lhs *govalue, op token.Token, rhs *govalue) *govalue {
 
 	case *types.Array:
 		// TODO(pcc): as above.
-		value := newValue(boolLLVMValue(true), types.Typ[types.Bool])
+		value := newValue(fr.boolLLVMValue(true), types.Typ[types.Bool])
 		t := typ.Elem()
 		for i := int64(0); i < typ.Len(); i++ {
 			lhs := newValue(b.CreateExtractValue(lhs.value, int(i), ""), t)
@@ -169,13 +169,13 @@ func (fr *frame) binaryOp(lhs *govalue, op token.Token, rhs *govalue) *govalue {
 		lhsptr := b.CreateExtractValue(lhs.value, 0, "")
 		rhsptr := b.CreateExtractValue(rhs.value, 0, "")
 		isnil := b.CreateICmp(llvm.IntEQ, lhsptr, rhsptr, "")
-		isnil = b.CreateZExt(isnil, llvm.Int8Type(), "")
+		isnil = b.CreateZExt(isnil, fr.ctx.Int8Type(), "")
 		return newValue(isnil, types.Typ[types.Bool])
 
 	case *types.Signature:
 		// func == nil or nil == func
 		isnil := b.CreateICmp(llvm.IntEQ, lhs.value, rhs.value, "")
-		isnil = b.CreateZExt(isnil, llvm.Int8Type(), "")
+		isnil = b.CreateZExt(isnil, fr.ctx.Int8Type(), "")
 		return newValue(isnil, types.Typ[types.Bool])
 
 	case *types.Interface:
@@ -246,7 +246,7 @@ func (fr *frame) binaryOp(lhs *govalue, op token.Token, rhs *govalue) *govalue {
 			realeq := b.CreateFCmp(llvm.FloatOEQ, a_, c_, "")
 			imageq := b.CreateFCmp(llvm.FloatOEQ, b_, d_, "")
 			result = b.CreateAnd(realeq, imageq, "")
-			result = b.CreateZExt(result, llvm.Int8Type(), "")
+			result = b.CreateZExt(result, fr.ctx.Int8Type(), "")
 			return newValue(result, types.Typ[types.Bool])
 		default:
 			panic(fmt.Errorf("unhandled operator: %v", op))
@@ -307,7 +307,7 @@ func (fr *frame) binaryOp(lhs *govalue, op token.Token, rhs *govalue) *govalue {
 		} else {
 			result = b.CreateICmp(llvm.IntEQ, lhs.value, rhs.value, "")
 		}
-		result = b.CreateZExt(result, llvm.Int8Type(), "")
+		result = b.CreateZExt(result, fr.ctx.Int8Type(), "")
 		return newValue(result, types.Typ[types.Bool])
 	case token.LSS:
 		switch {
@@ -318,7 +318,7 @@ func (fr *frame) binaryOp(lhs *govalue, op token.Token, rhs *govalue) *govalue {
 		default:
 			result = b.CreateICmp(llvm.IntULT, lhs.value, rhs.value, "")
 		}
-		result = b.CreateZExt(result, llvm.Int8Type(), "")
+		result = b.CreateZExt(result, fr.ctx.Int8Type(), "")
 		return newValue(result, types.Typ[types.Bool])
 	case token.LEQ:
 		switch {
@@ -329,7 +329,7 @@ func (fr *frame) binaryOp(lhs *govalue, op token.Token, rhs *govalue) *govalue {
 		default:
 			result = b.CreateICmp(llvm.IntULE, lhs.value, rhs.value, "")
 		}
-		result = b.CreateZExt(result, llvm.Int8Type(), "")
+		result = b.CreateZExt(result, fr.ctx.Int8Type(), "")
 		return newValue(result, types.Typ[types.Bool])
 	case token.GTR:
 		switch {
@@ -340,7 +340,7 @@ func (fr *frame) binaryOp(lhs *govalue, op token.Token, rhs *govalue) *govalue {
 		default:
 			result = b.CreateICmp(llvm.IntUGT, lhs.value, rhs.value, "")
 		}
-		result = b.CreateZExt(result, llvm.Int8Type(), "")
+		result = b.CreateZExt(result, fr.ctx.Int8Type(), "")
 		return newValue(result, types.Typ[types.Bool])
 	case token.GEQ:
 		switch {
@@ -351,7 +351,7 @@ func (fr *frame) binaryOp(lhs *govalue, op token.Token, rhs *govalue) *govalue {
 		default:
 			result = b.CreateICmp(llvm.IntUGE, lhs.value, rhs.value, "")
 		}
-		result = b.CreateZExt(result, llvm.Int8Type(), "")
+		result = b.CreateZExt(result, fr.ctx.Int8Type(), "")
 		return newValue(result, types.Typ[types.Bool])
 	case token.AND: // a & b
 		result = b.CreateAnd(lhs.value, rhs.value, "")
@@ -420,7 +420,7 @@ func (fr *frame) unaryOp(v *govalue, op token.Token) *govalue {
 	case token.ADD:
 		return v // No-op
 	case token.NOT:
-		value := fr.builder.CreateXor(v.value, boolLLVMValue(true), "")
+		value := fr.builder.CreateXor(v.value, fr.boolLLVMValue(true), "")
 		return newValue(value, v.typ)
 	case token.XOR:
 		lhs := v.value
@@ -603,10 +603,10 @@ func (fr *frame) convert(v *govalue, dsttyp types.Type) *govalue {
 		var fptype llvm.Type
 		if srctyp == types.Typ[types.Complex64] {
 			fpcast = (llvm.Builder).CreateFPExt
-			fptype = llvm.DoubleType()
+			fptype = fr.ctx.DoubleType()
 		} else {
 			fpcast = (llvm.Builder).CreateFPTrunc
-			fptype = llvm.FloatType()
+			fptype = fr.ctx.FloatType()
 		}
 		if fpcast != nil {
 			realv := b.CreateExtractValue(lv, 0, "")
@@ -640,9 +640,9 @@ func (fr *frame) extractImagValue(v *govalue) *govalue {
 	return newValue(component, types.Typ[types.Float64])
 }
 
-func boolLLVMValue(v bool) (lv llvm.Value) {
+func (c *compiler) boolLLVMValue(v bool) (lv llvm.Value) {
 	if v {
-		return llvm.ConstInt(llvm.Int8Type(), 1, false)
+		return llvm.ConstInt(c.ctx.Int8Type(), 1, false)
 	}
-	return llvm.ConstNull(llvm.Int8Type())
+	return llvm.ConstNull(c.ctx.Int8Type())
 }
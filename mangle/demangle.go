@@ -0,0 +1,435 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package mangle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The grammar of mangled types, as written by MangleType, is:
+//
+//	type      = named | pointer | map | chan | func | array | slice |
+//	            struct | interface .
+//	named     = "N" length "_" name .
+//	pointer   = "p" type .
+//	map       = "M" type "__" type .
+//	chan      = "C" type ( "s" | "r" | "sr" ) "e" .
+//	func      = "F" [ "m" type ] [ "p" { type } [ "V" ] "e" ] [ "r" { type } "e" ] "e" .
+//	array     = "A" type length "e" .
+//	slice     = "A" type "e" .
+//	struct    = "S" { ( length "_" name | "0_" ) type } "e" .
+//	interface = "I" { length "_" name func } "e" .
+//
+// where a length is a decimal number giving the length in bytes of the name
+// that follows, or of the array.
+
+// errDemangle is used by demangler to abandon a symbol that is not in the
+// grammar.
+type errDemangle struct{}
+
+type demangler struct {
+	s string
+	i int
+}
+
+func (d *demangler) fail() {
+	panic(errDemangle{})
+}
+
+// next consumes and returns the next byte of the symbol.
+func (d *demangler) next() byte {
+	if d.i == len(d.s) {
+		d.fail()
+	}
+	c := d.s[d.i]
+	d.i++
+	return c
+}
+
+// accept consumes prefix if the remainder of the symbol begins with it.
+func (d *demangler) accept(prefix string) bool {
+	if strings.HasPrefix(d.s[d.i:], prefix) {
+		d.i += len(prefix)
+		return true
+	}
+	return false
+}
+
+func (d *demangler) expect(prefix string) {
+	if !d.accept(prefix) {
+		d.fail()
+	}
+}
+
+func (d *demangler) peekDigit() bool {
+	return d.i < len(d.s) && '0' <= d.s[d.i] && d.s[d.i] <= '9'
+}
+
+func (d *demangler) number() int {
+	start := d.i
+	for d.peekDigit() {
+		d.i++
+	}
+	n, err := strconv.Atoi(d.s[start:d.i])
+	if err != nil {
+		d.fail()
+	}
+	return n
+}
+
+// name consumes a length-prefixed name.
+func (d *demangler) name() string {
+	n := d.number()
+	d.expect("_")
+	if n > len(d.s)-d.i {
+		d.fail()
+	}
+	name := d.s[d.i : d.i+n]
+	d.i += n
+	return name
+}
+
+// typ consumes a mangled type, and returns it in Go syntax.
+func (d *demangler) typ() string {
+	switch d.next() {
+	case 'N':
+		name := d.name()
+		if name == "" {
+			d.fail()
+		}
+		return name
+
+	case 'p':
+		return "*" + d.typ()
+
+	case 'M':
+		key := d.typ()
+		d.expect("__")
+		return "map[" + key + "]" + d.typ()
+
+	case 'C':
+		elem := d.typ()
+		var s string
+		switch {
+		case d.accept("sr"):
+			// A receive-only element type must be parenthesized,
+			// or the "<-" would bind to the outer chan.
+			if strings.HasPrefix(elem, "<-") {
+				elem = "(" + elem + ")"
+			}
+			s = "chan " + elem
+		case d.accept("s"):
+			s = "chan<- " + elem
+		case d.accept("r"):
+			s = "<-chan " + elem
+		default:
+			d.fail()
+		}
+		d.expect("e")
+		return s
+
+	case 'F':
+		return "func" + d.signature()
+
+	case 'A':
+		elem := d.typ()
+		if d.peekDigit() {
+			n := d.number()
+			d.expect("e")
+			return "[" + strconv.Itoa(n) + "]" + elem
+		}
+		d.expect("e")
+		return "[]" + elem
+
+	case 'S':
+		var fields []string
+		for !d.accept("e") {
+			if d.accept("0_") {
+				fields = append(fields, d.typ())
+			} else {
+				name := d.name()
+				fields = append(fields, name+" "+d.typ())
+			}
+		}
+		return "struct{" + strings.Join(fields, "; ") + "}"
+
+	case 'I':
+		var methods []string
+		for !d.accept("e") {
+			name := d.name()
+			// Unexported methods are qualified by their
+			// package path, as in ".path.name".
+			if strings.HasPrefix(name, ".") {
+				name = name[strings.LastIndex(name, ".")+1:]
+			}
+			d.expect("F")
+			methods = append(methods, name+d.signature())
+		}
+		return "interface{" + strings.Join(methods, "; ") + "}"
+
+	default:
+		panic(errDemangle{})
+	}
+}
+
+// signature consumes the remainder of a mangled function type following
+// the "F", and returns its parameters and results in Go syntax. A receiver
+// is shown as the first parameter.
+func (d *demangler) signature() string {
+	var params, results []string
+	if d.accept("m") {
+		params = append(params, d.typ())
+	}
+	if d.accept("p") {
+		for !d.accept("e") {
+			if d.accept("V") {
+				last := len(params) - 1
+				if last < 0 || !strings.HasPrefix(params[last], "[]") {
+					d.fail()
+				}
+				params[last] = "..." + params[last][2:]
+				d.expect("e")
+				break
+			}
+			params = append(params, d.typ())
+		}
+	}
+	if d.accept("r") {
+		for !d.accept("e") {
+			results = append(results, d.typ())
+		}
+	}
+	d.expect("e")
+
+	s := "(" + strings.Join(params, ", ") + ")"
+	switch len(results) {
+	case 0:
+	case 1:
+		s += " " + results[0]
+	default:
+		s += " (" + strings.Join(results, ", ") + ")"
+	}
+	return s
+}
+
+// parse calls f to consume s, and reports whether f consumed all of s
+// without failing.
+func parse(s string, f func(d *demangler)) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isErr := r.(errDemangle); !isErr {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	d := demangler{s: s}
+	f(&d)
+	return d.i == len(s)
+}
+
+// demangleType demangles a symbol consisting of a single mangled type.
+func demangleType(s string) (t string, ok bool) {
+	ok = parse(s, func(d *demangler) {
+		t = d.typ()
+	})
+	return t, ok
+}
+
+// demangleImt demangles the remainder of a method table symbol following
+// "__go_imt_", which is the mangled interface type and the mangled source
+// type, separated by "__".
+func demangleImt(s string) (iface, src string, ok bool) {
+	ok = parse(s, func(d *demangler) {
+		iface = d.typ()
+		d.expect("__")
+		src = d.typ()
+	})
+	return iface, src, ok
+}
+
+// demangleTypeDescriptor returns the type whose type descriptor is named
+// by sym.
+func demangleTypeDescriptor(sym string) (string, bool) {
+	switch {
+	case strings.HasPrefix(sym, "__go_tdn_"):
+		// Named types are not mangled, but their name components
+		// are separated by dots (see MangleTypeDescriptorName).
+		name := sym[len("__go_tdn_"):]
+		return name, name != ""
+	case strings.HasPrefix(sym, "__go_td_"):
+		return demangleType(sym[len("__go_td_"):])
+	}
+	return "", false
+}
+
+// isIdentifier reports whether s is a Go identifier.
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isMangledPackagePath reports whether s may be a package path mangled by
+// ManglePackagePath.
+func isMangledPackagePath(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-~+", r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isFuncLitName reports whether s may be the go/ssa name of a function
+// literal, which ends with "$" and a number.
+func isFuncLitName(s string) bool {
+	dollar := strings.LastIndex(s, "$")
+	if dollar <= 0 || dollar == len(s)-1 {
+		return false
+	}
+	for _, r := range s[dollar+1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// demangleFunction demangles the name of a function or global variable,
+// which is the mangled package path and the name, separated by a dot, and
+// followed by a dot and the mangled receiver type for methods.
+func demangleFunction(sym string) (string, bool) {
+	dot := strings.Index(sym, ".")
+	if dot <= 0 {
+		return "", false
+	}
+	pkg, name := sym[:dot], sym[dot+1:]
+	if !isMangledPackagePath(pkg) {
+		return "", false
+	}
+	if name == ".import" {
+		return pkg + ".init", true
+	}
+
+	var recv string
+	if dot := strings.Index(name, "."); dot >= 0 {
+		recv = name[dot+1:]
+		name = name[:dot]
+	}
+	if !isIdentifier(name) {
+		return "", false
+	}
+	if recv == "" {
+		return pkg + "." + name, true
+	}
+
+	recv, ok := demangleType(recv)
+	if !ok {
+		return "", false
+	}
+	ptr := strings.HasPrefix(recv, "*")
+	recv = strings.TrimPrefix(recv, "*")
+	if !strings.HasPrefix(recv, pkg+".") {
+		// Methods are declared in the package of their receiver's
+		// base type, so this is not an llgo symbol.
+		return "", false
+	}
+	recv = recv[len(pkg)+1:]
+	if ptr {
+		recv = "(*" + recv + ")"
+	}
+	return pkg + "." + recv + "." + name, true
+}
+
+// Demangle returns a description of the function, global variable, type
+// descriptor or other symbol that llgo gave the name sym, using Go syntax
+// for names and types. Methods are shown as "pkg.T.Name" or
+// "pkg.(*T).Name", and closures by the name of the function literal. An
+// error is returned if sym was not named by llgo.
+//
+// Package paths are shown as mangled, as the mangling of package paths
+// is not reversible.
+func Demangle(sym string) (string, error) {
+	if s, ok := demangle(sym); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("cannot demangle %q", sym)
+}
+
+func demangle(sym string) (string, bool) {
+	// Symbols derived from other symbols.
+	if base := strings.TrimSuffix(sym, "$descriptor"); base != sym {
+		if s, ok := demangle(base); ok {
+			return "function descriptor for " + s, true
+		}
+		return "", false
+	}
+	if base := strings.TrimSuffix(sym, "$recover"); base != sym {
+		if s, ok := demangle(base); ok {
+			return "recover thunk for " + s, true
+		}
+		return "", false
+	}
+	if base := strings.TrimSuffix(sym, "$gc"); base != sym {
+		if t, ok := demangleTypeDescriptor(base); ok {
+			return "GC data for " + t, true
+		}
+		return "", false
+	}
+
+	// Function literals are named by their parent function, a colon, and
+	// their name as given by go/ssa, which is that of the outermost
+	// function followed by "$" and a number for each level of nesting.
+	if colon := strings.LastIndex(sym, ":"); colon >= 0 {
+		parent, fn := sym[:colon], sym[colon+1:]
+		if !isFuncLitName(fn) {
+			return "", false
+		}
+		if _, ok := demangle(parent); !ok {
+			return "", false
+		}
+		return fn, true
+	}
+
+	if t, ok := demangleTypeDescriptor(sym); ok {
+		return "type descriptor for " + t, true
+	}
+
+	for _, p := range []struct{ prefix, desc string }{
+		{"__go_map_", "map descriptor for "},
+		{"__go_type_hash_", "hash function for "},
+		{"__go_type_equal_", "equality function for "},
+	} {
+		if strings.HasPrefix(sym, p.prefix) {
+			t, ok := demangleType(sym[len(p.prefix):])
+			if !ok {
+				return "", false
+			}
+			return p.desc + t, true
+		}
+	}
+
+	if strings.HasPrefix(sym, "__go_imt_") {
+		iface, src, ok := demangleImt(sym[len("__go_imt_"):])
+		if !ok {
+			return "", false
+		}
+		return "method table for " + src + " as " + iface, true
+	}
+
+	// Other symbols with the prefix reserved for the runtime are
+	// defined by libgo.
+	if strings.HasPrefix(sym, "__go_") {
+		return "", false
+	}
+	return demangleFunction(sym)
+}
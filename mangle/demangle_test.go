@@ -0,0 +1,88 @@
+package mangle_test
+
+import (
+	"testing"
+
+	"github.com/go-llvm/llgo/mangle"
+)
+
+func TestDemangle(t *testing.T) {
+	tests := []struct {
+		sym, name string
+	}{
+		{"main.main", "main.main"},
+		{"github_com_go-llvm_llgo_irgen.Compile", "github_com_go-llvm_llgo_irgen.Compile"},
+		{"main..import", "main.init"},
+		{"main.String.N6_main.T", "main.T.String"},
+		{"main.String.pN6_main.T", "main.(*T).String"},
+		{"main.main:main.main$1", "main.main$1"},
+		{"main.main:main.main$1:main.main$1$1", "main.main$1$1"},
+		{"main.m.pN6_main.T:(*main.T).m$1", "(*main.T).m$1"},
+		{"main.f$descriptor", "function descriptor for main.f"},
+		{"main.Close.pN6_main.T$descriptor", "function descriptor for main.(*T).Close"},
+		{"main.f$recover", "recover thunk for main.f"},
+		{"__go_tdn_main.T", "type descriptor for main.T"},
+		{"__go_tdn_int", "type descriptor for int"},
+		{"__go_tdn_main.f.1.T", "type descriptor for main.f.1.T"},
+		{"__go_tdn_main.T$gc", "GC data for main.T"},
+		{"__go_td_pN6_main.T", "type descriptor for *main.T"},
+		{"__go_td_AN5_uint8e", "type descriptor for []uint8"},
+		{"__go_td_AN3_int4e", "type descriptor for [4]int"},
+		{"__go_td_CN3_intsre", "type descriptor for chan int"},
+		{"__go_td_CN3_intse", "type descriptor for chan<- int"},
+		{"__go_td_CN3_intre", "type descriptor for <-chan int"},
+		{"__go_td_CCN3_intresre", "type descriptor for chan (<-chan int)"},
+		{"__go_td_S1_xN3_int0_N6_main.T1_yN6_stringe", "type descriptor for struct{x int; main.T; y string}"},
+		{"__go_td_Se", "type descriptor for struct{}"},
+		{"__go_td_Ie", "type descriptor for interface{}"},
+		{"__go_td_I5_ErrorFrN6_stringeee$gc", "GC data for interface{Error() string}"},
+		{"__go_td_I7_.main.fFpN3_inteee", "type descriptor for interface{f(int)}"},
+		{"__go_td_Fe", "type descriptor for func()"},
+		{"__go_td_FpN6_stringAN5_int32eVee", "type descriptor for func(string, ...int32)"},
+		{"__go_td_FrN3_intN5_erroree", "type descriptor for func() (int, error)"},
+		{"__go_td_FmN6_main.TpN3_intee", "type descriptor for func(main.T, int)"},
+		{"__go_map_MN6_string__N3_int", "map descriptor for map[string]int"},
+		{"__go_type_hash_AN6_string2e", "hash function for [2]string"},
+		{"__go_type_equal_S1_aN3_inte", "equality function for struct{a int}"},
+		{"__go_imt_I6_StringFrN6_stringeee__pN6_main.T", "method table for *main.T as interface{String() string}"},
+	}
+	for _, test := range tests {
+		name, err := mangle.Demangle(test.sym)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.sym, err)
+			continue
+		}
+		if name != test.name {
+			t.Errorf("%s: got %q, want %q", test.sym, name, test.name)
+		}
+	}
+}
+
+func TestDemangleInvalid(t *testing.T) {
+	for _, sym := range []string{
+		"",
+		"main",
+		"__go_new",
+		"__go_init_main",
+		"__go_type_hash_identity",
+		"__go_type_equal_string",
+		"__go_tdn_",
+		"__go_td_N9_main.T",
+		"__go_td_N6_main.Tx",
+		"__go_td_AN3_int",
+		"__go_td_CN3_intxe",
+		"__go_imt_Ie",
+		"main.String.N7_other.T",
+		"main.f:",
+		"main.f:g",
+		"foo.go:12:3",
+		"go$zerovalue",
+		"llvm.dbg.value",
+		"llvm.memcpy.p0i8.p0i8.i64",
+		".Lstr",
+	} {
+		if name, err := mangle.Demangle(sym); err == nil {
+			t.Errorf("%s: unexpected success: %q", sym, name)
+		}
+	}
+}
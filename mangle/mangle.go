@@ -0,0 +1,387 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package mangle implements the gccgo-compatible mangling of the names of
+// Go functions, globals and types into symbol names, and the demangling of
+// such symbols back into Go-style names.
+package mangle
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+	"golang.org/x/tools/go/types"
+)
+
+type localNamedTypeInfo struct {
+	functionName string
+	scopeNum     int
+}
+
+// NamedTypeInfo holds the components of the name of a named (or basic)
+// type, as used in symbol names and type descriptors.
+type NamedTypeInfo struct {
+	PkgName, PkgPath string
+	Name             string
+
+	// FunctionName is the name of the function in which the type is
+	// declared, or empty if it is declared at package level. Types
+	// declared in a function are numbered by ScopeNum to distinguish
+	// those declared in different scopes of the function.
+	FunctionName string
+	ScopeNum     int
+}
+
+// A Mangler mangles the names of the functions, globals and types of a
+// program.
+type Mangler struct {
+	ti  map[*types.Named]localNamedTypeInfo
+	msc *types.MethodSetCache
+}
+
+// OrderedMethodSet assembles the method set into the order that gccgo uses
+// (unexported methods first).
+// TODO(pcc): cache this.
+func OrderedMethodSet(ms *types.MethodSet) []*types.Selection {
+	oms := make([]*types.Selection, ms.Len())
+	omsi := 0
+	for i := 0; i != ms.Len(); i++ {
+		if sel := ms.At(i); !sel.Obj().Exported() {
+			oms[omsi] = sel
+			omsi++
+		}
+	}
+	for i := 0; i != ms.Len(); i++ {
+		if sel := ms.At(i); sel.Obj().Exported() {
+			oms[omsi] = sel
+			omsi++
+		}
+	}
+	return oms
+}
+
+// NewMangler returns a Mangler for the types and functions of prog. msc is
+// used to compute the method sets of interface types.
+func NewMangler(prog *ssa.Program, msc *types.MethodSetCache) *Mangler {
+	m := &Mangler{
+		ti:  make(map[*types.Named]localNamedTypeInfo),
+		msc: msc,
+	}
+	for f, _ := range ssautil.AllFunctions(prog) {
+		scopeNum := 0
+		var addNamedTypesToMap func(*types.Scope)
+		addNamedTypesToMap = func(scope *types.Scope) {
+			hasNamedTypes := false
+			for _, n := range scope.Names() {
+				if tn, ok := scope.Lookup(n).(*types.TypeName); ok {
+					hasNamedTypes = true
+					m.ti[tn.Type().(*types.Named)] = localNamedTypeInfo{f.Name(), scopeNum}
+				}
+			}
+			if hasNamedTypes {
+				scopeNum++
+			}
+			for i := 0; i != scope.NumChildren(); i++ {
+				addNamedTypesToMap(scope.Child(i))
+			}
+		}
+		if fobj, ok := f.Object().(*types.Func); ok && fobj.Scope() != nil {
+			addNamedTypesToMap(fobj.Scope())
+		}
+	}
+	return m
+}
+
+// NamedTypeInfo returns the components of the name of t, which must be a
+// named or basic type.
+func (m *Mangler) NamedTypeInfo(t types.Type) (nti NamedTypeInfo) {
+	switch t := t.(type) {
+	case *types.Basic:
+		switch t.Kind() {
+		case types.Byte:
+			nti.Name = "uint8"
+		case types.Rune:
+			nti.Name = "int32"
+		case types.UnsafePointer:
+			nti.PkgName = "unsafe"
+			nti.PkgPath = "unsafe"
+			nti.Name = "Pointer"
+		default:
+			nti.Name = t.Name()
+		}
+
+	case *types.Named:
+		obj := t.Obj()
+		if pkg := obj.Pkg(); pkg != nil {
+			nti.PkgName = obj.Pkg().Name()
+			nti.PkgPath = obj.Pkg().Path()
+		}
+		nti.Name = obj.Name()
+		lti := m.ti[t]
+		nti.FunctionName = lti.functionName
+		nti.ScopeNum = lti.scopeNum
+
+	default:
+		panic("not a named type")
+	}
+
+	return
+}
+
+func (m *Mangler) mangleSignature(s *types.Signature, recv *types.Var, b *bytes.Buffer) {
+	b.WriteRune('F')
+	if recv != nil {
+		b.WriteRune('m')
+		m.MangleType(recv.Type(), b)
+	}
+
+	if p := s.Params(); p.Len() != 0 {
+		b.WriteRune('p')
+		for i := 0; i != p.Len(); i++ {
+			m.MangleType(p.At(i).Type(), b)
+		}
+		if s.Variadic() {
+			b.WriteRune('V')
+		}
+		b.WriteRune('e')
+	}
+
+	if r := s.Results(); r.Len() != 0 {
+		b.WriteRune('r')
+		for i := 0; i != r.Len(); i++ {
+			m.MangleType(r.At(i).Type(), b)
+		}
+		b.WriteRune('e')
+	}
+
+	b.WriteRune('e')
+}
+
+// ManglePackagePath writes the mangled form of the package path to b. The
+// mangling is not reversible, as "/" and "." are replaced with "_".
+func ManglePackagePath(pkgpath string, b *bytes.Buffer) {
+	pkgpath = strings.Replace(pkgpath, "/", "_", -1)
+	pkgpath = strings.Replace(pkgpath, ".", "_", -1)
+	b.WriteString(pkgpath)
+}
+
+// MangleType writes the mangled form of t to b.
+func (m *Mangler) MangleType(t types.Type, b *bytes.Buffer) {
+	switch t := t.(type) {
+	case *types.Basic, *types.Named:
+		var nb bytes.Buffer
+		ti := m.NamedTypeInfo(t)
+		if ti.PkgPath != "" {
+			ManglePackagePath(ti.PkgPath, &nb)
+			nb.WriteRune('.')
+		}
+		if ti.FunctionName != "" {
+			nb.WriteString(ti.FunctionName)
+			nb.WriteRune('$')
+			if ti.ScopeNum != 0 {
+				nb.WriteString(strconv.Itoa(ti.ScopeNum))
+				nb.WriteRune('$')
+			}
+		}
+		nb.WriteString(ti.Name)
+
+		b.WriteRune('N')
+		b.WriteString(strconv.Itoa(nb.Len()))
+		b.WriteRune('_')
+		b.WriteString(nb.String())
+
+	case *types.Pointer:
+		b.WriteRune('p')
+		m.MangleType(t.Elem(), b)
+
+	case *types.Map:
+		b.WriteRune('M')
+		m.MangleType(t.Key(), b)
+		b.WriteString("__")
+		m.MangleType(t.Elem(), b)
+
+	case *types.Chan:
+		b.WriteRune('C')
+		m.MangleType(t.Elem(), b)
+		switch t.Dir() {
+		case types.SendOnly:
+			b.WriteRune('s')
+		case types.RecvOnly:
+			b.WriteRune('r')
+		case types.SendRecv:
+			b.WriteString("sr")
+		}
+		b.WriteRune('e')
+
+	case *types.Signature:
+		m.mangleSignature(t, t.Recv(), b)
+
+	case *types.Array:
+		b.WriteRune('A')
+		m.MangleType(t.Elem(), b)
+		b.WriteString(strconv.FormatInt(t.Len(), 10))
+		b.WriteRune('e')
+
+	case *types.Slice:
+		b.WriteRune('A')
+		m.MangleType(t.Elem(), b)
+		b.WriteRune('e')
+
+	case *types.Struct:
+		b.WriteRune('S')
+		for i := 0; i != t.NumFields(); i++ {
+			f := t.Field(i)
+			if f.Anonymous() {
+				b.WriteString("0_")
+			} else {
+				b.WriteString(strconv.Itoa(len(f.Name())))
+				b.WriteRune('_')
+				b.WriteString(f.Name())
+			}
+			m.MangleType(f.Type(), b)
+			// TODO: tags are mangled here
+		}
+		b.WriteRune('e')
+
+	case *types.Interface:
+		b.WriteRune('I')
+		methodset := m.msc.MethodSet(t)
+		for _, sel := range OrderedMethodSet(methodset) {
+			method := sel.Obj()
+			var nb bytes.Buffer
+			if !method.Exported() {
+				nb.WriteRune('.')
+				nb.WriteString(method.Pkg().Path())
+				nb.WriteRune('.')
+			}
+			nb.WriteString(method.Name())
+
+			b.WriteString(strconv.Itoa(nb.Len()))
+			b.WriteRune('_')
+			b.WriteString(nb.String())
+
+			m.mangleSignature(method.Type().(*types.Signature), nil, b)
+		}
+		b.WriteRune('e')
+
+	default:
+		panic(fmt.Sprintf("unhandled type: %#v", t))
+	}
+}
+
+// MangleTypeDescriptorName writes the symbol name of the type descriptor
+// of t to b.
+func (m *Mangler) MangleTypeDescriptorName(t types.Type, b *bytes.Buffer) {
+	switch t := t.(type) {
+	case *types.Basic, *types.Named:
+		b.WriteString("__go_tdn_")
+		ti := m.NamedTypeInfo(t)
+		if ti.PkgPath != "" {
+			ManglePackagePath(ti.PkgPath, b)
+			b.WriteRune('.')
+		}
+		if ti.FunctionName != "" {
+			b.WriteString(ti.FunctionName)
+			b.WriteRune('.')
+			if ti.ScopeNum != 0 {
+				b.WriteString(strconv.Itoa(ti.ScopeNum))
+				b.WriteRune('.')
+			}
+		}
+		b.WriteString(ti.Name)
+
+	default:
+		b.WriteString("__go_td_")
+		m.MangleType(t, b)
+	}
+}
+
+// MangleMapDescriptorName writes the symbol name of the map descriptor of
+// the map type t to b.
+func (m *Mangler) MangleMapDescriptorName(t types.Type, b *bytes.Buffer) {
+	b.WriteString("__go_map_")
+	m.MangleType(t, b)
+}
+
+// MangleImtName writes the symbol name of the method table used to convert
+// values of type srctype to the interface type targettype to b.
+func (m *Mangler) MangleImtName(srctype types.Type, targettype *types.Interface, b *bytes.Buffer) {
+	b.WriteString("__go_imt_")
+	m.MangleType(targettype, b)
+	b.WriteString("__")
+	m.MangleType(srctype, b)
+}
+
+// MangleHashFunctionName returns the symbol name of the hash function of t.
+func (m *Mangler) MangleHashFunctionName(t types.Type) string {
+	var b bytes.Buffer
+	b.WriteString("__go_type_hash_")
+	m.MangleType(t, &b)
+	return b.String()
+}
+
+// MangleEqualFunctionName returns the symbol name of the equality function
+// of t.
+func (m *Mangler) MangleEqualFunctionName(t types.Type) string {
+	var b bytes.Buffer
+	b.WriteString("__go_type_equal_")
+	m.MangleType(t, &b)
+	return b.String()
+}
+
+// MangleFunctionName returns the symbol name of f.
+func (m *Mangler) MangleFunctionName(f *ssa.Function) string {
+	var b bytes.Buffer
+
+	if f.Parent() != nil {
+		// Anonymous functions are not guaranteed to
+		// have unique identifiers at the global scope.
+		b.WriteString(m.MangleFunctionName(f.Parent()))
+		b.WriteRune(':')
+		b.WriteString(f.String())
+		return b.String()
+	}
+
+	pkg := f.Pkg
+	var pkgobj *types.Package
+	if pkg != nil {
+		pkgobj = pkg.Object
+	} else if f.Signature.Recv() != nil {
+		pkgobj = f.Signature.Recv().Pkg()
+	} else {
+		b.WriteString(f.String())
+		return b.String()
+	}
+
+	if pkg != nil {
+		ManglePackagePath(pkgobj.Path(), &b)
+		b.WriteRune('.')
+	}
+	if f.Signature.Recv() == nil && f.Name() == "init" {
+		b.WriteString(".import")
+	} else {
+		b.WriteString(f.Name())
+	}
+	if f.Signature.Recv() != nil {
+		b.WriteRune('.')
+		m.MangleType(f.Signature.Recv().Type(), &b)
+	}
+
+	return b.String()
+}
+
+// MangleGlobalName returns the symbol name of g.
+func (m *Mangler) MangleGlobalName(g *ssa.Global) string {
+	var b bytes.Buffer
+
+	ManglePackagePath(g.Pkg.Object.Path(), &b)
+	b.WriteRune('.')
+	b.WriteString(g.Name())
+
+	return b.String()
+}
@@ -0,0 +1,240 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ssaopt
+
+import (
+	"go/token"
+
+	"golang.org/x/tools/go/exact"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/types"
+)
+
+func init() {
+	RegisterPass("bce", func() Pass { return NewBoundsCheckElimination() })
+}
+
+// BoundsCheckElimination is a pass that proves index operations to be in
+// bounds. It does not modify the function; rather, the compiler omits the
+// bounds checks of the operations recorded in InBounds.
+//
+// An index operation is proven to be in bounds if:
+//   - its index is a constant within the bounds of an array or constant string;
+//   - it is dominated by an operation indexing the same value with the same
+//     index; or
+//   - its index is known to be non-negative (because it is a constant, a
+//     length, or an induction variable starting at a non-negative constant),
+//     and a dominating branch has established that it is less than the
+//     length of the indexed value.
+type BoundsCheckElimination struct {
+	// InBounds maps each index operation (an *ssa.Index, an *ssa.IndexAddr
+	// or an *ssa.Lookup on a string) proven to be in bounds to a
+	// description of the proof.
+	InBounds map[ssa.Instruction]string
+}
+
+func NewBoundsCheckElimination() *BoundsCheckElimination {
+	return &BoundsCheckElimination{InBounds: make(map[ssa.Instruction]string)}
+}
+
+func (*BoundsCheckElimination) Name() string {
+	return "bce"
+}
+
+func (b *BoundsCheckElimination) Run(f *ssa.Function) {
+	// Visit blocks in dominator tree preorder, so that every index
+	// operation that may dominate another has been seen before it.
+	var seen []ssa.Instruction
+	for _, bb := range f.DomPreorder() {
+		for _, instr := range bb.Instrs {
+			x, index, length, ok := indexOperands(instr)
+			if !ok {
+				continue
+			}
+			if reason := proveInBounds(instr, x, index, length, seen); reason != "" {
+				b.InBounds[instr] = reason
+			}
+			seen = append(seen, instr)
+		}
+	}
+}
+
+// indexOperands returns the indexed value and the index of the index
+// operation instr, together with the length of the indexed value if it is
+// constant, or -1 otherwise. If instr is not an index operation, ok is
+// false.
+func indexOperands(instr ssa.Instruction) (x, index ssa.Value, length int64, ok bool) {
+	switch instr := instr.(type) {
+	case *ssa.Index:
+		return instr.X, instr.Index, instr.X.Type().Underlying().(*types.Array).Len(), true
+	case *ssa.IndexAddr:
+		length = -1
+		if ptr, ok := instr.X.Type().Underlying().(*types.Pointer); ok {
+			length = ptr.Elem().Underlying().(*types.Array).Len()
+		}
+		return instr.X, instr.Index, length, true
+	case *ssa.Lookup:
+		if _, ok := instr.X.Type().Underlying().(*types.Basic); !ok {
+			return nil, nil, 0, false
+		}
+		length = -1
+		if c, ok := instr.X.(*ssa.Const); ok && c.Value != nil {
+			length = int64(len(exact.StringVal(c.Value)))
+		}
+		return instr.X, instr.Index, length, true
+	}
+	return nil, nil, 0, false
+}
+
+// proveInBounds returns a description of why the index operation instr is
+// in bounds, or the empty string if that cannot be proven. seen holds the
+// index operations that may dominate instr.
+func proveInBounds(instr ssa.Instruction, x, index ssa.Value, length int64, seen []ssa.Instruction) string {
+	if c, ok := constInt(index); ok && c >= 0 && c < length {
+		return "constant index"
+	}
+
+	for _, prev := range seen {
+		px, pindex, _, _ := indexOperands(prev)
+		if px == x && pindex == index && prev.Block().Dominates(instr.Block()) {
+			return "dominated by identical check"
+		}
+	}
+
+	reason := "index bounded by len"
+	if !nonNegative(index) {
+		if start, ok := inductionStart(index); ok && start >= 0 {
+			reason = "induction variable bounded by len"
+		} else {
+			return ""
+		}
+	}
+	for _, bound := range upperBounds(index, instr.Block()) {
+		if isLenOf(bound, x) {
+			return reason
+		}
+		if c, ok := constInt(bound); ok && c <= length {
+			return reason
+		}
+	}
+	return ""
+}
+
+// nonNegative reports whether v is trivially non-negative.
+func nonNegative(v ssa.Value) bool {
+	if c, ok := constInt(v); ok {
+		return c >= 0
+	}
+	if call, ok := v.(*ssa.Call); ok {
+		if builtin, ok := call.Call.Value.(*ssa.Builtin); ok {
+			return builtin.Name() == "len" || builtin.Name() == "cap"
+		}
+	}
+	return false
+}
+
+// isLenOf reports whether v is the result of len(x).
+func isLenOf(v, x ssa.Value) bool {
+	call, ok := v.(*ssa.Call)
+	if !ok {
+		return false
+	}
+	builtin, ok := call.Call.Value.(*ssa.Builtin)
+	return ok && builtin.Name() == "len" && call.Call.Args[0] == x
+}
+
+// inductionStart determines whether v is an induction variable of the form
+//
+//	i = phi [init: c, loop: i + 1]
+//
+// or the increment i + 1 of such a variable, where the loop's back edge is
+// only taken once a branch has established that i, or i + 1, is less than
+// some bound. If so, v never overflows, and it returns the initial value of
+// v.
+func inductionStart(v ssa.Value) (start int64, ok bool) {
+	if add, ok := v.(*ssa.BinOp); ok {
+		if phi, ok := add.X.(*ssa.Phi); ok && isIncrement(add, phi) {
+			start, ok := inductionStart(phi)
+			return start + 1, ok
+		}
+		return 0, false
+	}
+
+	phi, ok := v.(*ssa.Phi)
+	if !ok || len(phi.Edges) != 2 {
+		return 0, false
+	}
+	header := phi.Block()
+	var init, next ssa.Value
+	var latch *ssa.BasicBlock
+	for i, edge := range phi.Edges {
+		pred := header.Preds[i]
+		if header.Dominates(pred) {
+			next, latch = edge, pred
+		} else {
+			init = edge
+		}
+	}
+	if init == nil || next == nil {
+		return 0, false
+	}
+	start, ok = constInt(init)
+	if !ok {
+		return 0, false
+	}
+	add, ok := next.(*ssa.BinOp)
+	if !ok || !isIncrement(add, phi) {
+		return 0, false
+	}
+	if len(upperBounds(phi, latch)) == 0 && len(upperBounds(add, latch)) == 0 {
+		return 0, false
+	}
+	return start, true
+}
+
+// isIncrement reports whether add computes x + 1.
+func isIncrement(add *ssa.BinOp, x ssa.Value) bool {
+	if add.Op != token.ADD || add.X != x {
+		return false
+	}
+	c, ok := constInt(add.Y)
+	return ok && c == 1
+}
+
+// upperBounds returns the values that v is known to be less than on entry
+// to block bb, as established by branches on comparisons involving v.
+func upperBounds(v ssa.Value, bb *ssa.BasicBlock) []ssa.Value {
+	var bounds []ssa.Value
+	// A dominator of bb with a single predecessor ending in a branch
+	// can only be reached by the branch taking one particular edge.
+	for d := bb; d != nil; d = d.Idom() {
+		if len(d.Preds) != 1 {
+			continue
+		}
+		pred := d.Preds[0]
+		br, ok := pred.Instrs[len(pred.Instrs)-1].(*ssa.If)
+		if !ok || pred.Succs[0] == pred.Succs[1] {
+			continue
+		}
+		cond, ok := br.Cond.(*ssa.BinOp)
+		if !ok {
+			continue
+		}
+		taken := d == pred.Succs[0]
+		var lhs, rhs ssa.Value
+		switch {
+		case cond.Op == token.LSS && taken, cond.Op == token.GEQ && !taken:
+			lhs, rhs = cond.X, cond.Y
+		case cond.Op == token.GTR && taken, cond.Op == token.LEQ && !taken:
+			lhs, rhs = cond.Y, cond.X
+		default:
+			continue
+		}
+		if lhs == v {
+			bounds = append(bounds, rhs)
+		}
+	}
+	return bounds
+}
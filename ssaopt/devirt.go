@@ -0,0 +1,273 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ssaopt
+
+import (
+	"log"
+
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/types"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+func init() {
+	RegisterPass("devirt", func() Pass { return NewDevirtualization() })
+}
+
+// Devirtualization is a pass that replaces interface method calls with
+// direct calls of the concrete method, where the dynamic type of the
+// interface is statically known.
+//
+// If the interface was created by a MakeInterface in the same function
+// (possibly followed by ChangeInterfaces), the call is rewritten to a static
+// call with the concrete value as its receiver.
+//
+// Otherwise, if the interface has an unexported type declared by the
+// function's package, all of whose methods are unexported, none of them are
+// methods of the package's exported types, and exactly one type in the
+// package implements it, the call is recorded in Targets. The
+// compiler calls the target directly, passing it the interface's data word
+// as its receiver. As both a type and its pointer type implement an
+// interface satisfied by value methods, this only applies to interfaces
+// satisfied by pointer methods.
+type Devirtualization struct {
+	// Targets maps the invoke-mode calls whose receiver is known to
+	// be a pointer of a particular type to the method called.
+	Targets map[*ssa.CallCommon]*ssa.Function
+
+	// Logger, if not nil, is used to report each devirtualized call.
+	Logger *log.Logger
+
+	msc        types.MethodSetCache
+	candidates map[*ssa.Package][]types.Type
+	sole       map[*types.Named]types.Type
+}
+
+func NewDevirtualization() *Devirtualization {
+	return &Devirtualization{
+		Targets:    make(map[*ssa.CallCommon]*ssa.Function),
+		candidates: make(map[*ssa.Package][]types.Type),
+		sole:       make(map[*types.Named]types.Type),
+	}
+}
+
+func (*Devirtualization) Name() string {
+	return "devirt"
+}
+
+func (d *Devirtualization) Run(f *ssa.Function) {
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			call, ok := instr.(ssa.CallInstruction)
+			if !ok || !call.Common().IsInvoke() {
+				continue
+			}
+			common := call.Common()
+
+			if recv := concreteValue(common.Value); recv != nil {
+				fn := d.method(f.Prog, recv.Type(), common.Method)
+				d.logf(f, call, fn)
+				rewriteInvoke(call, fn, recv)
+				continue
+			}
+
+			if f.Pkg == nil {
+				continue
+			}
+			if t := d.soleImplementer(f.Pkg, common.Value.Type()); t != nil {
+				fn := d.method(f.Prog, t, common.Method)
+				d.logf(f, call, fn)
+				d.Targets[common] = fn
+			}
+		}
+	}
+}
+
+func (d *Devirtualization) logf(f *ssa.Function, call ssa.CallInstruction, fn *ssa.Function) {
+	if d.Logger != nil {
+		pos := f.Prog.Fset.Position(call.Pos())
+		d.Logger.Printf("Devirtualized call to %s at %s", fn, pos)
+	}
+}
+
+// concreteValue returns the concrete value converted to the interface v in
+// the same function, or nil if there is none.
+func concreteValue(v ssa.Value) ssa.Value {
+	for {
+		switch x := v.(type) {
+		case *ssa.ChangeInterface:
+			v = x.X
+		case *ssa.MakeInterface:
+			return x.X
+		default:
+			return nil
+		}
+	}
+}
+
+// method returns the implementation of the interface method m for the
+// concrete type t.
+func (d *Devirtualization) method(prog *ssa.Program, t types.Type, m *types.Func) *ssa.Function {
+	return prog.Method(d.msc.MethodSet(t).Lookup(m.Pkg(), m.Name()))
+}
+
+// rewriteInvoke turns the invoke-mode call into a static call of fn with
+// the given receiver, updating the referrers of the affected values.
+func rewriteInvoke(call ssa.CallInstruction, fn *ssa.Function, recv ssa.Value) {
+	common := call.Common()
+	removeReferrer(common.Value, call)
+	common.Value = fn
+	common.Method = nil
+	common.Args = append([]ssa.Value{recv}, common.Args...)
+	if refs := recv.Referrers(); refs != nil {
+		*refs = append(*refs, call)
+	}
+	if refs := fn.Referrers(); refs != nil {
+		*refs = append(*refs, call)
+	}
+}
+
+func removeReferrer(v ssa.Value, instr ssa.Instruction) {
+	refs := v.Referrers()
+	if refs == nil {
+		return
+	}
+	for i, ref := range *refs {
+		if ref == instr {
+			*refs = append((*refs)[:i], (*refs)[i+1:]...)
+			return
+		}
+	}
+}
+
+// soleImplementer returns the only type able to implement the interface
+// type t, a private interface of pkg, or nil if there is no such type.
+func (d *Devirtualization) soleImplementer(pkg *ssa.Package, t types.Type) types.Type {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return nil
+	}
+	if sole, ok := d.sole[named]; ok {
+		return sole
+	}
+	d.sole[named] = nil
+
+	obj := named.Obj()
+	if obj.Pkg() != pkg.Object || obj.Exported() {
+		return nil
+	}
+	iface := named.Underlying().(*types.Interface)
+	if iface.NumMethods() == 0 {
+		return nil
+	}
+	for i := 0; i < iface.NumMethods(); i++ {
+		if iface.Method(i).Exported() {
+			return nil
+		}
+	}
+
+	// Types declared by other packages cannot have this package's
+	// unexported methods, other than by embedding one of its exported
+	// types, from which they are promoted. Such types are not among the
+	// candidates, so we give up if any method of the interface may be
+	// promoted in this way.
+	scope := pkg.Object.Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || !tn.Exported() {
+			continue
+		}
+		for _, t := range []types.Type{tn.Type(), types.NewPointer(tn.Type())} {
+			mset := d.msc.MethodSet(t)
+			for i := 0; i < iface.NumMethods(); i++ {
+				if mset.Lookup(pkg.Object, iface.Method(i).Name()) != nil {
+					return nil
+				}
+			}
+		}
+	}
+
+	var sole types.Type
+	for _, c := range d.packageCandidates(pkg) {
+		if !types.Implements(c, iface) {
+			continue
+		}
+		if sole != nil && !types.Identical(sole, c) {
+			return nil
+		}
+		sole = c
+	}
+	if sole == nil {
+		return nil
+	}
+	// Only pointers are passed to methods unchanged in the data word of
+	// an interface.
+	if _, ok := sole.Underlying().(*types.Pointer); !ok {
+		return nil
+	}
+	d.sole[named] = sole
+	return sole
+}
+
+// packageCandidates returns the types that may be the dynamic type of
+// an interface whose methods are unexported methods of pkg: the package's
+// named types, the types of all values converted to interfaces by the
+// package's functions, and pointers to each of these, which may be created
+// through reflection.
+func (d *Devirtualization) packageCandidates(pkg *ssa.Package) []types.Type {
+	if c, ok := d.candidates[pkg]; ok {
+		return c
+	}
+
+	var c []types.Type
+	var seen typeutil.Map
+	add := func(t types.Type) {
+		if types.IsInterface(t) || seen.At(t) != nil {
+			return
+		}
+		seen.Set(t, true)
+		c = append(c, t)
+	}
+
+	scope := pkg.Object.Scope()
+	for _, name := range scope.Names() {
+		if tn, ok := scope.Lookup(name).(*types.TypeName); ok {
+			add(tn.Type())
+			add(types.NewPointer(tn.Type()))
+		}
+	}
+	for _, mem := range pkg.Members {
+		switch mem := mem.(type) {
+		case *ssa.Function:
+			addMakeInterfaceTypes(mem, add)
+		case *ssa.Type:
+			mset := d.msc.MethodSet(types.NewPointer(mem.Type()))
+			for i := 0; i < mset.Len(); i++ {
+				if fn := pkg.Prog.Method(mset.At(i)); fn.Pkg == pkg {
+					addMakeInterfaceTypes(fn, add)
+				}
+			}
+		}
+	}
+
+	d.candidates[pkg] = c
+	return c
+}
+
+// addMakeInterfaceTypes calls add with the type of each value converted to
+// an interface by fn or its anonymous functions.
+func addMakeInterfaceTypes(fn *ssa.Function, add func(types.Type)) {
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if mi, ok := instr.(*ssa.MakeInterface); ok {
+				add(mi.X.Type())
+				add(types.NewPointer(mi.X.Type()))
+			}
+		}
+	}
+	for _, anon := range fn.AnonFuncs {
+		addMakeInterfaceTypes(anon, add)
+	}
+}
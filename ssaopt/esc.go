@@ -7,13 +7,31 @@ package ssaopt
 import (
 	"go/token"
 
+	"golang.org/x/tools/go/exact"
 	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/types"
 )
 
-func escapes(val ssa.Value, bb *ssa.BasicBlock, pending []ssa.Value) bool {
+// escapeAnalysis determines whether values escape the function in which
+// they are defined.
+type escapeAnalysis struct {
+	// summaries is used to determine the effect of static calls on their
+	// arguments. Arguments to functions without a summary escape.
+	summaries Summaries
+
+	// allowReturn is set when analyzing a function's parameters. If set,
+	// a value reaching a return instruction does not escape, but causes
+	// returned to be set.
+	allowReturn bool
+	returned    bool
+}
+
+// escapes determines whether val escapes. If so, it returns the instruction
+// through which it escapes, otherwise nil.
+func (e *escapeAnalysis) escapes(val ssa.Value, bb *ssa.BasicBlock, pending []ssa.Value) ssa.Instruction {
 	for _, p := range pending {
 		if val == p {
-			return false
+			return nil
 		}
 	}
 
@@ -29,66 +47,255 @@ func escapes(val ssa.Value, bb *ssa.BasicBlock, pending []ssa.Value) bool {
 			// in the case where a phi node that (directly or indirectly)
 			// refers to the allocation dominates the allocation.
 			if ref.Block().Dominates(bb) {
-				return true
+				return ref
 			}
-			if escapes(ref, bb, append(pending, val)) {
-				return true
+			if cause := e.escapes(ref, bb, append(pending, val)); cause != nil {
+				return cause
 			}
 
 		case *ssa.BinOp, *ssa.ChangeType, *ssa.Convert, *ssa.ChangeInterface, *ssa.MakeInterface, *ssa.Slice, *ssa.FieldAddr, *ssa.IndexAddr, *ssa.TypeAssert, *ssa.Extract:
-			if escapes(ref.(ssa.Value), bb, append(pending, val)) {
-				return true
+			if cause := e.escapes(ref.(ssa.Value), bb, append(pending, val)); cause != nil {
+				return cause
 			}
 
-		case *ssa.Range, *ssa.DebugRef:
+		case *ssa.Range, *ssa.DebugRef, *ssa.Lookup:
 			continue
 
+		case *ssa.MapUpdate:
+			if val != ref.Map {
+				return ref
+			}
+
+		case *ssa.MakeClosure:
+			// The value is bound to one of the closure's free
+			// variables. It does not escape if neither the closure
+			// nor the free variable within the closure's body do.
+			if cause := e.escapes(ref, bb, append(pending, val)); cause != nil {
+				return cause
+			}
+			fn := ref.Fn.(*ssa.Function)
+			for i, binding := range ref.Bindings {
+				if binding != val {
+					continue
+				}
+				inner := escapeAnalysis{summaries: e.summaries}
+				if cause := inner.escapes(fn.FreeVars[i], fn.Blocks[0], nil); cause != nil {
+					return cause
+				}
+			}
+
 		case *ssa.UnOp:
 			if ref.Op == token.MUL || ref.Op == token.ARROW {
 				continue
 			}
-			if escapes(ref, bb, append(pending, val)) {
-				return true
+			if cause := e.escapes(ref, bb, append(pending, val)); cause != nil {
+				return cause
 			}
 
 		case *ssa.Store:
 			if val == ref.Val {
-				return true
+				return ref
 			}
 
+		case *ssa.Return:
+			if !e.allowReturn {
+				return ref
+			}
+			e.returned = true
+
 		case *ssa.Call:
 			if builtin, ok := ref.Call.Value.(*ssa.Builtin); ok {
 				switch builtin.Name() {
-				case "cap", "len", "copy", "ssa:wrapnilchk":
+				case "cap", "len", "copy", "delete", "ssa:wrapnilchk":
 					continue
 				case "append":
-					if ref.Call.Args[0] == val && escapes(ref, bb, append(pending, val)) {
-						return true
+					if ref.Call.Args[0] == val {
+						if cause := e.escapes(ref, bb, append(pending, val)); cause != nil {
+							return cause
+						}
 					}
 				default:
-					return true
+					return ref
+				}
+			} else if e.callEscapes(ref, val) {
+				return ref
+			} else if e.callReturns(ref, val) {
+				if cause := e.escapes(ref, bb, append(pending, val)); cause != nil {
+					return cause
 				}
-			} else {
-				return true
 			}
 
 		default:
+			return ref
+		}
+	}
+
+	return nil
+}
+
+// callEscapes reports whether val escapes through the given call, other
+// than by being returned from it.
+func (e *escapeAnalysis) callEscapes(call *ssa.Call, val ssa.Value) bool {
+	fn := call.Call.StaticCallee()
+	if fn == nil {
+		// Calling a function value does not cause it to escape, but
+		// passing a value to an unknown function, or invoking an
+		// interface method on it, does.
+		if call.Call.IsInvoke() {
+			return true
+		}
+		for _, arg := range call.Call.Args {
+			if arg == val {
+				return true
+			}
+		}
+		return false
+	}
+	summary := e.summaries.lookup(fn)
+	if summary == nil || len(summary.Params) != len(call.Call.Args) {
+		return true
+	}
+	for i, arg := range call.Call.Args {
+		if arg == val && summary.Params[i] == Escapes {
+			return true
+		}
+	}
+	return false
+}
+
+// callReturns reports whether val may be returned from the given call,
+// whose callee is known to not otherwise let it escape.
+func (e *escapeAnalysis) callReturns(call *ssa.Call, val ssa.Value) bool {
+	fn := call.Call.StaticCallee()
+	if fn == nil {
+		return false
+	}
+	summary := e.summaries.lookup(fn)
+	for i, arg := range call.Call.Args {
+		if arg == val && summary.Params[i] == Returned {
 			return true
 		}
 	}
+	return false
+}
+
+// MaxStackSliceSize is the size in bytes of the largest slice backing array
+// that LowerAllocsToStack will move to the stack.
+const MaxStackSliceSize = 64 << 10
+
+// AllocDecision records the result of escape analysis for a heap
+// allocation.
+type AllocDecision struct {
+	// Alloc is the allocating instruction: an *ssa.Alloc, *ssa.MakeSlice,
+	// *ssa.MakeClosure or *ssa.MakeMap. Decisions for maps are recorded
+	// so that maps which do not escape may be reported, although the
+	// runtime allocates them.
+	Alloc ssa.Value
 
+	// Cause is the instruction through which the allocation escapes, or
+	// nil if it does not escape.
+	Cause ssa.Instruction
+}
+
+// Lowered reports whether the allocation should be moved to the stack.
+// Maps are always allocated by the runtime, so they are never lowered,
+// even if they do not escape.
+func (d *AllocDecision) Lowered() bool {
+	_, isMap := d.Alloc.(*ssa.MakeMap)
+	return d.Cause == nil && !isMap
+}
+
+// isStackCandidate reports whether instr is an allocation that may be moved
+// to the stack if it does not escape. Slices are only candidates if their
+// length and capacity are constant, and their backing array is small. Maps
+// are analyzed, but never lowered.
+func isStackCandidate(instr ssa.Instruction, sizes types.Sizes) bool {
+	switch instr := instr.(type) {
+	case *ssa.Alloc:
+		return instr.Heap
+	case *ssa.MakeSlice:
+		length, ok := constInt(instr.Len)
+		if !ok {
+			return false
+		}
+		capacity, ok := constInt(instr.Cap)
+		if !ok || length < 0 || length > capacity {
+			return false
+		}
+		elemsize := sizes.Sizeof(instr.Type().Underlying().(*types.Slice).Elem())
+		return elemsize == 0 || capacity <= MaxStackSliceSize/elemsize
+	case *ssa.MakeClosure, *ssa.MakeMap:
+		return true
+	}
 	return false
 }
 
-func LowerAllocsToStack(f *ssa.Function) {
+func constInt(v ssa.Value) (int64, bool) {
+	c, ok := v.(*ssa.Const)
+	if !ok || c.Value == nil || c.Value.Kind() != exact.Int {
+		return 0, false
+	}
+	return exact.Int64Val(c.Value)
+}
+
+func init() {
+	RegisterPass("stackalloc", func() Pass { return NewStackAllocation() })
+}
+
+// StackAllocation is a pass that moves the heap allocations in a function
+// that do not escape it to the stack, using LowerAllocsToStack. Heap
+// *ssa.Allocs are marked as stack allocations by the pass; the compiler
+// lowers the other allocations according to the recorded decisions.
+//
+// The pass uses the escape summaries of the functions called, so it must be
+// run once the summaries of the functions of the package being compiled have
+// been added to Summaries. Sizes must be set before the pass is run.
+type StackAllocation struct {
+	Summaries Summaries
+	Sizes     types.Sizes
+
+	// Decisions maps each function the pass has been run over to the
+	// decisions made for its heap allocations.
+	Decisions map[*ssa.Function][]AllocDecision
+}
+
+func NewStackAllocation() *StackAllocation {
+	return &StackAllocation{Decisions: make(map[*ssa.Function][]AllocDecision)}
+}
+
+func (*StackAllocation) Name() string {
+	return "stackalloc"
+}
+
+func (s *StackAllocation) Run(f *ssa.Function) {
+	s.Decisions[f] = LowerAllocsToStack(f, s.Summaries, s.Sizes)
+}
+
+// LowerAllocsToStack determines which of the heap allocations in f do not
+// escape. Heap *ssa.Allocs that do not escape are marked as stack
+// allocations and added to f.Locals; the caller is responsible for lowering
+// the other kinds of allocation, as indicated by the returned decisions.
+// The given summaries are used to determine the effect of calls on their
+// arguments; it may be nil.
+func LowerAllocsToStack(f *ssa.Function, summaries Summaries, sizes types.Sizes) []AllocDecision {
 	pending := make([]ssa.Value, 0, 10)
+	e := escapeAnalysis{summaries: summaries}
+	var decisions []AllocDecision
 
 	for _, b := range f.Blocks {
 		for _, instr := range b.Instrs {
-			if alloc, ok := instr.(*ssa.Alloc); ok && alloc.Heap && !escapes(alloc, alloc.Block(), pending) {
+			if !isStackCandidate(instr, sizes) {
+				continue
+			}
+			val := instr.(ssa.Value)
+			cause := e.escapes(val, b, pending)
+			if alloc, ok := val.(*ssa.Alloc); ok && cause == nil {
 				alloc.Heap = false
 				f.Locals = append(f.Locals, alloc)
 			}
+			decisions = append(decisions, AllocDecision{val, cause})
 		}
 	}
+
+	return decisions
 }
@@ -0,0 +1,110 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ssaopt
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// A Pass is an optimization that transforms the SSA form of a function.
+// Passes may rely on knowledge of Go semantics that is lost once a function
+// has been translated to LLVM IR.
+type Pass interface {
+	// Name returns the name by which the pass is registered.
+	Name() string
+
+	// Run applies the pass to f. It is only called for functions
+	// with a body.
+	Run(f *ssa.Function)
+}
+
+var passes = make(map[string]func() Pass)
+
+// RegisterPass makes a pass available by name to NewPassManager. The given
+// function is called to create a new instance of the pass for each pass
+// manager. RegisterPass panics if a pass with the same name is already
+// registered.
+func RegisterPass(name string, newPass func() Pass) {
+	if _, ok := passes[name]; ok {
+		panic("ssaopt: pass " + name + " registered twice")
+	}
+	passes[name] = newPass
+}
+
+// PassNames returns the names of all registered passes, in sorted order.
+func PassNames() []string {
+	var names []string
+	for name := range passes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultPasses is the list of passes run when the compiler is not asked
+// to run a specific list.
+var DefaultPasses = []string{"devirt", "bce", "stackalloc"}
+
+// A PassManager runs a list of passes over functions, in order.
+type PassManager struct {
+	passes  []Pass
+	timings []time.Duration
+
+	// Logger, if not nil, is used to log each pass as it is run, and
+	// the time taken by it.
+	Logger *log.Logger
+}
+
+// NewPassManager returns a pass manager that runs the passes with the given
+// names, in the given order.
+func NewPassManager(names []string) (*PassManager, error) {
+	pm := &PassManager{}
+	for _, name := range names {
+		newPass, ok := passes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown SSA pass '%s'", name)
+		}
+		pm.passes = append(pm.passes, newPass())
+	}
+	pm.timings = make([]time.Duration, len(pm.passes))
+	return pm, nil
+}
+
+// Passes returns the passes run by pm.
+func (pm *PassManager) Passes() []Pass {
+	return pm.passes
+}
+
+// Run runs each pass over f. Functions without a body are skipped.
+func (pm *PassManager) Run(f *ssa.Function) {
+	if len(f.Blocks) == 0 {
+		return
+	}
+	for i, p := range pm.passes {
+		start := time.Now()
+		p.Run(f)
+		elapsed := time.Since(start)
+		pm.timings[i] += elapsed
+		if pm.Logger != nil {
+			pm.Logger.Printf("Pass %s on %s: %v", p.Name(), f, elapsed)
+		}
+	}
+}
+
+// LogTimings logs the total time spent in each pass since the pass manager
+// was created. Nothing is logged if Logger is nil.
+func (pm *PassManager) LogTimings() {
+	if pm.Logger == nil {
+		return
+	}
+	for i, p := range pm.passes {
+		pm.Logger.Printf("Pass %s total: %v", p.Name(), pm.timings[i])
+	}
+}
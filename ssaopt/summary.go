@@ -0,0 +1,239 @@
+// Copyright 2014 The llgo Authors.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ssaopt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// ParamFlow describes what a function may do with a value passed to it.
+// Flows are ordered from most to least precise.
+type ParamFlow int
+
+const (
+	// NoEscape means that the value does not outlive the call.
+	NoEscape ParamFlow = iota
+
+	// Returned means that the value may be returned by the function,
+	// but does not otherwise escape.
+	Returned
+
+	// Escapes means that the value may escape.
+	Escapes
+)
+
+var paramFlowChars = [...]byte{NoEscape: 'n', Returned: 'r', Escapes: 'e'}
+
+// FuncSummary summarizes the escape behaviour of a function.
+type FuncSummary struct {
+	// Params holds the flow of each of the function's parameters,
+	// including the receiver, if any.
+	Params []ParamFlow
+
+	// Recovers is set if the function calls recover directly, and so
+	// may stop a panic if it is deferred.
+	Recovers bool
+}
+
+// Summaries maps the fully qualified names of functions, as given by
+// ssa.Function.String, to their summaries.
+type Summaries map[string]*FuncSummary
+
+// intrinsicSummaries holds the summaries of functions implemented by the
+// runtime, which we cannot analyze.
+var intrinsicSummaries = func() Summaries {
+	s := make(Summaries)
+	for _, op := range []string{"Add", "Load", "Store", "Swap", "CompareAndSwap"} {
+		for _, typ := range []string{"Int32", "Int64", "Uint32", "Uint64", "Uintptr", "Pointer"} {
+			if op == "Add" && typ == "Pointer" {
+				continue
+			}
+			// The address operated on never escapes. Operands
+			// other than the address only escape if they are
+			// pointers stored at the address.
+			params := []ParamFlow{NoEscape}
+			switch op {
+			case "Add", "Store", "Swap":
+				params = append(params, NoEscape)
+			case "CompareAndSwap":
+				params = append(params, NoEscape, NoEscape)
+			}
+			if typ == "Pointer" {
+				params[len(params)-1] = Escapes
+			}
+			s["sync/atomic."+op+typ] = &FuncSummary{Params: params}
+		}
+	}
+	// The receivers of the locking methods are only passed to the
+	// runtime's semaphore functions, which do not retain them.
+	for _, method := range []string{
+		"(*sync.Mutex).Lock", "(*sync.Mutex).Unlock",
+		"(*sync.RWMutex).Lock", "(*sync.RWMutex).Unlock",
+		"(*sync.RWMutex).RLock", "(*sync.RWMutex).RUnlock",
+		"(*sync.WaitGroup).Done",
+	} {
+		s[method] = &FuncSummary{Params: []ParamFlow{NoEscape}}
+	}
+	return s
+}()
+
+func (s Summaries) lookup(fn *ssa.Function) *FuncSummary {
+	name := fn.String()
+	if summary, ok := s[name]; ok {
+		return summary
+	}
+	return intrinsicSummaries[name]
+}
+
+// MayRecover reports whether fn may call recover directly, and so may stop
+// a panic if it is deferred. Functions without a body or a summary are
+// assumed to do so.
+func (s Summaries) MayRecover(fn *ssa.Function) bool {
+	if len(fn.Blocks) != 0 {
+		return callsRecover(fn)
+	}
+	summary := s.lookup(fn)
+	return summary == nil || summary.Recovers
+}
+
+func callsRecover(fn *ssa.Function) bool {
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if call, ok := instr.(ssa.CallInstruction); ok {
+				builtin, ok := call.Common().Value.(*ssa.Builtin)
+				if ok && builtin.Name() == "recover" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ComputeSummaries computes summaries for the given functions, which may
+// call each other and the functions already summarized in s, and adds them
+// to s. Functions without bodies are ignored.
+func ComputeSummaries(fns []*ssa.Function, s Summaries) {
+	// We start by optimistically assuming that no parameter escapes,
+	// and iterate until the summaries no longer change. As analyzing a
+	// function under less precise summaries of its callees can only
+	// produce a less precise summary, this reaches a fixed point.
+	var defined []*ssa.Function
+	for _, fn := range fns {
+		if len(fn.Blocks) == 0 {
+			continue
+		}
+		defined = append(defined, fn)
+		s[fn.String()] = &FuncSummary{
+			Params:   make([]ParamFlow, len(fn.Params)),
+			Recovers: callsRecover(fn),
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, fn := range defined {
+			summary := s[fn.String()]
+			for i, param := range fn.Params {
+				e := escapeAnalysis{summaries: s, allowReturn: true}
+				flow := NoEscape
+				if e.escapes(param, fn.Blocks[0], nil) != nil {
+					flow = Escapes
+				} else if e.returned {
+					flow = Returned
+				}
+				if flow > summary.Params[i] {
+					summary.Params[i] = flow
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+const summariesHeader = "llgo escape summaries v2"
+
+// Write writes the summaries of the named functions to w, in a form that
+// can be read by Read. Names without a summary are ignored.
+//
+// Each summary is written on a line of its own, as the function's name,
+// the flows of its parameters and a flag recording whether it calls
+// recover, separated by spaces.
+func (s Summaries) Write(w io.Writer, names []string) error {
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, summariesHeader)
+	for _, name := range names {
+		summary, ok := s[name]
+		if !ok {
+			continue
+		}
+		flows := make([]byte, len(summary.Params))
+		for i, flow := range summary.Params {
+			flows[i] = paramFlowChars[flow]
+		}
+		recovers := '-'
+		if summary.Recovers {
+			recovers = 'R'
+		}
+		fmt.Fprintf(bw, "%s %s %c\n", name, flows, recovers)
+	}
+	return bw.Flush()
+}
+
+// Read reads summaries written by Write from r, and adds them to s.
+func (s Summaries) Read(r io.Reader) error {
+	br := bufio.NewReader(r)
+	header, err := br.ReadString('\n')
+	if err != nil || strings.TrimSpace(header) != summariesHeader {
+		return fmt.Errorf("invalid escape summary header %q", header)
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		sp := strings.LastIndex(line, " ")
+		if sp < 0 {
+			return fmt.Errorf("invalid escape summary %q", line)
+		}
+		rest, flag := line[:sp], line[sp+1:]
+		sp = strings.LastIndex(rest, " ")
+		if sp < 0 || (flag != "-" && flag != "R") {
+			return fmt.Errorf("invalid escape summary %q", line)
+		}
+		name, flowchars := rest[:sp], rest[sp+1:]
+		summary := &FuncSummary{
+			Params:   make([]ParamFlow, len(flowchars)),
+			Recovers: flag == "R",
+		}
+		for i := range flowchars {
+			switch flowchars[i] {
+			case 'n':
+				summary.Params[i] = NoEscape
+			case 'r':
+				summary.Params[i] = Returned
+			case 'e':
+				summary.Params[i] = Escapes
+			default:
+				return fmt.Errorf("invalid escape summary %q", line)
+			}
+		}
+		s[name] = summary
+	}
+}
@@ -0,0 +1,34 @@
+// REQUIRES: gdb
+// RUN: llgo -g -fgdb-script=%S/../../utils/gdb/llgo-gdb.py -o %t %s
+// RUN: gdb -nx -batch -iex 'set auto-load safe-path /' -x %S/Inputs/gdb.gdb %t 2>&1 | FileCheck %s
+
+package main
+
+type T struct {
+	X int
+}
+
+func (t T) String() string {
+	return "T"
+}
+
+type Stringer interface {
+	String() string
+}
+
+func inspect(s string, sl []int, m map[string]int, e interface{}, i Stringer, p interface{}, n interface{}) {
+	println(len(s), len(sl), len(m), e != nil, i != nil, p != nil, n == nil)
+}
+
+// CHECK: $1 = "hello"
+// CHECK: $2 = []int len 3 cap 3 = {1, 2, 3}
+// CHECK: $3 = map[string]int len 1 = {["one"] = 1}
+// CHECK: $4 = (int) 42
+// CHECK: $5 = (main.T) {X = 7}
+// CHECK: $6 = (*main.T) 0x{{[0-9a-f]+}}
+// CHECK: $7 = nil
+// CHECK: {{^[* ] }}{{[0-9]+}} running
+func main() {
+	t := &T{8}
+	inspect("hello", []int{1, 2, 3}, map[string]int{"one": 1}, 42, T{7}, t, nil)
+}
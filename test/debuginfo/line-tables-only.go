@@ -0,0 +1,32 @@
+// RUN: llgo -S -emit-llvm -gline-tables-only -o - %s | FileCheck %s
+// RUN: llgo -S -emit-llvm -gline-tables-only -o - %s | FileCheck --check-prefix=NOVARS %s
+
+// Only functions and locations are described, and the compile unit records
+// the emission kind.
+// CHECK-DAG: !"0x11\0022\00llgo\000\00\000\00\002"
+// CHECK-DAG: !"0x2e\00foo.Sum\00foo.Sum\00foo.Sum\00
+// CHECK-DAG: mul i64 {{.*}}, !dbg !
+
+// Variables, types and lexical blocks are not.
+// NOVARS-NOT: @llvm.dbg.value
+// NOVARS-NOT: @llvm.dbg.declare
+// NOVARS-NOT: !"0x100\00
+// NOVARS-NOT: !"0x101\00
+// NOVARS-NOT: !"0x13\00
+// NOVARS-NOT: !"0xb\00
+
+package foo
+
+type Point struct {
+	X, Y int
+}
+
+func Sum(ps []Point) int {
+	sum := 0
+	for _, p := range ps {
+		if d := p.X * p.Y; d > 0 {
+			sum += d
+		}
+	}
+	return sum
+}
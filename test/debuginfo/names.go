@@ -0,0 +1,39 @@
+// RUN: llgo -S -emit-llvm -g -o - %s | FileCheck %s
+// RUN: llgo -S -emit-llvm -o - %s | llgo-demangle | FileCheck --check-prefix=FILT %s
+
+package foo
+
+type T struct {
+	x int
+}
+
+// Functions are named in Go style, and keep their symbol as their linkage
+// name.
+// CHECK-DAG: !"0x2e\00foo.T.Get\00foo.T.Get\00foo.Get.N5_foo.T\00
+// FILT-DAG: define {{.*}}@foo.T.Get(
+func (t T) Get() int {
+	return t.x
+}
+
+// CHECK-DAG: !"0x2e\00foo.(*T).Set\00foo.(*T).Set\00foo.Set.pN5_foo.T\00
+// FILT-DAG: define {{.*}}@foo.(*T).Set(
+func (t *T) Set(x int) {
+	t.x = x
+}
+
+// CHECK-DAG: !"0x2e\00foo.Counter\00foo.Counter\00foo.Counter\00
+// CHECK-DAG: !"0x2e\00foo.Counter$1\00foo.Counter$1\00foo.Counter:foo.Counter$1\00
+// FILT-DAG: define {{.*}}@"foo.Counter$1"(
+func Counter() func() int {
+	n := 0
+	return func() int {
+		n++
+		return n
+	}
+}
+
+// Other symbols are described by the filter.
+// FILT-DAG: @type descriptor for foo.T =
+// FILT-DAG: @type descriptor for *foo.T =
+// FILT-DAG: @function descriptor for foo.Counter =
+// FILT-DAG: define {{.*}}@foo.init(
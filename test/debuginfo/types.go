@@ -0,0 +1,39 @@
+// RUN: llgo -S -emit-llvm -g -o - %s | FileCheck %s
+
+package foo
+
+// Named structs and their fields are described at their declarations.
+// CHECK-DAG: !"0x13\00Point\009\00
+// CHECK-DAG: !"0xd\00X\0010\00
+// CHECK-DAG: !"0xd\00Y\0011\00
+type Point struct {
+	X int
+	Y int
+}
+
+// Interfaces with methods point to a method table, listing each method.
+// CHECK-DAG: !"0xd\00__methods\00
+// CHECK-DAG: !"0xd\00Area\0020\00
+// CHECK-DAG: !"0xd\00__type_descriptor\00
+// CHECK-DAG: !"0x13\00__go_type_descriptor\00
+type Shape interface {
+	Area() int
+}
+
+// Maps and channels point to libgo's representations.
+// CHECK-DAG: !"0x13\00__go_map\00
+// CHECK-DAG: !"0x13\00__go_map_entry\00
+// CHECK-DAG: !"0xd\00__key\00
+// CHECK-DAG: !"0x13\00Hchan\00
+// CHECK-DAG: !"0xd\00qcount\00
+
+// Closures describe their free variables.
+// CHECK-DAG: !"0x100\00$closure\00
+// CHECK-DAG: !"0xd\00total\00
+func F(s Shape, p Point, m map[string]int, c chan int) func() int {
+	total := p.X + p.Y + len(m) + len(c) + s.Area()
+	return func() int {
+		total++
+		return total
+	}
+}
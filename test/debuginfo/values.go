@@ -0,0 +1,29 @@
+// RUN: llgo -S -emit-llvm -g -o - %s | FileCheck %s
+
+package foo
+
+// Parameters held in registers are described on entry, and variables
+// lifted to registers by their values, including the phis that merge them.
+// CHECK-LABEL: define {{.*}}@foo.Sum
+// CHECK: call void @llvm.dbg.value(metadata {{.*}}, i64 0, metadata [[N:![0-9]+]]
+// CHECK-DAG: call void @llvm.dbg.value(metadata i64 %sum{{[0-9]*}}, i64 0, metadata [[SUM:![0-9]+]]
+// CHECK-DAG: call void @llvm.dbg.value(metadata i64 %i{{[0-9]*}}, i64 0, metadata [[I:![0-9]+]]
+func Sum(n int) int {
+	sum := 0
+	for i := 0; i < n; i++ {
+		if sq := i * i; sq > 10 {
+			sum += sq
+		}
+	}
+	return sum
+}
+
+// Variables are placed in the lexical blocks of the scopes in which they
+// are declared.
+// CHECK-DAG: [[N]] = {{.*}}!"0x101\00n\00{{[^"]*}}", [[SUBPROGRAM:![0-9]+]],
+// CHECK-DAG: [[SUM]] = {{.*}}!"0x100\00sum\00{{[^"]*}}", [[SUBPROGRAM]],
+// CHECK-DAG: [[I]] = {{.*}}!"0x100\00i\00{{[^"]*}}", [[FORBLOCK:![0-9]+]],
+// CHECK-DAG: !"0x100\00sq\00{{[^"]*}}", [[IFBLOCK:![0-9]+]],
+// CHECK-DAG: [[FORBLOCK]] = {{.*}}!"0xb\0013\002\00{{[^"]*}}", {{![0-9]+}}, [[SUBPROGRAM]]}
+// CHECK-DAG: [[FORBODY:![0-9]+]] = {{.*}}!"0xb\0013\0025\00{{[^"]*}}", {{![0-9]+}}, [[FORBLOCK]]}
+// CHECK-DAG: [[IFBLOCK]] = {{.*}}!"0xb\0014\003\00{{[^"]*}}", {{![0-9]+}}, [[FORBODY]]}
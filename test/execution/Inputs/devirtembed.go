@@ -0,0 +1,23 @@
+package devirtembed
+
+type sizer interface {
+	size() int
+}
+
+// Box is the only type in this package implementing sizer, but types
+// embedding it in other packages implement sizer too.
+type Box struct {
+	n int
+}
+
+func (b *Box) size() int {
+	return b.n
+}
+
+func NewBox(n int) *Box {
+	return &Box{n}
+}
+
+func Size(s sizer) int {
+	return s.size()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func count(words []string) map[string]int {
+	m := make(map[string]int)
+	for _, w := range words {
+		m[w]++
+	}
+	return m
+}
+
+func recovered() (ok bool) {
+	defer func() {
+		ok = recover() != nil
+	}()
+	var words []string
+	_ = words[3]
+	return false
+}
+
+func main() {
+	words := strings.Fields("the quick brown fox jumps over the lazy dog")
+	sort.Strings(words)
+	fmt.Println(words[0], words[len(words)-1])
+
+	c := make(chan int)
+	go func() {
+		c <- count(words)["the"]
+	}()
+	fmt.Println(<-c)
+
+	fmt.Println(recovered())
+}
@@ -0,0 +1,64 @@
+// RUN: llgo -fopen-coded-defers -o %t %s
+// RUN: %t > %t1 2>&1
+// RUN: llgo -o %t %s
+// RUN: %t > %t2 2>&1
+// RUN: go run %s > %t3 2>&1
+// RUN: diff -u %t1 %t3
+// RUN: diff -u %t2 %t3
+
+package main
+
+type counter struct {
+	n int
+}
+
+func (c *counter) inc(by int) {
+	c.n += by
+	println("inc", by, c.n)
+}
+
+func show(s string, x int) {
+	println(s, x)
+}
+
+func cond(b bool) {
+	var c counter
+	if b {
+		defer c.inc(1)
+	}
+	defer c.inc(2)
+	if !b {
+		defer c.inc(3)
+	}
+	println("cond", b)
+}
+
+func args() int {
+	x := 1
+	defer show("x at defer", x)
+	x = 2
+	defer func() { show("x at return", x) }()
+	return x
+}
+
+func results() (r int) {
+	defer func() { r *= 3 }()
+	return 7
+}
+
+func earlyReturn(n int) {
+	defer show("first", n)
+	if n > 0 {
+		return
+	}
+	defer show("second", n)
+}
+
+func main() {
+	cond(true)
+	cond(false)
+	println(args())
+	println(results())
+	earlyReturn(0)
+	earlyReturn(1)
+}
@@ -0,0 +1,35 @@
+// RUN: llgo -fopen-coded-defers -o %t %s
+// RUN: not %t 2>&1 | FileCheck %s
+// RUN: not %t 2>&1 | sed '/^panic:/,$d' > %t1
+// RUN: llgo -o %t %s
+// RUN: not %t 2>&1 | FileCheck %s
+// RUN: not %t 2>&1 | sed '/^panic:/,$d' > %t2
+// RUN: not go run %s 2>&1 | sed '/^panic:/,$d' > %t3
+// RUN: diff -u %t1 %t3
+// RUN: diff -u %t2 %t3
+
+// Deferred calls are made as an unrecovered panic unwinds the stack, before
+// the program is terminated. The output before the panic message is compared
+// with that of the gc toolchain.
+
+// CHECK: inner second
+// CHECK-NEXT: inner first
+// CHECK-NEXT: outer
+// CHECK: boom
+
+package main
+
+func show(s string) {
+	println(s)
+}
+
+func inner() {
+	defer show("inner first")
+	defer show("inner second")
+	panic("boom")
+}
+
+func main() {
+	defer show("outer")
+	inner()
+}
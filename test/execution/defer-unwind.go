@@ -0,0 +1,58 @@
+// RUN: llgo -fopen-coded-defers -o %t %s
+// RUN: %t > %t1 2>&1
+// RUN: llgo -o %t %s
+// RUN: %t > %t2 2>&1
+// RUN: go run %s > %t3 2>&1
+// RUN: diff -u %t1 %t3
+// RUN: diff -u %t2 %t3
+
+package main
+
+import "runtime"
+
+func show(s string) {
+	println(s)
+}
+
+func signal(done chan bool) {
+	close(done)
+}
+
+func exit(done chan bool) {
+	defer signal(done)
+	defer show("deferred before Goexit")
+	runtime.Goexit()
+}
+
+func panics() {
+	defer show("callee deferred first")
+	defer show("callee deferred second")
+	panic("boom")
+}
+
+func repanic() {
+	panic("again")
+}
+
+func panicsAgain() {
+	defer show("callee deferred before repanic")
+	defer repanic()
+	panic("boom")
+}
+
+func recovers(f func()) {
+	defer func() {
+		println("recovered", recover().(string))
+	}()
+	defer show("caller deferred")
+	f()
+}
+
+func main() {
+	done := make(chan bool)
+	go exit(done)
+	<-done
+	recovers(panics)
+	recovers(panicsAgain)
+	println("done")
+}
@@ -0,0 +1,21 @@
+// RUN: rm -rf %t && mkdir -p %t
+// RUN: llgo -fgo-pkgpath=devirtembed -c -o %t/devirtembed.o %p/Inputs/devirtembed.go
+// RUN: llgo -I %t -o %t/prog %s %t/devirtembed.o
+// RUN: %t/prog 2>&1 | FileCheck %s
+
+// CHECK: 1
+// CHECK-NEXT: 2
+
+package main
+
+import "devirtembed"
+
+type wrapper struct {
+	pad [4]int
+	*devirtembed.Box
+}
+
+func main() {
+	println(devirtembed.Size(devirtembed.NewBox(1)))
+	println(devirtembed.Size(&wrapper{Box: devirtembed.NewBox(2)}))
+}
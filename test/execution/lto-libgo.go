@@ -0,0 +1,9 @@
+// REQUIRES: lto-libgo
+// RUN: %llgo_lto -flto -O2 -o %t %p/Inputs/lto.go
+// RUN: %t > %t1 2>&1
+// RUN: go run %p/Inputs/lto.go > %t2 2>&1
+// RUN: diff -u %t1 %t2
+
+// The program is linked with LTO together with the bitcode of libgo.
+
+package main
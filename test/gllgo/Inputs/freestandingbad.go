@@ -0,0 +1,9 @@
+package foo
+
+func f() {}
+
+func Unsupported(k int) int {
+	m := make(map[int]int)
+	go f()
+	return m[k]
+}
@@ -0,0 +1,7 @@
+package main
+
+var p = new(int)
+
+func main() {
+	*p = 1
+}
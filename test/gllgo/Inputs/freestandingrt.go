@@ -0,0 +1,20 @@
+// A minimal runtime for freestanding code, which allocates from a fixed
+// arena and never frees.
+
+package rt
+
+import "unsafe"
+
+var arena [4096]byte
+var next uintptr
+
+func New(size uintptr) unsafe.Pointer {
+	p := unsafe.Pointer(&arena[next])
+	next += (size + 7) &^ 7
+	return p
+}
+
+func RuntimeError(code int32) {
+	for {
+	}
+}
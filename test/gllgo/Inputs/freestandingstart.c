@@ -0,0 +1,10 @@
+/* Startup code for a freestanding program, which has no C library. It runs
+   the program, and then spins, as it has no means of exiting. */
+
+int main(void);
+
+void _start(void) {
+  main();
+  for (;;)
+    ;
+}
@@ -0,0 +1,10 @@
+/* A plugin adding LLVM's internalize pass, which gives every function other
+   than main internal linkage. The LLVM C API is provided by llgo. */
+
+typedef struct LLVMOpaquePassManager *LLVMPassManagerRef;
+
+void LLVMAddInternalizePass(LLVMPassManagerRef pm, unsigned allButMain);
+
+void llgo_add_passes(LLVMPassManagerRef pm) {
+  LLVMAddInternalizePass(pm, 1);
+}
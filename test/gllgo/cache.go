@@ -0,0 +1,16 @@
+// RUN: rm -rf %t && mkdir -p %t
+// RUN: llgo -fcache-dir=%t/cache -c -o %t/first.o %s
+// RUN: llgo -fcache-dir=%t/cache -c -o %t/second.o %s
+// RUN: cmp %t/first.o %t/second.o
+// RUN: find %t/cache -type f | wc -l | FileCheck --check-prefix=ONE %s
+// RUN: llgo -fcache-dir=%t/cache -O2 -c -o %t/third.o %s
+// RUN: find %t/cache -type f | wc -l | FileCheck --check-prefix=TWO %s
+
+// ONE: {{^ *1$}}
+// TWO: {{^ *2$}}
+
+package gotest
+
+func F() int {
+	return 42
+}
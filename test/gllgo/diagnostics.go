@@ -0,0 +1,21 @@
+// RUN: not llgo -S -o /dev/null %s 2>&1 | FileCheck %s
+// RUN: not llgo -fdiagnostics-format=json -S -o /dev/null %s 2>&1 | FileCheck --check-prefix=JSON %s
+// RUN: not llgo -fdiagnostics-format=xml -S -o /dev/null %s 2>&1 | FileCheck --check-prefix=FORMAT %s
+
+// CHECK: diagnostics.go:15:1: error: unknown #llgo attribute "frobnicate"
+// CHECK-NEXT: diagnostics.go:19:1: error: unknown #llgo attribute "twiddle"
+
+// JSON: {"file":"{{.*}}diagnostics.go","line":15,"column":1,"severity":"error","message":"unknown #llgo attribute \"frobnicate\""}
+// JSON-NEXT: {"file":"{{.*}}diagnostics.go","line":19,"column":1,"severity":"error","message":"unknown #llgo attribute \"twiddle\""}
+
+// FORMAT: gllgo: error: unknown diagnostics format 'xml'
+
+package foo
+
+// #llgo frobnicate
+func F() {
+}
+
+// #llgo twiddle
+func G() {
+}
@@ -0,0 +1,34 @@
+// RUN: llgo -fdump-escape -S -o /dev/null %s 2>&1 | FileCheck %s
+// RUN: llgo -fdump-escape=json -S -o /dev/null %s 2>&1 | FileCheck --check-prefix=JSON %s
+
+package foo
+
+var global *int
+
+func Escapes() int {
+	x := 0
+	global = &x
+	return x
+}
+
+func Stack() int {
+	y := 0
+	p := &y
+	return *p
+}
+
+// CHECK: dumpescape.go:9:2: foo.Escapes: x escapes to heap via "{{.*}}" at {{.*}}dumpescape.go:10:{{[0-9]+}}
+// CHECK-NEXT: dumpescape.go:15:2: foo.Stack: y moved to stack
+
+// JSON: "pos": "{{.*}}dumpescape.go:9:2",
+// JSON-NEXT: "func": "foo.Escapes",
+// JSON-NEXT: "var": "x",
+// JSON-NEXT: "stack": false,
+// JSON-NEXT: "escapes": true,
+// JSON-NEXT: "cause": "{{.*}}",
+// JSON-NEXT: "causePos": "{{.*}}dumpescape.go:10:{{[0-9]+}}"
+// JSON: "pos": "{{.*}}dumpescape.go:15:2",
+// JSON-NEXT: "func": "foo.Stack",
+// JSON-NEXT: "var": "y",
+// JSON-NEXT: "stack": true,
+// JSON-NEXT: "escapes": false
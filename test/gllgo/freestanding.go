@@ -0,0 +1,53 @@
+// RUN: rm -rf %t && mkdir -p %t
+// RUN: llgo -ffreestanding -fruntime-package=rt -fgo-pkgpath=rt -c -o %t/rt.o %S/Inputs/freestandingrt.go
+// RUN: llgo -ffreestanding -fruntime-package=rt -I %t -S -emit-llvm -o - %s | FileCheck %s
+// RUN: llgo -ffreestanding -fruntime-package=rt -I %t -S -emit-llvm -o - %s | FileCheck --check-prefix=NOLIBGO %s
+// RUN: not llgo -ffreestanding -fruntime-package=rt -I %t -S -o /dev/null %S/Inputs/freestandingbad.go 2>&1 | FileCheck --check-prefix=UNSUPPORTED %s
+// RUN: not llgo -ffreestanding -S -o /dev/null %S/Inputs/freestandingbad.go 2>&1 | FileCheck --check-prefix=NORUNTIME %s
+// RUN: not llgo -fruntime-package=rt -S -o /dev/null %s 2>&1 | FileCheck --check-prefix=FLAGS %s
+// RUN: llgo -ffreestanding -fruntime-package=rt -I %t -S -emit-llvm -o - %S/Inputs/freestandingmain.go | FileCheck --check-prefix=MAIN %s
+// RUN: llgo -ffreestanding -fruntime-package=rt -I %t -o %t/prog %S/Inputs/freestandingmain.go %t/rt.o %S/Inputs/freestandingstart.c
+// RUN: nm %t/prog | FileCheck --check-prefix=LINK %s
+// RUN: not llgo -target wasm32-unknown-unknown -ffreestanding -fruntime-package=rt -I %t -S -emit-llvm -o /dev/null %s 2>&1 | FileCheck --check-prefix=NOFLAG %s
+
+// NOLIBGO-NOT: call {{.*}}@__go_
+// NOLIBGO-NOT: split-stack
+
+// UNSUPPORTED: freestandingbad.go:6:{{[0-9]+}}: error: maps not supported in freestanding mode: runtime package "rt" does not define NewMap
+// UNSUPPORTED-NEXT: freestandingbad.go:7:{{[0-9]+}}: error: go statements not supported in freestanding mode: runtime package "rt" does not define Go
+// UNSUPPORTED-NEXT: freestandingbad.go:8:{{[0-9]+}}: error: maps not supported in freestanding mode: runtime package "rt" does not define MapIndex
+
+// NORUNTIME: freestandingbad.go:6:{{[0-9]+}}: error: maps not supported in freestanding mode without a runtime package
+
+// FLAGS: gllgo: error: '-fruntime-package' requires '-ffreestanding'
+
+// Main packages define the C entry point, which initializes the program and
+// calls main.main.
+// MAIN-LABEL: define i32 @main()
+// MAIN: call void @__go_init_main()
+// MAIN-NEXT: call void @main.main()
+// MAIN-NEXT: ret i32 0
+
+// Programs are linked without the C library and startup code, so the link
+// only succeeds if they depend on nothing but the runtime package and the
+// given startup code.
+// LINK-DAG: T _start
+// LINK-DAG: T main{{$}}
+// LINK-DAG: T main.main
+// LINK-DAG: T rt.New
+
+// NOFLAG: gllgo: error: runtime package "rt" does not define Panicking, which is required for WebAssembly targets
+
+package foo
+
+// CHECK-LABEL: define {{.*}}@foo.Alloc
+// CHECK: call {{.*}}@rt.New
+func Alloc() *int {
+	return new(int)
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Index
+// CHECK: call {{.*}}@rt.RuntimeError
+func Index(s []int, i int) int {
+	return s[i]
+}
@@ -0,0 +1,21 @@
+// RUN: %cc -shared -fPIC -o %t.so %S/Inputs/internalize-plugin.c
+// RUN: llgo -flto -fload-plugin %t.so -fplugin-ep=codegen -o %t %s
+// RUN: nm %t | FileCheck -check-prefix=PLUGIN %s
+// RUN: llgo -flto -o %t %s
+// RUN: nm %t | FileCheck -check-prefix=NOPLUGIN %s
+
+// With LTO, the passes of plugins at the codegen extension point run on the
+// linked module.
+
+// PLUGIN: t main.helper
+// NOPLUGIN: T main.helper
+
+package main
+
+func helper() int {
+	return 1
+}
+
+func main() {
+	println(helper())
+}
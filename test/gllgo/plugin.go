@@ -0,0 +1,18 @@
+// RUN: not llgo -fplugin-ep=bogus -S -o /dev/null %s 2>&1 | FileCheck -check-prefix=EP %s
+// RUN: not llgo -fload-plugin %t-missing.so -S -o /dev/null %s 2>&1 | FileCheck -check-prefix=LOAD %s
+// RUN: llgo -fplugin-ep=codegen -S -o /dev/null %s
+// RUN: %cc -shared -fPIC -o %t.so %S/Inputs/internalize-plugin.c
+// RUN: llgo -fload-plugin %t.so -fplugin-ep=codegen -S -emit-llvm -o - %s | FileCheck -check-prefix=PLUGIN %s
+// RUN: llgo -S -emit-llvm -o - %s | FileCheck -check-prefix=NOPLUGIN %s
+
+// EP: gllgo: error: unknown plugin extension point 'bogus'
+// LOAD: gllgo: error: could not load plugin '{{.*}}-missing.so'
+
+// PLUGIN: define internal {{.*}}@foo.F
+// NOPLUGIN: define i64 @foo.F
+
+package foo
+
+func F() int {
+	return 1
+}
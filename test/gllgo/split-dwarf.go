@@ -0,0 +1,28 @@
+// RUN: rm -rf %t && mkdir -p %t
+// RUN: llgo -gsplit-dwarf -S -emit-llvm -o %t/foo.ll %s
+// RUN: FileCheck --check-prefix=IR %s < %t/foo.ll
+// RUN: llgo -gsplit-dwarf -c -o %t/foo.o %s
+// RUN: readelf -S -W %t/foo.o | FileCheck --check-prefix=OBJ %s
+// RUN: readelf -S -W %t/foo.dwo | FileCheck --check-prefix=DWO %s
+// RUN: llgo -gsplit-dwarf -gz -c -o %t/bar.o %s
+// RUN: readelf -S -W %t/bar.o | FileCheck --check-prefix=GZ %s
+// RUN: not llgo -gz=lzma -c -o %t/baz.o %s 2>&1 | FileCheck --check-prefix=BAD %s
+
+// The compile unit names the .dwo file, which is named after the output.
+// IR: !"0x11\0022\00llgo\000\00\000\00{{.*}}/foo.dwo\001"
+
+// OBJ-NOT: .dwo
+// OBJ: .debug_info
+// OBJ-NOT: .dwo
+
+// DWO: .debug_info.dwo
+
+// GZ: .debug_info {{.*}} C
+
+// BAD: unsupported argument 'lzma' to '-gz'
+
+package foo
+
+func F(x int) int {
+	return x * 2
+}
@@ -0,0 +1,40 @@
+// RUN: llgo -S -o - %s | FileCheck %s
+// RUN: llgo -fno-split-stack -S -o - %s | FileCheck --check-prefix=NOSPLIT %s
+// RUN: llgo -fno-split-stack -S -emit-llvm -o - %s | FileCheck --check-prefix=IR %s
+// RUN: llgo -fno-split-stack -S -emit-llvm -o - %s | FileCheck --check-prefix=SIZES %s
+// RUN: llgo -fno-split-stack -print-multi-os-directory | FileCheck --check-prefix=VARIANT %s
+// RUN: llgo -S -emit-llvm -o - %s | FileCheck --check-prefix=SPLITIR %s
+// RUN: not llgo -fno-split-stack -fsanitize=address -S -o /dev/null %s 2>&1 | FileCheck --check-prefix=COMBINE %s
+// RUN: not llgo -fno-split-stack -flto -S -o /dev/null %s 2>&1 | FileCheck --check-prefix=COMBINE %s
+
+// CHECK-LABEL: foo.F:
+// CHECK: __morestack
+
+// NOSPLIT-NOT: __morestack
+
+// IR-NOT: "split-stack"
+// IR: !{i32 1, !"Go Split Stack", i32 0}
+
+// The stack space used by the locals of each function is recorded.
+// SIZES: !go.stack_sizes =
+// SIZES: !{i64 (i64, i64)* @foo.G, i64 512}
+// SPLITIR-NOT: go.stack_sizes
+
+// COMBINE: gllgo: error: '-fno-split-stack' cannot be combined with '-fsanitize' or '-flto'
+
+// VARIANT: llvm-nosplit.0
+
+package foo
+
+func g(*[64]int)
+
+func F() {
+	var a [64]int
+	g(&a)
+}
+
+func G(i, j int) int {
+	var a [64]int
+	a[i] = 1
+	return a[j]
+}
@@ -0,0 +1,25 @@
+// RUN: not llgo -fssa-passes=bogus -S -o /dev/null %s 2>&1 | FileCheck %s
+// RUN: llgo -fssa-passes= -S -o /dev/null %s
+// RUN: llgo -S -emit-llvm -o - %s | FileCheck --check-prefix=STACK %s
+// RUN: llgo -fssa-passes=devirt,bce -S -emit-llvm -o - %s | FileCheck --check-prefix=NOSTACK %s
+
+// CHECK: gllgo: error: unknown SSA pass 'bogus'
+
+package foo
+
+func F() int {
+	return 1
+}
+
+// STACK-LABEL: define {{.*}}@foo.G
+// STACK-NOT: @__go_new
+// STACK: ret
+
+// NOSTACK-LABEL: define {{.*}}@foo.G
+// NOSTACK: call {{.*}}@__go_new
+func G() int {
+	x := 0
+	p := &x
+	*p = 2
+	return x
+}
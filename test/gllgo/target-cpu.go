@@ -0,0 +1,36 @@
+// RUN: llgo -march=haswell -mtune=generic -mno-avx2 -msse4.2 -mavx512vl -m64 -S -emit-llvm -o - %s | FileCheck %s
+// RUN: llgo -mno-red-zone -S -emit-llvm -o - %s | FileCheck -check-prefix=REDZONE %s
+// RUN: not llgo -march=native -S -o /dev/null %s 2>&1 | FileCheck -check-prefix=NATIVE %s
+// RUN: llgo -target armv7-unknown-linux-gnueabihf -march=armv8-a+crc -S -emit-llvm -o - %s | FileCheck -check-prefix=ARM %s
+// RUN: llgo -target aarch64-unknown-linux-gnu -march=armv8-a+nofp+crypto -S -emit-llvm -o - %s | FileCheck -check-prefix=AARCH64 %s
+// RUN: not llgo -target x86_64-unknown-linux-gnu -march=haswell+avx2 -S -o /dev/null %s 2>&1 | FileCheck -check-prefix=EXT %s
+// RUN: not llgo -target armv7-unknown-linux-gnueabihf -march=armv7ve -S -o /dev/null %s 2>&1 | FileCheck -check-prefix=ARMARCH %s
+// RUN: not llgo -mcx8 -S -o /dev/null %s 2>&1 | FileCheck -check-prefix=ISA %s
+// RUN: not llgo -mno-cx8 -S -o /dev/null %s 2>&1 | FileCheck -check-prefix=NOISA %s
+// RUN: not llgo -target aarch64-unknown-linux-gnu -mavx2 -S -o /dev/null %s 2>&1 | FileCheck -check-prefix=ISATARGET %s
+
+// CHECK: define {{.*}}@foo.F{{.*}} #[[ATTRS:[0-9]+]]
+// CHECK: attributes #[[ATTRS]] = {{{.*}}"target-cpu"="haswell" "target-features"="-avx2,+sse4.2,+avx512vl"
+
+// REDZONE: define {{.*}}@foo.F{{.*}} #[[ATTRS:[0-9]+]]
+// REDZONE: attributes #[[ATTRS]] = {{{.*}}noredzone
+
+// NATIVE: gllgo: error: '-march=native' is not supported; name the CPU explicitly
+
+// ARM: define {{.*}}@foo.F{{.*}} #[[ATTRS:[0-9]+]]
+// ARM: attributes #[[ATTRS]] = {{{.*}}"target-cpu"="cortex-a53" "target-features"="+crc"
+
+// AARCH64: define {{.*}}@foo.F{{.*}} #[[ATTRS:[0-9]+]]
+// AARCH64: attributes #[[ATTRS]] = {{{.*}}"target-cpu"="generic" "target-features"="-fp-armv8,+crypto"
+
+// EXT: gllgo: error: '-march=haswell+avx2' is not supported for target 'x86_64-unknown-linux-gnu'
+// ARMARCH: gllgo: error: '-march=armv7ve' is not supported for target 'armv7-unknown-linux-gnueabihf'
+// ISA: gllgo: error: unrecognized command line option '-mcx8'
+// NOISA: gllgo: error: unrecognized command line option '-mno-cx8'
+// ISATARGET: gllgo: error: '-mavx2' is not supported for target 'aarch64-unknown-linux-gnu'
+
+package foo
+
+func F() int {
+	return 1
+}
@@ -0,0 +1,37 @@
+// RUN: not llgo -S -o /dev/null %s 2>&1 | FileCheck %s
+// RUN: not llgo -fmax-errors=2 -S -o /dev/null %s 2>&1 | FileCheck --check-prefix=MAX %s
+// RUN: not llgo -fdiagnostics-format=json -S -o /dev/null %s 2>&1 | FileCheck --check-prefix=JSON %s
+// RUN: not llgo -fmax-errors=x -S -o /dev/null %s 2>&1 | FileCheck --check-prefix=FLAG %s
+
+// Every type error is reported, in order of position, including the soft
+// errors which the type checker reports after the others. Soft errors are
+// marked as such in both formats.
+
+// CHECK: type-errors.go:27:8: soft error: {{.*}}"os" imported but not used
+// CHECK-NEXT: type-errors.go:30:2: soft error: {{.*}}x declared but not used
+// CHECK-NEXT: type-errors.go:31:9: error: undeclared name: y
+// CHECK-NEXT: type-errors.go:35:9: error: undeclared name: z
+
+// MAX: type-errors.go:27:8: soft error:
+// MAX-NEXT: type-errors.go:30:2: soft error:
+// MAX-NEXT: note: compilation terminated due to -fmax-errors=2
+// MAX-NOT: error
+
+// JSON: {"file":"{{.*}}type-errors.go","line":27,"column":8,"severity":"error","soft":true,
+// JSON-NEXT: {"file":"{{.*}}type-errors.go","line":30,"column":2,"severity":"error","soft":true,
+// JSON-NEXT: {"file":"{{.*}}type-errors.go","line":31,"column":9,"severity":"error","message":"undeclared name: y"}
+
+// FLAG: gllgo: error: argument to '-fmax-errors' should be a non-negative integer
+
+package foo
+
+import "os"
+
+func F() int {
+	x := 1
+	return y
+}
+
+func G() int {
+	return z
+}
@@ -0,0 +1,11 @@
+package escapelib
+
+var global *int
+
+func Set(p *int) {
+	*p = 1
+}
+
+func Leak(p *int) {
+	global = p
+}
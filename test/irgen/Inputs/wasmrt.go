@@ -0,0 +1,49 @@
+// A runtime for the panic flag model used on WebAssembly targets. Panics
+// set the flag and return, and are propagated by the generated code. The
+// runtime only provides what is needed to compile defer statements and
+// recover: deferred calls are never made, and panics are never recovered.
+
+package rt
+
+import "unsafe"
+
+// Panicking is the panic flag, which is set while a panic propagates.
+var Panicking bool
+
+var arena [4096]byte
+var next uintptr
+
+func New(size uintptr) unsafe.Pointer {
+	p := unsafe.Pointer(&arena[next])
+	next += (size + 7) &^ 7
+	return p
+}
+
+func Panic(e interface{}) {
+	Panicking = true
+}
+
+func RuntimeError(code int32) {
+	Panicking = true
+}
+
+func Defer(frame, fn, arg unsafe.Pointer) {
+}
+
+func Undefer(frame unsafe.Pointer) {
+}
+
+func CheckDefer(frame unsafe.Pointer) {
+}
+
+func SetDeferRetaddr(retaddr unsafe.Pointer) bool {
+	return false
+}
+
+func CanRecover(retaddr unsafe.Pointer) bool {
+	return false
+}
+
+func Recover() interface{} {
+	return nil
+}
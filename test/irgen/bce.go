@@ -0,0 +1,67 @@
+// RUN: llgo -fdump-bce -S -emit-llvm -o %t.ll %s 2> %t.bce
+// RUN: FileCheck %s < %t.ll
+// RUN: FileCheck --check-prefix=DUMP %s < %t.bce
+// RUN: llgo -fssa-passes= -S -emit-llvm -o - %s | FileCheck --check-prefix=NOBCE %s
+
+package foo
+
+// CHECK-LABEL: define {{.*}}@foo.Const
+// CHECK-NOT: @__go_runtime_error
+// CHECK: ret
+func Const(a [4]int) int {
+	return a[2]
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Loop
+// CHECK-NOT: @__go_runtime_error
+// CHECK: ret
+// NOBCE-LABEL: define {{.*}}@foo.Loop
+// NOBCE: @__go_runtime_error
+func Loop(s []int) int {
+	t := 0
+	for i := 0; i < len(s); i++ {
+		t += s[i]
+	}
+	return t
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Range
+// CHECK-NOT: @__go_runtime_error
+// CHECK: ret
+func Range(s []int) {
+	for i := range s {
+		s[i] = 0
+	}
+}
+
+// CHECK-LABEL: define {{.*}}@foo.String
+// CHECK-NOT: @__go_runtime_error
+// CHECK: ret
+func String(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		n += int(s[i])
+	}
+	return n
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Twice
+// CHECK: call {{.*}}@__go_runtime_error
+// CHECK-NOT: @__go_runtime_error
+// CHECK: ret
+func Twice(s []int, i int) int {
+	return s[i] + s[i]
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Unknown
+// CHECK: call {{.*}}@__go_runtime_error
+func Unknown(s []int, i int) int {
+	return s[i]
+}
+
+// DUMP: bce.go:12:10: foo.Const: bounds check for {{.*}} eliminated: constant index
+// DUMP-NEXT: bce.go:23:9: foo.Loop: bounds check for {{.*}} eliminated: induction variable bounded by len
+// DUMP-NEXT: bce.go:{{[0-9]+}}:{{[0-9]+}}: foo.Range: bounds check for {{.*}} eliminated: induction variable bounded by len
+// DUMP-NEXT: bce.go:43:13: foo.String: bounds check for {{.*}} eliminated: induction variable bounded by len
+// DUMP-NEXT: bce.go:53:17: foo.Twice: bounds check for {{.*}} eliminated: dominated by identical check
+// DUMP-NOT: foo.Unknown
@@ -0,0 +1,60 @@
+// RUN: llgo -target i686-linux-gnu -S -emit-llvm -o - %s | FileCheck %s
+
+// The expected signatures are those produced by clang for the equivalent C
+// declarations.
+
+package foo
+
+type Small struct {
+	a int8
+	b int16
+}
+
+type Big struct{ a, b, c, d, e int32 }
+
+// float Scalars(_Bool a, long long b, double c);
+// CHECK: define float @foo.Scalars(i8{{[^,]*}}, i64{{[^,]*}}, double{{[^,]*}})
+func Scalars(a bool, b int64, c float64) float32 {
+	return 0
+}
+
+// int PassString(struct { char *p; int n; } s);
+// CHECK: define i32 @foo.PassString(i8*{{[^,]*}}, i32{{[^,]*}})
+func PassString(s string) int {
+	return len(s)
+}
+
+// struct { char *p; int n; } PassSlice(struct { char *p; int len, cap; } s);
+// CHECK: define void @foo.PassSlice({{.*}}* sret{{[^,]*}}, i8*{{[^,]*}}, i32{{[^,]*}}, i32{{[^,]*}})
+func PassSlice(s []int32) string {
+	return ""
+}
+
+// _Complex double PassComplex(_Complex float c);
+// CHECK: define void @foo.PassComplex({ double, double }* sret{{[^,]*}}, float{{[^,]*}}, float{{[^,]*}})
+func PassComplex(c complex64) complex128 {
+	return 0
+}
+
+// _Complex float ReturnComplex64(void);
+// CHECK: define i64 @foo.ReturnComplex64()
+func ReturnComplex64() complex64 {
+	return 0
+}
+
+// void PassSmall(struct Small s);
+// CHECK: define void @foo.PassSmall({{.*}}* byval{{[^,]*}})
+func PassSmall(s Small) {
+}
+
+// struct Big PassBig(struct Big b);
+// CHECK: define void @foo.PassBig({{.*}}* sret{{[^,]*}}, {{.*}}* byval{{[^,]*}})
+func PassBig(b Big) Big {
+	return b
+}
+
+// struct { int a, b; } Multi(void);
+// CHECK: define void @foo.Multi({{.*}}* sret{{[^,]*}})
+func Multi() (int32, int32) {
+	return 0, 0
+}
@@ -0,0 +1,58 @@
+// RUN: llgo -target aarch64-linux-gnu -S -emit-llvm -o %t.ll %s
+// RUN: FileCheck %s < %t.ll
+// RUN: FileCheck -check-prefix=NOBYVAL %s < %t.ll
+
+// NOBYVAL-NOT: byval
+
+package foo
+
+type HFA3 struct{ a, b, c float32 }
+
+type HFA2D struct{ a, b float64 }
+
+type Mixed struct {
+	a float32
+	b int32
+}
+
+type Mid struct {
+	a int64
+	b int32
+}
+
+type Big struct{ a, b, c int64 }
+
+// CHECK: define float @foo.Scalars(i8{{[^,]*}}, i64{{[^,]*}}, double{{[^,]*}})
+func Scalars(a bool, b int64, c float64) float32 {
+	return 0
+}
+
+// CHECK: define [2 x double] @foo.PassHFA([3 x float]{{[^,]*}})
+func PassHFA(h HFA3) HFA2D {
+	return HFA2D{}
+}
+
+// CHECK: define [2 x float] @foo.PassComplex([2 x double]{{[^,]*}})
+func PassComplex(c complex128) complex64 {
+	return 0
+}
+
+// CHECK: define [2 x i64] @foo.PassSmall(i64{{[^,]*}}, [2 x i64]{{[^,]*}})
+func PassSmall(m Mixed, n Mid) Mid {
+	return n
+}
+
+// CHECK: define [2 x i64] @foo.PassString([2 x i64]{{[^,]*}})
+func PassString(s string) string {
+	return s
+}
+
+// CHECK: define [2 x i64] @foo.Multi()
+func Multi() (int32, float64) {
+	return 0, 0
+}
+
+// CHECK: define void @foo.PassBig({{.*}}* sret{{[^,]*}}, {{.*}}*{{[^,]*}})
+func PassBig(b Big) Big {
+	return b
+}
@@ -0,0 +1,48 @@
+// RUN: llgo -target armv7-linux-gnueabihf -S -emit-llvm -o - %s | FileCheck -check-prefix=CHECK -check-prefix=HF %s
+// RUN: llgo -target armv7-linux-gnueabi -S -emit-llvm -o - %s | FileCheck -check-prefix=CHECK -check-prefix=SOFT %s
+
+// The expected signatures are those produced by clang for the equivalent C
+// declarations, other than that composites of up to four bytes are passed
+// as an integer of their size rather than as [1 x i32].
+
+package foo
+
+type HFA3 struct{ a, b, c float32 }
+
+type HFA2D struct{ a, b float64 }
+
+type Small struct {
+	a int8
+	b int16
+}
+
+type Mixed struct {
+	a float32
+	b int32
+}
+
+type Aligned struct {
+	a int64
+	b int32
+}
+
+type Big struct{ a [17]int32 }
+
+// struct HFA2D PassHFA(struct HFA3 h);
+// HF: define [2 x double] @foo.PassHFA([3 x float]{{[^,]*}})
+// SOFT: define void @foo.PassHFA({{.*}}* sret{{[^,]*}}, [3 x i32]{{[^,]*}})
+func PassHFA(h HFA3) HFA2D {
+	return HFA2D{}
+}
+
+// struct Small PassSmall(struct Mixed m, struct Aligned a);
+// CHECK: define i32 @foo.PassSmall([2 x i32]{{[^,]*}}, [2 x i64]{{[^,]*}})
+func PassSmall(m Mixed, a Aligned) Small {
+	return Small{}
+}
+
+// struct Mixed PassBig(struct Big b);
+// CHECK: define void @foo.PassBig({{.*}}* sret{{[^,]*}}, {{.*}}* byval{{[^,]*}})
+func PassBig(b Big) Mixed {
+	return Mixed{}
+}
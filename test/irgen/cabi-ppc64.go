@@ -0,0 +1,9 @@
+// RUN: not llgo -target powerpc64-linux-gnu -S -o /dev/null %s 2>&1 | FileCheck %s
+
+// CHECK: gllgo: error: the C ABI of big-endian PowerPC64 is not supported: powerpc64-linux-gnu
+
+package foo
+
+func F(a, b float64) complex128 {
+	return complex(a, b)
+}
@@ -0,0 +1,35 @@
+// RUN: llgo -target powerpc64le-linux-gnu -S -emit-llvm -o - %s | FileCheck %s
+
+// The expected signatures are those produced by clang for the equivalent C
+// declarations under the ELFv2 ABI.
+
+package foo
+
+type HFA8 struct{ a, b, c, d, e, f, g, h float32 }
+
+type Mid struct {
+	a int64
+	b int32
+}
+
+type Big struct{ a, b, c int64 }
+
+type Huge struct{ a [9]int64 }
+
+// struct HFA8 PassHFA(struct HFA8 h);
+// CHECK: define [8 x float] @foo.PassHFA([8 x float]{{[^,]*}})
+func PassHFA(h HFA8) HFA8 {
+	return h
+}
+
+// struct Mid PassMid(struct Mid m, struct { char *p; long n; } s);
+// CHECK: define { i64, i64 } @foo.PassMid([2 x i64]{{[^,]*}}, [2 x i64]{{[^,]*}})
+func PassMid(m Mid, s string) Mid {
+	return m
+}
+
+// struct Big PassBig(struct Big b, struct Huge h);
+// CHECK: define void @foo.PassBig({{.*}}* sret{{[^,]*}}, [3 x i64]{{[^,]*}}, {{.*}}* byval{{[^,]*}})
+func PassBig(b Big, h Huge) Big {
+	return b
+}
@@ -0,0 +1,82 @@
+// RUN: llgo -fdump-trace -S -emit-llvm -o %t.ll %s 2> %t.trace
+// RUN: FileCheck %s < %t.ll
+// RUN: FileCheck --check-prefix=TRACE %s < %t.trace
+// RUN: FileCheck --check-prefix=NOTRACE %s < %t.trace
+
+package foo
+
+type Stringer interface {
+	String() string
+}
+
+type T int
+
+func (T) String() string {
+	return "T"
+}
+
+type shape interface {
+	area() int
+}
+
+type square struct {
+	side int
+}
+
+func (s *square) area() int {
+	return s.side * s.side
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Area
+// CHECK: call {{.*}}@foo.area.{{.*}}square
+// CHECK: ret
+func Area(s shape) int {
+	return s.area()
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Direct
+// CHECK: call {{.*}}@foo.String.{{.*}}T
+// CHECK: ret
+func Direct(t T) string {
+	var s Stringer = t
+	return s.String()
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Dynamic
+// CHECK-NOT: @foo.String.{{.*}}T
+// CHECK: ret
+func Dynamic(s Stringer) string {
+	return s.String()
+}
+
+func NewShape(side int) shape {
+	return &square{side}
+}
+
+// TRACE-DAG: Devirtualized call to (*foo.square).area at {{.*}}devirt.go:33:
+// TRACE-DAG: Devirtualized call to (foo.T).String at {{.*}}devirt.go:41:
+// TRACE-NOT: devirt.go:48:
+
+type sizer interface {
+	size() int
+}
+
+type Box struct {
+	n int
+}
+
+func (b *Box) size() int {
+	return b.n
+}
+
+// Box is the only type in the package implementing sizer, but types in
+// other packages embedding it implement sizer too.
+
+// CHECK-LABEL: define {{.*}}@foo.Size
+// CHECK-NOT: @foo.size.{{.*}}Box
+// CHECK: ret
+func Size(s sizer) int {
+	return s.size()
+}
+
+// NOTRACE-NOT: Devirtualized call to (*foo.Box).size
@@ -0,0 +1,58 @@
+// RUN: llgo -fdump-escape -S -emit-llvm -o %t.ll %s 2> %t.escape
+// RUN: FileCheck %s < %t.ll
+// RUN: FileCheck --check-prefix=DUMP %s < %t.escape
+
+package foo
+
+var global []byte
+var globalMap map[int]int
+
+// CHECK-LABEL: define {{.*}}@foo.Closure
+// CHECK-NOT: @__go_new
+// CHECK: ret
+func Closure() int {
+	x := 0
+	inc := func() { x++ }
+	inc()
+	inc()
+	return x
+}
+
+// CHECK-LABEL: define {{.*}}@foo.EscapingMap
+// CHECK: call {{.*}}@__go_new_map
+func EscapingMap() {
+	globalMap = make(map[int]int)
+}
+
+// CHECK-LABEL: define {{.*}}@foo.EscapingSlice
+// CHECK: call {{.*}}@__go_make_slice
+func EscapingSlice() {
+	global = make([]byte, 64)
+}
+
+// Maps that do not escape are still allocated by the runtime, but are
+// reported as not escaping.
+// CHECK-LABEL: define {{.*}}@foo.Map
+// CHECK: call {{.*}}@__go_new_map
+func Map() int {
+	m := make(map[int]int)
+	m[1] = 2
+	return m[1]
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Slice
+// CHECK: alloca [64 x i8]
+// CHECK-NOT: @__go_make_slice
+// CHECK: ret
+func Slice() byte {
+	s := make([]byte, 64)
+	s[0] = 1
+	return s[0]
+}
+
+// DUMP: foo.Closure: x moved to stack
+// DUMP: foo.Closure: make closure {{.*}} moved to stack
+// DUMP: foo.EscapingMap: make map[int]int {{.*}} escapes to heap
+// DUMP: foo.EscapingSlice: make []byte {{.*}} escapes to heap
+// DUMP: foo.Map: make map[int]int {{.*}} does not escape, but is allocated by the runtime
+// DUMP: foo.Slice: make []byte {{.*}} moved to stack
@@ -0,0 +1,61 @@
+// RUN: rm -rf %t && mkdir -p %t
+// RUN: llgo -fgo-pkgpath=escapelib -c -o %t/escapelib.o %S/Inputs/escapelib.go
+// RUN: llgo -I %t -S -emit-llvm -o - %s | FileCheck %s
+
+package foo
+
+import "escapelib"
+
+var global *int
+
+func id(p *int) *int {
+	return p
+}
+
+func leak(p *int) {
+	global = p
+}
+
+func set(p *int) {
+	*p = 1
+}
+
+// CHECK-LABEL: define {{.*}}@foo.ImportedLeak
+// CHECK: call {{.*}}@__go_new
+func ImportedLeak() int {
+	x := 0
+	escapelib.Leak(&x)
+	return x
+}
+
+// CHECK-LABEL: define {{.*}}@foo.ImportedNoEscape
+// CHECK-NOT: @__go_new
+func ImportedNoEscape() int {
+	x := 0
+	escapelib.Set(&x)
+	return x
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Leak
+// CHECK: call {{.*}}@__go_new
+func Leak() int {
+	x := 0
+	leak(&x)
+	return x
+}
+
+// CHECK-LABEL: define {{.*}}@foo.NoEscape
+// CHECK-NOT: @__go_new
+func NoEscape() int {
+	x := 0
+	set(&x)
+	return x
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Returned
+// CHECK-NOT: @__go_new
+// CHECK: ret
+func Returned() int {
+	x := 0
+	return *id(&x)
+}
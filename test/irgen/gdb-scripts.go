@@ -0,0 +1,19 @@
+// RUN: llgo -g -fgdb-script=/path/to/llgo-gdb.py -S -emit-llvm -o - %s | FileCheck %s
+// RUN: llgo -g -fgdb-script=/path/to/llgo-gdb.py -S -o - %s | FileCheck --check-prefix=ASM %s
+// RUN: llgo -g -fgdb-script=/path/to/llgo-gdb.py -fno-gdb-script -S -emit-llvm -o - %s | FileCheck --check-prefix=NONE %s
+// RUN: llgo -fgdb-script=/path/to/llgo-gdb.py -S -emit-llvm -o - %s | FileCheck --check-prefix=NONE %s
+
+// The main package records the path of the gdb script when generating debug
+// information, in a section of mergeable strings.
+
+// CHECK: module asm ".section \22.debug_gdb_scripts\22, \22MS\22,@progbits,1"
+// CHECK-NEXT: module asm ".byte 1,47,112,97,116,104,47,116,111,47,108,108,103,111,45,103,100,98,46,112,121,0"
+
+// ASM: .section ".debug_gdb_scripts", "MS",@progbits,1
+
+// NONE-NOT: .debug_gdb_scripts
+
+package main
+
+func main() {
+}
@@ -0,0 +1,39 @@
+// RUN: llgo -fopen-coded-defers -S -emit-llvm -o - %s | FileCheck %s
+// RUN: llgo -S -emit-llvm -o - %s | FileCheck --check-prefix=RUNTIME %s
+// RUN: llgo -fopen-coded-defers -fno-open-coded-defers -S -emit-llvm -o - %s | FileCheck --check-prefix=RUNTIME %s
+
+package foo
+
+func unlock(p *int) {
+	*p = 0
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Loop
+// CHECK: {{(call|invoke)}} {{.*}}@__go_defer
+func Loop(ps []*int) {
+	for _, p := range ps {
+		defer unlock(p)
+	}
+}
+
+// An open-coded function registers a single thunk with the runtime, makes
+// the deferred call directly, and then removes the thunk.
+
+// CHECK-LABEL: define {{.*}}@foo.Open
+// CHECK: call {{.*}}@__go_defer
+// CHECK-NOT: @__go_defer
+// CHECK: {{(call|invoke)}} {{.*}}@foo.unlock
+// CHECK: {{(call|invoke)}} {{.*}}@__go_undefer
+// CHECK: ret
+// RUNTIME-LABEL: define {{.*}}@foo.Open
+// RUNTIME: {{(call|invoke)}} {{.*}}@__go_defer
+func Open(p *int) {
+	*p = 1
+	defer unlock(p)
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Recovers
+// CHECK: {{(call|invoke)}} {{.*}}@__go_defer
+func Recovers() {
+	defer func() { recover() }()
+}
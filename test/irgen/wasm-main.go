@@ -0,0 +1,17 @@
+// RUN: rm -rf %t && mkdir -p %t
+// RUN: llgo -ffreestanding -fruntime-package=rt -fgo-pkgpath=rt -c -o %t/rt.o %S/Inputs/wasmrt.go
+// RUN: llgo -target wasm32-unknown-unknown -ffreestanding -fruntime-package=rt -I %t -S -emit-llvm -o - %s | FileCheck %s
+
+// No further packages are initialized once an initializer panics.
+// CHECK-LABEL: define void @__go_init_main()
+// CHECK: call void @{{.*}}()
+// CHECK-NEXT: load i8* @rt.Panicking
+
+package main
+
+func f() int
+
+var x = f()
+
+func main() {
+}
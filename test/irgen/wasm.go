@@ -0,0 +1,82 @@
+// RUN: rm -rf %t && mkdir -p %t
+// RUN: llgo -ffreestanding -fruntime-package=rt -fgo-pkgpath=rt -c -o %t/rt.o %S/Inputs/wasmrt.go
+// RUN: llgo -target wasm32-unknown-unknown -ffreestanding -fruntime-package=rt -fgo-pkgpath=rt -S -emit-llvm -o %t/rt.ll %S/Inputs/wasmrt.go
+// RUN: llgo -target wasm32-unknown-unknown -ffreestanding -fruntime-package=rt -I %t -S -emit-llvm -o %t/foo.ll %s
+// RUN: FileCheck %s < %t/foo.ll
+// RUN: FileCheck --check-prefix=NOEH %s < %t/foo.ll
+// RUN: FileCheck --check-prefix=THUNK %s < %t/foo.ll
+// RUN: FileCheck --check-prefix=RT %s < %t/rt.ll
+// RUN: llvm-link -S -o - %t/rt.ll %t/foo.ll | FileCheck --check-prefix=LINK %s
+// RUN: not llgo -target wasm32-unknown-unknown -S -o /dev/null %s 2>&1 | FileCheck --check-prefix=LIBGO %s
+
+// CHECK: target datalayout = "e-m:e-p:32:32-i64:64-n32:64-S128"
+// CHECK: @rt.Panicking = external global i8
+
+// Panics are propagated by checking the runtime package's flag after each
+// call, rather than by unwinding, and functions do not use split stacks.
+// Functions that panic return with the flag set, so none are noreturn.
+// NOEH-NOT: landingpad
+// NOEH-NOT: invoke
+// NOEH-NOT: split-stack
+// NOEH-NOT: noreturn
+
+// The runtime package defines the flag, so it is defined once the modules
+// are linked.
+// RT: @rt.Panicking = global i8 0
+// RT-LABEL: define void @rt.Panic(
+// RT: store i8 1, i8* @rt.Panicking
+// LINK: @rt.Panicking = global i8 0
+// LINK-NOT: @rt.Panicking = external
+
+// LIBGO: gllgo: error: WebAssembly targets require a freestanding runtime package
+
+package foo
+
+func g() int
+
+func h(int)
+
+// CHECK-LABEL: define {{.*}}@foo.Call
+// CHECK: call {{.*}}@foo.g
+// CHECK-NEXT: [[FLAG:%.*]] = load i8* @rt.Panicking
+// CHECK-NEXT: [[P:%.*]] = icmp ne i8 [[FLAG]], 0
+// CHECK-NEXT: br i1 [[P]]
+// CHECK: ret i32 0
+func Call() int {
+	return g() + 1
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Defer
+// CHECK: call {{.*}}@rt.Defer
+// CHECK: call {{.*}}@rt.Undefer
+// CHECK-NEXT: load i8* @rt.Panicking
+// CHECK: call {{.*}}@rt.CheckDefer
+// CHECK-NEXT: load i8* @rt.Panicking
+func Defer() {
+	defer g()
+	g()
+}
+
+// The thunk of a deferred call returns if the call panics.
+// THUNK: define internal void @{{[0-9]+}}(i8*
+// THUNK: call {{.*}}@foo.h
+// THUNK-NEXT: load i8* @rt.Panicking
+func DeferArg(x int) {
+	defer h(x)
+	g()
+}
+
+// CHECK-LABEL: define {{.*}}@foo.Panics
+// CHECK: call {{.*}}@rt.Panic
+// CHECK-NEXT: load i8* @rt.Panicking
+func Panics() {
+	panic("boom")
+}
+
+// The bridge to a function calling recover returns if the function panics.
+// CHECK-LABEL: define {{.*}}@foo.Recovers(
+// CHECK: call {{.*}}@foo.Recovers$recover
+// CHECK-NEXT: load i8* @rt.Panicking
+func Recovers() bool {
+	return recover() != nil
+}
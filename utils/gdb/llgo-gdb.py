@@ -0,0 +1,265 @@
+# Copyright 2014 The llgo Authors.
+# Use of this source code is governed by an MIT-style
+# license that can be found in the LICENSE file.
+
+"""GDB pretty-printers and commands for programs built by llgo.
+
+llgo records the path of this script in the .debug_gdb_scripts section of
+programs built with -g, so gdb loads it along with the program, subject to
+its auto-load safe-path; see "help set auto-load safe-path". The script may
+also be loaded explicitly with "source /path/to/llgo-gdb.py".
+
+The printers recognize values by the debug information that llgo generates
+for them (see the debug package):
+
+  string        struct "string" {ptr, len}
+  []T           struct "[]T" {ptr, len, cap}
+  interface     struct {__type_descriptor or __methods, __object}
+  map[K]V       pointer to struct "__go_map", whose buckets are lists of
+                struct "__go_map_entry" {__next, __key, __val}
+  chan T        pointer to struct "Hchan"
+
+The "goroutines" command lists the goroutines in libgo's list of all Gs,
+which requires libgo to have been built with debug information.
+"""
+
+from __future__ import print_function
+
+import gdb
+
+# The kinds of libgo's type descriptors (see irgen/typemap.go).
+KIND_MASK = 0x1f
+KIND_PTR = 22
+
+# The states of a G (see libgo's runtime.h).
+G_STATES = ["idle", "runnable", "running", "syscall", "waiting",
+            "moribund", "dead"]
+G_DEAD = 6
+
+
+def go_string(val):
+    """Returns the contents of a Go string value as a Python string."""
+    length = int(val["len"])
+    if length == 0:
+        return ""
+    return val["ptr"].string("utf-8", "replace", length)
+
+
+def struct_fields(t):
+    t = t.strip_typedefs()
+    if t.code != gdb.TYPE_CODE_STRUCT:
+        return []
+    return [f.name for f in t.fields()]
+
+
+class StringPrinter(object):
+    """Prints a string as a quoted string."""
+
+    def __init__(self, val):
+        self.val = val
+
+    def display_hint(self):
+        return "string"
+
+    def to_string(self):
+        return go_string(self.val)
+
+
+class SlicePrinter(object):
+    """Prints a slice as its length, capacity and elements."""
+
+    def __init__(self, val):
+        self.val = val
+
+    def display_hint(self):
+        return "array"
+
+    def to_string(self):
+        t = self.val.type.strip_typedefs()
+        return "%s len %d cap %d" % (t.tag, int(self.val["len"]),
+                                     int(self.val["cap"]))
+
+    def children(self):
+        ptr = self.val["ptr"]
+        for i in range(int(self.val["len"])):
+            yield ("[%d]" % i, (ptr + i).dereference())
+
+
+class MapPrinter(object):
+    """Prints a map as its keys and values, by walking libgo's hash
+    table."""
+
+    def __init__(self, val):
+        self.val = val
+
+    def display_hint(self):
+        return "map"
+
+    def to_string(self):
+        if not self.val:
+            return "%s nil" % self.val.type.name
+        m = self.val.dereference()
+        return "%s len %d" % (self.val.type.name, int(m["__element_count"]))
+
+    def children(self):
+        if not self.val:
+            return
+        m = self.val.dereference()
+        buckets = m["__buckets"]
+        n = 0
+        for i in range(int(m["__bucket_count"])):
+            entry = buckets[i]
+            while entry:
+                e = entry.dereference()
+                yield ("[%d]" % n, e["__key"])
+                yield ("[%d]" % (n + 1), e["__val"])
+                n += 2
+                entry = e["__next"]
+
+
+class ChanPrinter(object):
+    """Prints a channel as its length and capacity."""
+
+    def __init__(self, val):
+        self.val = val
+
+    def to_string(self):
+        if not self.val:
+            return "%s nil" % self.val.type.name
+        c = self.val.dereference()
+        s = "%s len %d cap %d" % (self.val.type.name, int(c["qcount"]),
+                                  int(c["dataqsiz"]))
+        if c["closed"]:
+            s += " closed"
+        return s
+
+
+def type_descriptor(iface):
+    """Returns the type descriptor of the dynamic type of an interface
+    value, or None if the interface is nil."""
+    if "__methods" in struct_fields(iface.type):
+        methods = iface["__methods"]
+        if not methods:
+            return None
+        td = methods.dereference()["__type_descriptor"]
+    else:
+        td = iface["__type_descriptor"]
+    if not td:
+        return None
+    return td.dereference()
+
+
+def lookup_go_type(name):
+    """Returns the gdb type named by a type descriptor's string, or None.
+    Named types are described by their unqualified names."""
+    ptrs = 0
+    while name.startswith("*"):
+        name = name[1:]
+        ptrs += 1
+    t = None
+    for candidate in (name, name.split(".")[-1]):
+        try:
+            t = gdb.lookup_type(candidate)
+            break
+        except gdb.error:
+            pass
+    if t is None:
+        return None
+    for _ in range(ptrs):
+        t = t.pointer()
+    return t
+
+
+class InterfacePrinter(object):
+    """Prints an interface as its dynamic type and value."""
+
+    def __init__(self, val):
+        self.val = val
+
+    def to_string(self):
+        td = type_descriptor(self.val)
+        if td is None:
+            return "nil"
+        name = go_string(td["__reflection"].dereference())
+        obj = self.val["__object"]
+        t = lookup_go_type(name)
+        if t is None:
+            return "(%s) %s" % (name, obj)
+        if int(td["__code"]) & KIND_MASK == KIND_PTR:
+            # Pointers are held directly.
+            value = obj.cast(t)
+        else:
+            value = obj.cast(t.pointer()).dereference()
+        return "(%s) %s" % (name, value)
+
+
+def is_interface(t):
+    fields = struct_fields(t)
+    return "__object" in fields and ("__type_descriptor" in fields or
+                                      "__methods" in fields)
+
+
+def lookup_printer(val):
+    t = val.type.strip_typedefs()
+    if t.code == gdb.TYPE_CODE_PTR:
+        target = t.target().strip_typedefs()
+        if target.tag == "__go_map":
+            return MapPrinter(val)
+        if target.tag == "Hchan":
+            return ChanPrinter(val)
+        return None
+    if t.code != gdb.TYPE_CODE_STRUCT:
+        return None
+    if t.tag == "string":
+        return StringPrinter(val)
+    if t.tag is not None and t.tag.startswith("[]"):
+        return SlicePrinter(val)
+    if is_interface(t):
+        return InterfacePrinter(val)
+    return None
+
+
+class GoroutinesCmd(gdb.Command):
+    """List the goroutines of the program.
+
+Each goroutine is listed with its id, its state and, if it is waiting, the
+reason. The current goroutine is marked with "*"."""
+
+    def __init__(self):
+        gdb.Command.__init__(self, "goroutines", gdb.COMMAND_STACK,
+                             gdb.COMPLETE_NONE)
+
+    def invoke(self, arg, from_tty):
+        try:
+            allg = gdb.parse_and_eval("runtime_allg")
+            allglen = int(gdb.parse_and_eval("runtime_allglen"))
+        except gdb.error:
+            raise gdb.GdbError("goroutines: libgo's list of goroutines is "
+                               "unavailable; was libgo built with -g?")
+        try:
+            current = gdb.parse_and_eval("g")
+        except gdb.error:
+            current = None
+        for i in range(allglen):
+            gp = allg[i]
+            g = gp.dereference()
+            status = int(g["status"])
+            if status == G_DEAD:
+                continue
+            state = "unknown"
+            if 0 <= status < len(G_STATES):
+                state = G_STATES[status]
+            if state == "waiting" and g["waitreason"]:
+                state += " (%s)" % g["waitreason"].string()
+            mark = " "
+            if current is not None and int(current) == int(gp):
+                mark = "*"
+            print("%s %d %s" % (mark, int(g["goid"]), state))
+
+
+def register(objfile):
+    objfile.pretty_printers.append(lookup_printer)
+
+
+register(gdb.current_objfile() or gdb.objfiles()[0])
+GoroutinesCmd()
@@ -0,0 +1,183 @@
+# Copyright 2014 The llgo Authors.
+# Use of this source code is governed by an MIT-style
+# license that can be found in the LICENSE file.
+
+"""LLDB data formatters for programs built by llgo.
+
+LLDB does not read the .debug_gdb_scripts section, so these formatters must
+be loaded explicitly:
+
+  (lldb) command script import /path/to/llgo_lldb.py
+
+They provide the same views of strings, slices, interfaces and maps as the
+gdb pretty-printers in utils/gdb/llgo-gdb.py, and recognize values by the
+same debug information.
+"""
+
+import lldb
+
+# The kinds of libgo's type descriptors (see irgen/typemap.go).
+KIND_MASK = 0x1f
+KIND_PTR = 22
+
+
+def go_string(valobj):
+    """Returns the contents of a Go string value as a Python string."""
+    length = valobj.GetChildMemberWithName("len").GetValueAsUnsigned()
+    if length == 0:
+        return ""
+    addr = valobj.GetChildMemberWithName("ptr").GetValueAsUnsigned()
+    err = lldb.SBError()
+    data = valobj.GetProcess().ReadMemory(addr, length, err)
+    if not err.Success():
+        return None
+    return data.decode("utf-8", "replace")
+
+
+def string_summary(valobj, internal_dict):
+    s = go_string(valobj)
+    if s is None:
+        return "<invalid string>"
+    return '"%s"' % s.replace("\\", "\\\\").replace('"', '\\"')
+
+
+class SliceProvider(object):
+    """Provides the elements of a slice as its children."""
+
+    def __init__(self, valobj, internal_dict):
+        self.valobj = valobj
+
+    def update(self):
+        v = self.valobj
+        self.ptr = v.GetChildMemberWithName("ptr")
+        self.len = v.GetChildMemberWithName("len").GetValueAsUnsigned()
+        self.elem = self.ptr.GetType().GetPointeeType()
+        return False
+
+    def num_children(self):
+        return self.len
+
+    def get_child_index(self, name):
+        try:
+            return int(name.lstrip("[").rstrip("]"))
+        except ValueError:
+            return -1
+
+    def get_child_at_index(self, index):
+        if index < 0 or index >= self.len:
+            return None
+        addr = self.ptr.GetValueAsUnsigned() + index * self.elem.GetByteSize()
+        return self.valobj.CreateValueFromAddress("[%d]" % index, addr,
+                                                  self.elem)
+
+    def has_children(self):
+        return True
+
+
+def slice_summary(valobj, internal_dict):
+    v = valobj.GetNonSyntheticValue()
+    return "len %d cap %d" % (
+        v.GetChildMemberWithName("len").GetValueAsUnsigned(),
+        v.GetChildMemberWithName("cap").GetValueAsUnsigned())
+
+
+class MapProvider(object):
+    """Provides the entries of a map as its children, by walking libgo's
+    hash table."""
+
+    def __init__(self, valobj, internal_dict):
+        self.valobj = valobj
+        self.entries = []
+
+    def update(self):
+        self.entries = []
+        if self.valobj.GetValueAsUnsigned() == 0:
+            return False
+        m = self.valobj.Dereference()
+        buckets = m.GetChildMemberWithName("__buckets")
+        count = m.GetChildMemberWithName("__bucket_count").GetValueAsUnsigned()
+        ptrtype = buckets.GetType().GetPointeeType()
+        for i in range(count):
+            addr = buckets.GetValueAsUnsigned() + i * ptrtype.GetByteSize()
+            entry = self.valobj.CreateValueFromAddress("", addr, ptrtype)
+            while entry.GetValueAsUnsigned() != 0:
+                e = entry.Dereference()
+                self.entries.append(e)
+                entry = e.GetChildMemberWithName("__next")
+        return False
+
+    def num_children(self):
+        return len(self.entries)
+
+    def get_child_index(self, name):
+        try:
+            return int(name.lstrip("[").rstrip("]"))
+        except ValueError:
+            return -1
+
+    def get_child_at_index(self, index):
+        if index < 0 or index >= len(self.entries):
+            return None
+        e = self.entries[index]
+        key = e.GetChildMemberWithName("__key")
+        val = e.GetChildMemberWithName("__val")
+        return self.valobj.CreateValueFromAddress(
+            "[%s]" % (key.GetSummary() or key.GetValue()),
+            val.GetLoadAddress(), val.GetType())
+
+    def has_children(self):
+        return True
+
+
+def map_summary(valobj, internal_dict):
+    v = valobj.GetNonSyntheticValue()
+    if v.GetValueAsUnsigned() == 0:
+        return "nil"
+    m = v.Dereference()
+    return "len %d" % m.GetChildMemberWithName("__element_count").GetValueAsUnsigned()
+
+
+def interface_summary(valobj, internal_dict):
+    methods = valobj.GetChildMemberWithName("__methods")
+    if methods.IsValid():
+        if methods.GetValueAsUnsigned() == 0:
+            return "nil"
+        td = methods.Dereference().GetChildMemberWithName("__type_descriptor")
+    else:
+        td = valobj.GetChildMemberWithName("__type_descriptor")
+    if td.GetValueAsUnsigned() == 0:
+        return "nil"
+    td = td.Dereference()
+    name = go_string(td.GetChildMemberWithName("__reflection").Dereference())
+    obj = valobj.GetChildMemberWithName("__object")
+
+    # Named types are described by their unqualified names.
+    base = name.lstrip("*")
+    ptrs = len(name) - len(base)
+    target = valobj.GetTarget()
+    t = target.FindFirstType(base)
+    if not t.IsValid():
+        t = target.FindFirstType(base.split(".")[-1])
+    if not t.IsValid():
+        return "(%s) 0x%x" % (name, obj.GetValueAsUnsigned())
+    for _ in range(ptrs):
+        t = t.GetPointerType()
+    code = td.GetChildMemberWithName("__code").GetValueAsUnsigned()
+    if code & KIND_MASK == KIND_PTR:
+        # Pointers are held directly.
+        value = obj.Cast(t)
+    else:
+        value = valobj.CreateValueFromAddress("", obj.GetValueAsUnsigned(), t)
+    return "(%s) %s" % (name, value.GetSummary() or value.GetValue() or "")
+
+
+def __lldb_init_module(debugger, internal_dict):
+    category = "llgo"
+    run = debugger.HandleCommand
+    run('type summary add -w %s -F llgo_lldb.string_summary "string"' % category)
+    run('type synthetic add -w %s -l llgo_lldb.SliceProvider -x "^\\[\\]"' % category)
+    run('type summary add -w %s -e -F llgo_lldb.slice_summary -x "^\\[\\]"' % category)
+    run('type synthetic add -w %s -l llgo_lldb.MapProvider -x "^map\\["' % category)
+    run('type summary add -w %s -e -F llgo_lldb.map_summary -x "^map\\["' % category)
+    run('type summary add -w %s -F llgo_lldb.interface_summary -x "^interface *\\{"' % category)
+    run("type category enable %s" % category)